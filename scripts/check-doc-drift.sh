@@ -12,6 +12,17 @@ fi
 rm -f docs/mcp-tools.generated.md.tmp
 echo "MCP docs generation: OK"
 
+echo "Checking OpenAPI spec generation drift..."
+go -C toolhub run ./cmd/openapigen > openapi.yaml.tmp
+if ! diff -q openapi.yaml openapi.yaml.tmp > /dev/null 2>&1; then
+    echo "DRIFT DETECTED: openapi.yaml is out of date"
+    diff openapi.yaml openapi.yaml.tmp || true
+    rm -f openapi.yaml.tmp
+    exit 1
+fi
+rm -f openapi.yaml.tmp
+echo "OpenAPI spec generation: OK"
+
 echo "Running doc drift Go tests..."
 go -C toolhub test -run 'TestDocDrift' -count=1 ./internal/core/
 echo "Doc drift checks: ALL PASSED"
@@ -6,14 +6,15 @@ import (
 	"sort"
 
 	"github.com/toolhub/toolhub/internal/mcp"
+	"github.com/toolhub/toolhub/internal/tools"
 )
 
 func main() {
-	defs := mcp.ToolDefinitions()
+	defs := mcp.ToolDefinitions(tools.Definitions())
 
 	fmt.Fprintln(os.Stdout, "# MCP Tools (Generated)")
 	fmt.Fprintln(os.Stdout)
-	fmt.Fprintln(os.Stdout, "This file is generated from `toolhub/internal/mcp/server.go`.")
+	fmt.Fprintln(os.Stdout, "This file is generated from the tool registry in `toolhub/internal/tools`.")
 	fmt.Fprintln(os.Stdout)
 
 	for _, d := range defs {
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+
+	httpsvr "github.com/toolhub/toolhub/internal/http"
+	"github.com/toolhub/toolhub/internal/tools"
+)
+
+func main() {
+	if _, err := os.Stdout.Write(httpsvr.OpenAPIDocument(tools.Definitions())); err != nil {
+		os.Exit(1)
+	}
+}
@@ -17,6 +17,8 @@ import (
 	httpsvr "github.com/toolhub/toolhub/internal/http"
 	mcpsvr "github.com/toolhub/toolhub/internal/mcp"
 	"github.com/toolhub/toolhub/internal/qa"
+	"github.com/toolhub/toolhub/internal/tools"
+	"github.com/toolhub/toolhub/internal/workspace"
 )
 
 var (
@@ -57,6 +59,12 @@ func main() {
 	forbiddenPrefixes := envOrDefault("PATH_POLICY_FORBIDDEN_PREFIXES", profile.PathPolicyForbiddenPrefixes)
 	approvalPrefixes := envOrDefault("PATH_POLICY_APPROVAL_PREFIXES", profile.PathPolicyApprovalPrefixes)
 	policy.SetPathPolicy(forbiddenPrefixes, approvalPrefixes)
+	rateLimitRules, err := core.ParseRateLimitRules(os.Getenv("RATE_LIMIT_RULES"))
+	if err != nil {
+		logger.Error("invalid RATE_LIMIT_RULES", "err", err)
+		os.Exit(1)
+	}
+	policy.SetRateLimiter(core.NewRateLimiter(rateLimitRules))
 
 	runService := core.NewRunService(database)
 	auditService := core.NewAuditService(database, artifactStore, policy)
@@ -131,6 +139,42 @@ func main() {
 	qaSandboxImage := strings.TrimSpace(envOrDefault("QA_SANDBOX_IMAGE", "golang:1.25"))
 	qaSandboxDockerBin := strings.TrimSpace(envOrDefault("QA_SANDBOX_DOCKER_BIN", "docker"))
 	qaSandboxContainerWD := strings.TrimSpace(envOrDefault("QA_SANDBOX_CONTAINER_WORKDIR", "/workspace"))
+	qaTargets, err := qa.ParseTargets(os.Getenv("QA_TARGETS"))
+	if err != nil {
+		logger.Error("invalid QA_TARGETS", "err", err)
+		os.Exit(1)
+	}
+	var qaRepoConfigs map[string]*qa.RepoConfig
+	if path := strings.TrimSpace(os.Getenv("QA_REPO_CONFIG_FILE")); path != "" {
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			logger.Error("read QA_REPO_CONFIG_FILE failed", "err", readErr)
+			os.Exit(1)
+		}
+		qaRepoConfigs, err = qa.ParseRepoConfigs(data)
+		if err != nil {
+			logger.Error("invalid QA_REPO_CONFIG_FILE", "err", err)
+			os.Exit(1)
+		}
+	}
+	qaRepoConfigDiscovery := false
+	if raw := strings.TrimSpace(os.Getenv("QA_REPO_CONFIG_DISCOVERY")); raw != "" {
+		v, parseErr := strconv.ParseBool(raw)
+		if parseErr != nil {
+			logger.Error("invalid QA_REPO_CONFIG_DISCOVERY", "value", raw)
+			os.Exit(1)
+		}
+		qaRepoConfigDiscovery = v
+	}
+	qaTestMaxReruns := 0
+	if raw := strings.TrimSpace(os.Getenv("QA_TEST_MAX_RERUNS")); raw != "" {
+		v, parseErr := strconv.Atoi(raw)
+		if parseErr != nil || v < 0 {
+			logger.Error("invalid QA_TEST_MAX_RERUNS", "value", raw)
+			os.Exit(1)
+		}
+		qaTestMaxReruns = v
+	}
 	qaRunner, err := qa.NewRunner(qa.Config{
 		WorkDir:            envOrDefault("QA_WORKDIR", "."),
 		TestCmd:            envOrDefault("QA_TEST_CMD", "go -C toolhub test ./..."),
@@ -143,15 +187,88 @@ func main() {
 		SandboxDockerBin:   qaSandboxDockerBin,
 		SandboxContainerWD: qaSandboxContainerWD,
 		AllowedExecutables: qaAllowedExecutables,
+		TestResultFormat:   strings.TrimSpace(os.Getenv("QA_TEST_RESULT_FORMAT")),
+		TestResultFile:     strings.TrimSpace(os.Getenv("QA_TEST_RESULT_FILE")),
+		LintResultFormat:   strings.TrimSpace(os.Getenv("QA_LINT_RESULT_FORMAT")),
+		LintResultFile:     strings.TrimSpace(os.Getenv("QA_LINT_RESULT_FILE")),
+		Targets:            qaTargets,
+		TestMaxReruns:      qaTestMaxReruns,
+		TestMapper:         strings.TrimSpace(os.Getenv("QA_TEST_MAPPER")),
+		RepoConfigs:        qaRepoConfigs,
 	})
 	if err != nil {
 		logger.Error("qa runner init failed", "err", err)
 		os.Exit(1)
 	}
 
+	var workspaces *workspace.Manager
+	if root := strings.TrimSpace(os.Getenv("WORKSPACE_ROOT")); root != "" {
+		maxRepos := 0
+		if raw := strings.TrimSpace(os.Getenv("WORKSPACE_MAX_REPOS")); raw != "" {
+			v, parseErr := strconv.Atoi(raw)
+			if parseErr != nil || v < 0 {
+				logger.Error("invalid WORKSPACE_MAX_REPOS", "value", raw)
+				os.Exit(1)
+			}
+			maxRepos = v
+		}
+		maxIdleHours := 0
+		if raw := strings.TrimSpace(os.Getenv("WORKSPACE_MAX_IDLE_HOURS")); raw != "" {
+			v, parseErr := strconv.Atoi(raw)
+			if parseErr != nil || v < 0 {
+				logger.Error("invalid WORKSPACE_MAX_IDLE_HOURS", "value", raw)
+				os.Exit(1)
+			}
+			maxIdleHours = v
+		}
+		workspaces, err = workspace.NewManager(workspace.Config{
+			Root:      root,
+			BaseURL:   os.Getenv("WORKSPACE_GIT_BASE_URL"),
+			Tokens:    ghClient,
+			AllowRepo: policy.CheckRepo,
+			MaxRepos:  maxRepos,
+			MaxIdle:   time.Duration(maxIdleHours) * time.Hour,
+		})
+		if err != nil {
+			logger.Error("workspace manager init failed", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	coAuthorFromPrincipal := false
+	if raw := strings.TrimSpace(os.Getenv("CODE_CO_AUTHOR_FROM_PRINCIPAL")); raw != "" {
+		v, parseErr := strconv.ParseBool(raw)
+		if parseErr != nil {
+			logger.Error("invalid CODE_CO_AUTHOR_FROM_PRINCIPAL", "value", raw)
+			os.Exit(1)
+		}
+		coAuthorFromPrincipal = v
+	}
+
+	codeSigning := codeops.Signing{
+		Method: strings.TrimSpace(envOrDefault("CODE_COMMIT_SIGNING", codeops.SignNone)),
+		Key:    strings.TrimSpace(os.Getenv("CODE_SIGNING_KEY")),
+	}
+	if err := codeSigning.Validate(); err != nil {
+		logger.Error("invalid CODE_COMMIT_SIGNING", "err", err)
+		os.Exit(1)
+	}
+
+	codeBackend := strings.TrimSpace(envOrDefault("CODE_BACKEND", codeops.BackendLocal))
+	if codeBackend != codeops.BackendLocal && codeBackend != codeops.BackendAPI {
+		logger.Error("invalid CODE_BACKEND", "value", codeBackend, "allowed", "local|api")
+		os.Exit(1)
+	}
+
 	codeRunner := codeops.NewRunner(codeops.Config{
-		WorkDir: envOrDefault("CODE_WORKDIR", envOrDefault("QA_WORKDIR", ".")),
-		Remote:  envOrDefault("CODE_GIT_REMOTE", "origin"),
+		Backend:    codeBackend,
+		WorkDir:    envOrDefault("CODE_WORKDIR", envOrDefault("QA_WORKDIR", ".")),
+		Remote:     envOrDefault("CODE_GIT_REMOTE", "origin"),
+		Workspaces: workspaces,
+		Tokens:     ghClient,
+		Author:     ghClient,
+		Signing:    codeSigning,
+		GitData:    ghClient,
 	})
 
 	httpAddr := envOrDefault("TOOLHUB_HTTP_LISTEN", "0.0.0.0:8080")
@@ -169,15 +286,52 @@ func main() {
 		"qa_timeout_seconds", qaTimeoutSecs,
 		"repair_max_iterations", repairMaxIterations,
 		"batch_mode", string(batchMode),
+		"rate_limit_rules", len(rateLimitRules),
+		"workspace_root", os.Getenv("WORKSPACE_ROOT"),
+		"code_backend", codeBackend,
+		"code_commit_signing", codeSigning.Method,
 	)
 
-	httpServer := httpsvr.NewServer(httpAddr, runService, auditService, policy, ghClient, qaRunner, codeRunner, logger, batchMode, repairMaxIterations, httpsvr.BuildInfo{
+	jobs := core.NewJobService(database, auditService, logger)
+	if n, err := jobs.InterruptStale(context.Background()); err != nil {
+		logger.Error("interrupt stale jobs failed", "err", err)
+		os.Exit(1)
+	} else if n > 0 {
+		logger.Warn("marked jobs interrupted by the previous shutdown", "count", n)
+	}
+	repairSessions := core.NewRepairSessionService(database)
+	if n, err := repairSessions.ReopenStale(context.Background()); err != nil {
+		logger.Error("reopen stale repair sessions failed", "err", err)
+		os.Exit(1)
+	} else if n > 0 {
+		logger.Warn("reopened repair sessions left running by the previous shutdown", "count", n)
+	}
+
+	registry := tools.NewRegistry(tools.Deps{
+		Runs:                  runService,
+		Audit:                 auditService,
+		Policy:                policy,
+		GitHub:                ghClient,
+		QA:                    qaRunner,
+		Code:                  codeRunner,
+		Workspaces:            workspaces,
+		RepairSessions:        repairSessions,
+		FlakyTests:            core.NewFlakyTestService(database),
+		Jobs:                  jobs,
+		Logger:                logger,
+		BatchMode:             batchMode,
+		RepairMaxIterations:   repairMaxIterations,
+		CoAuthorFromPrincipal: coAuthorFromPrincipal,
+		QARepoConfigDiscovery: qaRepoConfigDiscovery,
+	})
+
+	httpServer := httpsvr.NewServer(httpAddr, registry, runService, auditService, policy, logger, httpsvr.BuildInfo{
 		Version:         version,
 		GitCommit:       gitCommit,
 		BuildTime:       buildTime,
 		ContractVersion: core.ContractVersion,
 	})
-	mcpServer := mcpsvr.NewServer(mcpAddr, runService, auditService, policy, ghClient, qaRunner, codeRunner, logger, batchMode, repairMaxIterations)
+	mcpServer := mcpsvr.NewServer(mcpAddr, registry, logger)
 
 	errCh := make(chan error, 2)
 	go func() { errCh <- httpServer.ListenAndServe() }()
@@ -198,6 +352,9 @@ func main() {
 
 	httpServer.Shutdown(ctx)
 	mcpServer.Shutdown(ctx)
+	if err := jobs.Shutdown(ctx); err != nil {
+		logger.Error("jobs did not stop before shutdown timeout", "err", err)
+	}
 	logger.Info("shutdown complete")
 }
 
@@ -0,0 +1,261 @@
+package codeops
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	gh "github.com/toolhub/toolhub/internal/github"
+	"github.com/toolhub/toolhub/internal/workspace"
+)
+
+// Backends select where Execute builds commits.
+const (
+	// BackendLocal commits in a worktree of a local clone and pushes.
+	BackendLocal = "local"
+	// BackendAPI reads the base branch and creates the commit and head ref
+	// through the GitHub Git Data API, without a local checkout.
+	BackendAPI = "api"
+)
+
+// GitData is the subset of the GitHub Git Data API the runner uses.
+type GitData interface {
+	GetRef(ctx context.Context, owner, repo, ref string) (string, error)
+	CreateRef(ctx context.Context, owner, repo, ref, sha string) error
+	UpdateRef(ctx context.Context, owner, repo, ref, sha string, force bool) error
+	DeleteRef(ctx context.Context, owner, repo, ref string) error
+	GetCommit(ctx context.Context, owner, repo, sha string) (*gh.GitCommit, error)
+	GetTree(ctx context.Context, owner, repo, sha string, recursive bool) (*gh.GitTree, error)
+	GetBlob(ctx context.Context, owner, repo, sha string) ([]byte, error)
+	CreateBlob(ctx context.Context, owner, repo string, content []byte) (string, error)
+	CreateTree(ctx context.Context, owner, repo, baseTree string, entries []gh.GitTreeEntry) (string, error)
+	CreateCommit(ctx context.Context, owner, repo string, in gh.CreateGitCommitInput) (*gh.GitCommit, error)
+}
+
+// executeAPI is Execute for BackendAPI. It produces the same Result as the
+// local backend; only the planned commands name API calls instead of git.
+func (r *Runner) executeAPI(ctx context.Context, req Request) (*Result, error) {
+	if r.cfg.GitData == nil {
+		return nil, fmt.Errorf("api backend requires the GitHub Git Data API")
+	}
+	owner, name, err := splitRepo(req.Repo)
+	if err != nil {
+		return nil, err
+	}
+	if req.KeepWorktree && r.cfg.Workspaces == nil && !req.DryRun {
+		return nil, fmt.Errorf("a worktree of the head branch needs workspaces with the api backend")
+	}
+
+	message := commitMessage(req.CommitMessage, req.CoAuthor)
+	// The commit builds on the head branch for fast-forward updates and on
+	// the base branch otherwise.
+	start := req.BaseBranch
+	if req.Update && !req.ForceWithLease {
+		start = req.HeadBranch
+	}
+	var commands []string
+	if req.Update {
+		commands = append(commands, fmt.Sprintf("GET /repos/%s/git/ref/heads/%s", req.Repo, req.HeadBranch))
+	}
+	if start != req.HeadBranch {
+		commands = append(commands, fmt.Sprintf("GET /repos/%s/git/ref/heads/%s", req.Repo, start))
+	}
+	commands = append(commands, fmt.Sprintf("GET /repos/%s/git/trees/<base tree>?recursive=1", req.Repo))
+	for _, f := range req.Files {
+		fileCommands, err := changeCommands(f, "")
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, fileCommands...)
+	}
+	commands = append(commands, apiCommitCommands(req.Repo, req.HeadBranch, message, req.Update)...)
+
+	if req.DryRun {
+		return &Result{PlannedCommands: commands}, nil
+	}
+
+	var previousHead, lease string
+	if req.Update {
+		if previousHead, err = r.cfg.GitData.GetRef(ctx, owner, name, "refs/heads/"+req.HeadBranch); err != nil {
+			return nil, fmt.Errorf("resolve head branch %q: %w", req.HeadBranch, err)
+		}
+		if err := checkHead(req, previousHead); err != nil {
+			return nil, err
+		}
+		if req.ForceWithLease {
+			lease = previousHead
+		}
+	}
+	baseSHA := previousHead
+	if start != req.HeadBranch {
+		if baseSHA, err = r.cfg.GitData.GetRef(ctx, owner, name, "refs/heads/"+start); err != nil {
+			return nil, fmt.Errorf("resolve base branch %q: %w", start, err)
+		}
+	}
+	baseCommit, err := r.cfg.GitData.GetCommit(ctx, owner, name, baseSHA)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := r.cfg.GitData.GetTree(ctx, owner, name, baseCommit.Tree.SHA, true)
+	if err != nil {
+		return nil, err
+	}
+	if tree.Truncated {
+		return nil, fmt.Errorf("tree of %q is too large for the api backend", start)
+	}
+	index := make(map[string]gh.GitTreeEntry, len(tree.Entries))
+	for _, e := range tree.Entries {
+		if e.Type == "blob" {
+			index[e.Path] = e
+		}
+	}
+
+	changes, appliedPatch, conflicts, stale, err := resolveChanges(func(path string) (baseFile, bool, error) {
+		e, ok := index[path]
+		if !ok || e.SHA == nil {
+			return baseFile{}, false, nil
+		}
+		content, err := r.cfg.GitData.GetBlob(ctx, owner, name, *e.SHA)
+		if err != nil {
+			return baseFile{}, false, fmt.Errorf("read file %q: %w", path, err)
+		}
+		return baseFile{content: content, mode: e.Mode}, true, nil
+	}, req.Files)
+	if err != nil {
+		return nil, err
+	}
+	if len(stale) > 0 {
+		return &Result{PlannedCommands: commands, StaleFiles: stale}, &StaleContentError{Files: stale}
+	}
+	if len(conflicts) > 0 {
+		return &Result{PlannedCommands: commands, Conflicts: conflicts}, &ConflictError{Conflicts: conflicts}
+	}
+
+	commit, err := r.apiCommit(ctx, owner, name, baseSHA, baseCommit.Tree.SHA, req.HeadBranch, message, lease, changes)
+	if err != nil {
+		return nil, err
+	}
+	v := &Verification{Method: SignAPI}
+	applyVerification(v, commit)
+	result := &Result{PlannedCommands: commands, CommitHash: commit.SHA, AppliedPatch: appliedPatch, Verification: v, PreviousHeadSHA: previousHead}
+
+	if req.KeepWorktree {
+		if result.Worktree, err = r.headWorktree(ctx, req.Repo, req.HeadBranch); err != nil {
+			return result, err
+		}
+		result.BaseRef = workspace.Remote + "/" + req.BaseBranch
+	}
+	return result, nil
+}
+
+// headWorktree checks out the head branch from a freshly fetched workspace
+// clone, for callers that need the committed files on disk.
+func (r *Runner) headWorktree(ctx context.Context, repo, head string) (*workspace.Worktree, error) {
+	lease, err := r.cfg.Workspaces.Acquire(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	wt, err := workspace.AddWorktree(ctx, lease.GitDir, lease.BranchRef(head), r.cfg.WorktreeRoot)
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	wt.OnRemove(lease.Release)
+	return wt, nil
+}
+
+// apiCommit creates blobs for the changes, a tree on top of baseTree and a
+// commit with parent, then points head at the commit (see setRef for
+// lease). The commit carries no author, so GitHub attributes it to the App
+// and signs it.
+func (r *Runner) apiCommit(ctx context.Context, owner, name, parent, baseTree, head, message, lease string, changes []resolvedChange) (*gh.GitCommit, error) {
+	entries := make([]gh.GitTreeEntry, 0, len(changes)+1)
+	for _, c := range changes {
+		if c.oldPath != "" {
+			entries = append(entries, gh.GitTreeEntry{Path: c.oldPath, Mode: c.oldMode, Type: "blob"})
+		}
+		entry := gh.GitTreeEntry{Path: c.path, Mode: c.mode, Type: "blob"}
+		if !c.deleted {
+			sha, err := r.cfg.GitData.CreateBlob(ctx, owner, name, []byte(c.content))
+			if err != nil {
+				return nil, err
+			}
+			entry.SHA = &sha
+		}
+		entries = append(entries, entry)
+	}
+	tree, err := r.cfg.GitData.CreateTree(ctx, owner, name, baseTree, entries)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.cfg.GitData.CreateCommit(ctx, owner, name, gh.CreateGitCommitInput{Message: message, Tree: tree, Parents: []string{parent}})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.setRef(ctx, owner, name, head, commit.SHA, lease); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// setRef creates the head branch, or fast-forwards it when it already
+// exists. With a lease the branch is force-updated instead, but only while
+// it still points at the lease; GitHub has no compare-and-swap for refs, so
+// this narrows rather than closes the window for a concurrent push.
+func (r *Runner) setRef(ctx context.Context, owner, name, head, sha, lease string) error {
+	ref := "refs/heads/" + head
+	current, err := r.cfg.GitData.GetRef(ctx, owner, name, ref)
+	if gh.IsNotFound(err) && lease == "" {
+		return r.cfg.GitData.CreateRef(ctx, owner, name, ref, sha)
+	}
+	if err != nil {
+		return err
+	}
+	if lease != "" {
+		if current != lease {
+			return &HeadMovedError{Branch: head, Expected: lease, Current: current}
+		}
+		return r.cfg.GitData.UpdateRef(ctx, owner, name, ref, sha, true)
+	}
+	return r.cfg.GitData.UpdateRef(ctx, owner, name, ref, sha, false)
+}
+
+// rollbackAPI deletes the head branch on GitHub.
+func (r *Runner) rollbackAPI(ctx context.Context, repo, head string, dryRun bool) (*RollbackResult, error) {
+	commands := []string{fmt.Sprintf("DELETE /repos/%s/git/refs/heads/%s", repo, head)}
+	if dryRun {
+		return &RollbackResult{PlannedCommands: commands}, nil
+	}
+	if r.cfg.GitData == nil {
+		return nil, fmt.Errorf("api backend requires the GitHub Git Data API")
+	}
+	owner, name, err := splitRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.cfg.GitData.DeleteRef(ctx, owner, name, "refs/heads/"+head); err != nil {
+		return &RollbackResult{PlannedCommands: commands}, fmt.Errorf("rollback failed: %w", err)
+	}
+	return &RollbackResult{PlannedCommands: commands}, nil
+}
+
+func apiCommitCommands(repo, head, message string, update bool) []string {
+	ref := fmt.Sprintf("POST /repos/%s/git/refs ref=%q", repo, "refs/heads/"+head)
+	if update {
+		ref = fmt.Sprintf("PATCH /repos/%s/git/refs/heads/%s", repo, head)
+	}
+	return []string{
+		fmt.Sprintf("POST /repos/%s/git/blobs (one per written file)", repo),
+		fmt.Sprintf("POST /repos/%s/git/trees", repo),
+		fmt.Sprintf("POST /repos/%s/git/commits message=%q", repo, message),
+		ref,
+	}
+}
+
+func splitRepo(repo string) (string, string, error) {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid repository %q", repo)
+	}
+	return owner, name, nil
+}
@@ -0,0 +1,288 @@
+package codeops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/toolhub/toolhub/internal/core"
+	gh "github.com/toolhub/toolhub/internal/github"
+)
+
+// fakeGitData is an in-memory GitHub repository behind the Git Data API.
+type fakeGitData struct {
+	refs    map[string]string
+	commits map[string]*gh.GitCommit
+	trees   map[string][]gh.GitTreeEntry
+	blobs   map[string][]byte
+	parents map[string]string
+	n       int
+
+	created      []gh.CreateGitCommitInput
+	lastBaseTree string
+	lastEntries  []gh.GitTreeEntry
+	updatedRefs  []string
+	forcedRefs   []string
+}
+
+func newFakeGitData() *fakeGitData {
+	return &fakeGitData{
+		refs:    map[string]string{},
+		commits: map[string]*gh.GitCommit{},
+		trees:   map[string][]gh.GitTreeEntry{},
+		blobs:   map[string][]byte{},
+		parents: map[string]string{},
+	}
+}
+
+func notFound(what string) error {
+	return &gh.APIError{Operation: what, StatusCode: http.StatusNotFound, Body: "Not Found"}
+}
+
+func (f *fakeGitData) id(prefix string) string {
+	f.n++
+	return fmt.Sprintf("%s%d", prefix, f.n)
+}
+
+// seed points branch at a commit whose tree holds files, path to content;
+// a "+x " content prefix marks an executable.
+func (f *fakeGitData) seed(branch string, files map[string]string) string {
+	var entries []gh.GitTreeEntry
+	for path, content := range files {
+		mode := "100644"
+		if rest, ok := strings.CutPrefix(content, "+x "); ok {
+			mode, content = "100755", rest
+		}
+		sha, _ := f.CreateBlob(context.Background(), "", "", []byte(content))
+		entries = append(entries, gh.GitTreeEntry{Path: path, Mode: mode, Type: "blob", SHA: &sha})
+	}
+	tree, _ := f.CreateTree(context.Background(), "", "", "", entries)
+	sha := f.id("commit")
+	f.commits[sha] = &gh.GitCommit{SHA: sha, Tree: gh.GitObject{SHA: tree}}
+	f.refs["refs/heads/"+branch] = sha
+	return sha
+}
+
+func (f *fakeGitData) GetRef(_ context.Context, _, _, ref string) (string, error) {
+	sha, ok := f.refs[ref]
+	if !ok {
+		return "", notFound("get ref")
+	}
+	return sha, nil
+}
+
+func (f *fakeGitData) CreateRef(_ context.Context, _, _, ref, sha string) error {
+	if _, ok := f.refs[ref]; ok {
+		return &gh.APIError{Operation: "create ref", StatusCode: http.StatusUnprocessableEntity, Body: "Reference already exists"}
+	}
+	f.refs[ref] = sha
+	return nil
+}
+
+// UpdateRef rejects non-fast-forward updates unless force is set, as
+// GitHub does.
+func (f *fakeGitData) UpdateRef(_ context.Context, _, _, ref, sha string, force bool) error {
+	if force {
+		f.forcedRefs = append(f.forcedRefs, ref)
+	} else {
+		c := sha
+		for c != "" && c != f.refs[ref] {
+			c = f.parents[c]
+		}
+		if c == "" {
+			return &gh.APIError{Operation: "update ref", StatusCode: http.StatusUnprocessableEntity, Body: "Update is not a fast forward"}
+		}
+	}
+	f.refs[ref] = sha
+	f.updatedRefs = append(f.updatedRefs, ref)
+	return nil
+}
+
+func (f *fakeGitData) DeleteRef(_ context.Context, _, _, ref string) error {
+	if _, ok := f.refs[ref]; !ok {
+		return notFound("delete ref")
+	}
+	delete(f.refs, ref)
+	return nil
+}
+
+func (f *fakeGitData) GetCommit(_ context.Context, _, _, sha string) (*gh.GitCommit, error) {
+	c, ok := f.commits[sha]
+	if !ok {
+		return &gh.GitCommit{SHA: sha, Verification: &gh.GitVerification{Reason: "unsigned"}}, nil
+	}
+	return c, nil
+}
+
+func (f *fakeGitData) GetTree(_ context.Context, _, _, sha string, _ bool) (*gh.GitTree, error) {
+	entries, ok := f.trees[sha]
+	if !ok {
+		return nil, notFound("get tree")
+	}
+	return &gh.GitTree{SHA: sha, Entries: entries}, nil
+}
+
+func (f *fakeGitData) GetBlob(_ context.Context, _, _, sha string) ([]byte, error) {
+	content, ok := f.blobs[sha]
+	if !ok {
+		return nil, notFound("get blob")
+	}
+	return content, nil
+}
+
+func (f *fakeGitData) CreateBlob(_ context.Context, _, _ string, content []byte) (string, error) {
+	sha := f.id("blob")
+	f.blobs[sha] = content
+	return sha, nil
+}
+
+func (f *fakeGitData) CreateTree(_ context.Context, _, _, baseTree string, entries []gh.GitTreeEntry) (string, error) {
+	f.lastBaseTree, f.lastEntries = baseTree, entries
+	byPath := map[string]gh.GitTreeEntry{}
+	for _, e := range f.trees[baseTree] {
+		byPath[e.Path] = e
+	}
+	for _, e := range entries {
+		if e.SHA == nil {
+			delete(byPath, e.Path)
+		} else {
+			byPath[e.Path] = e
+		}
+	}
+	merged := make([]gh.GitTreeEntry, 0, len(byPath))
+	for _, e := range byPath {
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Path < merged[j].Path })
+	sha := f.id("tree")
+	f.trees[sha] = merged
+	return sha, nil
+}
+
+func (f *fakeGitData) CreateCommit(_ context.Context, _, _ string, in gh.CreateGitCommitInput) (*gh.GitCommit, error) {
+	f.created = append(f.created, in)
+	sha := f.id("commit")
+	c := &gh.GitCommit{SHA: sha, Tree: gh.GitObject{SHA: in.Tree}, Verification: &gh.GitVerification{Verified: true, Reason: "valid"}}
+	f.commits[sha] = c
+	if len(in.Parents) > 0 {
+		f.parents[sha] = in.Parents[0]
+	}
+	return c, nil
+}
+
+// file returns the content of path at ref, and its mode.
+func (f *fakeGitData) file(t *testing.T, ref, path string) (string, string) {
+	t.Helper()
+	for _, e := range f.trees[f.commits[f.refs[ref]].Tree.SHA] {
+		if e.Path == path {
+			return string(f.blobs[*e.SHA]), e.Mode
+		}
+	}
+	return "", ""
+}
+
+func TestRunnerExecuteAPIBackend(t *testing.T) {
+	api := newFakeGitData()
+	base := api.seed("main", map[string]string{"main.go": applyBase, "run.sh": "+x echo hi\n", "old.txt": "bye\n"})
+	// The api backend never touches a work directory.
+	r := NewRunner(Config{Backend: BackendAPI, WorkDir: "/nonexistent", GitData: api})
+
+	modified := strings.Replace(applyBase, `"b"`, `"B"`, 1)
+	req := Request{
+		Repo:          "acme/app",
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/api",
+		CommitMessage: "api change",
+		Files: []FileChange{
+			{Path: "main.go", Patch: core.GenerateUnifiedDiff("main.go", applyBase, modified)},
+			{Path: "run.sh", Edits: []EditBlock{{Search: "hi", Replace: "hello"}}},
+			{Path: "old.txt", Patch: core.GenerateUnifiedDiffWithOptions("old.txt", "bye\n", "", core.DiffOptions{DeletedFile: true})},
+			{Path: "docs/new.md", ModifiedContent: "# new\n"},
+		},
+	}
+
+	plan, err := r.Execute(context.Background(), Request{Repo: req.Repo, BaseBranch: "main", HeadBranch: "toolhub/api", CommitMessage: "api change", Files: req.Files, DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if want := "GET /repos/acme/app/git/ref/heads/main"; plan.PlannedCommands[0] != want || len(api.created) != 0 {
+		t.Fatalf("dry run planned %q, created %d commits", plan.PlannedCommands, len(api.created))
+	}
+
+	res, err := r.Execute(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if got := api.refs["refs/heads/toolhub/api"]; got != res.CommitHash || api.created[0].Parents[0] != base {
+		t.Fatalf("head ref = %q, commit %q, parents %v", got, res.CommitHash, api.created[0].Parents)
+	}
+	if strings.Join(res.PlannedCommands, "\n") != strings.Join(plan.PlannedCommands, "\n") {
+		t.Fatalf("planned commands differ from dry run:\n%q\n%q", res.PlannedCommands, plan.PlannedCommands)
+	}
+	for path, want := range map[string][2]string{
+		"main.go":     {modified, "100644"},
+		"run.sh":      {"echo hello\n", "100755"},
+		"docs/new.md": {"# new\n", "100644"},
+		"old.txt":     {"", ""},
+	} {
+		if content, mode := api.file(t, "refs/heads/toolhub/api", path); content != want[0] || mode != want[1] {
+			t.Fatalf("%s = %q (%s), want %q (%s)", path, content, mode, want[0], want[1])
+		}
+	}
+	if !strings.Contains(res.AppliedPatch, "deleted file mode 100644") || !strings.Contains(res.AppliedPatch, "+\tfmt.Println(\"B\")") {
+		t.Fatalf("applied patch:\n%s", res.AppliedPatch)
+	}
+	if res.Verification == nil || !res.Verification.Verified || res.Verification.Method != SignAPI {
+		t.Fatalf("verification = %+v", res.Verification)
+	}
+
+	if _, err := r.RollbackBranch(context.Background(), "acme/app", "main", "toolhub/api", false); err != nil {
+		t.Fatalf("RollbackBranch: %v", err)
+	}
+	if _, ok := api.refs["refs/heads/toolhub/api"]; ok {
+		t.Fatal("head ref not deleted")
+	}
+}
+
+func TestRunnerExecuteAPIBackendRejectsStaleAndConflicts(t *testing.T) {
+	api := newFakeGitData()
+	api.seed("main", map[string]string{"main.go": applyBase})
+	r := NewRunner(Config{Backend: BackendAPI, GitData: api})
+	req := Request{Repo: "acme/app", BaseBranch: "main", HeadBranch: "toolhub/api", CommitMessage: "change"}
+
+	req.Files = []FileChange{{Path: "main.go", OriginalContent: "package main\n", ModifiedContent: "x\n"}}
+	var stale *StaleContentError
+	if _, err := r.Execute(context.Background(), req); !errors.As(err, &stale) {
+		t.Fatalf("err = %v, want stale content", err)
+	}
+	req.Files = []FileChange{{Path: "main.go", Edits: []EditBlock{{Search: "missing", Replace: "x"}}}}
+	var conflict *ConflictError
+	if _, err := r.Execute(context.Background(), req); !errors.As(err, &conflict) {
+		t.Fatalf("err = %v, want conflict", err)
+	}
+	if len(api.created) != 0 || len(api.refs) != 1 {
+		t.Fatalf("commits %d, refs %v", len(api.created), api.refs)
+	}
+}
+
+func TestRunnerSetRefFastForwardsExistingHead(t *testing.T) {
+	api := newFakeGitData()
+	api.seed("main", map[string]string{"main.go": applyBase})
+	api.refs["refs/heads/toolhub/api"] = api.refs["refs/heads/main"]
+	r := NewRunner(Config{Backend: BackendAPI, GitData: api})
+
+	res, err := r.Execute(context.Background(), Request{
+		Repo: "acme/app", BaseBranch: "main", HeadBranch: "toolhub/api", CommitMessage: "change",
+		Files: []FileChange{{Path: "a.txt", ModifiedContent: "a\n"}},
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if len(api.updatedRefs) != 1 || api.refs["refs/heads/toolhub/api"] != res.CommitHash {
+		t.Fatalf("updated %v, refs %v", api.updatedRefs, api.refs)
+	}
+}
@@ -0,0 +1,345 @@
+package codeops
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EditBlock replaces the single occurrence of Search with Replace.
+type EditBlock struct {
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+}
+
+// ChangeMode is how a FileChange describes the new file content.
+type ChangeMode string
+
+const (
+	ModeContent ChangeMode = "content"
+	ModePatch   ChangeMode = "patch"
+	ModeEdits   ChangeMode = "edits"
+)
+
+// Mode reports how f describes its change. Exactly one of ModifiedContent,
+// Patch or Edits may be set; an empty ModifiedContent alone means content
+// mode, which truncates the file.
+func (f FileChange) Mode() (ChangeMode, error) {
+	set := 0
+	mode := ModeContent
+	if f.ModifiedContent != "" {
+		set++
+	}
+	if f.Patch != "" {
+		set++
+		mode = ModePatch
+	}
+	if len(f.Edits) > 0 {
+		set++
+		mode = ModeEdits
+	}
+	if set > 1 {
+		return "", fmt.Errorf("file %q: set only one of modified_content, patch or edits", f.Path)
+	}
+	return mode, nil
+}
+
+// HunkConflict describes one patch hunk or edit block that does not apply
+// to the base content.
+type HunkConflict struct {
+	Path   string `json:"path"`
+	Hunk   int    `json:"hunk"`
+	Header string `json:"header,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (c HunkConflict) String() string {
+	if c.Header != "" {
+		return fmt.Sprintf("%s: hunk %d (%s): %s", c.Path, c.Hunk, c.Header, c.Reason)
+	}
+	return fmt.Sprintf("%s: edit %d: %s", c.Path, c.Hunk, c.Reason)
+}
+
+// ConflictError is returned when patches or edit blocks do not apply to the
+// base branch. Every failing hunk is listed, not only the first.
+type ConflictError struct {
+	Conflicts []HunkConflict
+}
+
+func (e *ConflictError) Error() string {
+	parts := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		parts = append(parts, c.String())
+	}
+	return "patch does not apply: " + strings.Join(parts, "; ")
+}
+
+func (e *ConflictError) ErrorCode() string { return "patch_conflict" }
+
+// applied is the outcome of applying one FileChange to its base content.
+type applied struct {
+	content string
+	created bool
+	deleted bool
+}
+
+// applyChange computes the new content of f from the base content. exists
+// reports whether the file is present on the base branch.
+func applyChange(f FileChange, path, base string, exists bool) (applied, []HunkConflict, error) {
+	mode, err := f.Mode()
+	if err != nil {
+		return applied{}, nil, err
+	}
+	switch mode {
+	case ModePatch:
+		return applyUnifiedDiff(path, base, exists, f.Patch)
+	case ModeEdits:
+		if !exists {
+			return applied{}, nil, fmt.Errorf("file %q does not exist on the base branch; edits need existing content", path)
+		}
+		out, conflicts := applyEdits(path, base, f.Edits)
+		return applied{content: out}, conflicts, nil
+	default:
+		return applied{content: f.ModifiedContent, created: !exists}, nil, nil
+	}
+}
+
+func applyEdits(path, content string, edits []EditBlock) (string, []HunkConflict) {
+	var conflicts []HunkConflict
+	for i, e := range edits {
+		conflict := HunkConflict{Path: path, Hunk: i + 1}
+		switch n := strings.Count(content, e.Search); {
+		case e.Search == "":
+			conflict.Reason = "search text is empty"
+		case n == 0:
+			conflict.Reason = fmt.Sprintf("search text not found: %q", firstLine(e.Search))
+		case n > 1:
+			conflict.Reason = fmt.Sprintf("search text matches %d times; include more surrounding lines", n)
+		default:
+			content = strings.Replace(content, e.Search, e.Replace, 1)
+			continue
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return content, conflicts
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+type patchHunk struct {
+	header   string
+	oldStart int
+	oldLines []string
+	newLines []string
+}
+
+// applyUnifiedDiff applies a single-file unified diff. Hunks are matched at
+// their recorded position first and then at the nearest offset, like git
+// apply without fuzz.
+func applyUnifiedDiff(path, base string, exists bool, patch string) (applied, []HunkConflict, error) {
+	hunks, oldName, newName, err := parseUnifiedDiff(path, patch)
+	if err != nil {
+		return applied{}, nil, err
+	}
+	out := applied{created: oldName == "/dev/null", deleted: newName == "/dev/null"}
+	if out.created && exists {
+		return applied{}, nil, fmt.Errorf("file %q: patch creates a file that already exists on the base branch", path)
+	}
+	if !out.created && !exists {
+		return applied{}, nil, fmt.Errorf("file %q does not exist on the base branch", path)
+	}
+
+	lines := splitKeepEOL(base)
+	var result []string
+	var conflicts []HunkConflict
+	pos, drift := 0, 0
+	for i, h := range hunks {
+		expected := h.oldStart - 1
+		if len(h.oldLines) == 0 {
+			expected = h.oldStart
+		}
+		at := findBlock(lines, h.oldLines, pos, expected+drift)
+		if at < 0 {
+			conflicts = append(conflicts, HunkConflict{Path: path, Hunk: i + 1, Header: h.header, Reason: mismatchReason(lines, h.oldLines, expected+drift)})
+			continue
+		}
+		result = append(result, lines[pos:at]...)
+		result = append(result, h.newLines...)
+		pos = at + len(h.oldLines)
+		drift = at - expected
+	}
+	if len(conflicts) > 0 {
+		return applied{}, conflicts, nil
+	}
+	result = append(result, lines[pos:]...)
+	out.content = strings.Join(result, "")
+	if out.deleted && out.content != "" {
+		return applied{}, nil, fmt.Errorf("file %q: deletion patch leaves content behind", path)
+	}
+	return out, nil, nil
+}
+
+func parseUnifiedDiff(path, patch string) ([]patchHunk, string, string, error) {
+	lines := strings.Split(strings.TrimSuffix(patch, "\n"), "\n")
+	var hunks []patchHunk
+	var oldName, newName string
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			if oldName != "" || len(hunks) > 0 {
+				return nil, "", "", fmt.Errorf("file %q: patch must describe a single file", path)
+			}
+		case strings.HasPrefix(line, "--- ") && len(hunks) == 0:
+			oldName = patchName(line[4:])
+		case strings.HasPrefix(line, "+++ ") && len(hunks) == 0:
+			newName = patchName(line[4:])
+		case strings.HasPrefix(line, "@@"):
+			m := hunkHeaderRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, "", "", fmt.Errorf("file %q: malformed hunk header %q", path, line)
+			}
+			h := patchHunk{header: strings.TrimSpace(line[:len(m[0])])}
+			h.oldStart, _ = strconv.Atoi(m[1])
+			oldCount, newCount := hunkCount(m[2]), hunkCount(m[4])
+			for i+1 < len(lines) && (len(h.oldLines) < oldCount || len(h.newLines) < newCount || strings.HasPrefix(lines[i+1], `\`)) {
+				i++
+				body := lines[i]
+				if strings.HasPrefix(body, `\`) {
+					// "\ No newline at end of file" strips the previous line's newline.
+					trimLastNewline(&h, lines[i-1])
+					continue
+				}
+				kind, text := byte(' '), ""
+				if body != "" {
+					kind, text = body[0], body[1:]
+				}
+				text += "\n"
+				switch kind {
+				case ' ':
+					h.oldLines = append(h.oldLines, text)
+					h.newLines = append(h.newLines, text)
+				case '-':
+					h.oldLines = append(h.oldLines, text)
+				case '+':
+					h.newLines = append(h.newLines, text)
+				default:
+					return nil, "", "", fmt.Errorf("file %q: unexpected line in %s: %q", path, h.header, body)
+				}
+			}
+			if len(h.oldLines) != oldCount || len(h.newLines) != newCount {
+				return nil, "", "", fmt.Errorf("file %q: truncated hunk %s", path, h.header)
+			}
+			hunks = append(hunks, h)
+		case strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ "):
+			return nil, "", "", fmt.Errorf("file %q: patch must describe a single file", path)
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, "", "", fmt.Errorf("file %q: patch has no hunks", path)
+	}
+	for _, name := range []string{oldName, newName} {
+		if name != "" && name != "/dev/null" && name != path {
+			return nil, "", "", fmt.Errorf("file %q: patch header names %q", path, name)
+		}
+	}
+	return hunks, oldName, newName, nil
+}
+
+// patchName strips the a/ or b/ prefix and any trailing timestamp.
+func patchName(s string) string {
+	if tab := strings.IndexByte(s, '\t'); tab >= 0 {
+		s = s[:tab]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return s
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+func hunkCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func trimLastNewline(h *patchHunk, prev string) {
+	trim := func(lines []string) {
+		if n := len(lines); n > 0 {
+			lines[n-1] = strings.TrimSuffix(lines[n-1], "\n")
+		}
+	}
+	switch {
+	case strings.HasPrefix(prev, "-"):
+		trim(h.oldLines)
+	case strings.HasPrefix(prev, "+"):
+		trim(h.newLines)
+	default:
+		trim(h.oldLines)
+		trim(h.newLines)
+	}
+}
+
+// findBlock returns the index at or after from where block occurs, preferring
+// the position closest to want, or -1.
+func findBlock(lines, block []string, from, want int) int {
+	matches := func(at int) bool {
+		if at < from || at+len(block) > len(lines) {
+			return false
+		}
+		for i, l := range block {
+			if lines[at+i] != l {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; want-d >= from || want+d <= len(lines); d++ {
+		if matches(want - d) {
+			return want - d
+		}
+		if d > 0 && matches(want+d) {
+			return want + d
+		}
+	}
+	return -1
+}
+
+// mismatchReason names the first line that differs at the expected position.
+func mismatchReason(lines, block []string, at int) string {
+	for i, want := range block {
+		n := at + i
+		if n < 0 || n >= len(lines) {
+			return fmt.Sprintf("expected %q at line %d, found end of file", strings.TrimSuffix(want, "\n"), n+1)
+		}
+		if lines[n] != want {
+			return fmt.Sprintf("expected %q at line %d, found %q", strings.TrimSuffix(want, "\n"), n+1, strings.TrimSuffix(lines[n], "\n"))
+		}
+	}
+	return "context lines not found"
+}
+
+func splitKeepEOL(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i] + "..."
+	}
+	return s
+}
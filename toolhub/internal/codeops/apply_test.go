@@ -0,0 +1,236 @@
+package codeops
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/toolhub/toolhub/internal/core"
+)
+
+const applyBase = "package main\n\nimport \"fmt\"\n\nfunc a() {\n\tfmt.Println(\"a\")\n}\n\nfunc b() {\n\tfmt.Println(\"b\")\n}\n\nfunc c() {\n\tfmt.Println(\"c\")\n}\n"
+
+func TestApplyChange(t *testing.T) {
+	modified := strings.Replace(strings.Replace(applyBase, `"a"`, `"A"`, 1), `"c"`, `"C"`, 1)
+	generated := core.GenerateUnifiedDiffWithOptions("main.go", applyBase, modified, core.DiffOptions{Context: 1})
+	// The same patch against a base with two extra lines on top must still
+	// apply, at an offset.
+	shifted := "// header\n// header\n" + applyBase
+
+	tests := []struct {
+		name          string
+		change        FileChange
+		base          string
+		exists        bool
+		want          string
+		wantCreated   bool
+		wantDeleted   bool
+		wantConflicts []string
+		wantErr       string
+	}{
+		{name: "full content", change: FileChange{Path: "main.go", ModifiedContent: modified}, base: applyBase, exists: true, want: modified},
+		{name: "generated patch", change: FileChange{Path: "main.go", Patch: generated}, base: applyBase, exists: true, want: modified},
+		{name: "patch at offset", change: FileChange{Path: "main.go", Patch: generated}, base: shifted, exists: true, want: "// header\n// header\n" + modified},
+		{
+			name:   "hunks without file headers",
+			change: FileChange{Path: "main.go", Patch: "@@ -10 +10 @@\n-\tfmt.Println(\"b\")\n+\tfmt.Println(\"B\")\n"},
+			base:   applyBase,
+			exists: true,
+			want:   strings.Replace(applyBase, `"b"`, `"B"`, 1),
+		},
+		{
+			name:          "conflicts are reported per hunk",
+			change:        FileChange{Path: "main.go", Patch: generated},
+			base:          strings.Replace(strings.Replace(applyBase, `"a"`, `"x"`, 1), `"c"`, `"y"`, 1),
+			exists:        true,
+			wantConflicts: []string{`main.go: hunk 1 (@@ -5,3 +5,3 @@): expected "\tfmt.Println(\"a\")" at line 6, found "\tfmt.Println(\"x\")"`, `main.go: hunk 2 (@@ -13,3 +13,3 @@)`},
+		},
+		{
+			name:        "new file patch",
+			change:      FileChange{Path: "new.txt", Patch: core.GenerateUnifiedDiffWithOptions("new.txt", "", "hello\n", core.DiffOptions{NewFile: true})},
+			want:        "hello\n",
+			wantCreated: true,
+		},
+		{
+			name:    "new file patch on existing file",
+			change:  FileChange{Path: "new.txt", Patch: core.GenerateUnifiedDiffWithOptions("new.txt", "", "hello\n", core.DiffOptions{NewFile: true})},
+			base:    "old\n",
+			exists:  true,
+			wantErr: "already exists",
+		},
+		{
+			name:        "delete file patch",
+			change:      FileChange{Path: "old.txt", Patch: core.GenerateUnifiedDiffWithOptions("old.txt", "bye\n", "", core.DiffOptions{DeletedFile: true})},
+			base:        "bye\n",
+			exists:      true,
+			wantDeleted: true,
+		},
+		{
+			name:    "patch for another file",
+			change:  FileChange{Path: "main.go", Patch: strings.ReplaceAll(generated, "main.go", "other.go")},
+			base:    applyBase,
+			exists:  true,
+			wantErr: `patch header names "other.go"`,
+		},
+		{
+			name:    "multi-file patch",
+			change:  FileChange{Path: "main.go", Patch: generated + strings.ReplaceAll(generated, "main.go", "other.go")},
+			base:    applyBase,
+			exists:  true,
+			wantErr: "single file",
+		},
+		{
+			name: "edit blocks",
+			change: FileChange{Path: "main.go", Edits: []EditBlock{
+				{Search: "func a() {\n\tfmt.Println(\"a\")", Replace: "func a() {\n\tfmt.Println(\"A\")"},
+				{Search: `fmt.Println("c")`, Replace: `fmt.Println("C")`},
+			}},
+			base:   applyBase,
+			exists: true,
+			want:   modified,
+		},
+		{
+			name: "edit block conflicts",
+			change: FileChange{Path: "main.go", Edits: []EditBlock{
+				{Search: "missing line", Replace: "x"},
+				{Search: "fmt.Println", Replace: "log.Println"},
+			}},
+			base:          applyBase,
+			exists:        true,
+			wantConflicts: []string{`main.go: edit 1: search text not found: "missing line"`, "main.go: edit 2: search text matches 3 times"},
+		},
+		{
+			name:    "edits on missing file",
+			change:  FileChange{Path: "main.go", Edits: []EditBlock{{Search: "a", Replace: "b"}}},
+			wantErr: "does not exist on the base branch",
+		},
+		{
+			name:    "more than one mode",
+			change:  FileChange{Path: "main.go", ModifiedContent: "x", Patch: generated},
+			base:    applyBase,
+			exists:  true,
+			wantErr: "set only one of",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, conflicts, err := applyChange(tc.change, tc.change.Path, tc.base, tc.exists)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(conflicts) != len(tc.wantConflicts) {
+				t.Fatalf("conflicts = %v, want %d", conflicts, len(tc.wantConflicts))
+			}
+			for i, want := range tc.wantConflicts {
+				if !strings.HasPrefix(conflicts[i].String(), want) {
+					t.Fatalf("conflict %d = %q, want prefix %q", i, conflicts[i].String(), want)
+				}
+			}
+			if len(conflicts) > 0 {
+				return
+			}
+			if got.content != tc.want || got.created != tc.wantCreated || got.deleted != tc.wantDeleted {
+				t.Fatalf("applied = %+v, want content %q created=%v deleted=%v", got, tc.want, tc.wantCreated, tc.wantDeleted)
+			}
+		})
+	}
+}
+
+func gitT(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newTestRepo returns a clone of a bare remote whose main branch holds
+// main.go with applyBase.
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	remote := filepath.Join(root, "remote.git")
+	work := filepath.Join(root, "work")
+	gitT(t, root, "init", "-q", "--bare", "-b", "main", remote)
+	gitT(t, root, "clone", "-q", remote, work)
+	gitT(t, work, "config", "user.email", "toolhub@example.com")
+	gitT(t, work, "config", "user.name", "ToolHub Test")
+	gitT(t, work, "checkout", "-q", "-b", "main")
+	if err := os.WriteFile(filepath.Join(work, "main.go"), []byte(applyBase), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gitT(t, work, "add", "main.go")
+	gitT(t, work, "commit", "-q", "-m", "base")
+	gitT(t, work, "push", "-q", "origin", "main")
+	return work
+}
+
+func TestRunnerExecuteAppliesPatchAgainstBase(t *testing.T) {
+	work := newTestRepo(t)
+	modified := strings.Replace(applyBase, `"a"`, `"A"`, 1)
+	r := NewRunner(Config{WorkDir: work})
+
+	res, err := r.Execute(context.Background(), Request{
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/apply",
+		CommitMessage: "apply changes",
+		Files: []FileChange{
+			{Path: "main.go", Patch: core.GenerateUnifiedDiff("main.go", applyBase, modified)},
+			{Path: "docs/new.md", ModifiedContent: "# new\n"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if got := gitT(t, work, "show", "toolhub/apply:main.go"); got != strings.TrimSuffix(modified, "\n") {
+		t.Fatalf("committed main.go = %q", got)
+	}
+	if !strings.Contains(res.AppliedPatch, "+\tfmt.Println(\"A\")") || !strings.Contains(res.AppliedPatch, "new file mode 100644\n--- /dev/null\n+++ b/docs/new.md") {
+		t.Fatalf("applied patch missing changes:\n%s", res.AppliedPatch)
+	}
+	if res.CommitHash != gitT(t, work, "rev-parse", "toolhub/apply") {
+		t.Fatalf("commit hash = %q", res.CommitHash)
+	}
+}
+
+func TestRunnerExecuteConflictLeavesTreeUntouched(t *testing.T) {
+	work := newTestRepo(t)
+	r := NewRunner(Config{WorkDir: work})
+
+	res, err := r.Execute(context.Background(), Request{
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/conflict",
+		CommitMessage: "apply changes",
+		Files: []FileChange{
+			{Path: "main.go", Edits: []EditBlock{{Search: "func z()", Replace: "func y()"}}},
+		},
+	})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || len(conflict.Conflicts) != 1 {
+		t.Fatalf("err = %v, want one conflict", err)
+	}
+	if res == nil || len(res.Conflicts) != 1 {
+		t.Fatalf("result conflicts = %+v", res)
+	}
+	if out := gitT(t, work, "branch", "--list", "toolhub/conflict"); out != "" {
+		t.Fatalf("head branch was created: %q", out)
+	}
+	if status := gitT(t, work, "status", "--porcelain"); status != "" {
+		t.Fatalf("work tree changed: %q", status)
+	}
+}
@@ -0,0 +1,54 @@
+package codeops
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// AuthorSource resolves the identity commits are authored and committed as,
+// normally the GitHub App's bot user.
+type AuthorSource interface {
+	BotIdentity(ctx context.Context) (name, email string, err error)
+}
+
+// commitEnv sets author and committer to the configured identity. Without
+// an AuthorSource git falls back to its own configuration.
+func (r *Runner) commitEnv(ctx context.Context) ([]string, error) {
+	if r.cfg.Author == nil {
+		return nil, nil
+	}
+	name, email, err := r.cfg.Author.BotIdentity(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("resolve commit author: %w", err)
+	}
+	return []string{
+		"GIT_AUTHOR_NAME=" + name,
+		"GIT_AUTHOR_EMAIL=" + email,
+		"GIT_COMMITTER_NAME=" + name,
+		"GIT_COMMITTER_EMAIL=" + email,
+	}, nil
+}
+
+// CoAuthor turns a principal into a Co-authored-by identity. Principals that
+// are not an email address, with or without a display name, yield "".
+func CoAuthor(principal string) string {
+	addr, err := mail.ParseAddress(strings.TrimSpace(principal))
+	if err != nil {
+		return ""
+	}
+	name := strings.TrimSpace(addr.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(addr.Address, "@")
+	}
+	return fmt.Sprintf("%s <%s>", name, addr.Address)
+}
+
+// commitMessage appends the Co-authored-by trailer when coAuthor is set.
+func commitMessage(msg, coAuthor string) string {
+	if coAuthor == "" {
+		return msg
+	}
+	return strings.TrimRight(msg, "\n") + "\n\nCo-authored-by: " + coAuthor
+}
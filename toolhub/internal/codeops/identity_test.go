@@ -0,0 +1,51 @@
+package codeops
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type fakeAuthor struct{}
+
+func (fakeAuthor) BotIdentity(context.Context) (string, string, error) {
+	return "toolhub[bot]", "42+toolhub[bot]@users.noreply.github.com", nil
+}
+
+func TestCoAuthor(t *testing.T) {
+	tests := map[string]string{
+		"Jane Doe <jane@example.com>": "Jane Doe <jane@example.com>",
+		"jane@example.com":            "jane <jane@example.com>",
+		"agent-7":                     "",
+		"":                            "",
+	}
+	for principal, want := range tests {
+		if got := CoAuthor(principal); got != want {
+			t.Fatalf("CoAuthor(%q) = %q, want %q", principal, got, want)
+		}
+	}
+}
+
+func TestRunnerExecuteCommitsAsBot(t *testing.T) {
+	work := newTestRepo(t)
+	r := NewRunner(Config{WorkDir: work, Author: fakeAuthor{}})
+
+	res, err := r.Execute(context.Background(), Request{
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/bot",
+		CommitMessage: "bot change\n",
+		CoAuthor:      "Jane Doe <jane@example.com>",
+		Files:         []FileChange{{Path: "bot.txt", ModifiedContent: "bot\n"}},
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	got := gitT(t, work, "log", "-1", "--format=%an <%ae>|%cn <%ce>|%B", res.CommitHash)
+	bot := "toolhub[bot] <42+toolhub[bot]@users.noreply.github.com>"
+	if want := bot + "|" + bot + "|bot change\n\nCo-authored-by: Jane Doe <jane@example.com>"; got != want {
+		t.Fatalf("commit = %q, want %q", got, want)
+	}
+	if !strings.Contains(res.PlannedCommands[len(res.PlannedCommands)-3], "Co-authored-by: Jane Doe") {
+		t.Fatalf("planned commit lacks trailer: %q", res.PlannedCommands)
+	}
+}
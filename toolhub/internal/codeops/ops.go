@@ -0,0 +1,355 @@
+package codeops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/toolhub/toolhub/internal/core"
+)
+
+// File operations of a FileChange.
+const (
+	OpWrite  = "write"
+	OpDelete = "delete"
+	OpRename = "rename"
+	OpChmod  = "chmod"
+)
+
+const (
+	modeRegular    = "100644"
+	modeExecutable = "100755"
+	modeSymlink    = "120000"
+)
+
+// Operation returns the operation of f, OpWrite by default, after checking
+// that f sets exactly the fields the operation uses.
+func (f FileChange) Operation() (string, error) {
+	if _, err := f.Mode(); err != nil {
+		return "", err
+	}
+	op := f.Op
+	if op == "" {
+		op = OpWrite
+	}
+	switch f.FileMode {
+	case "", modeRegular, modeExecutable:
+	default:
+		return "", fmt.Errorf("file %q: file_mode must be %s or %s", f.Path, modeRegular, modeExecutable)
+	}
+	if f.NewPath != "" && op != OpRename {
+		return "", fmt.Errorf("file %q: new_path is only valid for rename", f.Path)
+	}
+	switch op {
+	case OpWrite:
+	case OpDelete:
+		if f.hasContent() || f.FileMode != "" {
+			return "", fmt.Errorf("file %q: delete takes no content or file_mode", f.Path)
+		}
+	case OpRename:
+		if strings.TrimSpace(f.NewPath) == "" {
+			return "", fmt.Errorf("file %q: rename requires new_path", f.Path)
+		}
+	case OpChmod:
+		if f.FileMode == "" {
+			return "", fmt.Errorf("file %q: chmod requires file_mode", f.Path)
+		}
+		if f.hasContent() {
+			return "", fmt.Errorf("file %q: chmod takes no content", f.Path)
+		}
+	default:
+		return "", fmt.Errorf("file %q: unknown op %q (want write, delete, rename or chmod)", f.Path, f.Op)
+	}
+	return op, nil
+}
+
+// Paths lists every path f touches: its path and, for a rename, the
+// destination. Path policy applies to all of them.
+func (f FileChange) Paths() []string {
+	if f.NewPath != "" {
+		return []string{f.Path, f.NewPath}
+	}
+	return []string{f.Path}
+}
+
+func (f FileChange) hasContent() bool {
+	return f.ModifiedContent != "" || f.Patch != "" || len(f.Edits) > 0
+}
+
+// changeCommands describes how one file change is applied. wt is the
+// worktree placeholder of the local backend, or "" when no git commands run
+// locally.
+func changeCommands(f FileChange, wt string) ([]string, error) {
+	op, err := f.Operation()
+	if err != nil {
+		return nil, err
+	}
+	path, err := safeRelativePath(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []string
+	switch op {
+	case OpDelete:
+		if wt == "" {
+			return []string{fmt.Sprintf("delete %q", path)}, nil
+		}
+		return []string{fmt.Sprintf("git -C %s rm -q -- %q", wt, path)}, nil
+	case OpRename:
+		newPath, err := safeRelativePath(f.NewPath)
+		if err != nil {
+			return nil, err
+		}
+		if wt == "" {
+			commands = append(commands, fmt.Sprintf("rename %q %q", path, newPath))
+		} else {
+			commands = append(commands, fmt.Sprintf("git -C %s mv -- %q %q", wt, path, newPath))
+		}
+		path = newPath
+		if f.hasContent() {
+			commands = append(commands, contentCommand(f, path))
+		}
+	case OpWrite:
+		commands = append(commands, contentCommand(f, path))
+	}
+	if f.FileMode != "" {
+		commands = append(commands, fmt.Sprintf("chmod %s %q", permString(f.FileMode), path))
+	}
+	if wt != "" {
+		commands = append(commands, fmt.Sprintf("git -C %s add -- %q", wt, path))
+	}
+	return commands, nil
+}
+
+func contentCommand(f FileChange, path string) string {
+	switch {
+	case f.Patch != "":
+		return fmt.Sprintf("apply patch %q", path)
+	case len(f.Edits) > 0:
+		return fmt.Sprintf("apply %d edit(s) %q", len(f.Edits), path)
+	}
+	return fmt.Sprintf("write %q", path)
+}
+
+func permString(mode string) string {
+	if mode == modeExecutable {
+		return "755"
+	}
+	return "644"
+}
+
+// resolvedChange is a FileChange applied to the base branch.
+type resolvedChange struct {
+	applied
+	path string
+	// oldPath is the source of a rename.
+	oldPath string
+	mode    string
+	oldMode string
+}
+
+// baseFile is a file on the base branch.
+type baseFile struct {
+	content []byte
+	mode    string
+}
+
+// baseReader returns path on the base branch and whether it exists there.
+type baseReader func(path string) (baseFile, bool, error)
+
+// readFromGit reads files as committed at ref in the repository of dir,
+// not from its checkout, so a symlink committed there cannot point a read
+// at a file of the host. Paths that are, or lie below, a symlink fail.
+func readFromGit(ctx context.Context, dir, ref string) baseReader {
+	return func(path string) (baseFile, bool, error) {
+		args := []string{"--literal-pathspecs", "ls-tree", "-z", ref, "--", path}
+		for parent := filepath.Dir(path); parent != "."; parent = filepath.Dir(parent) {
+			args = append(args, parent)
+		}
+		out, err := runGitOutput(ctx, dir, args...)
+		if err != nil {
+			return baseFile{}, false, fmt.Errorf("read file %q: %w", path, err)
+		}
+		var mode, kind, sha string
+		for _, entry := range strings.Split(out, "\x00") {
+			meta, name, ok := strings.Cut(entry, "\t")
+			fields := strings.Fields(meta)
+			if !ok || len(fields) != 3 {
+				continue
+			}
+			if fields[0] == modeSymlink {
+				return baseFile{}, false, fmt.Errorf("file %q: %q is a symlink on the base branch", path, name)
+			}
+			if name == path {
+				mode, kind, sha = fields[0], fields[1], fields[2]
+			}
+		}
+		if sha == "" {
+			return baseFile{}, false, nil
+		}
+		if kind != "blob" {
+			return baseFile{}, false, fmt.Errorf("file %q is a %s on the base branch, not a file", path, kind)
+		}
+		content, err := runGitOutput(ctx, dir, "cat-file", "blob", sha)
+		if err != nil {
+			return baseFile{}, false, fmt.Errorf("read file %q: %w", path, err)
+		}
+		f := baseFile{content: []byte(content), mode: modeRegular}
+		if mode == modeExecutable {
+			f.mode = modeExecutable
+		}
+		return f, true, nil
+	}
+}
+
+// noSymlinks fails when path, or a directory on the way to it, is a
+// symlink in dir, so a write cannot leave the worktree.
+func noSymlinks(dir, path string) error {
+	full := dir
+	for _, part := range strings.Split(path, "/") {
+		full = filepath.Join(full, part)
+		info, err := os.Lstat(full)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("stat file %q: %w", path, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("file %q: %q is a symlink", path, strings.TrimPrefix(full, dir+string(filepath.Separator)))
+		}
+	}
+	return nil
+}
+
+// resolveChanges reads each file from the base and computes its new
+// content, collecting stale originals and conflicts across all files.
+func resolveChanges(read baseReader, files []FileChange) ([]resolvedChange, string, []HunkConflict, []StaleFile, error) {
+	changes := make([]resolvedChange, 0, len(files))
+	var patch strings.Builder
+	var conflicts []HunkConflict
+	var stale []StaleFile
+	for _, f := range files {
+		op, err := f.Operation()
+		if err != nil {
+			return nil, "", nil, nil, err
+		}
+		path, err := safeRelativePath(f.Path)
+		if err != nil {
+			return nil, "", nil, nil, err
+		}
+		base, exists, err := read(path)
+		if err != nil {
+			return nil, "", nil, nil, err
+		}
+		if base.mode == modeSymlink {
+			return nil, "", nil, nil, fmt.Errorf("file %q is a symlink on the base branch", path)
+		}
+		if s, ok := checkOriginal(f, path, base.content, exists); !ok {
+			stale = append(stale, s)
+			continue
+		}
+		if op != OpWrite && !exists {
+			return nil, "", nil, nil, fmt.Errorf("file %q does not exist on the base branch; %s needs an existing file", path, op)
+		}
+
+		c := resolvedChange{path: path, oldMode: base.mode}
+		var fileConflicts []HunkConflict
+		switch op {
+		case OpDelete:
+			c.applied = applied{deleted: true}
+		case OpChmod:
+			c.applied = applied{content: string(base.content)}
+		case OpRename:
+			if c.path, err = safeRelativePath(f.NewPath); err != nil {
+				return nil, "", nil, nil, err
+			}
+			if c.path == path {
+				return nil, "", nil, nil, fmt.Errorf("file %q: new_path must differ from path", path)
+			}
+			if _, taken, err := read(c.path); err != nil {
+				return nil, "", nil, nil, err
+			} else if taken {
+				return nil, "", nil, nil, fmt.Errorf("file %q: rename destination %q already exists on the base branch", path, c.path)
+			}
+			c.oldPath = path
+			c.applied = applied{content: string(base.content)}
+			if f.hasContent() {
+				c.applied, fileConflicts, err = applyChange(f, path, string(base.content), true)
+				if err == nil && (c.created || c.deleted) {
+					err = fmt.Errorf("file %q: a rename patch must modify the file, not create or delete it", path)
+				}
+			}
+		default:
+			c.applied, fileConflicts, err = applyChange(f, path, string(base.content), exists)
+		}
+		if err != nil {
+			return nil, "", nil, nil, err
+		}
+		if len(fileConflicts) > 0 {
+			conflicts = append(conflicts, fileConflicts...)
+			continue
+		}
+
+		switch {
+		case f.FileMode != "":
+			c.mode = f.FileMode
+		case exists:
+			c.mode = base.mode
+		default:
+			c.mode = modeRegular
+		}
+		if c.deleted {
+			c.mode = c.oldMode
+		}
+		changes = append(changes, c)
+		patch.WriteString(core.GenerateUnifiedDiffWithOptions(c.path, string(base.content), c.content, core.DiffOptions{
+			Context:     core.DefaultDiffContext,
+			NewFile:     c.created,
+			DeletedFile: c.deleted,
+			OldPath:     c.oldPath,
+			OldMode:     c.oldMode,
+			NewMode:     c.mode,
+		}))
+	}
+	return changes, patch.String(), conflicts, stale, nil
+}
+
+// applyLocal applies a resolved change to the worktree and stages it.
+func applyLocal(ctx context.Context, dir string, c resolvedChange) error {
+	for _, path := range []string{c.oldPath, c.path} {
+		if path == "" {
+			continue
+		}
+		if err := noSymlinks(dir, path); err != nil {
+			return err
+		}
+	}
+	if c.deleted {
+		return runGit(ctx, dir, "rm", "-q", "--", c.path)
+	}
+	full := filepath.Join(dir, c.path)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return fmt.Errorf("mkdir for file %q: %w", c.path, err)
+	}
+	if c.oldPath != "" {
+		if err := runGit(ctx, dir, "mv", "--", c.oldPath, c.path); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(full, []byte(c.content), 0o644); err != nil {
+		return fmt.Errorf("write file %q: %w", c.path, err)
+	}
+	perm := os.FileMode(0o644)
+	if c.mode == modeExecutable {
+		perm = 0o755
+	}
+	if err := os.Chmod(full, perm); err != nil {
+		return fmt.Errorf("chmod file %q: %w", c.path, err)
+	}
+	return runGit(ctx, dir, "add", "--", c.path)
+}
@@ -0,0 +1,245 @@
+package codeops
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileChangeOperation(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  FileChange
+		want    string
+		wantErr string
+	}{
+		{name: "write by default", change: FileChange{Path: "a.go", ModifiedContent: "x"}, want: OpWrite},
+		{name: "write executable", change: FileChange{Path: "run.sh", ModifiedContent: "x", FileMode: "100755"}, want: OpWrite},
+		{name: "delete", change: FileChange{Path: "a.go", Op: OpDelete}, want: OpDelete},
+		{name: "delete with content", change: FileChange{Path: "a.go", Op: OpDelete, ModifiedContent: "x"}, wantErr: "delete takes no content"},
+		{name: "rename", change: FileChange{Path: "a.go", Op: OpRename, NewPath: "b.go"}, want: OpRename},
+		{name: "rename with edits", change: FileChange{Path: "a.go", Op: OpRename, NewPath: "b.go", Edits: []EditBlock{{Search: "a", Replace: "b"}}}, want: OpRename},
+		{name: "rename without new path", change: FileChange{Path: "a.go", Op: OpRename}, wantErr: "rename requires new_path"},
+		{name: "new path on write", change: FileChange{Path: "a.go", NewPath: "b.go", ModifiedContent: "x"}, wantErr: "only valid for rename"},
+		{name: "chmod", change: FileChange{Path: "run.sh", Op: OpChmod, FileMode: "100755"}, want: OpChmod},
+		{name: "chmod without mode", change: FileChange{Path: "run.sh", Op: OpChmod}, wantErr: "chmod requires file_mode"},
+		{name: "chmod with content", change: FileChange{Path: "run.sh", Op: OpChmod, FileMode: "100755", Patch: "@@"}, wantErr: "chmod takes no content"},
+		{name: "unsupported mode", change: FileChange{Path: "run.sh", Op: OpChmod, FileMode: "120000"}, wantErr: "file_mode must be"},
+		{name: "unknown op", change: FileChange{Path: "a.go", Op: "copy"}, wantErr: `unknown op "copy"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.change.Operation()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("Operation() = %q, %v; want %q", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunnerExecuteFileOperations(t *testing.T) {
+	work := newTestRepo(t)
+	for path, content := range map[string]string{"old.txt": "bye\n", "tool.sh": "echo hi\n", "lib/util.go": "package lib\n\nconst name = \"util\"\n"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(work, path)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(work, path), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gitT(t, work, "add", ".")
+	gitT(t, work, "commit", "-q", "-m", "more files")
+	gitT(t, work, "push", "-q", "origin", "main")
+	r := NewRunner(Config{WorkDir: work})
+
+	files := []FileChange{
+		{Path: "old.txt", Op: OpDelete},
+		{Path: "tool.sh", Op: OpChmod, FileMode: "100755"},
+		{Path: "lib/util.go", Op: OpRename, NewPath: "pkg/util.go", Edits: []EditBlock{{Search: `"util"`, Replace: `"pkg"`}}},
+		{Path: "bin/run.sh", ModifiedContent: "#!/bin/sh\n", FileMode: "100755"},
+	}
+	plan, err := r.Execute(context.Background(), Request{BaseBranch: "main", HeadBranch: "toolhub/ops", CommitMessage: "ops", Files: files, DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	planned := strings.Join(plan.PlannedCommands, "\n")
+	for _, want := range []string{`rm -q -- "old.txt"`, `chmod 755 "tool.sh"`, `mv -- "lib/util.go" "pkg/util.go"`, `apply 1 edit(s) "pkg/util.go"`, `chmod 755 "bin/run.sh"`} {
+		if !strings.Contains(planned, want) {
+			t.Fatalf("planned commands missing %q:\n%s", want, planned)
+		}
+	}
+
+	res, err := r.Execute(context.Background(), Request{BaseBranch: "main", HeadBranch: "toolhub/ops", CommitMessage: "ops", Files: files})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	tree := gitT(t, work, "ls-tree", "-r", "--format=%(objectmode) %(path)", "toolhub/ops")
+	want := "100755 bin/run.sh\n100644 main.go\n100644 pkg/util.go\n100755 tool.sh"
+	if tree != want {
+		t.Fatalf("tree = %q, want %q", tree, want)
+	}
+	if got := gitT(t, work, "show", "toolhub/ops:pkg/util.go"); !strings.Contains(got, `"pkg"`) {
+		t.Fatalf("renamed file = %q", got)
+	}
+	for _, want := range []string{
+		"deleted file mode 100644",
+		"diff --git a/tool.sh b/tool.sh\nold mode 100644\nnew mode 100755\n",
+		"rename from lib/util.go\nrename to pkg/util.go\n",
+		"new file mode 100755",
+	} {
+		if !strings.Contains(res.AppliedPatch, want) {
+			t.Fatalf("applied patch missing %q:\n%s", want, res.AppliedPatch)
+		}
+	}
+
+	// The applied patch reproduces the commit on the base branch.
+	check := t.TempDir()
+	gitT(t, work, "worktree", "add", "-q", "--detach", check, "main")
+	patchFile := filepath.Join(t.TempDir(), "applied.diff")
+	if err := os.WriteFile(patchFile, []byte(res.AppliedPatch), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gitT(t, check, "apply", "--index", patchFile)
+	if got, want := gitT(t, check, "write-tree"), gitT(t, work, "rev-parse", "toolhub/ops^{tree}"); got != want {
+		t.Fatalf("applied patch tree = %s, commit tree = %s", got, want)
+	}
+}
+
+func TestRunnerExecuteRejectsInvalidFileOperations(t *testing.T) {
+	work := newTestRepo(t)
+	r := NewRunner(Config{WorkDir: work})
+
+	tests := []struct {
+		name    string
+		change  FileChange
+		wantErr string
+	}{
+		{name: "delete missing file", change: FileChange{Path: "missing.txt", Op: OpDelete}, wantErr: "does not exist on the base branch"},
+		{name: "rename onto existing file", change: FileChange{Path: "main.go", Op: OpRename, NewPath: "main.go"}, wantErr: "new_path must differ"},
+		{name: "rename outside the repository", change: FileChange{Path: "main.go", Op: OpRename, NewPath: "../x.go"}, wantErr: "path"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := r.Execute(context.Background(), Request{BaseBranch: "main", HeadBranch: "toolhub/bad", CommitMessage: "bad", Files: []FileChange{tc.change}})
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tc.wantErr)
+			}
+			if out := gitT(t, work, "branch", "--list", "toolhub/bad"); out != "" {
+				t.Fatalf("head branch was created: %q", out)
+			}
+		})
+	}
+}
+
+func TestRunnerExecuteAPIBackendRename(t *testing.T) {
+	api := newFakeGitData()
+	api.seed("main", map[string]string{"main.go": applyBase, "run.sh": "+x echo hi\n"})
+	r := NewRunner(Config{Backend: BackendAPI, GitData: api})
+
+	res, err := r.Execute(context.Background(), Request{
+		Repo:          "acme/app",
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/rename",
+		CommitMessage: "rename",
+		Files: []FileChange{
+			{Path: "main.go", Op: OpRename, NewPath: "cmd/app/main.go"},
+			{Path: "run.sh", Op: OpChmod, FileMode: "100644"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	for path, want := range map[string][2]string{
+		"main.go":         {"", ""},
+		"cmd/app/main.go": {applyBase, "100644"},
+		"run.sh":          {"echo hi\n", "100644"},
+	} {
+		if content, mode := api.file(t, "refs/heads/toolhub/rename", path); content != want[0] || mode != want[1] {
+			t.Fatalf("%s = %q (%s), want %q (%s)", path, content, mode, want[0], want[1])
+		}
+	}
+	if !strings.Contains(res.AppliedPatch, "similarity index 100%\nrename from main.go\nrename to cmd/app/main.go\n") ||
+		!strings.Contains(res.AppliedPatch, "old mode 100755\nnew mode 100644\n") {
+		t.Fatalf("applied patch:\n%s", res.AppliedPatch)
+	}
+}
+
+func TestRunnerExecuteRejectsSymlinks(t *testing.T) {
+	work := newTestRepo(t)
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(work, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(work, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+	gitT(t, work, "add", "link.txt", "linkdir")
+	gitT(t, work, "commit", "-q", "-m", "symlinks")
+	gitT(t, work, "push", "-q", "origin", "main")
+	r := NewRunner(Config{WorkDir: work})
+
+	tests := []struct {
+		name   string
+		change FileChange
+	}{
+		{name: "overwrite symlinked file", change: FileChange{Path: "link.txt", ModifiedContent: "pwned\n"}},
+		{name: "edit symlinked file", change: FileChange{Path: "link.txt", Edits: []EditBlock{{Search: "secret", Replace: "pwned"}}}},
+		{name: "chmod symlinked file", change: FileChange{Path: "link.txt", Op: OpChmod, FileMode: "100755"}},
+		{name: "create under symlinked directory", change: FileChange{Path: "linkdir/new.txt", ModifiedContent: "pwned\n"}},
+		{name: "rename into symlinked directory", change: FileChange{Path: "main.go", Op: OpRename, NewPath: "linkdir/main.go"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := r.Execute(context.Background(), Request{BaseBranch: "main", HeadBranch: "toolhub/link", CommitMessage: "link", Files: []FileChange{tc.change}})
+			if err == nil || !strings.Contains(err.Error(), "symlink") {
+				t.Fatalf("err = %v, want a symlink rejection", err)
+			}
+			if got, err := os.ReadFile(secret); err != nil || string(got) != "secret\n" {
+				t.Fatalf("file outside the repository = %q, %v", got, err)
+			}
+			if info, err := os.Stat(secret); err != nil || info.Mode()&0o111 != 0 {
+				t.Fatalf("file outside the repository mode = %v, %v", info, err)
+			}
+			entries, err := os.ReadDir(outside)
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("directory outside the repository has %d entries, %v", len(entries), err)
+			}
+		})
+	}
+}
+
+func TestRunnerExecuteReadsBaseWithoutGitStderr(t *testing.T) {
+	work := newTestRepo(t)
+	// GIT_TRACE makes git write to stderr on every run; none of it may end
+	// up in the content read from the base branch.
+	t.Setenv("GIT_TRACE", "1")
+	r := NewRunner(Config{WorkDir: work})
+
+	modified := strings.Replace(applyBase, `"a"`, `"A"`, 1)
+	_, err := r.Execute(context.Background(), Request{
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/trace",
+		CommitMessage: "edit",
+		Files:         []FileChange{{Path: "main.go", OriginalContent: applyBase, Edits: []EditBlock{{Search: `"a"`, Replace: `"A"`}}}},
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	os.Unsetenv("GIT_TRACE")
+	if got := gitT(t, work, "show", "toolhub/trace:main.go"); got != strings.TrimSuffix(modified, "\n") {
+		t.Fatalf("committed main.go = %q", got)
+	}
+}
@@ -3,24 +3,58 @@ package codeops
 import (
 	"context"
 	"fmt"
-	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"github.com/toolhub/toolhub/internal/workspace"
 )
 
 var branchNameRe = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
 
+// FileChange describes one file operation: write (the default), delete,
+// rename or chmod. The new content of a write or rename is given either in
+// full (ModifiedContent), as a unified diff (Patch) or as search/replace
+// blocks (Edits); patches and edits are applied to the base branch.
+//
+// OriginalContent or OriginalSHA (the git blob SHA) pin the content the
+// change was made against; the runner refuses to write when the base branch
+// has moved on.
 type FileChange struct {
-	Path            string `json:"path"`
-	OriginalContent string `json:"original_content,omitempty"`
-	ModifiedContent string `json:"modified_content"`
+	Path            string      `json:"path"`
+	OriginalContent string      `json:"original_content,omitempty"`
+	OriginalSHA     string      `json:"original_sha,omitempty"`
+	ModifiedContent string      `json:"modified_content,omitempty"`
+	Patch           string      `json:"patch,omitempty"`
+	Edits           []EditBlock `json:"edits,omitempty"`
+	Op              string      `json:"op,omitempty" jsonschema:"enum=write|delete|rename|chmod,description=File operation (default write)"`
+	// NewPath is the destination of a rename.
+	NewPath string `json:"new_path,omitempty" jsonschema:"description=Destination path of a rename"`
+	// FileMode is the git mode after the change; required for chmod.
+	FileMode string `json:"file_mode,omitempty" jsonschema:"enum=100644|100755,description=File mode after the change; required for chmod"`
 }
 
 type Config struct {
+	// Backend is BackendLocal (default) or BackendAPI; the api backend uses
+	// neither WorkDir nor Workspaces, except for KeepWorktree.
+	Backend string
 	WorkDir string
 	Remote  string
+	// WorktreeRoot is where per-operation worktrees are created; empty means
+	// the system temp directory.
+	WorktreeRoot string
+	// Workspaces, when set, resolves the repository from Request.Repo to a
+	// cached clone instead of using WorkDir and Remote.
+	Workspaces *workspace.Manager
+	// Tokens authenticates pushes to WorkDir's remote over HTTPS. Workspace
+	// clones use the manager's own token source.
+	Tokens workspace.TokenSource
+	// Author is the identity of every commit; nil keeps git's configuration.
+	Author  AuthorSource
+	Signing Signing
+	// GitData creates commits for SignAPI and reports GitHub's verification
+	// of signed commits.
+	GitData GitData
 }
 
 type Runner struct {
@@ -28,16 +62,49 @@ type Runner struct {
 }
 
 type Request struct {
+	// Repo is the owner/repo of the run; it selects the workspace clone.
+	Repo          string
 	BaseBranch    string
 	HeadBranch    string
 	CommitMessage string
-	Files         []FileChange
-	DryRun        bool
+	// CoAuthor, as "Name <email>", is credited with a Co-authored-by trailer.
+	CoAuthor string
+	Files    []FileChange
+	DryRun   bool
+	// KeepWorktree leaves the worktree in place after a successful commit
+	// and returns it in Result.Worktree; the caller must Remove it.
+	KeepWorktree bool
+	// Update adds the commit to the existing HeadBranch instead of creating
+	// it from BaseBranch; changes apply to the head branch and the push is
+	// fast-forward only.
+	Update bool
+	// ExpectedHeadSHA is the head commit an update was made against. When
+	// the branch has moved since, the update fails with HeadMovedError.
+	ExpectedHeadSHA string
+	// ForceWithLease makes an update rebuild HeadBranch from BaseBranch,
+	// replacing its commits. The push only succeeds while the branch is
+	// still at ExpectedHeadSHA, or at the head observed when the update
+	// started.
+	ForceWithLease bool
 }
 
 type Result struct {
 	PlannedCommands []string `json:"planned_commands"`
 	CommitHash      string   `json:"commit_hash,omitempty"`
+	// AppliedPatch is the diff of the base branch against the content that
+	// was committed, whatever form the changes were submitted in.
+	AppliedPatch string         `json:"applied_patch,omitempty"`
+	Conflicts    []HunkConflict `json:"conflicts,omitempty"`
+	StaleFiles   []StaleFile    `json:"stale_files,omitempty"`
+	Verification *Verification  `json:"verification,omitempty"`
+	// PreviousHeadSHA is the head branch commit an update replaced or built
+	// on.
+	PreviousHeadSHA string `json:"previous_head_sha,omitempty"`
+	// Worktree is set when Request.KeepWorktree was requested. BaseRef then
+	// names the base branch in the worktree's repository, e.g. to read
+	// files as the base branch has them.
+	Worktree *workspace.Worktree `json:"-"`
+	BaseRef  string              `json:"-"`
 }
 
 type RollbackResult struct {
@@ -54,6 +121,11 @@ func NewRunner(cfg Config) *Runner {
 	return &Runner{cfg: cfg}
 }
 
+// Execute commits req.Files on a new head branch, or on the existing one
+// for Request.Update, and pushes it. The work happens in a temporary worktree
+// checked out from BaseBranch (or the head branch), so the shared work
+// directory is never switched and concurrent operations do not see each
+// other's files.
 func (r *Runner) Execute(ctx context.Context, req Request) (*Result, error) {
 	if err := validateBranch(req.BaseBranch); err != nil {
 		return nil, err
@@ -67,106 +139,192 @@ func (r *Runner) Execute(ctx context.Context, req Request) (*Result, error) {
 	if len(req.Files) == 0 {
 		return nil, fmt.Errorf("files is required")
 	}
+	if !req.Update && (req.ForceWithLease || req.ExpectedHeadSHA != "") {
+		return nil, fmt.Errorf("force_with_lease and expected_head_sha only apply to updates")
+	}
+	if r.cfg.Backend == BackendAPI {
+		return r.executeAPI(ctx, req)
+	}
 
-	absWD, err := filepath.Abs(r.cfg.WorkDir)
+	target, err := r.target(ctx, req.Repo, req.DryRun)
 	if err != nil {
-		return nil, fmt.Errorf("resolve workdir: %w", err)
+		return nil, err
 	}
+	baseRef := target.ref(req.BaseBranch)
+	startRef := baseRef
+	checkout := "-b"
 
-	commands := []string{
-		fmt.Sprintf("git -C %q checkout %q", absWD, req.BaseBranch),
-		fmt.Sprintf("git -C %q checkout -b %q", absWD, req.HeadBranch),
+	const wtPlaceholder = "<worktree>"
+	var commands []string
+	if target.fetchBase {
+		commands = append(commands, fmt.Sprintf("git -C %q fetch %q %q", target.gitDir, target.remote, branchFetchSpec(target.remote, req.BaseBranch)))
+	}
+	if req.Update {
+		// Always fetch the head branch: it may have moved since the clone
+		// was last synced, and the WorkDir clone does not track it.
+		headRef := target.remote + "/" + req.HeadBranch
+		commands = append(commands, fmt.Sprintf("git -C %q fetch %q %q", target.gitDir, target.remote, branchFetchSpec(target.remote, req.HeadBranch)))
+		if !req.ForceWithLease {
+			startRef = headRef
+		}
+		checkout = "-B"
 	}
+	commands = append(commands,
+		fmt.Sprintf("git -C %q worktree add --detach %s %q", target.gitDir, wtPlaceholder, startRef),
+		fmt.Sprintf("git -C %s checkout %s %q", wtPlaceholder, checkout, req.HeadBranch),
+	)
 
 	for _, f := range req.Files {
-		cleanPath, err := safeRelativePath(f.Path)
+		fileCommands, err := changeCommands(f, wtPlaceholder)
 		if err != nil {
 			return nil, err
 		}
-		commands = append(commands, fmt.Sprintf("write %q", cleanPath))
-		commands = append(commands, fmt.Sprintf("git -C %q add -- %q", absWD, cleanPath))
+		commands = append(commands, fileCommands...)
 	}
 
-	commands = append(commands,
-		fmt.Sprintf("git -C %q commit -m %q", absWD, req.CommitMessage),
-		fmt.Sprintf("git -C %q push -u %q %q", absWD, r.cfg.Remote, req.HeadBranch),
-	)
+	message := commitMessage(req.CommitMessage, req.CoAuthor)
+	switch r.cfg.Signing.Method {
+	case SignAPI:
+		commands = append(commands, apiCommitCommands(req.Repo, req.HeadBranch, message, req.Update)...)
+	case SignSSH, SignGPG:
+		commands = append(commands,
+			fmt.Sprintf("git -C %s commit -S -m %q", wtPlaceholder, message),
+			fmt.Sprintf("git -C %s %s %q %q", wtPlaceholder, strings.Join(pushFlags(req, "<head>"), " "), target.remote, req.HeadBranch),
+		)
+	default:
+		commands = append(commands,
+			fmt.Sprintf("git -C %s commit -m %q", wtPlaceholder, message),
+			fmt.Sprintf("git -C %s %s %q %q", wtPlaceholder, strings.Join(pushFlags(req, "<head>"), " "), target.remote, req.HeadBranch),
+		)
+	}
+	commands = append(commands, fmt.Sprintf("git -C %q worktree remove --force %s", target.gitDir, wtPlaceholder))
 
 	if req.DryRun {
 		return &Result{PlannedCommands: commands}, nil
 	}
 
-	if err := runGit(ctx, absWD, "checkout", req.BaseBranch); err != nil {
+	if target.fetchBase {
+		if err := workspace.Fetch(ctx, target.gitDir, target.env, target.remote, branchFetchSpec(target.remote, req.BaseBranch)); err != nil {
+			return nil, fmt.Errorf("fetch base branch %q: %w", req.BaseBranch, err)
+		}
+	}
+	var previousHead string
+	if req.Update {
+		if previousHead, err = fetchHead(ctx, target, req); err != nil {
+			target.release()
+			return nil, err
+		}
+	}
+
+	wt, err := workspace.AddWorktree(ctx, target.gitDir, startRef, r.cfg.WorktreeRoot)
+	if err != nil {
+		target.release()
 		return nil, err
 	}
-	if err := runGit(ctx, absWD, "checkout", "-b", req.HeadBranch); err != nil {
+	// The workspace lease lasts as long as the worktree.
+	wt.OnRemove(target.release)
+	keep := false
+	defer func() {
+		if !keep {
+			_ = wt.Remove(ctx)
+		}
+	}()
+
+	// Resolve every change against the base branch before creating the head
+	// branch, so a conflict leaves no branch behind.
+	changes, appliedPatch, conflicts, stale, err := resolveChanges(readFromGit(ctx, wt.Dir, "HEAD"), req.Files)
+	if err != nil {
 		return nil, err
 	}
+	if len(stale) > 0 {
+		return &Result{PlannedCommands: commands, StaleFiles: stale}, &StaleContentError{Files: stale}
+	}
+	if len(conflicts) > 0 {
+		return &Result{PlannedCommands: commands, Conflicts: conflicts}, &ConflictError{Conflicts: conflicts}
+	}
 
-	for _, f := range req.Files {
-		cleanPath, err := safeRelativePath(f.Path)
+	if err := runGit(ctx, wt.Dir, "checkout", checkout, req.HeadBranch); err != nil {
+		return nil, err
+	}
+
+	for _, c := range changes {
+		if err := applyLocal(ctx, wt.Dir, c); err != nil {
+			return nil, err
+		}
+	}
+
+	var commitHash string
+	var verification *Verification
+	if r.cfg.Signing.Method == SignAPI {
+		lease := ""
+		if req.ForceWithLease {
+			lease = previousHead
+		}
+		commitHash, verification, err = r.commitViaAPI(ctx, wt.Dir, req.Repo, req.HeadBranch, message, lease, changes)
 		if err != nil {
 			return nil, err
 		}
-		full := filepath.Join(absWD, cleanPath)
-		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
-			return nil, fmt.Errorf("mkdir for file %q: %w", cleanPath, err)
+	} else {
+		commitEnv, err := r.commitEnv(ctx)
+		if err != nil {
+			return nil, err
 		}
-		if err := os.WriteFile(full, []byte(f.ModifiedContent), 0o644); err != nil {
-			return nil, fmt.Errorf("write file %q: %w", cleanPath, err)
+		commitEnv = append(commitEnv, r.cfg.Signing.signingEnv()...)
+		if _, err := workspace.RunGit(ctx, wt.Dir, commitEnv, "commit", "-m", message); err != nil {
+			return nil, err
 		}
-		if err := runGit(ctx, absWD, "add", "--", cleanPath); err != nil {
+		if _, err := workspace.RunGit(ctx, wt.Dir, target.env, append(pushFlags(req, previousHead), target.remote, req.HeadBranch)...); err != nil {
 			return nil, err
 		}
+		out, err := runGitOutput(ctx, wt.Dir, "rev-parse", "HEAD")
+		if err != nil {
+			return nil, err
+		}
+		commitHash = strings.TrimSpace(out)
+		verification = r.verifyCommit(ctx, wt.Dir, req.Repo, commitHash)
 	}
 
-	if err := runGit(ctx, absWD, "commit", "-m", req.CommitMessage); err != nil {
-		return nil, err
-	}
-	if err := runGit(ctx, absWD, "push", "-u", r.cfg.Remote, req.HeadBranch); err != nil {
-		return nil, err
-	}
-
-	out, err := runGitOutput(ctx, absWD, "rev-parse", "HEAD")
-	if err != nil {
-		return nil, err
+	result := &Result{PlannedCommands: commands, CommitHash: commitHash, AppliedPatch: appliedPatch, Verification: verification, PreviousHeadSHA: previousHead}
+	if req.KeepWorktree {
+		keep = true
+		result.Worktree, result.BaseRef = wt, baseRef
 	}
-
-	return &Result{PlannedCommands: commands, CommitHash: strings.TrimSpace(out)}, nil
+	return result, nil
 }
 
-func (r *Runner) RollbackBranch(ctx context.Context, baseBranch, headBranch string, dryRun bool) (*RollbackResult, error) {
+func (r *Runner) RollbackBranch(ctx context.Context, repo, baseBranch, headBranch string, dryRun bool) (*RollbackResult, error) {
 	if err := validateBranch(baseBranch); err != nil {
 		return nil, err
 	}
 	if err := validateBranch(headBranch); err != nil {
 		return nil, err
 	}
+	if r.cfg.Backend == BackendAPI {
+		return r.rollbackAPI(ctx, repo, headBranch, dryRun)
+	}
 
-	absWD, err := filepath.Abs(r.cfg.WorkDir)
+	target, err := r.target(ctx, repo, dryRun)
 	if err != nil {
-		return nil, fmt.Errorf("resolve workdir: %w", err)
+		return nil, err
 	}
+	defer target.release()
 
+	// Operations never switch the shared work directory, so the head branch
+	// can be deleted without checking out the base branch first.
 	commands := []string{
-		fmt.Sprintf("git -C %q checkout %q", absWD, baseBranch),
-		fmt.Sprintf("git -C %q branch -D %q", absWD, headBranch),
-		fmt.Sprintf("git -C %q push %q --delete %q", absWD, r.cfg.Remote, headBranch),
+		fmt.Sprintf("git -C %q branch -D %q", target.gitDir, headBranch),
+		fmt.Sprintf("git -C %q push %q --delete %q", target.gitDir, target.remote, headBranch),
 	}
 
 	if dryRun {
 		return &RollbackResult{PlannedCommands: commands}, nil
 	}
 
-	if err := runGit(ctx, absWD, "checkout", baseBranch); err != nil {
-		return nil, err
-	}
-
 	errMsgs := make([]string, 0, 2)
-	if err := runGit(ctx, absWD, "branch", "-D", headBranch); err != nil {
+	if err := runGit(ctx, target.gitDir, "branch", "-D", headBranch); err != nil {
 		errMsgs = append(errMsgs, err.Error())
 	}
-	if err := runGit(ctx, absWD, "push", r.cfg.Remote, "--delete", headBranch); err != nil {
+	if _, err := workspace.RunGit(ctx, target.gitDir, target.env, "push", target.remote, "--delete", headBranch); err != nil {
 		errMsgs = append(errMsgs, err.Error())
 	}
 	if len(errMsgs) > 0 {
@@ -209,20 +367,67 @@ func safeRelativePath(path string) (string, error) {
 	return cleaned, nil
 }
 
-func runGit(ctx context.Context, workdir string, args ...string) error {
-	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", workdir}, args...)...)
-	out, err := cmd.CombinedOutput()
+// repoTarget is the git directory an operation runs against: a leased
+// workspace clone when workspaces are configured, WorkDir otherwise.
+type repoTarget struct {
+	gitDir string
+	remote string
+	env    []string
+	// remoteRefs is set when branches are read from refs/remotes. Workspace
+	// clones are fetched when leased; fetchBase makes an operation on WorkDir
+	// fetch its base branch first, so it sees what is upstream rather than a
+	// local branch that may be behind.
+	remoteRefs bool
+	fetchBase  bool
+	lease      *workspace.Repo
+}
+
+func (r *Runner) target(ctx context.Context, repo string, dryRun bool) (*repoTarget, error) {
+	if r.cfg.Workspaces == nil {
+		absWD, err := filepath.Abs(r.cfg.WorkDir)
+		if err != nil {
+			return nil, fmt.Errorf("resolve workdir: %w", err)
+		}
+		target := &repoTarget{gitDir: absWD, remote: r.cfg.Remote, remoteRefs: true, fetchBase: true}
+		if !dryRun {
+			if target.env, err = workspace.AuthEnv(ctx, r.cfg.Tokens, workspace.DefaultBaseURL); err != nil {
+				return nil, err
+			}
+		}
+		return target, nil
+	}
+	if dryRun {
+		// Plan against the clone's path without cloning or fetching.
+		gitDir, err := r.cfg.Workspaces.GitDir(repo)
+		if err != nil {
+			return nil, err
+		}
+		return &repoTarget{gitDir: gitDir, remote: workspace.Remote, remoteRefs: true}, nil
+	}
+	lease, err := r.cfg.Workspaces.Acquire(ctx, repo)
 	if err != nil {
-		return fmt.Errorf("git %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		return nil, err
 	}
-	return nil
+	return &repoTarget{gitDir: lease.GitDir, remote: workspace.Remote, env: lease.Env, remoteRefs: true, lease: lease}, nil
 }
 
-func runGitOutput(ctx context.Context, workdir string, args ...string) (string, error) {
-	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", workdir}, args...)...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("git %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+// ref names branch as fetched into the target.
+func (t *repoTarget) ref(branch string) string {
+	if t.remoteRefs {
+		return t.remote + "/" + branch
 	}
-	return string(out), nil
+	return branch
+}
+
+func (t *repoTarget) release() {
+	t.lease.Release()
+}
+
+func runGit(ctx context.Context, workdir string, args ...string) error {
+	_, err := workspace.RunGit(ctx, workdir, nil, args...)
+	return err
+}
+
+func runGitOutput(ctx context.Context, workdir string, args ...string) (string, error) {
+	return workspace.RunGitOutput(ctx, workdir, nil, args...)
 }
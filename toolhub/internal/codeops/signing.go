@@ -0,0 +1,138 @@
+package codeops
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	gh "github.com/toolhub/toolhub/internal/github"
+)
+
+// Commit signing methods.
+const (
+	SignNone = "none"
+	// SignSSH and SignGPG sign commits locally with a mounted key.
+	SignSSH = "ssh"
+	SignGPG = "gpg"
+	// SignAPI creates commits through the GitHub Git Data API, which signs
+	// commits made by Apps.
+	SignAPI = "api"
+)
+
+// Signing configures how commits are signed.
+type Signing struct {
+	Method string
+	// Key is the private key file for ssh or the key ID for gpg.
+	Key string
+}
+
+func (s Signing) Validate() error {
+	switch s.Method {
+	case "", SignNone, SignAPI:
+		return nil
+	case SignSSH, SignGPG:
+		if strings.TrimSpace(s.Key) == "" {
+			return fmt.Errorf("signing method %q requires a key", s.Method)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown signing method %q (want none, ssh, gpg or api)", s.Method)
+}
+
+func (s Signing) method() string {
+	if s.Method == "" {
+		return SignNone
+	}
+	return s.Method
+}
+
+// Verification is the signature status of the commit an operation made.
+type Verification struct {
+	Method   string `json:"method" jsonschema:"enum=none|ssh|gpg|api"`
+	Signed   bool   `json:"signed"`
+	Verified bool   `json:"verified"`
+	// Reason is GitHub's verification reason, or not_checked when GitHub was
+	// not asked.
+	Reason string `json:"reason,omitempty"`
+}
+
+// signingEnv turns on commit signing with the configured key.
+func (s Signing) signingEnv() []string {
+	var format string
+	switch s.Method {
+	case SignSSH:
+		format = "ssh"
+	case SignGPG:
+		format = "openpgp"
+	default:
+		return nil
+	}
+	return []string{
+		"GIT_CONFIG_COUNT=3",
+		"GIT_CONFIG_KEY_0=gpg.format",
+		"GIT_CONFIG_VALUE_0=" + format,
+		"GIT_CONFIG_KEY_1=user.signingKey",
+		"GIT_CONFIG_VALUE_1=" + s.Key,
+		"GIT_CONFIG_KEY_2=commit.gpgSign",
+		"GIT_CONFIG_VALUE_2=true",
+	}
+}
+
+// verifyCommit reports whether the local commit carries a signature and,
+// when the Git Data API is available, whether GitHub verified it.
+func (r *Runner) verifyCommit(ctx context.Context, dir, repo, sha string) *Verification {
+	v := &Verification{Method: r.cfg.Signing.method(), Reason: "not_checked"}
+	if raw, err := runGitOutput(ctx, dir, "cat-file", "commit", sha); err == nil {
+		header, _, _ := strings.Cut(raw, "\n\n")
+		v.Signed = strings.Contains(header, "\ngpgsig")
+	}
+	owner, name, ok := strings.Cut(repo, "/")
+	if r.cfg.GitData == nil || !ok {
+		return v
+	}
+	commit, err := r.cfg.GitData.GetCommit(ctx, owner, name, sha)
+	if err != nil {
+		v.Reason = "check_failed: " + err.Error()
+		return v
+	}
+	applyVerification(v, commit)
+	return v
+}
+
+func applyVerification(v *Verification, commit *gh.GitCommit) {
+	if commit.Verification == nil {
+		return
+	}
+	v.Verified = commit.Verification.Verified
+	v.Reason = commit.Verification.Reason
+	// GitHub reports "unsigned" for commits without a signature.
+	v.Signed = v.Signed || commit.Verification.Reason != "unsigned"
+}
+
+// commitViaAPI recreates the worktree's changes on top of its HEAD as a
+// commit made through the Git Data API and points the head branch at it,
+// see setRef for lease.
+func (r *Runner) commitViaAPI(ctx context.Context, dir, repo, head, message, lease string, changes []resolvedChange) (string, *Verification, error) {
+	if r.cfg.GitData == nil {
+		return "", nil, fmt.Errorf("api signing requires the GitHub Git Data API")
+	}
+	owner, name, err := splitRepo(repo)
+	if err != nil {
+		return "", nil, err
+	}
+	base, err := runGitOutput(ctx, dir, "rev-parse", "HEAD", "HEAD^{tree}")
+	if err != nil {
+		return "", nil, err
+	}
+	ids := strings.Fields(base)
+	if len(ids) != 2 {
+		return "", nil, fmt.Errorf("resolve base commit: %q", base)
+	}
+	commit, err := r.apiCommit(ctx, owner, name, ids[0], ids[1], head, message, lease, changes)
+	if err != nil {
+		return "", nil, err
+	}
+	v := &Verification{Method: SignAPI}
+	applyVerification(v, commit)
+	return commit.SHA, v, nil
+}
@@ -0,0 +1,100 @@
+package codeops
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSigningValidate(t *testing.T) {
+	tests := []struct {
+		signing Signing
+		wantErr string
+	}{
+		{signing: Signing{}},
+		{signing: Signing{Method: SignAPI}},
+		{signing: Signing{Method: SignSSH, Key: "/secrets/id_ed25519"}},
+		{signing: Signing{Method: SignGPG}, wantErr: "requires a key"},
+		{signing: Signing{Method: "x509"}, wantErr: "unknown signing method"},
+	}
+	for _, tc := range tests {
+		err := tc.signing.Validate()
+		if (tc.wantErr == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Fatalf("Validate(%+v) = %v, want %q", tc.signing, err, tc.wantErr)
+		}
+	}
+}
+
+func TestRunnerExecuteSignsWithSSHKey(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not installed")
+	}
+	work := newTestRepo(t)
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	r := NewRunner(Config{WorkDir: work, Signing: Signing{Method: SignSSH, Key: key}})
+
+	res, err := r.Execute(context.Background(), Request{
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/signed",
+		CommitMessage: "signed change",
+		Files:         []FileChange{{Path: "signed.txt", ModifiedContent: "signed\n"}},
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if raw := gitT(t, work, "cat-file", "commit", res.CommitHash); !strings.Contains(raw, "gpgsig -----BEGIN SSH SIGNATURE-----") {
+		t.Fatalf("commit not signed:\n%s", raw)
+	}
+	want := Verification{Method: SignSSH, Signed: true, Reason: "not_checked"}
+	if res.Verification == nil || *res.Verification != want {
+		t.Fatalf("verification = %+v, want %+v", res.Verification, want)
+	}
+}
+
+func TestRunnerExecuteCommitsThroughAPI(t *testing.T) {
+	work := newTestRepo(t)
+	gitT(t, work, "update-index", "--chmod=+x", "main.go")
+	gitT(t, work, "commit", "-q", "-m", "make executable")
+	gitT(t, work, "push", "-q", "origin", "main")
+	api := newFakeGitData()
+	r := NewRunner(Config{WorkDir: work, Signing: Signing{Method: SignAPI}, GitData: api})
+
+	res, err := r.Execute(context.Background(), Request{
+		Repo:          "acme/app",
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/api",
+		CommitMessage: "api change",
+		Files: []FileChange{
+			{Path: "main.go", ModifiedContent: "package main\n"},
+			{Path: "docs/new.md", ModifiedContent: "# new\n"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if res.CommitHash == "" || api.refs["refs/heads/toolhub/api"] != res.CommitHash {
+		t.Fatalf("commit = %q, refs %v", res.CommitHash, api.refs)
+	}
+	if want := gitT(t, work, "rev-parse", "main^{tree}"); api.lastBaseTree != want {
+		t.Fatalf("base tree = %q, want %q", api.lastBaseTree, want)
+	}
+	commit := api.created[0]
+	if want := gitT(t, work, "rev-parse", "main"); len(commit.Parents) != 1 || commit.Parents[0] != want || commit.Message != "api change" {
+		t.Fatalf("commit input = %+v", commit)
+	}
+	entries := api.lastEntries
+	if len(entries) != 2 || entries[0].Mode != "100755" || entries[1].Mode != "100644" || string(api.blobs[*entries[1].SHA]) != "# new\n" {
+		t.Fatalf("tree entries = %+v", entries)
+	}
+	if want := (Verification{Method: SignAPI, Signed: true, Verified: true, Reason: "valid"}); *res.Verification != want {
+		t.Fatalf("verification = %+v", res.Verification)
+	}
+	if out := gitT(t, work, "ls-remote", "origin", "toolhub/api"); out != "" {
+		t.Fatalf("branch was pushed with git: %q", out)
+	}
+}
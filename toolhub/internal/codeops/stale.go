@@ -0,0 +1,72 @@
+package codeops
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// StaleFile is a file whose content on the base branch no longer matches
+// the original the change was made against.
+type StaleFile struct {
+	Path        string `json:"path"`
+	ExpectedSHA string `json:"expected_sha"`
+	// CurrentSHA is the git blob SHA on the base branch, empty when the file
+	// does not exist there.
+	CurrentSHA string `json:"current_sha,omitempty"`
+}
+
+// StaleContentError is returned when any FileChange was made against
+// content that is no longer on the base branch.
+type StaleContentError struct {
+	Files []StaleFile
+}
+
+func (e *StaleContentError) Error() string {
+	parts := make([]string, 0, len(e.Files))
+	for _, f := range e.Files {
+		current := f.CurrentSHA
+		if current == "" {
+			current = "missing"
+		}
+		parts = append(parts, fmt.Sprintf("%s (expected %s, current %s)", f.Path, f.ExpectedSHA, current))
+	}
+	return "original content is stale on the base branch: " + strings.Join(parts, "; ")
+}
+
+func (e *StaleContentError) ErrorCode() string { return "stale_original_content" }
+
+// BlobSHA returns the git blob object ID of content: SHA-1 by default, or
+// SHA-256 when like is a 64-character ID from a SHA-256 repository.
+func BlobSHA(content []byte, like string) string {
+	var h hash.Hash = sha1.New()
+	if len(like) == sha256.Size*2 {
+		h = sha256.New()
+	}
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkOriginal compares the base content with the original pinned by f.
+// Changes without OriginalContent or OriginalSHA are not checked.
+func checkOriginal(f FileChange, path string, base []byte, exists bool) (StaleFile, bool) {
+	expected := strings.ToLower(strings.TrimSpace(f.OriginalSHA))
+	if expected == "" && f.OriginalContent == "" {
+		return StaleFile{}, true
+	}
+	if expected == "" {
+		expected = BlobSHA([]byte(f.OriginalContent), "")
+	}
+	if !exists {
+		return StaleFile{Path: path, ExpectedSHA: expected}, false
+	}
+	current := BlobSHA(base, expected)
+	if current != expected {
+		return StaleFile{Path: path, ExpectedSHA: expected, CurrentSHA: current}, false
+	}
+	return StaleFile{}, true
+}
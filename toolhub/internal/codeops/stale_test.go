@@ -0,0 +1,130 @@
+package codeops
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlobSHA(t *testing.T) {
+	tests := []struct {
+		content string
+		like    string
+		want    string
+	}{
+		{content: "", want: "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+		{content: "hello\n", want: "ce013625030ba8dba906f756967f9e9ca394464a"},
+		{content: "", like: strings.Repeat("0", 64), want: "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813"},
+	}
+	for _, tc := range tests {
+		if got := BlobSHA([]byte(tc.content), tc.like); got != tc.want {
+			t.Fatalf("BlobSHA(%q) = %s, want %s", tc.content, got, tc.want)
+		}
+	}
+}
+
+func TestCheckOriginal(t *testing.T) {
+	base := []byte("current\n")
+	currentSHA := BlobSHA(base, "")
+	tests := []struct {
+		name      string
+		change    FileChange
+		exists    bool
+		wantOK    bool
+		wantStale StaleFile
+	}{
+		{name: "no original given", change: FileChange{Path: "a.txt"}, exists: true, wantOK: true},
+		{name: "matching content", change: FileChange{Path: "a.txt", OriginalContent: "current\n"}, exists: true, wantOK: true},
+		{name: "matching sha", change: FileChange{Path: "a.txt", OriginalSHA: strings.ToUpper(currentSHA)}, exists: true, wantOK: true},
+		{
+			name:      "stale content",
+			change:    FileChange{Path: "a.txt", OriginalContent: "older\n"},
+			exists:    true,
+			wantStale: StaleFile{Path: "a.txt", ExpectedSHA: BlobSHA([]byte("older\n"), ""), CurrentSHA: currentSHA},
+		},
+		{
+			name:      "file removed upstream",
+			change:    FileChange{Path: "a.txt", OriginalSHA: currentSHA},
+			wantStale: StaleFile{Path: "a.txt", ExpectedSHA: currentSHA},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stale, ok := checkOriginal(tc.change, tc.change.Path, base, tc.exists)
+			if ok != tc.wantOK || stale != tc.wantStale {
+				t.Fatalf("checkOriginal = %+v, %v; want %+v, %v", stale, ok, tc.wantStale, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestRunnerExecuteRejectsStaleOriginal(t *testing.T) {
+	work := newTestRepo(t)
+	r := NewRunner(Config{WorkDir: work})
+
+	_, err := r.Execute(context.Background(), Request{
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/stale",
+		CommitMessage: "overwrite",
+		Files: []FileChange{
+			{Path: "main.go", OriginalContent: "package main\n", ModifiedContent: "package main\n\nfunc main() {}\n"},
+		},
+	})
+	var stale *StaleContentError
+	if !errors.As(err, &stale) || len(stale.Files) != 1 {
+		t.Fatalf("err = %v, want stale content error", err)
+	}
+	if want := gitT(t, work, "rev-parse", "main:main.go"); stale.Files[0].CurrentSHA != want || !strings.Contains(err.Error(), want) {
+		t.Fatalf("current sha = %q, want %q in %q", stale.Files[0].CurrentSHA, want, err.Error())
+	}
+	if out := gitT(t, work, "branch", "--list", "toolhub/stale"); out != "" {
+		t.Fatalf("head branch was created: %q", out)
+	}
+
+	_, err = r.Execute(context.Background(), Request{
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/fresh",
+		CommitMessage: "overwrite",
+		Files: []FileChange{
+			{Path: "main.go", OriginalSHA: stale.Files[0].CurrentSHA, ModifiedContent: "package main\n\nfunc main() {}\n"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute with current sha: %v", err)
+	}
+}
+
+func TestRunnerExecuteChecksOriginalAgainstUpstream(t *testing.T) {
+	work := newTestRepo(t)
+	other := filepath.Join(t.TempDir(), "other")
+	gitT(t, work, "clone", "-q", filepath.Join(filepath.Dir(work), "remote.git"), other)
+	gitT(t, other, "config", "user.email", "toolhub@example.com")
+	gitT(t, other, "config", "user.name", "ToolHub Test")
+	if err := os.WriteFile(filepath.Join(other, "main.go"), []byte("package main\n\n// moved upstream\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gitT(t, other, "commit", "-q", "-am", "upstream change")
+	gitT(t, other, "push", "-q", "origin", "main")
+	r := NewRunner(Config{WorkDir: work})
+
+	// The local main branch still holds applyBase, which is no longer what
+	// is upstream.
+	_, err := r.Execute(context.Background(), Request{
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/behind",
+		CommitMessage: "overwrite",
+		Files: []FileChange{
+			{Path: "main.go", OriginalContent: applyBase, ModifiedContent: "package main\n\nfunc main() {}\n"},
+		},
+	})
+	var stale *StaleContentError
+	if !errors.As(err, &stale) || len(stale.Files) != 1 {
+		t.Fatalf("err = %v, want stale content error", err)
+	}
+	if want := gitT(t, other, "rev-parse", "main:main.go"); stale.Files[0].CurrentSHA != want {
+		t.Fatalf("current sha = %q, want upstream %q", stale.Files[0].CurrentSHA, want)
+	}
+}
@@ -0,0 +1,64 @@
+package codeops
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/toolhub/toolhub/internal/workspace"
+)
+
+// HeadMovedError is returned when an update was made against a head commit
+// the branch is no longer at.
+type HeadMovedError struct {
+	Branch   string
+	Expected string
+	Current  string
+}
+
+func (e *HeadMovedError) Error() string {
+	return fmt.Sprintf("head branch %q moved: expected %s, current %s", e.Branch, e.Expected, e.Current)
+}
+
+func (e *HeadMovedError) ErrorCode() string { return "head_branch_moved" }
+
+// checkHead compares the current head commit with req.ExpectedHeadSHA.
+func checkHead(req Request, current string) error {
+	expected := strings.ToLower(strings.TrimSpace(req.ExpectedHeadSHA))
+	if expected != "" && expected != current {
+		return &HeadMovedError{Branch: req.HeadBranch, Expected: expected, Current: current}
+	}
+	return nil
+}
+
+// pushFlags starts the git push command line for req. Updates are pushed
+// without force, so git rejects anything but a fast-forward, unless
+// ForceWithLease allows replacing the branch while it is still at lease.
+func pushFlags(req Request, lease string) []string {
+	switch {
+	case !req.Update:
+		return []string{"push", "-u"}
+	case req.ForceWithLease:
+		return []string{"push", fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", req.HeadBranch, lease)}
+	}
+	return []string{"push"}
+}
+
+// branchFetchSpec fetches branch into its remote-tracking ref.
+func branchFetchSpec(remote, branch string) string {
+	return fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", branch, remote, branch)
+}
+
+// fetchHead updates the remote-tracking ref of the head branch and returns
+// its commit.
+func fetchHead(ctx context.Context, target *repoTarget, req Request) (string, error) {
+	if err := workspace.Fetch(ctx, target.gitDir, target.env, target.remote, branchFetchSpec(target.remote, req.HeadBranch)); err != nil {
+		return "", fmt.Errorf("fetch head branch %q: %w", req.HeadBranch, err)
+	}
+	out, err := runGitOutput(ctx, target.gitDir, "rev-parse", "--verify", "refs/remotes/"+target.remote+"/"+req.HeadBranch+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	current := strings.TrimSpace(out)
+	return current, checkHead(req, current)
+}
@@ -0,0 +1,156 @@
+package codeops
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunnerExecuteUpdatesExistingHead(t *testing.T) {
+	work := newTestRepo(t)
+	r := NewRunner(Config{WorkDir: work})
+	ctx := context.Background()
+
+	first, err := r.Execute(ctx, Request{
+		BaseBranch: "main", HeadBranch: "toolhub/pr", CommitMessage: "first",
+		Files: []FileChange{{Path: "a.txt", ModifiedContent: "a\n"}},
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	update := Request{
+		BaseBranch: "main", HeadBranch: "toolhub/pr", CommitMessage: "review fixes",
+		Files:           []FileChange{{Path: "a.txt", Edits: []EditBlock{{Search: "a", Replace: "b"}}}},
+		Update:          true,
+		ExpectedHeadSHA: first.CommitHash,
+	}
+	plan, err := r.Execute(ctx, Request{BaseBranch: update.BaseBranch, HeadBranch: update.HeadBranch, CommitMessage: update.CommitMessage, Files: update.Files, Update: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	planned := strings.Join(plan.PlannedCommands, "\n")
+	for _, want := range []string{`fetch "origin" "+refs/heads/toolhub/pr:refs/remotes/origin/toolhub/pr"`, `"origin/toolhub/pr"`, `checkout -B "toolhub/pr"`, `push "origin" "toolhub/pr"`} {
+		if !strings.Contains(planned, want) {
+			t.Fatalf("planned commands missing %q:\n%s", want, planned)
+		}
+	}
+
+	res, err := r.Execute(ctx, update)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if res.PreviousHeadSHA != first.CommitHash {
+		t.Fatalf("previous head = %q, want %q", res.PreviousHeadSHA, first.CommitHash)
+	}
+	if parent := gitT(t, work, "rev-parse", "origin/toolhub/pr^"); parent != first.CommitHash {
+		t.Fatalf("update parent = %q, want %q", parent, first.CommitHash)
+	}
+	if got := gitT(t, work, "show", "origin/toolhub/pr:a.txt"); got != "b" {
+		t.Fatalf("a.txt = %q", got)
+	}
+	if !strings.Contains(res.AppliedPatch, "-a\n+b") {
+		t.Fatalf("applied patch is not against the head branch:\n%s", res.AppliedPatch)
+	}
+
+	// A second update made against the first commit is rejected.
+	_, err = r.Execute(ctx, update)
+	var moved *HeadMovedError
+	if !errors.As(err, &moved) || moved.Current != res.CommitHash {
+		t.Fatalf("err = %v, want head moved to %s", err, res.CommitHash)
+	}
+	if tip := gitT(t, work, "rev-parse", "origin/toolhub/pr"); tip != res.CommitHash {
+		t.Fatalf("head branch changed to %s", tip)
+	}
+}
+
+func TestRunnerExecuteUpdateForceWithLease(t *testing.T) {
+	work := newTestRepo(t)
+	r := NewRunner(Config{WorkDir: work})
+	ctx := context.Background()
+
+	first, err := r.Execute(ctx, Request{
+		BaseBranch: "main", HeadBranch: "toolhub/pr", CommitMessage: "first",
+		Files: []FileChange{{Path: "a.txt", ModifiedContent: "a\n"}},
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	res, err := r.Execute(ctx, Request{
+		BaseBranch: "main", HeadBranch: "toolhub/pr", CommitMessage: "redo",
+		Files:  []FileChange{{Path: "b.txt", ModifiedContent: "b\n"}},
+		Update: true, ForceWithLease: true, ExpectedHeadSHA: first.CommitHash,
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if !strings.Contains(strings.Join(res.PlannedCommands, "\n"), "push --force-with-lease=refs/heads/toolhub/pr:<head>") {
+		t.Fatalf("planned commands: %q", res.PlannedCommands)
+	}
+	if parent, base := gitT(t, work, "rev-parse", "origin/toolhub/pr^"), gitT(t, work, "rev-parse", "main"); parent != base {
+		t.Fatalf("rebuilt head parent = %q, want base %q", parent, base)
+	}
+	if files := gitT(t, work, "ls-tree", "--name-only", "origin/toolhub/pr"); files != "b.txt\nmain.go" {
+		t.Fatalf("rebuilt head files = %q", files)
+	}
+}
+
+func TestRunnerExecuteRejectsLeaseWithoutUpdate(t *testing.T) {
+	r := NewRunner(Config{})
+	_, err := r.Execute(context.Background(), Request{
+		BaseBranch: "main", HeadBranch: "toolhub/pr", CommitMessage: "x",
+		Files: []FileChange{{Path: "a.txt", ModifiedContent: "a\n"}}, ForceWithLease: true, DryRun: true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "only apply to updates") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestRunnerExecuteAPIBackendUpdate(t *testing.T) {
+	api := newFakeGitData()
+	base := api.seed("main", map[string]string{"main.go": applyBase})
+	r := NewRunner(Config{Backend: BackendAPI, GitData: api})
+	ctx := context.Background()
+	req := Request{Repo: "acme/app", BaseBranch: "main", HeadBranch: "toolhub/pr", CommitMessage: "change"}
+
+	req.Files = []FileChange{{Path: "a.txt", ModifiedContent: "a\n"}}
+	first, err := r.Execute(ctx, req)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	req.Update = true
+	req.Files = []FileChange{{Path: "a.txt", Edits: []EditBlock{{Search: "a", Replace: "b"}}}}
+	res, err := r.Execute(ctx, req)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if api.parents[res.CommitHash] != first.CommitHash || res.PreviousHeadSHA != first.CommitHash || len(api.forcedRefs) != 0 {
+		t.Fatalf("update parent = %q, previous %q, forced %v", api.parents[res.CommitHash], res.PreviousHeadSHA, api.forcedRefs)
+	}
+	if content, _ := api.file(t, "refs/heads/toolhub/pr", "a.txt"); content != "b\n" {
+		t.Fatalf("a.txt = %q", content)
+	}
+
+	req.ExpectedHeadSHA = first.CommitHash
+	var moved *HeadMovedError
+	if _, err := r.Execute(ctx, req); !errors.As(err, &moved) {
+		t.Fatalf("err = %v, want HeadMovedError", err)
+	}
+
+	req.ExpectedHeadSHA = res.CommitHash
+	req.ForceWithLease = true
+	req.Files = []FileChange{{Path: "c.txt", ModifiedContent: "c\n"}}
+	forced, err := r.Execute(ctx, req)
+	if err != nil {
+		t.Fatalf("force update: %v", err)
+	}
+	if api.parents[forced.CommitHash] != base || len(api.forcedRefs) != 1 {
+		t.Fatalf("forced parent = %q, want %q; forced %v", api.parents[forced.CommitHash], base, api.forcedRefs)
+	}
+	if content, _ := api.file(t, "refs/heads/toolhub/pr", "a.txt"); content != "" {
+		t.Fatalf("rebuilt head still has a.txt = %q", content)
+	}
+}
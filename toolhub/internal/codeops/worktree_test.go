@@ -0,0 +1,131 @@
+package codeops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/toolhub/toolhub/internal/workspace"
+)
+
+func TestRunnerExecuteUsesIsolatedWorktrees(t *testing.T) {
+	work := newTestRepo(t)
+	r := NewRunner(Config{WorkDir: work, WorktreeRoot: t.TempDir()})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = r.Execute(context.Background(), Request{
+				BaseBranch:    "main",
+				HeadBranch:    fmt.Sprintf("toolhub/parallel-%d", i),
+				CommitMessage: "parallel change",
+				Files:         []FileChange{{Path: fmt.Sprintf("file-%d.txt", i), ModifiedContent: "content\n"}},
+			})
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Execute %d: %v", i, err)
+		}
+		// Each branch holds only its own file.
+		files := gitT(t, work, "ls-tree", "--name-only", fmt.Sprintf("toolhub/parallel-%d", i))
+		if files != fmt.Sprintf("file-%d.txt\nmain.go", i) {
+			t.Fatalf("branch %d files = %q", i, files)
+		}
+	}
+
+	if branch := gitT(t, work, "rev-parse", "--abbrev-ref", "HEAD"); branch != "main" {
+		t.Fatalf("shared workdir switched to %q", branch)
+	}
+	if status := gitT(t, work, "status", "--porcelain"); status != "" {
+		t.Fatalf("shared workdir changed: %q", status)
+	}
+	if list := gitT(t, work, "worktree", "list", "--porcelain"); strings.Count(list, "worktree ") != 1 {
+		t.Fatalf("worktrees left behind:\n%s", list)
+	}
+}
+
+func TestRunnerExecuteKeepWorktree(t *testing.T) {
+	work := newTestRepo(t)
+	r := NewRunner(Config{WorkDir: work})
+
+	res, err := r.Execute(context.Background(), Request{
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/kept",
+		CommitMessage: "kept change",
+		Files:         []FileChange{{Path: "kept.txt", ModifiedContent: "kept\n"}},
+		KeepWorktree:  true,
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	dir := res.Worktree.Dir
+	if got, err := os.ReadFile(dir + "/kept.txt"); err != nil || string(got) != "kept\n" {
+		t.Fatalf("worktree content = %q, %v", got, err)
+	}
+	if err := res.Worktree.Remove(context.Background()); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("worktree dir still exists: %v", err)
+	}
+
+	if _, err := r.RollbackBranch(context.Background(), "", "main", "toolhub/kept", false); err != nil {
+		t.Fatalf("RollbackBranch: %v", err)
+	}
+	if out := gitT(t, work, "branch", "--list", "toolhub/kept"); out != "" {
+		t.Fatalf("branch not deleted: %q", out)
+	}
+}
+
+func TestRunnerExecuteInWorkspace(t *testing.T) {
+	work := newTestRepo(t)
+	base := t.TempDir()
+	gitT(t, base, "clone", "-q", "--bare", filepath.Join(filepath.Dir(work), "remote.git"), filepath.Join(base, "acme", "app.git"))
+	m, err := workspace.NewManager(workspace.Config{Root: t.TempDir(), BaseURL: "file://" + base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Workspace clones carry no identity of their own.
+	r := NewRunner(Config{Workspaces: m, Author: fakeAuthor{}})
+
+	req := Request{
+		Repo:          "acme/app",
+		BaseBranch:    "main",
+		HeadBranch:    "toolhub/workspace",
+		CommitMessage: "workspace change",
+		Files:         []FileChange{{Path: "ws.txt", ModifiedContent: "ws\n"}},
+	}
+	res, err := r.Execute(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if got := gitT(t, filepath.Join(base, "acme", "app.git"), "rev-parse", "toolhub/workspace"); got != res.CommitHash {
+		t.Fatalf("pushed head = %s, want %s", got, res.CommitHash)
+	}
+
+	req.DryRun = true
+	plan, err := r.Execute(context.Background(), req)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	gitDir, _ := m.GitDir("acme/app")
+	if want := fmt.Sprintf("git -C %q worktree add --detach <worktree> \"origin/main\"", gitDir); plan.PlannedCommands[0] != want {
+		t.Fatalf("planned = %q, want %q", plan.PlannedCommands[0], want)
+	}
+
+	if _, err := r.RollbackBranch(context.Background(), "acme/app", "main", "toolhub/workspace", false); err != nil {
+		t.Fatalf("RollbackBranch: %v", err)
+	}
+	if out := gitT(t, filepath.Join(base, "acme", "app.git"), "branch", "--list", "toolhub/workspace"); out != "" {
+		t.Fatalf("remote branch not deleted: %q", out)
+	}
+}
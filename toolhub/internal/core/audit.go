@@ -30,6 +30,11 @@ func NewAuditService(database *db.DB, store *ArtifactStore, policy *Policy) *Aud
 
 // RecordInput captures what is needed to log a tool call.
 type RecordInput struct {
+	// ToolCallID is the ID assigned to the call before execution; empty
+	// generates one.
+	ToolCallID string
+	// Cancelled records the call with status cancelled instead of fail.
+	Cancelled      bool
 	RunID          string
 	ToolName       string
 	IdemKey        *string
@@ -97,14 +102,21 @@ func (a *AuditService) Record(ctx context.Context, in RecordInput) (*db.ToolCall
 	}
 
 	status := "ok"
-	if in.Err != nil {
+	switch {
+	case in.Cancelled:
+		status = ToolCallCancelled
+	case in.Err != nil:
 		status = "fail"
 	}
+	toolCallID := in.ToolCallID
+	if toolCallID == "" {
+		toolCallID = uuid.New().String()
+	}
 
 	evidence := sha256.Sum256(append(reqJSON, respJSON...))
 
 	tc := &db.ToolCall{
-		ToolCallID:         uuid.New().String(),
+		ToolCallID:         toolCallID,
 		RunID:              in.RunID,
 		ToolName:           in.ToolName,
 		IdempotencyKey:     in.IdemKey,
@@ -192,6 +204,15 @@ func (a *AuditService) ReplayResponseWithRequestCheck(ctx context.Context, runID
 }
 
 // ListToolCallsByRun returns all tool calls associated with a run.
+// GetToolCall returns the recorded tool call of the run, or nil.
+func (a *AuditService) GetToolCall(ctx context.Context, runID, toolCallID string) (*db.ToolCall, error) {
+	tc, err := a.db.GetToolCall(ctx, toolCallID)
+	if err != nil || tc == nil || tc.RunID != runID {
+		return nil, err
+	}
+	return tc, nil
+}
+
 func (a *AuditService) ListToolCallsByRun(ctx context.Context, runID string) ([]*db.ToolCall, error) {
 	return a.db.ListToolCallsByRun(ctx, runID)
 }
@@ -308,6 +329,9 @@ func (a *AuditService) StartStep(ctx context.Context, runID, name, stepType stri
 		StartedAt: &now,
 		CreatedAt: now,
 	}
+	if jobID := JobIDFromContext(ctx); jobID != "" {
+		step.JobID = &jobID
+	}
 	if err := a.db.InsertStep(ctx, step); err != nil {
 		return nil, err
 	}
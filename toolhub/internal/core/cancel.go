@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ToolCallCancelled is the tool_calls status of a call cancelled while it
+// ran; its artifacts hold the output produced until then.
+const ToolCallCancelled = "cancelled"
+
+// ErrToolCallCancelled is the cancellation cause of a call stopped through
+// CancelToolCall.
+var ErrToolCallCancelled = errors.New("tool call cancelled by request")
+
+// ToolCallError rejects a cancel request for a tool call that does not
+// exist in the run or is no longer running.
+type ToolCallError struct {
+	Code    string
+	Message string
+}
+
+func (e *ToolCallError) Error() string     { return e.Message }
+func (e *ToolCallError) ErrorCode() string { return e.Code }
+
+// ToolCallCancelledError is the failure of a call that was cancelled while
+// it ran. The call is recorded with status cancelled under ToolCallID.
+type ToolCallCancelledError struct {
+	ToolCallID string
+	Cause      error
+}
+
+func (e *ToolCallCancelledError) Error() string {
+	return fmt.Sprintf("tool call %s cancelled: %v", e.ToolCallID, e.Cause)
+}
+
+func (e *ToolCallCancelledError) ErrorCode() string { return "tool_call_cancelled" }
+func (e *ToolCallCancelledError) Unwrap() error     { return e.Cause }
+
+// RunningToolCall is a call in flight in this process.
+type RunningToolCall struct {
+	ToolCallID string    `json:"tool_call_id"`
+	RunID      string    `json:"run_id"`
+	ToolName   string    `json:"tool_name"`
+	StartedAt  time.Time `json:"started_at"`
+	// Cancelled is set once cancellation was requested; the call stops and
+	// is recorded as cancelled shortly after.
+	Cancelled bool `json:"cancelled"`
+
+	cancel context.CancelCauseFunc
+}
+
+// track registers call as running until the returned func is called. The
+// returned context is cancelled by CancelToolCall.
+func (r *Registry) track(ctx context.Context, call *ToolCall) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	running := &RunningToolCall{
+		ToolCallID: call.ID,
+		RunID:      call.Run.RunID,
+		ToolName:   call.Name,
+		StartedAt:  time.Now().UTC(),
+		cancel:     cancel,
+	}
+	r.inflightMu.Lock()
+	r.inflight[call.ID] = running
+	r.inflightMu.Unlock()
+	return ctx, func() {
+		r.inflightMu.Lock()
+		delete(r.inflight, call.ID)
+		r.inflightMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// CancelToolCall cancels a call of the run that is running in this process.
+// Its context is cancelled with ErrToolCallCancelled, which stops QA
+// commands, sandbox containers and git. It returns false when no such call
+// is running.
+func (r *Registry) CancelToolCall(runID, toolCallID string) (*RunningToolCall, bool) {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+	running, ok := r.inflight[toolCallID]
+	if !ok || running.RunID != runID {
+		return nil, false
+	}
+	running.Cancelled = true
+	running.cancel(ErrToolCallCancelled)
+	out := *running
+	return &out, true
+}
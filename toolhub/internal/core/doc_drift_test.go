@@ -10,6 +10,9 @@ import (
 	"sort"
 	"strings"
 	"testing"
+
+	httpsvr "github.com/toolhub/toolhub/internal/http"
+	"github.com/toolhub/toolhub/internal/tools"
 )
 
 func repoRoot(t *testing.T) string {
@@ -116,22 +119,11 @@ func TestDocDrift_HTTPEndpointsInREADME(t *testing.T) {
 
 func TestDocDrift_MCPToolsInREADME(t *testing.T) {
 	root := repoRoot(t)
-	mcpSrc := readFile(t, filepath.Join(root, "toolhub", "internal", "mcp", "server.go"))
 	readme := readFile(t, filepath.Join(root, "README.md"))
 
-	reToolName := regexp.MustCompile(`"name":\s*"([a-z_]+)"`)
-	matches := reToolName.FindAllStringSubmatch(mcpSrc, -1)
-
-	skipNames := map[string]bool{
-		"toolhub": true,
-	}
-
 	serverTools := make(map[string]bool)
-	for _, m := range matches {
-		name := m[1]
-		if !skipNames[name] {
-			serverTools[name] = true
-		}
+	for _, spec := range tools.Definitions() {
+		serverTools[spec.MCPName()] = true
 	}
 
 	reMCPSection := regexp.MustCompile(`(?s)## MCP Tools\n(.*?)(?:\n## |\z)`)
@@ -165,11 +157,35 @@ func TestDocDrift_MCPToolsInREADME(t *testing.T) {
 	sort.Strings(missingInServer)
 
 	if len(missingInREADME) > 0 {
-		t.Errorf("MCP tools registered in server.go but missing from README:\n  %s",
+		t.Errorf("MCP tools registered in the tool registry but missing from README:\n  %s",
 			strings.Join(missingInREADME, "\n  "))
 	}
 	if len(missingInServer) > 0 {
-		t.Errorf("MCP tools listed in README but not registered in server.go:\n  %s",
+		t.Errorf("MCP tools listed in README but not registered in the tool registry:\n  %s",
 			strings.Join(missingInServer, "\n  "))
 	}
 }
+
+func TestDocDrift_OpenAPISpecGenerated(t *testing.T) {
+	root := repoRoot(t)
+	committed := readFile(t, filepath.Join(root, "openapi.yaml"))
+	generated := string(httpsvr.OpenAPIDocument(tools.Definitions()))
+	if committed == generated {
+		return
+	}
+
+	committedLines := strings.Split(committed, "\n")
+	generatedLines := strings.Split(generated, "\n")
+	for i := 0; i < len(committedLines) || i < len(generatedLines); i++ {
+		var c, g string
+		if i < len(committedLines) {
+			c = committedLines[i]
+		}
+		if i < len(generatedLines) {
+			g = generatedLines[i]
+		}
+		if c != g {
+			t.Fatalf("openapi.yaml differs from the generated spec at line %d:\n  committed: %q\n  generated: %q\nregenerate with: go -C toolhub run ./cmd/openapigen > openapi.yaml", i+1, c, g)
+		}
+	}
+}
@@ -23,6 +23,9 @@ type QAArtifacts struct {
 	StdoutArtifactID string `json:"stdout_artifact_id,omitempty"`
 	StderrArtifactID string `json:"stderr_artifact_id,omitempty"`
 	ReportArtifactID string `json:"report_artifact_id,omitempty"`
+	// SARIFArtifactID holds the lint findings as SARIF, for runs that
+	// parsed them.
+	SARIFArtifactID string `json:"sarif_artifact_id,omitempty"`
 }
 
 // ToolError represents a tool-level error (distinct from transport errors).
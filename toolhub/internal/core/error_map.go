@@ -15,6 +15,8 @@ type ErrorInfo struct {
 	Code       string
 	Message    string
 	HTTPStatus int
+	// RetryAfterSeconds is set for rate_limited errors.
+	RetryAfterSeconds int
 }
 
 func MapError(err error, fallbackStatus int) ErrorInfo {
@@ -25,20 +27,38 @@ func MapError(err error, fallbackStatus int) ErrorInfo {
 	msg := err.Error()
 	lower := strings.ToLower(msg)
 
+	var failed *JobFailedError
+	if errors.As(err, &failed) {
+		return ErrorInfo{Code: failed.Code, Message: failed.Message, HTTPStatus: failed.Status}
+	}
+
 	var coded CodedError
 	if errors.As(err, &coded) {
 		code := coded.ErrorCode()
 		switch code {
-		case "qa_command_empty", "qa_command_invalid", "qa_workdir_invalid", "qa_tool_unsupported", "qa_backend_invalid":
+		case "qa_command_empty", "qa_command_invalid", "qa_workdir_invalid", "qa_tool_unsupported", "qa_backend_invalid", "qa_target_invalid", "qa_repo_config_invalid":
 			return ErrorInfo{Code: code, Message: msg, HTTPStatus: 400}
-		case "qa_command_not_allowed":
+		case "qa_command_not_allowed", "pr_not_owned":
 			return ErrorInfo{Code: code, Message: msg, HTTPStatus: 403}
-		case "qa_timeout":
+		case "qa_timeout", "qa_cancelled":
 			return ErrorInfo{Code: code, Message: msg, HTTPStatus: 200}
 		case "qa_execution_failed":
 			return ErrorInfo{Code: code, Message: msg, HTTPStatus: 200}
-		case "idempotency_key_conflict":
+		case "invalid_request_schema":
+			return ErrorInfo{Code: code, Message: msg, HTTPStatus: 400}
+		case "idempotency_key_conflict", "patch_conflict", "stale_original_content", "head_branch_moved", "pr_not_open", "repair_session_closed":
+			return ErrorInfo{Code: code, Message: msg, HTTPStatus: 409}
+		case "job_not_finished", "job_finished", "job_cancelled", "job_interrupted", "tool_call_cancelled", "tool_call_finished":
 			return ErrorInfo{Code: code, Message: msg, HTTPStatus: 409}
+		case "repair_session_not_found", "job_not_found", "tool_call_not_found", "qa_target_not_found":
+			return ErrorInfo{Code: code, Message: msg, HTTPStatus: 404}
+		case "rate_limited":
+			info := ErrorInfo{Code: code, Message: msg, HTTPStatus: 429}
+			var rl *RateLimitError
+			if errors.As(err, &rl) {
+				info.RetryAfterSeconds = rl.RetryAfterSeconds()
+			}
+			return info
 		}
 	}
 
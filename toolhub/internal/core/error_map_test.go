@@ -3,6 +3,7 @@ package core
 import (
 	"errors"
 	"testing"
+	"time"
 )
 
 type testCodedError struct{ code, msg string }
@@ -48,9 +49,26 @@ func TestMapErrorQACodedErrors(t *testing.T) {
 		{name: "qa command empty", err: &testCodedError{code: "qa_command_empty", msg: "qa command is empty"}, fallback: 500, wantCode: "qa_command_empty", wantHTTP: 400},
 		{name: "qa command not allowed", err: &testCodedError{code: "qa_command_not_allowed", msg: "qa executable \"foo\" is not in allowlist"}, fallback: 500, wantCode: "qa_command_not_allowed", wantHTTP: 403},
 		{name: "qa backend invalid", err: &testCodedError{code: "qa_backend_invalid", msg: "unsupported qa backend: nope"}, fallback: 500, wantCode: "qa_backend_invalid", wantHTTP: 400},
+		{name: "qa target not found", err: &testCodedError{code: "qa_target_not_found", msg: "unknown qa target: e2e"}, fallback: 400, wantCode: "qa_target_not_found", wantHTTP: 404},
+		{name: "qa repo config invalid", err: &testCodedError{code: "qa_repo_config_invalid", msg: "invalid qa repo config: qa.test.timeout_seconds exceeds the server limit of 600"}, fallback: 500, wantCode: "qa_repo_config_invalid", wantHTTP: 400},
 		{name: "qa timeout", err: &testCodedError{code: "qa_timeout", msg: "qa command timed out after 5s"}, fallback: 500, wantCode: "qa_timeout", wantHTTP: 200},
 		{name: "qa exec failed", err: &testCodedError{code: "qa_execution_failed", msg: "qa command failed with exit code 1"}, fallback: 500, wantCode: "qa_execution_failed", wantHTTP: 200},
 		{name: "idempotency conflict", err: &testCodedError{code: "idempotency_key_conflict", msg: "idempotency key reused with different request payload"}, fallback: 500, wantCode: "idempotency_key_conflict", wantHTTP: 409},
+		{name: "patch conflict", err: &testCodedError{code: "patch_conflict", msg: "patch does not apply: a.go: hunk 1 (@@ -1 +1 @@): expected \"x\" at line 1, found \"y\""}, fallback: 502, wantCode: "patch_conflict", wantHTTP: 409},
+		{name: "stale original content", err: &testCodedError{code: "stale_original_content", msg: "original content is stale on the base branch: a.go (expected abc, current def)"}, fallback: 502, wantCode: "stale_original_content", wantHTTP: 409},
+		{name: "pr not owned", err: &testCodedError{code: "pr_not_owned", msg: "pull request #3 was opened by octocat, not by ToolHub"}, fallback: 502, wantCode: "pr_not_owned", wantHTTP: 403},
+		{name: "pr not open", err: &testCodedError{code: "pr_not_open", msg: "pull request #3 is closed"}, fallback: 502, wantCode: "pr_not_open", wantHTTP: 409},
+		{name: "repair session not found", err: &RepairSessionError{Code: "repair_session_not_found", Message: `repair session "x" not found in run`}, fallback: 502, wantCode: "repair_session_not_found", wantHTTP: 404},
+		{name: "repair session closed", err: &RepairSessionError{Code: "repair_session_closed", Message: `repair session "x" is completed, not open`}, fallback: 502, wantCode: "repair_session_closed", wantHTTP: 409},
+		{name: "job not found", err: &JobError{Code: "job_not_found", Message: `job "x" not found in run`}, fallback: 500, wantCode: "job_not_found", wantHTTP: 404},
+		{name: "job not finished", err: &JobError{Code: "job_not_finished", Message: `job "x" is still running`}, fallback: 500, wantCode: "job_not_finished", wantHTTP: 409},
+		{name: "job failed replays its error", err: &JobFailedError{Code: "patch_conflict", Message: "1 hunk(s) do not apply", Status: 409}, fallback: 500, wantCode: "patch_conflict", wantHTTP: 409},
+		{name: "tool call cancelled", err: &ToolCallCancelledError{ToolCallID: "tc-1", Cause: ErrToolCallCancelled}, fallback: 409, wantCode: "tool_call_cancelled", wantHTTP: 409},
+		{name: "tool call not found", err: &ToolCallError{Code: "tool_call_not_found", Message: `tool call "x" not found in run`}, fallback: 500, wantCode: "tool_call_not_found", wantHTTP: 404},
+		{name: "tool call finished", err: &ToolCallError{Code: "tool_call_finished", Message: `tool call "x" already finished with status ok`}, fallback: 500, wantCode: "tool_call_finished", wantHTTP: 409},
+		{name: "qa cancelled", err: &testCodedError{code: "qa_cancelled", msg: "qa command cancelled: tool call cancelled by request"}, fallback: 500, wantCode: "qa_cancelled", wantHTTP: 200},
+		{name: "head branch moved", err: &testCodedError{code: "head_branch_moved", msg: `head branch "toolhub/x" moved: expected abc, current def`}, fallback: 502, wantCode: "head_branch_moved", wantHTTP: 409},
+		{name: "rate limited", err: &RateLimitError{Rule: "rule_1", Reason: "rate", RetryAfter: 1500 * time.Millisecond}, fallback: 500, wantCode: "rate_limited", wantHTTP: 429},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/toolhub/toolhub/internal/db"
+	"github.com/toolhub/toolhub/internal/qa"
+)
+
+// FlakyTestService keeps the per-repository history of tests that passed
+// when rerun after failing.
+type FlakyTestService struct {
+	db  *db.DB
+	now func() time.Time
+}
+
+// NewFlakyTestService creates a FlakyTestService backed by the given
+// database.
+func NewFlakyTestService(database *db.DB) *FlakyTestService {
+	return &FlakyTestService{db: database, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Record adds the outcome of the test reruns of report to repo's history.
+// Reports whose failed tests were not rerun are skipped.
+func (s *FlakyTestService) Record(ctx context.Context, repo string, report *qa.Report) error {
+	if report == nil || report.TestResults == nil || len(report.TestReruns) == 0 {
+		return nil
+	}
+	var flaky []string
+	for _, tc := range report.TestResults.Tests {
+		if tc.Flaky {
+			flaky = append(flaky, tc.ID())
+		}
+	}
+	if err := s.db.RecordTestOutcomes(ctx, repo, flaky, report.TestResults.FailedIDs(), s.now()); err != nil {
+		return fmt.Errorf("record flaky tests: %w", err)
+	}
+	return nil
+}
+
+// List returns up to limit tests of repo that were flaky, most often
+// flaky first.
+func (s *FlakyTestService) List(ctx context.Context, repo string, limit int) ([]db.FlakyTest, error) {
+	return s.db.ListFlakyTests(ctx, repo, limit)
+}
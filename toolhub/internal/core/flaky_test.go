@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/toolhub/toolhub/internal/db"
+	"github.com/toolhub/toolhub/internal/qa"
+)
+
+func TestFlakyTestHistory(t *testing.T) {
+	databaseURL := os.Getenv("TOOLHUB_TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TOOLHUB_TEST_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	database, err := db.New(databaseURL)
+	if err != nil {
+		t.Fatalf("db connect: %v", err)
+	}
+	defer database.Close()
+
+	migration, err := os.ReadFile("../db/migrations/010_flaky_tests.sql")
+	if err != nil {
+		t.Fatalf("read migration: %v", err)
+	}
+	if _, err := database.Conn().ExecContext(ctx, string(migration)); err != nil {
+		t.Fatalf("apply migration: %v", err)
+	}
+	repo := "owner/flaky-" + time.Now().Format("150405.000000000")
+
+	report := func(reruns int, tests ...qa.TestCase) *qa.Report {
+		results := &qa.TestResults{Tests: tests}
+		for _, tc := range tests {
+			if tc.Status == qa.TestFail {
+				results.Failed++
+				if tc.Flaky {
+					results.Flaky++
+				}
+			}
+		}
+		return &qa.Report{TestResults: results, TestReruns: make([]qa.TestRerun, reruns)}
+	}
+	flaky := NewFlakyTestService(database)
+	for _, r := range []*qa.Report{
+		report(1, qa.TestCase{Suite: "p", Name: "TestA", Status: qa.TestFail, Flaky: true}, qa.TestCase{Suite: "p", Name: "TestB", Status: qa.TestFail}),
+		report(1, qa.TestCase{Suite: "p", Name: "TestA", Status: qa.TestFail, Flaky: true}),
+		report(1, qa.TestCase{Suite: "p", Name: "TestB", Status: qa.TestFail, Flaky: true}),
+		// Without reruns nothing is known about flakiness.
+		report(0, qa.TestCase{Suite: "p", Name: "TestB", Status: qa.TestFail}),
+	} {
+		if err := flaky.Record(ctx, repo, r); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+
+	tests, err := flaky.List(ctx, repo, 10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(tests) != 2 || tests[0].TestID != "p::TestA" || tests[0].FlakyCount != 2 || tests[1].TestID != "p::TestB" || tests[1].FlakyCount != 1 || tests[1].FailedCount != 1 || tests[1].LastFlakyAt == nil {
+		t.Fatalf("flaky tests = %+v", tests)
+	}
+}
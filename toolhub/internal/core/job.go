@@ -0,0 +1,332 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/toolhub/toolhub/internal/db"
+)
+
+// Job statuses. A job is running until its tool call returns; it is
+// interrupted when the server stops before that.
+const (
+	JobRunning     = "running"
+	JobSucceeded   = "succeeded"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+	JobInterrupted = "interrupted"
+)
+
+// jobFinishTimeout bounds the writes that record a job's outcome, which
+// run after the job's own context is done.
+const jobFinishTimeout = 10 * time.Second
+
+var (
+	errJobCancelled   = errors.New("job cancelled")
+	errJobInterrupted = errors.New("job interrupted by server shutdown")
+)
+
+// JobError rejects a call for a job that does not exist in the run or is
+// not in a status that allows it.
+type JobError struct {
+	Code    string
+	Message string
+}
+
+func (e *JobError) Error() string     { return e.Message }
+func (e *JobError) ErrorCode() string { return e.Code }
+
+// JobFailedError replays the error a job's tool call failed with.
+type JobFailedError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *JobFailedError) Error() string     { return e.Message }
+func (e *JobFailedError) ErrorCode() string { return e.Code }
+
+// JobFunc runs a tool call in the background and returns its response.
+type JobFunc func(ctx context.Context) (*ToolResponse, error)
+
+// JobService runs tool calls as background jobs and keeps their status and
+// outcome in the jobs table, so callers can poll, cancel and fetch results
+// after the submitting request has returned.
+type JobService struct {
+	db     *db.DB
+	audit  *AuditService
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+	wg      sync.WaitGroup
+}
+
+// NewJobService creates a JobService backed by the given database.
+func NewJobService(database *db.DB, audit *AuditService, logger *slog.Logger) *JobService {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &JobService{
+		db:      database,
+		audit:   audit,
+		logger:  logger,
+		now:     func() time.Time { return time.Now().UTC() },
+		cancels: make(map[string]context.CancelCauseFunc),
+	}
+}
+
+type jobIDCtxKey struct{}
+
+// WithJobID marks ctx as running inside a job; steps started with it are
+// attributed to the job.
+func WithJobID(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, jobIDCtxKey{}, jobID)
+}
+
+// JobIDFromContext returns the job set by WithJobID, or "".
+func JobIDFromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(jobIDCtxKey{}).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// Start records a job for toolName and runs fn in the background. fn gets a
+// context detached from ctx's cancellation, which ends when the job is
+// cancelled or the service shuts down.
+func (s *JobService) Start(ctx context.Context, runID, toolName string, fn JobFunc) (*db.Job, error) {
+	jobID := uuid.New().String()
+	// The job outlives the request, so it must not report progress to it.
+	jobCtx := WithProgress(WithJobID(context.WithoutCancel(ctx), jobID), nil)
+
+	step, err := s.audit.StartStep(jobCtx, runID, toolName, "job")
+	if err != nil {
+		return nil, fmt.Errorf("start job step: %w", err)
+	}
+	now := s.now()
+	job := &db.Job{
+		JobID:     jobID,
+		RunID:     runID,
+		ToolName:  toolName,
+		Status:    JobRunning,
+		StepID:    &step.StepID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Steps:     []*db.Step{step},
+	}
+	if err := s.db.InsertJob(ctx, job); err != nil {
+		if ferr := s.audit.FinishStep(ctx, step.StepID, "failed"); ferr != nil {
+			s.logger.Error("audit finish step failed", "err", ferr, "step_id", step.StepID)
+		}
+		return nil, err
+	}
+
+	jobCtx, cancel := context.WithCancelCause(jobCtx)
+	s.mu.Lock()
+	s.cancels[jobID] = cancel
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.cancels, jobID)
+			s.mu.Unlock()
+			cancel(nil)
+		}()
+		resp, err := runJob(jobCtx, fn)
+		s.finish(jobCtx, job, resp, err)
+	}()
+	return job, nil
+}
+
+func runJob(ctx context.Context, fn JobFunc) (resp *ToolResponse, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return fn(ctx)
+}
+
+func (s *JobService) finish(ctx context.Context, job *db.Job, resp *ToolResponse, runErr error) {
+	var callCancelled *ToolCallCancelledError
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errJobInterrupted):
+		job.Status = JobInterrupted
+	case errors.Is(cause, errJobCancelled), errors.As(runErr, &callCancelled):
+		job.Status = JobCancelled
+	case runErr != nil:
+		job.Status = JobFailed
+	default:
+		job.Status = JobSucceeded
+	}
+	if resp != nil {
+		body, err := json.Marshal(resp.Body)
+		if err != nil {
+			runErr = fmt.Errorf("marshal job result: %w", err)
+			if job.Status == JobSucceeded {
+				job.Status = JobFailed
+			}
+		} else {
+			job.HTTPStatus = resp.Status
+			job.Result = body
+		}
+	}
+	if runErr != nil {
+		info := jobErrorInfo(runErr)
+		job.HTTPStatus = info.HTTPStatus
+		job.ErrorCode = info.Code
+		job.ErrorMessage = info.Message
+		job.Result = nil
+	}
+	now := s.now()
+	job.UpdatedAt = now
+	job.FinishedAt = &now
+
+	writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobFinishTimeout)
+	defer cancel()
+	logger := s.logger.With("run_id", job.RunID, "job_id", job.JobID, "tool_name", job.ToolName)
+	if err := s.db.FinishJob(writeCtx, job); err != nil {
+		logger.Error("finish job failed", "err", err)
+	}
+	if err := s.audit.FinishStep(writeCtx, *job.StepID, jobStepStatus(job.Status)); err != nil {
+		logger.Error("audit finish step failed", "err", err, "step_id", *job.StepID)
+	}
+	logger.Info("job finished", "status", job.Status, "err", runErr)
+}
+
+// jobErrorInfo maps the error of a job's tool call the way the transports
+// map a synchronous call's error.
+func jobErrorInfo(err error) ErrorInfo {
+	var reqErr *ToolRequestError
+	var execErr *ToolExecError
+	switch {
+	case errors.As(err, &execErr):
+		return MapError(execErr.Err, execErr.FallbackStatus)
+	case errors.As(err, &reqErr):
+		return MapError(reqErr, reqErr.Status)
+	default:
+		return MapError(err, http.StatusInternalServerError)
+	}
+}
+
+func jobStepStatus(status string) string {
+	switch status {
+	case JobSucceeded:
+		return "completed"
+	default:
+		return status
+	}
+}
+
+// Get returns the job of the run with the steps it took, or a
+// job_not_found error.
+func (s *JobService) Get(ctx context.Context, runID, jobID string) (*db.Job, error) {
+	job, err := s.db.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil || job.RunID != runID {
+		return nil, &JobError{Code: "job_not_found", Message: fmt.Sprintf("job %q not found in run", jobID)}
+	}
+	steps, err := s.db.ListStepsByJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	job.Steps = steps
+	return job, nil
+}
+
+// Cancel asks a running job to stop. The job records the cancelled status
+// once its tool call has returned.
+func (s *JobService) Cancel(ctx context.Context, runID, jobID string) (*db.Job, error) {
+	job, err := s.Get(ctx, runID, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != JobRunning {
+		return nil, &JobError{Code: "job_finished", Message: fmt.Sprintf("job %q is already %s", jobID, job.Status)}
+	}
+
+	s.mu.Lock()
+	cancel, ok := s.cancels[jobID]
+	s.mu.Unlock()
+	if ok {
+		cancel(errJobCancelled)
+		return job, nil
+	}
+
+	// No goroutine of this process runs the job; nothing else will finish it.
+	now := s.now()
+	if _, err := s.db.TransitionJobStatus(ctx, jobID, JobCancelled, now); err != nil {
+		return nil, err
+	}
+	if job.StepID != nil {
+		if err := s.audit.FinishStep(ctx, *job.StepID, JobCancelled); err != nil {
+			s.logger.Error("audit finish step failed", "err", err, "step_id", *job.StepID)
+		}
+	}
+	return s.Get(ctx, runID, jobID)
+}
+
+// Result returns the response of a finished job as the tool call would
+// have returned it, or the error the call failed with.
+func (s *JobService) Result(ctx context.Context, runID, jobID string) (*ToolResponse, error) {
+	job, err := s.db.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil || job.RunID != runID {
+		return nil, &JobError{Code: "job_not_found", Message: fmt.Sprintf("job %q not found in run", jobID)}
+	}
+	switch {
+	case job.Status == JobRunning:
+		return nil, &JobError{Code: "job_not_finished", Message: fmt.Sprintf("job %q is still running", jobID)}
+	case job.Result != nil:
+		return &ToolResponse{Status: job.HTTPStatus, Body: job.Result}, nil
+	case job.ErrorCode != "":
+		return nil, &JobFailedError{Code: job.ErrorCode, Message: job.ErrorMessage, Status: job.HTTPStatus}
+	default:
+		return nil, &JobError{Code: "job_" + job.Status, Message: fmt.Sprintf("job %q was %s before it produced a result", jobID, job.Status)}
+	}
+}
+
+// InterruptStale marks jobs left running by a previous server process as
+// interrupted. Call it at startup, before any job is started.
+func (s *JobService) InterruptStale(ctx context.Context) (int64, error) {
+	return s.db.InterruptRunningJobs(ctx, s.now())
+}
+
+// Shutdown interrupts the running jobs and waits until they have recorded
+// their outcome or ctx is done.
+func (s *JobService) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	for _, cancel := range s.cancels {
+		cancel(errJobInterrupted)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
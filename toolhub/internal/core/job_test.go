@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/toolhub/toolhub/internal/db"
+)
+
+func TestJobLifecycle(t *testing.T) {
+	databaseURL := os.Getenv("TOOLHUB_TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TOOLHUB_TEST_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	database, err := db.New(databaseURL)
+	if err != nil {
+		t.Fatalf("db connect: %v", err)
+	}
+	defer database.Close()
+
+	if err := ensureSchema(ctx, database); err != nil {
+		t.Fatalf("ensure schema: %v", err)
+	}
+	for _, name := range []string{"003_steps_decisions_approvals.sql", "005_jobs.sql"} {
+		migration, err := os.ReadFile("../db/migrations/" + name)
+		if err != nil {
+			t.Fatalf("read migration: %v", err)
+		}
+		if _, err := database.Conn().ExecContext(ctx, string(migration)); err != nil {
+			t.Fatalf("apply migration %s: %v", name, err)
+		}
+	}
+
+	store, err := NewArtifactStore(database, t.TempDir())
+	if err != nil {
+		t.Fatalf("new artifact store: %v", err)
+	}
+	audit := NewAuditService(database, store, NewPolicy("owner/repo", "qa.test"))
+	run, err := NewRunService(database).CreateRun(ctx, CreateRunRequest{Repo: "owner/repo", Purpose: "job_test"})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	jobs := NewJobService(database, audit, nil)
+
+	wait := func(jobID string) *db.Job {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			job, err := jobs.Get(ctx, run.RunID, jobID)
+			if err != nil {
+				t.Fatalf("get job: %v", err)
+			}
+			if job.Status != JobRunning {
+				return job
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %s still running", jobID)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	wantCode := func(err error, code string) {
+		t.Helper()
+		var coded CodedError
+		if !errors.As(err, &coded) || coded.ErrorCode() != code {
+			t.Fatalf("err = %v, want %s", err, code)
+		}
+	}
+
+	t.Run("succeeds", func(t *testing.T) {
+		release := make(chan struct{})
+		job, err := jobs.Start(ctx, run.RunID, "qa.test", func(ctx context.Context) (*ToolResponse, error) {
+			<-release
+			if _, err := audit.StartStep(ctx, run.RunID, "qa_test", "qa"); err != nil {
+				return nil, err
+			}
+			return &ToolResponse{Status: http.StatusOK, Body: ToolEnvelope{OK: true, Result: map[string]string{"status": "pass"}}}, nil
+		})
+		if err != nil {
+			t.Fatalf("start job: %v", err)
+		}
+		_, err = jobs.Result(ctx, run.RunID, job.JobID)
+		wantCode(err, "job_not_finished")
+		close(release)
+
+		done := wait(job.JobID)
+		if done.Status != JobSucceeded || len(done.Steps) != 2 || done.Steps[0].Status != "completed" {
+			t.Fatalf("job = %+v", done)
+		}
+		resp, err := jobs.Result(ctx, run.RunID, job.JobID)
+		if err != nil {
+			t.Fatalf("result: %v", err)
+		}
+		var env ToolEnvelope
+		if err := json.Unmarshal(resp.Body.(json.RawMessage), &env); err != nil || resp.Status != http.StatusOK || !env.OK {
+			t.Fatalf("result = %d %s (%v)", resp.Status, resp.Body, err)
+		}
+		_, err = jobs.Cancel(ctx, run.RunID, job.JobID)
+		wantCode(err, "job_finished")
+	})
+
+	t.Run("fails", func(t *testing.T) {
+		job, err := jobs.Start(ctx, run.RunID, "qa.test", func(ctx context.Context) (*ToolResponse, error) {
+			return nil, &ToolExecError{Err: &testCodedError{code: "stale_original_content", msg: "stale"}, FallbackStatus: http.StatusBadGateway}
+		})
+		if err != nil {
+			t.Fatalf("start job: %v", err)
+		}
+		if done := wait(job.JobID); done.Status != JobFailed || done.ErrorCode != "stale_original_content" {
+			t.Fatalf("job = %+v", done)
+		}
+		_, err = jobs.Result(ctx, run.RunID, job.JobID)
+		if info := MapError(err, http.StatusInternalServerError); info.Code != "stale_original_content" || info.HTTPStatus != http.StatusConflict {
+			t.Fatalf("result error = %+v", info)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		job, err := jobs.Start(ctx, run.RunID, "qa.test", func(ctx context.Context) (*ToolResponse, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		if err != nil {
+			t.Fatalf("start job: %v", err)
+		}
+		if _, err := jobs.Cancel(ctx, run.RunID, job.JobID); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+		if done := wait(job.JobID); done.Status != JobCancelled || done.Steps[0].Status != JobCancelled {
+			t.Fatalf("job = %+v", done)
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		now := time.Now().UTC()
+		stale := &db.Job{JobID: "stale-" + run.RunID, RunID: run.RunID, ToolName: "qa.test", Status: JobRunning, CreatedAt: now, UpdatedAt: now}
+		if err := database.InsertJob(ctx, stale); err != nil {
+			t.Fatalf("insert job: %v", err)
+		}
+		if n, err := jobs.InterruptStale(ctx); err != nil || n == 0 {
+			t.Fatalf("interrupt stale = %d, %v", n, err)
+		}
+		_, err := jobs.Result(ctx, run.RunID, stale.JobID)
+		wantCode(err, "job_interrupted")
+	})
+
+	_, err = jobs.Get(ctx, "other-run", "missing")
+	wantCode(err, "job_not_found")
+}
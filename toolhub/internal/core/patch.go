@@ -5,36 +5,116 @@ import (
 	"strings"
 )
 
+// DefaultDiffContext is the number of unchanged lines kept around each
+// change, matching git diff.
+const DefaultDiffContext = 3
+
+// DiffOptions controls GenerateUnifiedDiffWithOptions.
+type DiffOptions struct {
+	// Context is the number of unchanged lines around each change; negative
+	// values are treated as zero.
+	Context int
+	// NewFile and DeletedFile emit git's new/deleted file headers and
+	// /dev/null paths instead of a plain modification.
+	NewFile     bool
+	DeletedFile bool
+	// OldPath, when it differs from the path, makes the diff a rename.
+	OldPath string
+	// OldMode and NewMode are git file modes such as 100644 or 100755; a
+	// difference emits git's mode change headers. Empty means 100644.
+	OldMode string
+	NewMode string
+}
+
+// GenerateUnifiedDiff returns a git-style unified diff of one file with
+// DefaultDiffContext lines of context. Identical contents yield "".
 func GenerateUnifiedDiff(path, originalContent, modifiedContent string) string {
+	return GenerateUnifiedDiffWithOptions(path, originalContent, modifiedContent, DiffOptions{Context: DefaultDiffContext})
+}
+
+// GenerateUnifiedDiffWithOptions returns a git-style unified diff that
+// `git apply` accepts. Lines are compared byte for byte, so CRLF endings are
+// preserved, and a missing final newline is marked with
+// "\ No newline at end of file".
+func GenerateUnifiedDiffWithOptions(path, originalContent, modifiedContent string, opts DiffOptions) string {
 	cleanPath := strings.TrimSpace(path)
 	cleanPath = strings.TrimPrefix(cleanPath, "./")
 	if cleanPath == "" {
 		cleanPath = "unknown.txt"
 	}
+	if opts.Context < 0 {
+		opts.Context = 0
+	}
 
-	origLines := splitLines(originalContent)
-	modLines := splitLines(modifiedContent)
+	origLines := diffLines(originalContent)
+	modLines := diffLines(modifiedContent)
+	edits := myersDiff(origLines, modLines)
+	hunks := groupHunks(edits, opts.Context)
+	oldPath := strings.TrimPrefix(strings.TrimSpace(opts.OldPath), "./")
+	if oldPath == "" {
+		oldPath = cleanPath
+	}
+	oldMode, newMode := fileModeOrDefault(opts.OldMode), fileModeOrDefault(opts.NewMode)
+	renamed := oldPath != cleanPath
+	modeChanged := oldMode != newMode && !opts.NewFile && !opts.DeletedFile
+	if len(hunks) == 0 && !opts.NewFile && !opts.DeletedFile && !renamed && !modeChanged {
+		return ""
+	}
 
 	var b strings.Builder
-	b.WriteString(fmt.Sprintf("diff --git a/%s b/%s\n", cleanPath, cleanPath))
-	b.WriteString(fmt.Sprintf("--- a/%s\n", cleanPath))
-	b.WriteString(fmt.Sprintf("+++ b/%s\n", cleanPath))
-	b.WriteString(fmt.Sprintf("@@ -1,%d +1,%d @@\n", len(origLines), len(modLines)))
-
-	for _, line := range origLines {
-		b.WriteString("-")
-		b.WriteString(line)
-		b.WriteString("\n")
+	b.WriteString(fmt.Sprintf("diff --git a/%s b/%s\n", oldPath, cleanPath))
+	oldName, newName := "a/"+oldPath, "b/"+cleanPath
+	switch {
+	case opts.NewFile:
+		b.WriteString(fmt.Sprintf("new file mode %s\n", newMode))
+		oldName = "/dev/null"
+	case opts.DeletedFile:
+		b.WriteString(fmt.Sprintf("deleted file mode %s\n", oldMode))
+		newName = "/dev/null"
+	case modeChanged:
+		b.WriteString(fmt.Sprintf("old mode %s\nnew mode %s\n", oldMode, newMode))
 	}
-	for _, line := range modLines {
-		b.WriteString("+")
-		b.WriteString(line)
-		b.WriteString("\n")
+	if renamed {
+		b.WriteString(fmt.Sprintf("similarity index %d%%\n", similarity(origLines, modLines, edits)))
+		b.WriteString(fmt.Sprintf("rename from %s\nrename to %s\n", oldPath, cleanPath))
+	}
+	if len(hunks) == 0 {
+		// Empty file created or deleted, pure renames and mode changes: git
+		// records only the header.
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("--- %s\n", oldName))
+	b.WriteString(fmt.Sprintf("+++ %s\n", newName))
+	for _, h := range hunks {
+		writeHunk(&b, h, edits)
 	}
-
 	return b.String()
 }
 
+func fileModeOrDefault(mode string) string {
+	if mode == "" {
+		return "100644"
+	}
+	return mode
+}
+
+// similarity approximates git's rename score as the share of unchanged
+// lines.
+func similarity(orig, mod []string, edits []edit) int {
+	total := max(len(orig), len(mod))
+	if total == 0 {
+		return 100
+	}
+	same := 0
+	for _, e := range edits {
+		if e.kind == editEqual {
+			same++
+		}
+	}
+	return same * 100 / total
+}
+
+// CountContentLines counts lines, ignoring a trailing newline.
 func CountContentLines(content string) int {
 	return len(splitLines(content))
 }
@@ -50,3 +130,230 @@ func splitLines(content string) []string {
 	}
 	return strings.Split(trimmed, "\n")
 }
+
+// diffLines splits content into lines that keep their "\n" terminator, so
+// a last line without one compares unequal to the same text with one.
+func diffLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type editKind byte
+
+const (
+	editEqual  editKind = ' '
+	editDelete editKind = '-'
+	editInsert editKind = '+'
+)
+
+// edit is one line of the edit script. oldLine and newLine are the 0-based
+// positions in each file before the edit is applied.
+type edit struct {
+	kind    editKind
+	line    string
+	oldLine int
+	newLine int
+}
+
+// myersDiff computes a shortest edit script with Myers' O(ND) algorithm,
+// or replaces the changed range outright past maxDiffCost. The common
+// prefix and suffix are trimmed first, which keeps the search small for
+// typical local edits.
+func myersDiff(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, edit{kind: editEqual, line: a[i], oldLine: i, newLine: i})
+	}
+	edits = append(edits, myersMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix, prefix)...)
+	for i := suffix; i > 0; i-- {
+		edits = append(edits, edit{kind: editEqual, line: a[len(a)-i], oldLine: len(a) - i, newLine: len(b) - i})
+	}
+	return edits
+}
+
+// maxDiffCost caps the edit distance myersMiddle searches. Its trace grows
+// with the square of the distance, so larger rewrites fall back to
+// replacing the whole changed range, which costs linear memory.
+const maxDiffCost = 1000
+
+func myersMiddle(a, b []string, oldOff, newOff int) []edit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	limit := n + m
+	if limit > maxDiffCost {
+		limit = maxDiffCost
+	}
+	offset := limit
+	v := make([]int, 2*limit+2)
+	// trace[d] holds v[-d..d] as it was before round d, which is all the
+	// backtracking needs; keeping only that range bounds memory to O(D^2).
+	var trace [][]int
+	found := false
+
+search:
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break search
+			}
+		}
+	}
+	if !found {
+		return replaceRange(a, b, oldOff, newOff)
+	}
+
+	// Walk the trace backwards from (n, m) to recover the path.
+	var rev []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		k := x - y
+		prevX, prevY := 0, 0
+		if d > 0 {
+			at := func(k int) int { return trace[d][k+d] }
+			prevK := k - 1
+			if k == -d || (k != d && at(k-1) < at(k+1)) {
+				prevK = k + 1
+			}
+			prevX = at(prevK)
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, edit{kind: editEqual, line: a[x], oldLine: oldOff + x, newLine: newOff + y})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			rev = append(rev, edit{kind: editInsert, line: b[y], oldLine: oldOff + x, newLine: newOff + y})
+		} else {
+			x--
+			rev = append(rev, edit{kind: editDelete, line: a[x], oldLine: oldOff + x, newLine: newOff + y})
+		}
+	}
+
+	out := make([]edit, len(rev))
+	for i := range rev {
+		out[i] = rev[len(rev)-1-i]
+	}
+	return out
+}
+
+// replaceRange is the edit script that deletes all of a and inserts all of
+// b, for changes too large to search for a shorter one.
+func replaceRange(a, b []string, oldOff, newOff int) []edit {
+	out := make([]edit, 0, len(a)+len(b))
+	for i, line := range a {
+		out = append(out, edit{kind: editDelete, line: line, oldLine: oldOff + i, newLine: newOff})
+	}
+	for i, line := range b {
+		out = append(out, edit{kind: editInsert, line: line, oldLine: oldOff + len(a), newLine: newOff + i})
+	}
+	return out
+}
+
+// hunk is a half-open range of the edit script.
+type hunk struct {
+	start, end int
+}
+
+// groupHunks keeps context lines around each change and merges changes whose
+// context would overlap or touch.
+func groupHunks(edits []edit, context int) []hunk {
+	var hunks []hunk
+	for i := 0; i < len(edits); i++ {
+		if edits[i].kind == editEqual {
+			continue
+		}
+		start := max(i-context, 0)
+		end := i + 1
+		for end < len(edits) {
+			if edits[end].kind != editEqual {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].kind == editEqual {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		end = min(end+context, len(edits))
+		if n := len(hunks); n > 0 && start <= hunks[n-1].end {
+			hunks[n-1].end = end
+		} else {
+			hunks = append(hunks, hunk{start: start, end: end})
+		}
+		i = end - 1
+	}
+	return hunks
+}
+
+func writeHunk(b *strings.Builder, h hunk, edits []edit) {
+	first := edits[h.start]
+	oldCount, newCount := 0, 0
+	for _, e := range edits[h.start:h.end] {
+		if e.kind != editInsert {
+			oldCount++
+		}
+		if e.kind != editDelete {
+			newCount++
+		}
+	}
+	b.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(first.oldLine, oldCount), hunkRange(first.newLine, newCount)))
+	for _, e := range edits[h.start:h.end] {
+		b.WriteByte(byte(e.kind))
+		b.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats a hunk side as git does: an empty range points at the
+// line before it, and a count of one is omitted.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,291 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		b.WriteString("line ")
+		b.WriteString(strings.Repeat("x", i%7))
+		b.WriteString(string(rune('a' + i%26)))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// rewrittenLines is numberedLines(n) with every other line replaced, so no
+// run of lines is shared except single unchanged ones.
+func rewrittenLines(n int) string {
+	lines := strings.SplitAfter(numberedLines(n), "\n")
+	for i := 0; i < n; i += 2 {
+		lines[i] = fmt.Sprintf("rewritten %d\n", i)
+	}
+	return strings.Join(lines, "")
+}
+
+func replaceLine(content string, lineNo int, text string) string {
+	lines := strings.SplitAfter(content, "\n")
+	lines[lineNo-1] = text + "\n"
+	return strings.Join(lines, "")
+}
+
+func TestGenerateUnifiedDiffHunks(t *testing.T) {
+	base := numberedLines(20)
+	tests := []struct {
+		name     string
+		orig     string
+		mod      string
+		opts     DiffOptions
+		want     string
+		hunkLine []string
+	}{
+		{
+			name:     "single change keeps three context lines",
+			orig:     base,
+			mod:      replaceLine(base, 10, "changed"),
+			opts:     DiffOptions{Context: 3},
+			hunkLine: []string{"@@ -7,7 +7,7 @@"},
+		},
+		{
+			name:     "distant changes produce separate hunks",
+			orig:     base,
+			mod:      replaceLine(replaceLine(base, 2, "first"), 18, "second"),
+			opts:     DiffOptions{Context: 3},
+			hunkLine: []string{"@@ -1,5 +1,5 @@", "@@ -15,6 +15,6 @@"},
+		},
+		{
+			name:     "nearby changes merge into one hunk",
+			orig:     base,
+			mod:      replaceLine(replaceLine(base, 5, "first"), 10, "second"),
+			opts:     DiffOptions{Context: 3},
+			hunkLine: []string{"@@ -2,12 +2,12 @@"},
+		},
+		{
+			name:     "zero context",
+			orig:     base,
+			mod:      replaceLine(base, 10, "changed"),
+			opts:     DiffOptions{Context: 0},
+			hunkLine: []string{"@@ -10 +10 @@"},
+		},
+		{
+			name: "pure insertion",
+			orig: "a\nb\n",
+			mod:  "a\nnew\nb\n",
+			opts: DiffOptions{Context: 0},
+			want: "diff --git a/f.txt b/f.txt\n--- a/f.txt\n+++ b/f.txt\n@@ -1,0 +2 @@\n+new\n",
+		},
+		{
+			name: "missing final newline is marked",
+			orig: "a\nb",
+			mod:  "a\nc",
+			opts: DiffOptions{Context: 3},
+			want: "diff --git a/f.txt b/f.txt\n--- a/f.txt\n+++ b/f.txt\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name: "adding final newline",
+			orig: "a\nb",
+			mod:  "a\nb\n",
+			opts: DiffOptions{Context: 3},
+			want: "diff --git a/f.txt b/f.txt\n--- a/f.txt\n+++ b/f.txt\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "new file",
+			orig: "",
+			mod:  "hello\nworld\n",
+			opts: DiffOptions{Context: 3, NewFile: true},
+			want: "diff --git a/f.txt b/f.txt\nnew file mode 100644\n--- /dev/null\n+++ b/f.txt\n@@ -0,0 +1,2 @@\n+hello\n+world\n",
+		},
+		{
+			name: "deleted file",
+			orig: "hello\n",
+			mod:  "",
+			opts: DiffOptions{Context: 3, DeletedFile: true},
+			want: "diff --git a/f.txt b/f.txt\ndeleted file mode 100644\n--- a/f.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-hello\n",
+		},
+		{
+			name: "identical content",
+			orig: base,
+			mod:  base,
+			opts: DiffOptions{Context: 3},
+			want: "",
+		},
+		{
+			name: "mode change only",
+			orig: "a\n",
+			mod:  "a\n",
+			opts: DiffOptions{Context: 3, OldMode: "100644", NewMode: "100755"},
+			want: "diff --git a/f.txt b/f.txt\nold mode 100644\nnew mode 100755\n",
+		},
+		{
+			name: "pure rename",
+			orig: "a\n",
+			mod:  "a\n",
+			opts: DiffOptions{Context: 3, OldPath: "old.txt"},
+			want: "diff --git a/old.txt b/f.txt\nsimilarity index 100%\nrename from old.txt\nrename to f.txt\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := GenerateUnifiedDiffWithOptions("f.txt", tc.orig, tc.mod, tc.opts)
+			if tc.hunkLine == nil && got != tc.want {
+				t.Fatalf("diff mismatch\n got: %q\nwant: %q", got, tc.want)
+			}
+			var headers []string
+			for _, line := range strings.Split(got, "\n") {
+				if strings.HasPrefix(line, "@@") {
+					headers = append(headers, line)
+				}
+			}
+			if tc.hunkLine != nil && strings.Join(headers, "|") != strings.Join(tc.hunkLine, "|") {
+				t.Fatalf("hunk headers = %v, want %v\n%s", headers, tc.hunkLine, got)
+			}
+		})
+	}
+}
+
+func TestGenerateUnifiedDiffGitApply(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	base := numberedLines(40)
+	tests := []struct {
+		name string
+		orig string
+		mod  string
+		opts DiffOptions
+		// path is where the file ends up, when renamed away from src/f.txt.
+		path string
+	}{
+		{name: "modify several regions", orig: base, mod: replaceLine(replaceLine(replaceLine(base, 3, "one"), 20, "two"), 38, "three"), opts: DiffOptions{Context: 3}},
+		{name: "insert and delete", orig: "a\nb\nc\nd\ne\n", mod: "a\nc\nd\nx\ny\ne\n", opts: DiffOptions{Context: 1}},
+		{name: "remove final newline", orig: "a\nb\n", mod: "a\nb", opts: DiffOptions{Context: 3}},
+		{name: "crlf lines", orig: "a\r\nb\r\nc\r\n", mod: "a\r\nB\r\nc\r\n", opts: DiffOptions{Context: 3}},
+		{name: "rewrite everything", orig: "a\nb\n", mod: "c\nd\ne\n", opts: DiffOptions{Context: 3}},
+		{name: "rewrite past the cost cap", orig: "keep\n" + numberedLines(1500) + "end\n", mod: "keep\n" + rewrittenLines(1500) + "end\n", opts: DiffOptions{Context: 3}},
+		{name: "new file", orig: "", mod: "x\ny", opts: DiffOptions{Context: 3, NewFile: true}},
+		{name: "deleted file", orig: "x\ny\n", mod: "", opts: DiffOptions{Context: 3, DeletedFile: true}},
+		{name: "new executable", orig: "", mod: "#!/bin/sh\n", opts: DiffOptions{Context: 3, NewFile: true, NewMode: "100755"}},
+		{name: "mode change only", orig: "a\n", mod: "a\n", opts: DiffOptions{Context: 3, NewMode: "100755"}},
+		{name: "pure rename", orig: base, mod: base, opts: DiffOptions{Context: 3, OldPath: "src/f.txt"}, path: "lib/g.txt"},
+		{
+			name: "rename with changes and mode",
+			orig: base,
+			mod:  replaceLine(base, 10, "ten"),
+			opts: DiffOptions{Context: 3, OldPath: "src/f.txt", OldMode: "100755", NewMode: "100644"},
+			path: "lib/g.txt",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := "src/f.txt"
+			if tc.path != "" {
+				path = tc.path
+			}
+			target := filepath.Join(dir, path)
+			if !tc.opts.NewFile {
+				source := filepath.Join(dir, "src", "f.txt")
+				if err := os.MkdirAll(filepath.Dir(source), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				perm := os.FileMode(0o644)
+				if tc.opts.OldMode == "100755" {
+					perm = 0o755
+				}
+				if err := os.WriteFile(source, []byte(tc.orig), perm); err != nil {
+					t.Fatal(err)
+				}
+			}
+			patch := GenerateUnifiedDiffWithOptions(path, tc.orig, tc.mod, tc.opts)
+			patchFile := filepath.Join(dir, "change.diff")
+			if err := os.WriteFile(patchFile, []byte(patch), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, args := range [][]string{{"apply", "--check", patchFile}, {"apply", patchFile}} {
+				cmd := exec.Command("git", args...)
+				cmd.Dir = dir
+				if out, err := cmd.CombinedOutput(); err != nil {
+					t.Fatalf("git %s: %v\n%s\npatch:\n%s", strings.Join(args, " "), err, out, patch)
+				}
+			}
+
+			got, err := os.ReadFile(target)
+			if tc.opts.DeletedFile {
+				if !os.IsNotExist(err) {
+					t.Fatalf("expected file to be deleted, stat err = %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.mod {
+				t.Fatalf("applied content = %q, want %q", got, tc.mod)
+			}
+			info, err := os.Stat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exec := info.Mode()&0o100 != 0; exec != (tc.opts.NewMode == "100755") {
+				t.Fatalf("mode = %v, want %s", info.Mode(), tc.opts.NewMode)
+			}
+			if tc.path != "" {
+				if _, err := os.Stat(filepath.Join(dir, "src", "f.txt")); !os.IsNotExist(err) {
+					t.Fatalf("rename source still exists: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMyersDiffIsMinimal(t *testing.T) {
+	a := strings.Split("a b c a b b a", " ")
+	b := strings.Split("c b a b a c", " ")
+	changes := 0
+	for _, e := range myersDiff(a, b) {
+		if e.kind != editEqual {
+			changes++
+		}
+	}
+	// The classic example from Myers' paper has an edit distance of 5.
+	if changes != 5 {
+		t.Fatalf("edit script has %d changes, want 5", changes)
+	}
+}
+
+func TestMyersDiffLargeRewriteIsBounded(t *testing.T) {
+	orig, mod := numberedLines(5000), rewrittenLines(5000)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	diff := GenerateUnifiedDiff("f.txt", orig, mod)
+	runtime.ReadMemStats(&after)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Fatalf("diff of a 5000-line rewrite allocated %d MiB", allocated>>20)
+	}
+	if !strings.Contains(diff, "@@ -1,5000 +1,5000 @@") {
+		t.Fatalf("expected one whole-file hunk, got %.200s", diff)
+	}
+	// The unchanged last line is trimmed as a common suffix; the rest is
+	// replaced outright.
+	changes := 0
+	for _, e := range myersDiff(diffLines(orig), diffLines(mod)) {
+		if e.kind != editEqual {
+			changes++
+		}
+	}
+	if changes != 2*4999 {
+		t.Fatalf("edit script has %d changes, want %d", changes, 2*4999)
+	}
+}
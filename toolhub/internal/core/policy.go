@@ -12,6 +12,7 @@ type Policy struct {
 	allowedTools          map[string]bool
 	forbiddenPathPrefixes []string
 	approvalPathPrefixes  []string
+	limiter               *RateLimiter
 }
 
 var builtinForbiddenPrefixes = []string{
@@ -37,6 +38,20 @@ func (p *Policy) SetPathPolicy(forbiddenCSV, approvalCSV string) {
 	p.approvalPathPrefixes = parsePrefixesCSV(approvalCSV)
 }
 
+// SetRateLimiter installs the limiter consulted by CheckRateLimit.
+func (p *Policy) SetRateLimiter(l *RateLimiter) {
+	p.limiter = l
+}
+
+// CheckRateLimit returns a *RateLimitError when the call exceeds a configured
+// bucket or daily quota. Without a limiter every call is allowed.
+func (p *Policy) CheckRateLimit(key RateLimitKey) error {
+	if p == nil {
+		return nil
+	}
+	return p.limiter.Allow(key)
+}
+
 // CheckRepo returns an error if repo is not in the allowlist.
 func (p *Policy) CheckRepo(repo string) error {
 	if len(p.allowedRepos) == 0 {
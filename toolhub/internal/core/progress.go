@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"fmt"
+)
+
+// Progress event types.
+const (
+	ProgressStarted         = "started"
+	ProgressOutput          = "output"
+	ProgressRepairIteration = "repair_iteration"
+)
+
+// ProgressEvent is a live update of a running tool call. Transports stream
+// it to clients that asked for progress: HTTP as server-sent events, MCP as
+// notifications/progress.
+type ProgressEvent struct {
+	Type string `json:"type" jsonschema:"enum=started|output|repair_iteration"`
+	// ToolCallID is set on the started event, sent once the call has passed
+	// its checks; the call can be cancelled by it while it runs.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// Tool is the QA tool producing output, e.g. qa.test.
+	Tool string `json:"tool,omitempty"`
+	// Stream and Data carry a chunk of command output; the chunks of a
+	// stream add up to the output in the QA report.
+	Stream string `json:"stream,omitempty" jsonschema:"enum=stdout|stderr"`
+	Data   string `json:"data,omitempty"`
+	// The repair fields describe an iteration of a repair loop: Status is
+	// started before its QA run, then passed or failed.
+	RepairSessionID string `json:"repair_session_id,omitempty"`
+	Iteration       int    `json:"iteration,omitempty"`
+	MaxIterations   int    `json:"max_iterations,omitempty"`
+	Status          string `json:"status,omitempty"`
+}
+
+// Message renders ev as a single human-readable line.
+func (ev ProgressEvent) Message() string {
+	switch ev.Type {
+	case ProgressStarted:
+		return "started tool call " + ev.ToolCallID
+	case ProgressOutput:
+		return ev.Data
+	case ProgressRepairIteration:
+		return fmt.Sprintf("repair iteration %d of %d %s", ev.Iteration, ev.MaxIterations, ev.Status)
+	default:
+		return ev.Type
+	}
+}
+
+// ProgressFunc receives the progress events of a tool call. It may be
+// called concurrently.
+type ProgressFunc func(ProgressEvent)
+
+type progressCtxKey struct{}
+
+// WithProgress returns a context whose tool call reports progress to fn. A
+// nil fn detaches progress reporting, e.g. for a background job that
+// outlives the request.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressCtxKey{}, fn)
+}
+
+// ReportProgress sends ev to the ProgressFunc of ctx, if any.
+func ReportProgress(ctx context.Context, ev ProgressEvent) {
+	if fn, _ := ctx.Value(progressCtxKey{}).(ProgressFunc); fn != nil {
+		fn(ev)
+	}
+}
+
+// HasProgress reports whether ctx carries a ProgressFunc.
+func HasProgress(ctx context.Context) bool {
+	fn, _ := ctx.Value(progressCtxKey{}).(ProgressFunc)
+	return fn != nil
+}
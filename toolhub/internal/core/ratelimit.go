@@ -0,0 +1,440 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toolhub/toolhub/internal/telemetry"
+)
+
+// Rate limit dimensions used to partition buckets and quotas.
+const (
+	RateLimitByRun       = "run"
+	RateLimitByRepo      = "repo"
+	RateLimitByTool      = "tool"
+	RateLimitByPrincipal = "principal"
+)
+
+var defaultRateLimitPer = []string{RateLimitByTool, RateLimitByRepo, RateLimitByPrincipal}
+
+// RateLimitRule configures a token bucket and/or a daily quota for tool calls
+// matching its selectors. Empty selectors and "*" match everything. Per lists
+// the dimensions that get their own bucket, so a rule with Per=[run] limits
+// each run independently.
+type RateLimitRule struct {
+	Name      string
+	Tool      string
+	Repo      string
+	Principal string
+	Per       []string
+	Rate      float64 // tokens per second; 0 disables the bucket
+	Burst     int
+	Daily     int // calls per UTC day; 0 disables the quota
+}
+
+// RateLimitKey identifies one tool call for limiting purposes.
+type RateLimitKey struct {
+	Tool      string
+	Repo      string
+	RunID     string
+	Principal string
+}
+
+// RateLimitError is returned when a call exceeds a bucket or daily quota.
+type RateLimitError struct {
+	Rule       string
+	Reason     string // "rate" or "daily_quota"
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded (rule %s, %s); retry after %ds", e.Rule, e.Reason, e.RetryAfterSeconds())
+}
+
+func (e *RateLimitError) ErrorCode() string { return "rate_limited" }
+
+// RetryAfterSeconds rounds RetryAfter up to whole seconds, minimum 1.
+func (e *RateLimitError) RetryAfterSeconds() int {
+	secs := int(math.Ceil(e.RetryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// RateLimitUsage is a point-in-time view of one bucket/quota.
+type RateLimitUsage struct {
+	Rule            string  `json:"rule"`
+	Key             string  `json:"key"`
+	TokensRemaining float64 `json:"tokens_remaining"`
+	DailyUsed       int     `json:"daily_used"`
+	DailyLimit      int     `json:"daily_limit"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  int
+}
+
+// full reports whether the bucket has refilled to its burst by now, so
+// dropping it changes nothing.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= float64(b.burst)
+}
+
+type dailyQuota struct {
+	day  string
+	used int
+}
+
+// rateLimitPruneInterval is how often Allow drops the buckets and quotas
+// that no longer limit anything; their keys hold run IDs and principals,
+// so they would otherwise accumulate for the life of the process.
+const rateLimitPruneInterval = time.Minute
+
+// RateLimiter enforces RateLimitRules in memory. A nil *RateLimiter allows
+// every call.
+type RateLimiter struct {
+	mu         sync.Mutex
+	rules      []RateLimitRule
+	buckets    map[string]*tokenBucket
+	quotas     map[string]*dailyQuota
+	now        func() time.Time
+	lastPruned time.Time
+}
+
+func NewRateLimiter(rules []RateLimitRule) *RateLimiter {
+	normalized := make([]RateLimitRule, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule_%d", i+1)
+		}
+		if len(rule.Per) == 0 {
+			rule.Per = append([]string{}, defaultRateLimitPer...)
+		}
+		if rule.Rate > 0 && rule.Burst <= 0 {
+			rule.Burst = int(math.Max(1, math.Ceil(rule.Rate)))
+		}
+		normalized[i] = rule
+	}
+	return &RateLimiter{
+		rules:   normalized,
+		buckets: make(map[string]*tokenBucket),
+		quotas:  make(map[string]*dailyQuota),
+		now:     time.Now,
+	}
+}
+
+// Allow consumes one token from every matching bucket and one unit of every
+// matching daily quota. Nothing is consumed when any rule rejects the call.
+func (l *RateLimiter) Allow(key RateLimitKey) error {
+	if l == nil || len(l.rules) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	day := now.UTC().Format("2006-01-02")
+	if now.Sub(l.lastPruned) >= rateLimitPruneInterval {
+		l.prune(now, day)
+	}
+
+	type pending struct {
+		rule   RateLimitRule
+		key    string
+		bucket *tokenBucket
+		quota  *dailyQuota
+	}
+	matched := make([]pending, 0, len(l.rules))
+	var rejection *RateLimitError
+
+	for _, rule := range l.rules {
+		if !rule.matches(key) {
+			continue
+		}
+		p := pending{rule: rule, key: rule.bucketKey(key)}
+		id := rule.Name + "|" + p.key
+
+		if rule.Rate > 0 {
+			b, ok := l.buckets[id]
+			if !ok {
+				b = &tokenBucket{tokens: float64(rule.Burst), last: now, rate: rule.Rate, burst: rule.Burst}
+				l.buckets[id] = b
+			}
+			b.tokens = math.Min(float64(rule.Burst), b.tokens+now.Sub(b.last).Seconds()*rule.Rate)
+			b.last = now
+			if b.tokens < 1 {
+				wait := time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+				rejection = longerRetry(rejection, &RateLimitError{Rule: rule.Name, Reason: "rate", RetryAfter: wait})
+			}
+			p.bucket = b
+		}
+
+		if rule.Daily > 0 {
+			q, ok := l.quotas[id]
+			if !ok || q.day != day {
+				q = &dailyQuota{day: day}
+				l.quotas[id] = q
+			}
+			if q.used >= rule.Daily {
+				midnight := time.Date(now.UTC().Year(), now.UTC().Month(), now.UTC().Day()+1, 0, 0, 0, 0, time.UTC)
+				rejection = longerRetry(rejection, &RateLimitError{Rule: rule.Name, Reason: "daily_quota", RetryAfter: midnight.Sub(now)})
+			}
+			p.quota = q
+		}
+		matched = append(matched, p)
+	}
+
+	if rejection != nil {
+		telemetry.IncRateLimitRejection(key.Tool, rejection.Reason)
+		return rejection
+	}
+
+	for _, p := range matched {
+		if p.bucket != nil {
+			p.bucket.tokens--
+		}
+		if p.quota != nil {
+			p.quota.used++
+		}
+		telemetry.SetRateLimitUsage(p.rule.Name, key.Tool, bucketTokens(p.bucket), quotaUsed(p.quota), p.rule.Daily)
+	}
+	return nil
+}
+
+// prune drops the buckets that have refilled to their burst and the
+// quotas of past days: a new call recreates them in the same state.
+func (l *RateLimiter) prune(now time.Time, day string) {
+	for id, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, id)
+		}
+	}
+	for id, q := range l.quotas {
+		if q.day != day {
+			delete(l.quotas, id)
+		}
+	}
+	l.lastPruned = now
+}
+
+// Usage returns the current state of every bucket and quota seen so far,
+// sorted by rule and key.
+func (l *RateLimiter) Usage() []RateLimitUsage {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	day := now.UTC().Format("2006-01-02")
+	byID := make(map[string]*RateLimitUsage)
+	for _, rule := range l.rules {
+		prefix := rule.Name + "|"
+		for id, b := range l.buckets {
+			if !strings.HasPrefix(id, prefix) {
+				continue
+			}
+			u := usageFor(byID, rule, id)
+			u.TokensRemaining = math.Min(float64(rule.Burst), b.tokens+now.Sub(b.last).Seconds()*rule.Rate)
+		}
+		for id, q := range l.quotas {
+			if !strings.HasPrefix(id, prefix) {
+				continue
+			}
+			u := usageFor(byID, rule, id)
+			if q.day == day {
+				u.DailyUsed = q.used
+			}
+		}
+	}
+
+	out := make([]RateLimitUsage, 0, len(byID))
+	for _, u := range byID {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Rule != out[j].Rule {
+			return out[i].Rule < out[j].Rule
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func usageFor(byID map[string]*RateLimitUsage, rule RateLimitRule, id string) *RateLimitUsage {
+	if u, ok := byID[id]; ok {
+		return u
+	}
+	u := &RateLimitUsage{Rule: rule.Name, Key: strings.TrimPrefix(id, rule.Name+"|"), DailyLimit: rule.Daily}
+	byID[id] = u
+	return u
+}
+
+func (r RateLimitRule) matches(key RateLimitKey) bool {
+	return selectorMatches(r.Tool, key.Tool) && selectorMatches(r.Repo, key.Repo) && selectorMatches(r.Principal, key.Principal)
+}
+
+func (r RateLimitRule) bucketKey(key RateLimitKey) string {
+	parts := make([]string, 0, len(r.Per))
+	for _, dim := range r.Per {
+		switch dim {
+		case RateLimitByRun:
+			parts = append(parts, "run="+key.RunID)
+		case RateLimitByRepo:
+			parts = append(parts, "repo="+key.Repo)
+		case RateLimitByTool:
+			parts = append(parts, "tool="+key.Tool)
+		case RateLimitByPrincipal:
+			parts = append(parts, "principal="+key.Principal)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func selectorMatches(selector, value string) bool {
+	return selector == "" || selector == "*" || selector == value
+}
+
+func longerRetry(current, candidate *RateLimitError) *RateLimitError {
+	if current == nil || candidate.RetryAfter > current.RetryAfter {
+		return candidate
+	}
+	return current
+}
+
+func bucketTokens(b *tokenBucket) float64 {
+	if b == nil {
+		return 0
+	}
+	return b.tokens
+}
+
+func quotaUsed(q *dailyQuota) int {
+	if q == nil {
+		return 0
+	}
+	return q.used
+}
+
+// ParseRateLimitRules parses RATE_LIMIT_RULES. Rules are separated by ";" and
+// each rule is a comma-separated list of key=value pairs:
+//
+//	tool=github.issues.create,rate=30/m,burst=10,daily=500;per=run,rate=5/s
+//
+// Supported keys: name, tool, repo, principal, per (dimensions joined by "|"),
+// rate (N/s, N/m or N/h), burst and daily.
+func ParseRateLimitRules(raw string) ([]RateLimitRule, error) {
+	rules := make([]RateLimitRule, 0)
+	for i, chunk := range strings.Split(raw, ";") {
+		chunk = strings.TrimSpace(chunk)
+		if chunk == "" {
+			continue
+		}
+		rule := RateLimitRule{}
+		for _, field := range strings.Split(chunk, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("rate limit rule %d: expected key=value, got %q", i+1, field)
+			}
+			k = strings.TrimSpace(k)
+			v = strings.TrimSpace(v)
+			switch k {
+			case "name":
+				rule.Name = v
+			case "tool":
+				rule.Tool = v
+			case "repo":
+				rule.Repo = v
+			case "principal":
+				rule.Principal = v
+			case "per":
+				for _, dim := range strings.Split(v, "|") {
+					dim = strings.TrimSpace(dim)
+					switch dim {
+					case RateLimitByRun, RateLimitByRepo, RateLimitByTool, RateLimitByPrincipal:
+						rule.Per = append(rule.Per, dim)
+					default:
+						return nil, fmt.Errorf("rate limit rule %d: unknown per dimension %q", i+1, dim)
+					}
+				}
+			case "rate":
+				rate, err := parseRate(v)
+				if err != nil {
+					return nil, fmt.Errorf("rate limit rule %d: %w", i+1, err)
+				}
+				rule.Rate = rate
+			case "burst":
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("rate limit rule %d: burst must be a positive integer", i+1)
+				}
+				rule.Burst = n
+			case "daily":
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("rate limit rule %d: daily must be a positive integer", i+1)
+				}
+				rule.Daily = n
+			default:
+				return nil, fmt.Errorf("rate limit rule %d: unknown key %q", i+1, k)
+			}
+		}
+		if rule.Rate == 0 && rule.Daily == 0 {
+			return nil, fmt.Errorf("rate limit rule %d: rate or daily is required", i+1)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRate(v string) (float64, error) {
+	countRaw, unit, ok := strings.Cut(v, "/")
+	if !ok {
+		return 0, fmt.Errorf("rate must look like N/s, N/m or N/h, got %q", v)
+	}
+	count, err := strconv.ParseFloat(strings.TrimSpace(countRaw), 64)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("rate count must be a positive number, got %q", countRaw)
+	}
+	switch strings.TrimSpace(unit) {
+	case "s":
+		return count, nil
+	case "m":
+		return count / 60, nil
+	case "h":
+		return count / 3600, nil
+	default:
+		return 0, fmt.Errorf("rate unit must be s, m or h, got %q", unit)
+	}
+}
+
+type principalCtxKey struct{}
+
+// WithPrincipal attaches the calling principal to ctx for rate limiting and audit.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, strings.TrimSpace(principal))
+}
+
+// PrincipalFromContext returns the principal set by WithPrincipal, or "".
+func PrincipalFromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(principalCtxKey{}).(string); ok {
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(t *testing.T, raw string, now *time.Time) *RateLimiter {
+	t.Helper()
+	rules, err := ParseRateLimitRules(raw)
+	if err != nil {
+		t.Fatalf("parse rules: %v", err)
+	}
+	l := NewRateLimiter(rules)
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	l := newTestLimiter(t, "tool=qa.test,rate=1/s,burst=2", &now)
+	key := RateLimitKey{Tool: "qa.test", Repo: "o/r", RunID: "run-1"}
+
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(key); err != nil {
+			t.Fatalf("call %d: unexpected error %v", i, err)
+		}
+	}
+	err := l.Allow(key)
+	var rl *RateLimitError
+	if !errors.As(err, &rl) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if rl.ErrorCode() != "rate_limited" || rl.Reason != "rate" || rl.RetryAfterSeconds() != 1 {
+		t.Fatalf("unexpected rejection: %+v", rl)
+	}
+
+	now = now.Add(time.Second)
+	if err := l.Allow(key); err != nil {
+		t.Fatalf("expected refill after 1s, got %v", err)
+	}
+
+	if err := l.Allow(RateLimitKey{Tool: "qa.lint", Repo: "o/r"}); err != nil {
+		t.Fatalf("rule must not apply to other tools: %v", err)
+	}
+}
+
+func TestRateLimiterDailyQuotaResetsAtUTCMidnight(t *testing.T) {
+	now := time.Date(2026, 1, 2, 23, 0, 0, 0, time.UTC)
+	l := newTestLimiter(t, "repo=o/r,daily=1", &now)
+	key := RateLimitKey{Tool: "github.issues.create", Repo: "o/r"}
+
+	if err := l.Allow(key); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	err := l.Allow(key)
+	var rl *RateLimitError
+	if !errors.As(err, &rl) || rl.Reason != "daily_quota" {
+		t.Fatalf("expected daily_quota rejection, got %v", err)
+	}
+	if rl.RetryAfterSeconds() != 3600 {
+		t.Fatalf("expected retry after 3600s, got %d", rl.RetryAfterSeconds())
+	}
+
+	now = now.Add(time.Hour)
+	if err := l.Allow(key); err != nil {
+		t.Fatalf("quota should reset on new UTC day: %v", err)
+	}
+}
+
+func TestRateLimiterPerDimensions(t *testing.T) {
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	l := newTestLimiter(t, "per=run,daily=1", &now)
+
+	if err := l.Allow(RateLimitKey{Tool: "qa.test", RunID: "a"}); err != nil {
+		t.Fatalf("run a: %v", err)
+	}
+	if err := l.Allow(RateLimitKey{Tool: "qa.lint", RunID: "a"}); err == nil {
+		t.Fatalf("expected run a to share one quota across tools")
+	}
+	if err := l.Allow(RateLimitKey{Tool: "qa.test", RunID: "b"}); err != nil {
+		t.Fatalf("run b should have its own quota: %v", err)
+	}
+}
+
+func TestRateLimiterRejectionDoesNotConsume(t *testing.T) {
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	l := newTestLimiter(t, "name=wide,daily=5;name=narrow,principal=bot,daily=1", &now)
+	key := RateLimitKey{Tool: "qa.test", Principal: "bot"}
+
+	if err := l.Allow(key); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := l.Allow(key); err == nil {
+			t.Fatalf("expected narrow quota rejection")
+		}
+	}
+
+	usage := l.Usage()
+	if len(usage) != 2 {
+		t.Fatalf("expected 2 usage entries, got %d", len(usage))
+	}
+	if usage[0].Rule != "narrow" || usage[0].DailyUsed != 1 {
+		t.Fatalf("unexpected narrow usage: %+v", usage[0])
+	}
+	if usage[1].Rule != "wide" || usage[1].DailyUsed != 1 || usage[1].DailyLimit != 5 {
+		t.Fatalf("rejected calls must not consume other quotas: %+v", usage[1])
+	}
+}
+
+func TestNilRateLimiterAllows(t *testing.T) {
+	var l *RateLimiter
+	if err := l.Allow(RateLimitKey{Tool: "qa.test"}); err != nil {
+		t.Fatalf("nil limiter must allow: %v", err)
+	}
+	p := NewPolicy("o/r", "qa.test")
+	if err := p.CheckRateLimit(RateLimitKey{Tool: "qa.test"}); err != nil {
+		t.Fatalf("policy without limiter must allow: %v", err)
+	}
+}
+
+func TestParseRateLimitRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+		check   func(t *testing.T, rules []RateLimitRule)
+	}{
+		{name: "empty", raw: "", check: func(t *testing.T, rules []RateLimitRule) {
+			if len(rules) != 0 {
+				t.Fatalf("expected no rules, got %d", len(rules))
+			}
+		}},
+		{name: "full rule", raw: "name=issues,tool=github.issues.create,repo=o/r,per=run|principal,rate=30/m,burst=10,daily=500", check: func(t *testing.T, rules []RateLimitRule) {
+			r := rules[0]
+			if r.Name != "issues" || r.Tool != "github.issues.create" || r.Repo != "o/r" || r.Burst != 10 || r.Daily != 500 {
+				t.Fatalf("unexpected rule: %+v", r)
+			}
+			if r.Rate != 0.5 || len(r.Per) != 2 || r.Per[0] != "run" || r.Per[1] != "principal" {
+				t.Fatalf("unexpected rate/per: %+v", r)
+			}
+		}},
+		{name: "hourly rate", raw: "rate=36/h", check: func(t *testing.T, rules []RateLimitRule) {
+			if rules[0].Rate != 0.01 {
+				t.Fatalf("unexpected rate: %v", rules[0].Rate)
+			}
+		}},
+		{name: "missing limit", raw: "tool=qa.test", wantErr: true},
+		{name: "bad unit", raw: "rate=1/d", wantErr: true},
+		{name: "unknown key", raw: "rate=1/s,foo=bar", wantErr: true},
+		{name: "unknown dimension", raw: "rate=1/s,per=org", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRateLimitRules(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, rules)
+		})
+	}
+}
+
+func TestRateLimiterPrunesIdleEntries(t *testing.T) {
+	now := time.Date(2026, 1, 2, 23, 0, 0, 0, time.UTC)
+	l := newTestLimiter(t, "per=run|principal,rate=1/s,burst=2,daily=5", &now)
+
+	for i := 0; i < 100; i++ {
+		if err := l.Allow(RateLimitKey{Tool: "qa.test", RunID: fmt.Sprintf("run-%d", i), Principal: "bot"}); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	if len(l.buckets) != 100 || len(l.quotas) != 100 {
+		t.Fatalf("buckets = %d, quotas = %d", len(l.buckets), len(l.quotas))
+	}
+
+	// An hour later every bucket has refilled and the day has changed.
+	now = now.Add(time.Hour)
+	key := RateLimitKey{Tool: "qa.test", RunID: "run-1", Principal: "bot"}
+	if err := l.Allow(key); err != nil {
+		t.Fatalf("call after prune: %v", err)
+	}
+	if len(l.buckets) != 1 || len(l.quotas) != 1 {
+		t.Fatalf("after prune buckets = %d, quotas = %d", len(l.buckets), len(l.quotas))
+	}
+
+	// A bucket in use is kept, with its state.
+	if err := l.Allow(key); err != nil {
+		t.Fatalf("second call: %v", err)
+	}
+	now = now.Add(rateLimitPruneInterval)
+	l.prune(now, now.Format("2006-01-02"))
+	if err := l.Allow(key); err != nil {
+		t.Fatalf("refilled call: %v", err)
+	}
+	if usage := l.Usage(); len(usage) != 1 || usage[0].DailyUsed != 3 {
+		t.Fatalf("usage = %+v", usage)
+	}
+}
@@ -0,0 +1,513 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/toolhub/toolhub/internal/db"
+	"github.com/toolhub/toolhub/internal/telemetry"
+)
+
+// ToolSpec is the single definition of a tool. The HTTP and MCP transports,
+// MCP tools/list and the generated docs are all derived from it.
+type ToolSpec struct {
+	// Name is the canonical dotted tool name checked against TOOL_ALLOWLIST.
+	Name        string
+	Description string
+	// Route exposes the tool over HTTP; nil keeps it MCP-only.
+	Route *ToolRoute
+	// InputSchema is generated from NewArgs by Register unless set.
+	InputSchema map[string]any
+	// NewArgs returns a pointer to a zero argument struct for decoding.
+	NewArgs func() any
+	// Result is a zero value of the success result type. It only documents
+	// the response in the generated OpenAPI spec.
+	Result any
+	// Async lets callers run the tool as a background job by passing
+	// async=true; the call then answers 202 with the job.
+	Async bool
+	// Progress marks tools that report live progress (see ReportProgress);
+	// their HTTP route streams it as server-sent events on request.
+	Progress bool
+	Policy   ToolPolicy
+	Execute  ToolFunc
+}
+
+// MCPName is the tool name exposed over MCP (dots replaced by underscores).
+func (s *ToolSpec) MCPName() string {
+	return strings.ReplaceAll(s.Name, ".", "_")
+}
+
+// ToolRoute describes the HTTP endpoint of a tool. Path wildcards listed in
+// Params are copied into the arguments before decoding.
+type ToolRoute struct {
+	Method      string
+	Path        string
+	OperationID string
+	Params      []RouteParam
+	// Status is the success status code; 0 means 200.
+	Status int
+	// Raw documents that the route responds with the bare result instead
+	// of an envelope (see ToolOutcome.Raw).
+	Raw bool
+}
+
+// RouteParam maps a path wildcard onto an argument field.
+type RouteParam struct {
+	Name    string
+	Arg     string
+	Integer bool
+}
+
+// ToolPolicy lists the checks the registry runs before Execute.
+type ToolPolicy struct {
+	// NoRun marks tools that do not operate inside an existing run
+	// (runs.create). They skip run lookup and TOOL_ALLOWLIST.
+	NoRun bool
+	// SkipAllowlist exempts tools that only inspect or control the run's
+	// own jobs from TOOL_ALLOWLIST; the job's tool was checked on submit.
+	SkipAllowlist bool
+	// ApprovalScope requires an approved approval_id with this scope.
+	ApprovalScope string
+	// Paths returns the repository paths touched by the call; they are
+	// checked against the path policy.
+	Paths func(args any) []string
+	// Validate rejects malformed arguments with a 400.
+	Validate func(args any) error
+}
+
+// ToolCall is the validated input handed to ToolSpec.Execute.
+type ToolCall struct {
+	// ID is the tool_call_id the call is recorded under. It is assigned
+	// before execution so a running call can be cancelled by it.
+	ID       string
+	Name     string
+	Run      *db.Run
+	Args     any
+	DryRun   bool
+	Approval *db.Approval
+	// IdempotencyKey is the client-supplied key (HTTP Idempotency-Key header).
+	IdempotencyKey string
+	Principal      string
+	Logger         *slog.Logger
+}
+
+// ToolFunc executes a tool. A returned error rejects the call before anything
+// is audited; failures that must be audited go in ToolOutcome.Err.
+type ToolFunc func(ctx context.Context, call *ToolCall) (*ToolOutcome, error)
+
+// ToolOutcome is what a tool produced. The registry audits it (unless
+// Audited is set) and turns it into a transport response.
+type ToolOutcome struct {
+	Result any
+	// Err is the audited execution failure. Without SoftError it is returned
+	// to the caller as a mapped error using ErrStatus as fallback status.
+	Err       error
+	ErrStatus int
+	// SoftError reports Err inside an OK=false envelope instead (QA results).
+	SoftError *ToolError
+
+	// Request and Response are the audited payloads.
+	Request        any
+	Response       any
+	IdemKey        *string
+	ExtraArtifacts []ExtraArtifact
+	// Finalize attaches the IDs of ExtraArtifacts to the envelope.
+	Finalize func(env *ToolEnvelope, artifactIDs []string)
+
+	// Replayed returns Result from an earlier tool call without auditing.
+	Replayed *db.ToolCall
+	// Audited marks tools that wrote their own audit records (batch).
+	Audited bool
+	// Raw writes Result without an envelope (runs.create).
+	Raw    bool
+	Status int
+}
+
+// ToolResponse is a transport-neutral response: HTTP writes Body with
+// Status, MCP returns Body as the tools/call result.
+type ToolResponse struct {
+	Status   int
+	Body     any
+	Replayed bool
+}
+
+// ToolRequestError rejects a call before execution. Status follows HTTP
+// semantics; MCP maps 4xx to invalid params and 5xx to internal errors.
+type ToolRequestError struct {
+	Status  int
+	Message string
+}
+
+func (e *ToolRequestError) Error() string { return e.Message }
+
+// BadToolRequest builds a 400 ToolRequestError.
+func BadToolRequest(format string, args ...any) error {
+	return &ToolRequestError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// ToolExecError is an audited execution failure; transports map Err with
+// MapError using FallbackStatus.
+type ToolExecError struct {
+	Err            error
+	FallbackStatus int
+}
+
+func (e *ToolExecError) Error() string { return e.Err.Error() }
+func (e *ToolExecError) Unwrap() error { return e.Err }
+
+// InvokeOptions carries transport-specific call context.
+type InvokeOptions struct {
+	IdempotencyKey string
+	Logger         *slog.Logger
+}
+
+// Registry holds every tool and runs the canonical chain for each call:
+// policy checks, execution, artifact write and audit record.
+type Registry struct {
+	specs  []*ToolSpec
+	byName map[string]*ToolSpec
+	byMCP  map[string]*ToolSpec
+	runs   *RunService
+	audit  *AuditService
+	policy *Policy
+	jobs   *JobService
+	logger *slog.Logger
+
+	inflightMu sync.Mutex
+	inflight   map[string]*RunningToolCall
+}
+
+func NewRegistry(runs *RunService, audit *AuditService, policy *Policy, logger *slog.Logger) *Registry {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Registry{
+		byName:   make(map[string]*ToolSpec),
+		byMCP:    make(map[string]*ToolSpec),
+		runs:     runs,
+		audit:    audit,
+		policy:   policy,
+		logger:   logger,
+		inflight: make(map[string]*RunningToolCall),
+	}
+}
+
+// Register adds a tool. It panics on duplicate names, which is a programming error.
+func (r *Registry) Register(spec ToolSpec) {
+	if _, dup := r.byName[spec.Name]; dup {
+		panic(fmt.Sprintf("tool %q registered twice", spec.Name))
+	}
+	s := &spec
+	if s.InputSchema == nil && s.NewArgs != nil {
+		s.InputSchema = SchemaFor(s.NewArgs())
+	}
+	r.specs = append(r.specs, s)
+	r.byName[s.Name] = s
+	r.byMCP[s.MCPName()] = s
+}
+
+// SetJobs enables async=true for Async tools, running them on jobs.
+func (r *Registry) SetJobs(jobs *JobService) {
+	r.jobs = jobs
+}
+
+// Specs returns tools in registration order.
+func (r *Registry) Specs() []*ToolSpec {
+	return append([]*ToolSpec(nil), r.specs...)
+}
+
+// Lookup finds a tool by canonical name.
+func (r *Registry) Lookup(name string) (*ToolSpec, bool) {
+	s, ok := r.byName[name]
+	return s, ok
+}
+
+// LookupMCP finds a tool by its MCP name.
+func (r *Registry) LookupMCP(name string) (*ToolSpec, bool) {
+	s, ok := r.byMCP[name]
+	return s, ok
+}
+
+// commonArgs are the argument fields the registry itself understands.
+type commonArgs struct {
+	RunID      string `json:"run_id"`
+	Repo       string `json:"repo"`
+	DryRun     bool   `json:"dry_run"`
+	ApprovalID string `json:"approval_id"`
+	Async      bool   `json:"async"`
+}
+
+// Invoke runs the tool named name with JSON arguments raw. Arguments that do
+// not match the tool's InputSchema are rejected with a *SchemaError.
+func (r *Registry) Invoke(ctx context.Context, name string, raw json.RawMessage, opts InvokeOptions) (*ToolResponse, error) {
+	spec, ok := r.byName[name]
+	if !ok {
+		return nil, &ToolRequestError{Status: http.StatusNotFound, Message: fmt.Sprintf("unknown tool: %s", name)}
+	}
+
+	start := time.Now()
+	defer func() { telemetry.ObserveToolDuration(spec.Name, time.Since(start)) }()
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = r.logger
+	}
+
+	if len(bytes.TrimSpace(raw)) == 0 {
+		raw = json.RawMessage("{}")
+	}
+	if err := ValidateSchema(spec.InputSchema, raw); err != nil {
+		return nil, err
+	}
+	args := spec.NewArgs()
+	if err := decodeStrict(raw, args); err != nil {
+		return nil, BadToolRequest("invalid json: %s", err.Error())
+	}
+	var common commonArgs
+	_ = json.Unmarshal(raw, &common)
+	if common.Async && (!spec.Async || spec.Policy.NoRun) {
+		return nil, BadToolRequest("%s cannot run asynchronously", spec.Name)
+	}
+
+	call := &ToolCall{
+		Name:           spec.Name,
+		Args:           args,
+		DryRun:         common.DryRun,
+		IdempotencyKey: strings.TrimSpace(opts.IdempotencyKey),
+		Principal:      PrincipalFromContext(ctx),
+	}
+
+	rlKey := RateLimitKey{Tool: spec.Name, Repo: common.Repo, Principal: call.Principal}
+	if !spec.Policy.NoRun {
+		if strings.TrimSpace(common.RunID) == "" {
+			return nil, BadToolRequest("run_id is required")
+		}
+		run, err := r.runs.GetRun(ctx, common.RunID)
+		if err != nil {
+			return nil, &ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+		}
+		if run == nil {
+			return nil, &ToolRequestError{Status: http.StatusNotFound, Message: "run not found"}
+		}
+		if !spec.Policy.SkipAllowlist {
+			if err := r.policy.CheckTool(spec.Name); err != nil {
+				return nil, &ToolRequestError{Status: http.StatusForbidden, Message: err.Error()}
+			}
+		}
+		call.Run = run
+		call.ID = uuid.New().String()
+		rlKey.Repo = run.Repo
+		rlKey.RunID = run.RunID
+	}
+	call.Logger = logger.With("run_id", common.RunID, "tool_name", spec.Name)
+	if call.Run != nil {
+		call.Logger = call.Logger.With("repo", call.Run.Repo)
+	}
+
+	if spec.Policy.Validate != nil {
+		if err := spec.Policy.Validate(args); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.Policy.ApprovalScope != "" {
+		approval, err := r.checkApproval(ctx, common, spec.Policy.ApprovalScope)
+		if err != nil {
+			return nil, err
+		}
+		call.Approval = approval
+	}
+
+	if spec.Policy.Paths != nil {
+		if err := r.policy.CheckPaths(spec.Policy.Paths(args)); err != nil {
+			if pv, ok := err.(*PolicyViolation); ok {
+				return &ToolResponse{
+					Status: http.StatusForbidden,
+					Body: ToolEnvelope{
+						OK:    false,
+						Meta:  ToolMeta{RunID: common.RunID, DryRun: common.DryRun},
+						Error: &ToolError{Code: string(pv.Code), Message: pv.Error()},
+					},
+				}, nil
+			}
+			return nil, &ToolRequestError{Status: http.StatusForbidden, Message: err.Error()}
+		}
+	}
+
+	if err := r.policy.CheckRateLimit(rlKey); err != nil {
+		call.Logger.Warn("tool call rate limited", "principal", call.Principal, "err", err)
+		return nil, err
+	}
+
+	if common.Async {
+		return r.startJob(ctx, spec, call)
+	}
+	return r.execute(ctx, spec, call)
+}
+
+// execute runs the checked call and responds with its outcome. Calls of a
+// run can be cancelled with CancelToolCall while they run.
+func (r *Registry) execute(ctx context.Context, spec *ToolSpec, call *ToolCall) (*ToolResponse, error) {
+	if call.Run != nil {
+		var done func()
+		ctx, done = r.track(ctx, call)
+		defer done()
+		if spec.Progress {
+			ReportProgress(ctx, ProgressEvent{Type: ProgressStarted, ToolCallID: call.ID})
+		}
+	}
+	outcome, err := spec.Execute(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+	return r.respond(ctx, spec, call, outcome)
+}
+
+// startJob runs the checked call in the background and answers with the
+// job. The job stores the response Invoke would have returned.
+func (r *Registry) startJob(ctx context.Context, spec *ToolSpec, call *ToolCall) (*ToolResponse, error) {
+	if r.jobs == nil {
+		return nil, &ToolRequestError{Status: http.StatusInternalServerError, Message: "async jobs are not configured"}
+	}
+	job, err := r.jobs.Start(ctx, call.Run.RunID, spec.Name, func(ctx context.Context) (*ToolResponse, error) {
+		return r.execute(ctx, spec, call)
+	})
+	if err != nil {
+		call.Logger.Error("start job failed", "err", err)
+		return nil, &ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	call.Logger = call.Logger.With("job_id", job.JobID)
+	call.Logger.Info("tool call started as job")
+	return &ToolResponse{
+		Status: http.StatusAccepted,
+		Body: ToolEnvelope{
+			OK:     true,
+			Meta:   ToolMeta{RunID: call.Run.RunID, DryRun: call.DryRun},
+			Result: job,
+		},
+	}, nil
+}
+
+func (r *Registry) checkApproval(ctx context.Context, common commonArgs, scope string) (*db.Approval, error) {
+	if strings.TrimSpace(common.ApprovalID) == "" {
+		return nil, BadToolRequest("approval_id is required")
+	}
+	approval, err := r.audit.GetApproval(ctx, common.ApprovalID)
+	if err != nil {
+		return nil, &ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if approval == nil || approval.RunID != common.RunID {
+		return nil, &ToolRequestError{Status: http.StatusNotFound, Message: "approval not found"}
+	}
+	if approval.Status != "approved" {
+		return nil, &ToolRequestError{Status: http.StatusForbidden, Message: "approval is not approved"}
+	}
+	if approval.Scope != scope {
+		return nil, &ToolRequestError{Status: http.StatusForbidden, Message: fmt.Sprintf("approval scope must be %s", scope)}
+	}
+	return approval, nil
+}
+
+func (r *Registry) respond(ctx context.Context, spec *ToolSpec, call *ToolCall, out *ToolOutcome) (*ToolResponse, error) {
+	status := out.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if out.Raw {
+		return &ToolResponse{Status: status, Body: out.Result}, nil
+	}
+
+	runID := ""
+	if call.Run != nil {
+		runID = call.Run.RunID
+	}
+	env := ToolEnvelope{
+		OK:     out.Err == nil,
+		Meta:   ToolMeta{RunID: runID, DryRun: call.DryRun},
+		Result: out.Result,
+	}
+
+	if out.Replayed != nil {
+		env.OK = true
+		env.Meta.ToolCallID = out.Replayed.ToolCallID
+		env.Meta.EvidenceHash = out.Replayed.EvidenceHash
+		env.Meta.DryRun = false
+		env.Meta.Replayed = true
+		call.Logger.Info("tool call replayed", "tool_call_id", out.Replayed.ToolCallID)
+		return &ToolResponse{Status: status, Body: env, Replayed: true}, nil
+	}
+
+	// A call that failed because its context was cancelled is recorded as
+	// cancelled, with whatever partial output it produced. The record is
+	// written without the cancelled context.
+	auditCtx := ctx
+	cancelled := out.Err != nil && ctx.Err() != nil
+	if cancelled {
+		auditCtx = context.WithoutCancel(ctx)
+	}
+	if !out.Audited {
+		tc, extraIDs, auditErr := r.audit.Record(auditCtx, RecordInput{
+			ToolCallID:     call.ID,
+			Cancelled:      cancelled,
+			RunID:          runID,
+			ToolName:       spec.Name,
+			IdemKey:        out.IdemKey,
+			Request:        out.Request,
+			Response:       out.Response,
+			Err:            out.Err,
+			ExtraArtifacts: out.ExtraArtifacts,
+		})
+		if auditErr != nil {
+			call.Logger.Error("audit record failed", "err", auditErr)
+			return nil, &ToolRequestError{Status: http.StatusInternalServerError, Message: "audit record failed: " + auditErr.Error()}
+		}
+		env.Meta.ToolCallID = tc.ToolCallID
+		env.Meta.EvidenceHash = tc.EvidenceHash
+		if out.Finalize != nil {
+			out.Finalize(&env, extraIDs)
+		}
+	}
+
+	logger := call.Logger.With("tool_call_id", env.Meta.ToolCallID, "dry_run", call.DryRun)
+	if cancelled {
+		cause := context.Cause(ctx)
+		logger.Warn("tool call cancelled", "cause", cause, "err", out.Err)
+		return nil, &ToolExecError{Err: &ToolCallCancelledError{ToolCallID: env.Meta.ToolCallID, Cause: cause}, FallbackStatus: http.StatusConflict}
+	}
+	if out.Err != nil {
+		logger.Error("tool call failed", "err", out.Err)
+		if out.SoftError == nil {
+			fallback := out.ErrStatus
+			if fallback == 0 {
+				fallback = http.StatusBadGateway
+			}
+			return nil, &ToolExecError{Err: out.Err, FallbackStatus: fallback}
+		}
+		env.Error = out.SoftError
+	} else {
+		logger.Info("tool call completed")
+	}
+	return &ToolResponse{Status: status, Body: env}, nil
+}
+
+func decodeStrict(raw json.RawMessage, dst any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return fmt.Errorf("request body must contain a single JSON object")
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/toolhub/toolhub/internal/db"
+)
+
+type echoArgs struct {
+	Repo string `json:"repo,omitempty"`
+	Note string `json:"note,omitempty"`
+}
+
+func newEchoRegistry(policy *Policy) *Registry {
+	reg := NewRegistry(nil, nil, policy, nil)
+	reg.Register(ToolSpec{
+		Name:    "runs.echo",
+		NewArgs: func() any { return &echoArgs{} },
+		Policy: ToolPolicy{
+			NoRun: true,
+			Validate: func(args any) error {
+				if args.(*echoArgs).Repo == "" {
+					return BadToolRequest("repo is required")
+				}
+				return nil
+			},
+		},
+		Execute: func(_ context.Context, call *ToolCall) (*ToolOutcome, error) {
+			return &ToolOutcome{Result: call.Args, Raw: true, Status: http.StatusCreated}, nil
+		},
+	})
+	return reg
+}
+
+func TestRegistryInvoke(t *testing.T) {
+	tests := []struct {
+		name       string
+		tool       string
+		raw        string
+		wantStatus int
+		wantErr    int
+		wantSchema string
+	}{
+		{name: "ok", tool: "runs.echo", raw: `{"repo":"o/r","note":"hi"}`, wantStatus: http.StatusCreated},
+		{name: "unknown tool", tool: "runs.nope", raw: `{}`, wantErr: http.StatusNotFound},
+		{name: "unknown field", tool: "runs.echo", raw: `{"repo":"o/r","extra":1}`, wantSchema: "$.extra"},
+		{name: "wrong type", tool: "runs.echo", raw: `{"repo":1}`, wantSchema: "$.repo"},
+		{name: "trailing data", tool: "runs.echo", raw: `{"repo":"o/r"}{}`, wantErr: http.StatusBadRequest},
+		{name: "validate", tool: "runs.echo", raw: ``, wantErr: http.StatusBadRequest},
+	}
+
+	reg := newEchoRegistry(NewPolicy("o/r", "runs.echo"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := reg.Invoke(context.Background(), tt.tool, json.RawMessage(tt.raw), InvokeOptions{})
+			if tt.wantSchema != "" {
+				var schemaErr *SchemaError
+				if !errors.As(err, &schemaErr) || schemaErr.Fields[0].Path != tt.wantSchema {
+					t.Fatalf("expected schema error at %s, got %v", tt.wantSchema, err)
+				}
+				return
+			}
+			if tt.wantErr != 0 {
+				var reqErr *ToolRequestError
+				if !errors.As(err, &reqErr) || reqErr.Status != tt.wantErr {
+					t.Fatalf("expected ToolRequestError %d, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.Status != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, resp.Status)
+			}
+			if got := resp.Body.(*echoArgs); got.Repo != "o/r" || got.Note != "hi" {
+				t.Fatalf("unexpected body: %+v", got)
+			}
+		})
+	}
+}
+
+func TestRegistryInvokeRateLimited(t *testing.T) {
+	policy := NewPolicy("o/r", "runs.echo")
+	rules, err := ParseRateLimitRules("tool=runs.echo,daily=1")
+	if err != nil {
+		t.Fatalf("parse rules: %v", err)
+	}
+	limiter := NewRateLimiter(rules)
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	policy.SetRateLimiter(limiter)
+	reg := newEchoRegistry(policy)
+
+	raw := json.RawMessage(`{"repo":"o/r"}`)
+	if _, err := reg.Invoke(context.Background(), "runs.echo", raw, InvokeOptions{}); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	_, err = reg.Invoke(context.Background(), "runs.echo", raw, InvokeOptions{})
+	var rl *RateLimitError
+	if !errors.As(err, &rl) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+}
+
+func TestRegistryRejectsAsyncForSyncTools(t *testing.T) {
+	type asyncArgs struct {
+		RunID string `json:"run_id"`
+		Async bool   `json:"async,omitempty"`
+	}
+	reg := NewRegistry(nil, nil, NewPolicy("o/r", "qa.sync,runs.async"), nil)
+	reg.Register(ToolSpec{Name: "qa.sync", NewArgs: func() any { return &asyncArgs{} }})
+	reg.Register(ToolSpec{Name: "runs.async", NewArgs: func() any { return &asyncArgs{} }, Async: true, Policy: ToolPolicy{NoRun: true}})
+
+	for _, tool := range []string{"qa.sync", "runs.async"} {
+		_, err := reg.Invoke(context.Background(), tool, json.RawMessage(`{"run_id":"r1","async":true}`), InvokeOptions{})
+		var reqErr *ToolRequestError
+		if !errors.As(err, &reqErr) || reqErr.Status != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %v", tool, err)
+		}
+	}
+}
+
+func TestRegistryRejectsDuplicateNames(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic on duplicate registration")
+		}
+	}()
+	reg := newEchoRegistry(nil)
+	reg.Register(ToolSpec{Name: "runs.echo"})
+}
+
+func TestToolSpecMCPName(t *testing.T) {
+	spec := ToolSpec{Name: "github.pr.files.list"}
+	if got := spec.MCPName(); got != "github_pr_files_list" {
+		t.Fatalf("unexpected MCP name %q", got)
+	}
+	reg := newEchoRegistry(nil)
+	if _, ok := reg.LookupMCP("runs_echo"); !ok {
+		t.Fatalf("expected runs_echo to resolve")
+	}
+}
+
+func TestRegistryCancelToolCall(t *testing.T) {
+	reg := NewRegistry(nil, nil, NewPolicy("o/r", ""), nil)
+	call := &ToolCall{ID: "tc-1", Name: "qa.test", Run: &db.Run{RunID: "r1"}}
+	ctx, done := reg.track(context.Background(), call)
+
+	if _, ok := reg.CancelToolCall("other-run", "tc-1"); ok {
+		t.Fatal("cancelled a call of another run")
+	}
+	running, ok := reg.CancelToolCall("r1", "tc-1")
+	if !ok || !running.Cancelled || running.ToolName != "qa.test" {
+		t.Fatalf("cancel = %+v, %v", running, ok)
+	}
+	if !errors.Is(context.Cause(ctx), ErrToolCallCancelled) {
+		t.Fatalf("cause = %v", context.Cause(ctx))
+	}
+
+	done()
+	if _, ok := reg.CancelToolCall("r1", "tc-1"); ok {
+		t.Fatal("cancelled a finished call")
+	}
+}
@@ -16,13 +16,20 @@ func MapQAStatusToMetric(status qa.Status) string {
 		return "fail"
 	case qa.StatusTimeout:
 		return "timeout"
+	case qa.StatusCancelled:
+		return "cancelled"
 	default:
 		return "error"
 	}
 }
 
 // DeriveQAFailureCategory returns a stable failure category for repair-loop QA failures.
+// flaky_only means lint passed and every failed test passed when rerun, so
+// the change itself is not at fault.
 func DeriveQAFailureCategory(testErr, lintErr error, testReport, lintReport *qa.Report) string {
+	if testErr != nil && lintErr == nil && testReport != nil && testReport.TestResults.FlakyOnly() {
+		return "flaky_only"
+	}
 	if testErr != nil && lintErr != nil {
 		return "both_failure"
 	}
@@ -47,3 +54,13 @@ func DeriveQAFailureCategory(testErr, lintErr error, testReport, lintReport *qa.
 
 	return "qa_error"
 }
+
+// DeriveTargetFailureCategory returns the failure category of a repair loop
+// whose test and lint passed but a required named target did not.
+func DeriveTargetFailureCategory(targetErr error) string {
+	var qaErr *qa.QAError
+	if errors.As(targetErr, &qaErr) && qaErr.ErrCode == qa.ErrCodeTimeout {
+		return "qa_timeout"
+	}
+	return "target_failure"
+}
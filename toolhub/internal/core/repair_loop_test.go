@@ -34,6 +34,8 @@ func TestDeriveQAFailureCategory(t *testing.T) {
 	testReportPass := qa.Report{ExitCode: 0}
 	testReportFail := qa.Report{ExitCode: 2}
 	lintReportPass := qa.Report{ExitCode: 0}
+	flaky := qa.Report{ExitCode: 1, TestResults: &qa.TestResults{Failed: 1, Flaky: 1, Tests: []qa.TestCase{{Name: "TestA", Status: qa.TestFail, Flaky: true}}}}
+	partlyFlaky := qa.Report{ExitCode: 1, TestResults: &qa.TestResults{Failed: 2, Flaky: 1}}
 
 	tests := []struct {
 		name    string
@@ -64,6 +66,25 @@ func TestDeriveQAFailureCategory(t *testing.T) {
 			testErr: errors.New("test failed"),
 			want:    "test_failure",
 		},
+		{
+			name:    "only flaky tests failed",
+			testErr: &qa.QAError{ErrCode: qa.ErrCodeExecFailed, Detail: "exit code 1"},
+			testRpt: &flaky,
+			want:    "flaky_only",
+		},
+		{
+			name:    "flaky tests and real failures",
+			testErr: &qa.QAError{ErrCode: qa.ErrCodeExecFailed, Detail: "exit code 1"},
+			testRpt: &partlyFlaky,
+			want:    "test_failure",
+		},
+		{
+			name:    "flaky tests and lint failure",
+			testErr: &qa.QAError{ErrCode: qa.ErrCodeExecFailed, Detail: "exit code 1"},
+			lintErr: errors.New("lint failed"),
+			testRpt: &flaky,
+			want:    "both_failure",
+		},
 		{
 			name:    "lint fail",
 			lintErr: errors.New("lint failed"),
@@ -92,3 +113,19 @@ func TestDeriveQAFailureCategory(t *testing.T) {
 		})
 	}
 }
+
+func TestDeriveTargetFailureCategory(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "timeout", err: &qa.QAError{ErrCode: qa.ErrCodeTimeout, Detail: "timed out"}, want: "qa_timeout"},
+		{name: "failure", err: &qa.QAError{ErrCode: qa.ErrCodeExecFailed, Detail: "exit code 1"}, want: "target_failure"},
+	}
+	for _, tt := range tests {
+		if got := DeriveTargetFailureCategory(tt.err); got != tt.want {
+			t.Fatalf("%s: DeriveTargetFailureCategory() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
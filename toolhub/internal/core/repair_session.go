@@ -0,0 +1,103 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/toolhub/toolhub/internal/db"
+)
+
+// Repair session statuses. A session is open between iterations, waiting
+// for the next fix, and running while an iteration commits and runs QA.
+const (
+	RepairSessionOpen      = "open"
+	RepairSessionRunning   = "running"
+	RepairSessionCompleted = "completed"
+	RepairSessionFailed    = "failed"
+	RepairSessionAbandoned = "abandoned"
+)
+
+// RepairSessionError rejects a call for a repair session that does not
+// exist in the run or cannot take it in its current status.
+type RepairSessionError struct {
+	Code    string
+	Message string
+}
+
+func (e *RepairSessionError) Error() string     { return e.Message }
+func (e *RepairSessionError) ErrorCode() string { return e.Code }
+
+// RepairSessionService keeps the state of interactive repair loops between
+// tool calls.
+type RepairSessionService struct {
+	db  *db.DB
+	now func() time.Time
+}
+
+// NewRepairSessionService creates a RepairSessionService backed by the
+// given database.
+func NewRepairSessionService(database *db.DB) *RepairSessionService {
+	return &RepairSessionService{db: database, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Create persists a new session in the running status, for the iteration
+// that is creating it.
+func (s *RepairSessionService) Create(ctx context.Context, sess *db.RepairSession) error {
+	now := s.now()
+	sess.SessionID = uuid.New().String()
+	sess.Status = RepairSessionRunning
+	sess.CreatedAt = now
+	sess.UpdatedAt = now
+	if err := s.db.InsertRepairSession(ctx, sess); err != nil {
+		return fmt.Errorf("create repair session: %w", err)
+	}
+	return nil
+}
+
+// Get returns the session of the run, or a repair_session_not_found error.
+func (s *RepairSessionService) Get(ctx context.Context, runID, sessionID string) (*db.RepairSession, error) {
+	sess, err := s.db.GetRepairSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if sess == nil || sess.RunID != runID {
+		return nil, &RepairSessionError{Code: "repair_session_not_found", Message: fmt.Sprintf("repair session %q not found in run", sessionID)}
+	}
+	return sess, nil
+}
+
+// Claim moves an open session to running, so that concurrent calls cannot
+// start two iterations at once.
+func (s *RepairSessionService) Claim(ctx context.Context, sess *db.RepairSession) error {
+	now := s.now()
+	ok, err := s.db.TransitionRepairSessionStatus(ctx, sess.SessionID, RepairSessionOpen, RepairSessionRunning, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		current, err := s.db.GetRepairSession(ctx, sess.SessionID)
+		if err == nil && current != nil {
+			sess.Status = current.Status
+		}
+		return &RepairSessionError{Code: "repair_session_closed", Message: fmt.Sprintf("repair session %q is %s, not open", sess.SessionID, sess.Status)}
+	}
+	sess.Status = RepairSessionRunning
+	sess.UpdatedAt = now
+	return nil
+}
+
+// Save stores the status, iteration count, head commit and failed tests of
+// sess.
+func (s *RepairSessionService) Save(ctx context.Context, sess *db.RepairSession) error {
+	sess.UpdatedAt = s.now()
+	return s.db.UpdateRepairSession(ctx, sess)
+}
+
+// ReopenStale hands sessions whose iteration was cut short by a previous
+// server process back for the next submit or an abandon. Call it at
+// startup, before any tool call is served.
+func (s *RepairSessionService) ReopenStale(ctx context.Context) (int64, error) {
+	return s.db.ReopenRunningRepairSessions(ctx, s.now())
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/toolhub/toolhub/internal/db"
+)
+
+func TestRepairSessionLifecycle(t *testing.T) {
+	databaseURL := os.Getenv("TOOLHUB_TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TOOLHUB_TEST_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	database, err := db.New(databaseURL)
+	if err != nil {
+		t.Fatalf("db connect: %v", err)
+	}
+	defer database.Close()
+
+	if err := ensureSchema(ctx, database); err != nil {
+		t.Fatalf("ensure schema: %v", err)
+	}
+	for _, name := range []string{"004_repair_sessions.sql", "007_repair_failed_tests.sql", "008_repair_qa_targets.sql", "009_repair_targeted_tests.sql", "011_repair_test_reruns.sql"} {
+		migration, err := os.ReadFile("../db/migrations/" + name)
+		if err != nil {
+			t.Fatalf("read migration: %v", err)
+		}
+		if _, err := database.Conn().ExecContext(ctx, string(migration)); err != nil {
+			t.Fatalf("apply migration: %v", err)
+		}
+	}
+
+	runs := NewRunService(database)
+	run, err := runs.CreateRun(ctx, CreateRunRequest{Repo: "owner/repo", Purpose: "repair_session_test"})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	other, err := runs.CreateRun(ctx, CreateRunRequest{Repo: "owner/repo", Purpose: "repair_session_test"})
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	sessions := NewRepairSessionService(database)
+	sess := &db.RepairSession{RunID: run.RunID, BaseBranch: "main", HeadBranch: "toolhub/fix", PRTitle: "fix", MaxIterations: 2, HeadSHA: "c1", QATargets: []string{"test", "build"}, TargetedTests: true, TestReruns: 2}
+	if err := sessions.Create(ctx, sess); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	wantCode := func(err error, code string) {
+		t.Helper()
+		var sessErr *RepairSessionError
+		if !errors.As(err, &sessErr) || sessErr.Code != code {
+			t.Fatalf("err = %v, want %s", err, code)
+		}
+	}
+
+	// The creating iteration holds the session until it is saved.
+	wantCode(sessions.Claim(ctx, &db.RepairSession{SessionID: sess.SessionID}), "repair_session_closed")
+
+	sess.Status = RepairSessionOpen
+	sess.IterationsRun = 1
+	if err := sessions.Save(ctx, sess); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+
+	_, err = sessions.Get(ctx, other.RunID, sess.SessionID)
+	wantCode(err, "repair_session_not_found")
+
+	got, err := sessions.Get(ctx, run.RunID, sess.SessionID)
+	if err != nil {
+		t.Fatalf("get session: %v", err)
+	}
+	if got.Status != RepairSessionOpen || got.IterationsRun != 1 || got.HeadSHA != "c1" || len(got.QATargets) != 2 || !got.TargetedTests || got.TestReruns != 2 {
+		t.Fatalf("session = %+v", got)
+	}
+	if err := sessions.Claim(ctx, got); err != nil {
+		t.Fatalf("claim open session: %v", err)
+	}
+	wantCode(sessions.Claim(ctx, &db.RepairSession{SessionID: sess.SessionID}), "repair_session_closed")
+
+	// A restart hands a session whose iteration was cut short back for a fix.
+	if n, err := sessions.ReopenStale(ctx); err != nil || n == 0 {
+		t.Fatalf("reopen stale = %d, %v", n, err)
+	}
+	got, err = sessions.Get(ctx, run.RunID, sess.SessionID)
+	if err != nil {
+		t.Fatalf("get session: %v", err)
+	}
+	if got.Status != RepairSessionOpen || got.IterationsRun != 1 {
+		t.Fatalf("reopened session = %+v", got)
+	}
+	if err := sessions.Claim(ctx, got); err != nil {
+		t.Fatalf("claim reopened session: %v", err)
+	}
+}
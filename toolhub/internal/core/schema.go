@@ -0,0 +1,392 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaFor generates the JSON Schema of a tool argument type from its Go
+// definition. Fields follow their json tags; fields without omitempty are
+// required. The optional jsonschema tag adds keywords:
+//
+//	jsonschema:"description=owner/repo,enum=a|b,minimum=1"
+//
+// Structs are closed (additionalProperties false), matching the strict
+// decoding of tool arguments.
+func SchemaFor(v any) map[string]any {
+	t := derefType(reflect.TypeOf(v))
+	if t == nil {
+		return map[string]any{}
+	}
+	return (&schemaGen{closed: true}).forType(t)
+}
+
+// SchemaBuilder generates schemas for API documentation. Named struct types
+// are emitted once under Components and referenced with $ref, so every Go
+// type has a single definition in the generated document.
+type SchemaBuilder struct {
+	components map[string]any
+	types      map[string]reflect.Type
+}
+
+func NewSchemaBuilder() *SchemaBuilder {
+	return &SchemaBuilder{components: map[string]any{}, types: map[string]reflect.Type{}}
+}
+
+// Schema returns the schema of v, a $ref when v is a named struct.
+func (b *SchemaBuilder) Schema(v any) map[string]any {
+	t := derefType(reflect.TypeOf(v))
+	if t == nil {
+		return map[string]any{}
+	}
+	return (&schemaGen{builder: b}).forType(t)
+}
+
+// Inline returns the closed schema of the struct v without registering it
+// as a component. Nested named structs are still referenced. Fields named in
+// omit are left out (they are supplied by path parameters).
+func (b *SchemaBuilder) Inline(v any, omit ...string) map[string]any {
+	t := derefType(reflect.TypeOf(v))
+	if t == nil || t.Kind() != reflect.Struct {
+		return b.Schema(v)
+	}
+	schema := (&schemaGen{builder: b}).structSchema(t)
+	schema["additionalProperties"] = false
+	props := schema["properties"].(map[string]any)
+	required, _ := schema["required"].([]string)
+	for _, name := range omit {
+		delete(props, name)
+		kept := required[:0]
+		for _, r := range required {
+			if r != name {
+				kept = append(kept, r)
+			}
+		}
+		required = kept
+	}
+	if len(required) == 0 {
+		delete(schema, "required")
+	} else {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// Components returns the schemas referenced so far, keyed by type name.
+func (b *SchemaBuilder) Components() map[string]any {
+	return b.components
+}
+
+func (b *SchemaBuilder) ref(g *schemaGen, t reflect.Type) map[string]any {
+	name := t.Name()
+	if prev, ok := b.types[name]; ok {
+		if prev != t {
+			panic(fmt.Sprintf("schema component %q is defined by both %s and %s", name, prev, t))
+		}
+	} else {
+		// Register the type before walking it so recursive types terminate.
+		b.types[name] = t
+		b.components[name] = g.structSchema(t)
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+func derefType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// schemaGen walks Go types. Argument schemas are inlined and closed;
+// documentation schemas reference named structs through builder.
+type schemaGen struct {
+	builder *SchemaBuilder
+	closed  bool
+}
+
+func (g *schemaGen) forType(t reflect.Type) map[string]any {
+	t = derefType(t)
+	switch t {
+	case rawMessageType:
+		return map[string]any{}
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.forType(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.forType(t.Elem())}
+	case reflect.Struct:
+		if g.builder != nil && t.Name() != "" {
+			return g.builder.ref(g, t)
+		}
+		return g.structSchema(t)
+	default:
+		return map[string]any{}
+	}
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, omitempty := jsonFieldName(f)
+		if name == "-" {
+			continue
+		}
+		prop := g.forType(f.Type)
+		if _, isRef := prop["$ref"]; !isRef {
+			applySchemaTag(prop, f.Tag.Get("jsonschema"))
+		}
+		props[name] = prop
+		if !omitempty {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{
+		"type":       "object",
+		"properties": props,
+	}
+	if g.closed {
+		schema["additionalProperties"] = false
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "" {
+		return f.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	omitempty := false
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
+
+func applySchemaTag(prop map[string]any, tag string) {
+	if tag == "" {
+		return
+	}
+	for _, kv := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(kv, "=")
+		switch strings.TrimSpace(key) {
+		case "description":
+			prop["description"] = value
+		case "enum":
+			prop["enum"] = strings.Split(value, "|")
+		case "minimum":
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				prop["minimum"] = n
+			}
+		}
+	}
+}
+
+// SchemaFieldError describes one argument that does not match the schema.
+type SchemaFieldError struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+}
+
+// SchemaError rejects tool arguments that fail schema validation.
+type SchemaError struct {
+	Fields []SchemaFieldError
+}
+
+func (e *SchemaError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, fmt.Sprintf("%s: expected %s, got %s", f.Path, f.Expected, f.Got))
+	}
+	return "invalid request: " + strings.Join(parts, "; ")
+}
+
+func (e *SchemaError) ErrorCode() string { return "invalid_request_schema" }
+
+// ValidateSchema checks raw JSON against a schema produced by SchemaFor. It
+// returns nil or a *SchemaError listing every mismatching field.
+func ValidateSchema(schema map[string]any, raw json.RawMessage) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return &SchemaError{Fields: []SchemaFieldError{{Path: "$", Expected: "valid JSON", Got: err.Error()}}}
+	}
+	var errs []SchemaFieldError
+	validateValue(schema, v, "$", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &SchemaError{Fields: errs}
+}
+
+func validateValue(schema map[string]any, v any, path string, errs *[]SchemaFieldError) {
+	typ, _ := schema["type"].(string)
+	if typ == "" {
+		return
+	}
+	if v == nil {
+		// Arrays and objects decode null to their zero value.
+		if typ != "array" && typ != "object" {
+			*errs = append(*errs, SchemaFieldError{Path: path, Expected: typ, Got: "null"})
+		}
+		return
+	}
+
+	got := jsonTypeOf(v)
+	switch typ {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			*errs = append(*errs, SchemaFieldError{Path: path, Expected: typ, Got: got})
+			return
+		}
+		if _, err := n.Int64(); err != nil {
+			*errs = append(*errs, SchemaFieldError{Path: path, Expected: typ, Got: "number " + n.String()})
+			return
+		}
+		checkMinimum(schema, n, path, errs)
+		return
+	case "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			*errs = append(*errs, SchemaFieldError{Path: path, Expected: typ, Got: got})
+			return
+		}
+		checkMinimum(schema, n, path, errs)
+		return
+	}
+	if got != typ {
+		*errs = append(*errs, SchemaFieldError{Path: path, Expected: typ, Got: got})
+		return
+	}
+
+	switch typ {
+	case "string":
+		if enum, ok := schema["enum"].([]string); ok && !containsString(enum, v.(string)) {
+			*errs = append(*errs, SchemaFieldError{Path: path, Expected: "one of " + strings.Join(enum, "|"), Got: strconv.Quote(v.(string))})
+		}
+	case "array":
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range v.([]any) {
+			validateValue(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case "object":
+		validateObject(schema, v.(map[string]any), path, errs)
+	}
+}
+
+func validateObject(schema map[string]any, obj map[string]any, path string, errs *[]SchemaFieldError) {
+	props, _ := schema["properties"].(map[string]any)
+	required, _ := schema["required"].([]string)
+	for _, name := range required {
+		if _, ok := obj[name]; !ok {
+			expected := "value"
+			if p, ok := props[name].(map[string]any); ok {
+				if t, ok := p["type"].(string); ok {
+					expected = t
+				}
+			}
+			*errs = append(*errs, SchemaFieldError{Path: path + "." + name, Expected: expected, Got: "missing"})
+		}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if p, ok := props[k].(map[string]any); ok {
+			validateValue(p, obj[k], path+"."+k, errs)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				*errs = append(*errs, SchemaFieldError{Path: path + "." + k, Expected: "no such field", Got: jsonTypeOf(obj[k])})
+			}
+		case map[string]any:
+			validateValue(extra, obj[k], path+"."+k, errs)
+		}
+	}
+}
+
+func checkMinimum(schema map[string]any, n json.Number, path string, errs *[]SchemaFieldError) {
+	min, ok := schema["minimum"].(float64)
+	if !ok {
+		return
+	}
+	if f, err := n.Float64(); err == nil && f < min {
+		*errs = append(*errs, SchemaFieldError{Path: path, Expected: fmt.Sprintf(">= %s", strconv.FormatFloat(min, 'f', -1, 64)), Got: n.String()})
+	}
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
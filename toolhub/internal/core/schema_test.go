@@ -0,0 +1,163 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type schemaItem struct {
+	Path  string `json:"path"`
+	Mode  string `json:"mode,omitempty" jsonschema:"enum=write|delete"`
+	Extra string `json:"-"`
+}
+
+type schemaArgs struct {
+	RunID  string       `json:"run_id" jsonschema:"description=Run identifier"`
+	Count  int          `json:"count,omitempty" jsonschema:"minimum=1"`
+	DryRun bool         `json:"dry_run,omitempty"`
+	Items  []schemaItem `json:"items"`
+	Meta   any          `json:"meta,omitempty"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema := SchemaFor(&schemaArgs{})
+	if schema["type"] != "object" || schema["additionalProperties"] != false {
+		t.Fatalf("unexpected root schema: %v", schema)
+	}
+	if got := schema["required"]; !reflect.DeepEqual(got, []string{"run_id", "items"}) {
+		t.Fatalf("unexpected required: %v", got)
+	}
+	props := schema["properties"].(map[string]any)
+	runID := props["run_id"].(map[string]any)
+	if runID["type"] != "string" || runID["description"] != "Run identifier" {
+		t.Fatalf("unexpected run_id schema: %v", runID)
+	}
+	if props["count"].(map[string]any)["minimum"] != float64(1) {
+		t.Fatalf("expected minimum on count: %v", props["count"])
+	}
+	items := props["items"].(map[string]any)["items"].(map[string]any)
+	itemProps := items["properties"].(map[string]any)
+	if _, ok := itemProps["Extra"]; ok {
+		t.Fatalf("json:\"-\" fields must be skipped")
+	}
+	if got := itemProps["mode"].(map[string]any)["enum"]; !reflect.DeepEqual(got, []string{"write", "delete"}) {
+		t.Fatalf("unexpected enum: %v", got)
+	}
+	if len(props["meta"].(map[string]any)) != 0 {
+		t.Fatalf("interface fields must accept any value: %v", props["meta"])
+	}
+}
+
+type schemaResult struct {
+	Items   []schemaItem `json:"items"`
+	First   *schemaItem  `json:"first,omitempty"`
+	Created time.Time    `json:"created"`
+	Size    int64        `json:"size"`
+}
+
+func TestSchemaBuilderReferencesNamedTypes(t *testing.T) {
+	b := NewSchemaBuilder()
+	if got := b.Schema(schemaResult{}); !reflect.DeepEqual(got, map[string]any{"$ref": "#/components/schemas/schemaResult"}) {
+		t.Fatalf("expected a component reference, got %v", got)
+	}
+	components := b.Components()
+	result := components["schemaResult"].(map[string]any)
+	if _, closed := result["additionalProperties"]; closed {
+		t.Fatalf("documented response types must stay open: %v", result)
+	}
+	props := result["properties"].(map[string]any)
+	if got := props["first"]; !reflect.DeepEqual(got, map[string]any{"$ref": "#/components/schemas/schemaItem"}) {
+		t.Fatalf("expected pointer field to reference schemaItem, got %v", got)
+	}
+	if got := props["created"]; !reflect.DeepEqual(got, map[string]any{"type": "string", "format": "date-time"}) {
+		t.Fatalf("unexpected time schema: %v", got)
+	}
+	if got := props["size"].(map[string]any)["format"]; got != "int64" {
+		t.Fatalf("expected int64 format, got %v", got)
+	}
+	if _, ok := components["schemaItem"]; !ok {
+		t.Fatalf("nested named struct must become a component")
+	}
+
+	inline := b.Inline(&schemaArgs{}, "run_id")
+	if inline["additionalProperties"] != false {
+		t.Fatalf("request bodies must be closed: %v", inline)
+	}
+	if _, ok := inline["properties"].(map[string]any)["run_id"]; ok {
+		t.Fatalf("omitted fields must be dropped")
+	}
+	if got := inline["required"]; !reflect.DeepEqual(got, []string{"items"}) {
+		t.Fatalf("unexpected required after omit: %v", got)
+	}
+}
+
+func TestSchemaBuilderRejectsNameCollision(t *testing.T) {
+	type schemaItem struct{ Other string }
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for two types named schemaItem")
+		}
+	}()
+	b := NewSchemaBuilder()
+	b.Schema(schemaResult{})
+	b.Schema(schemaItem{})
+}
+
+func TestValidateSchema(t *testing.T) {
+	schema := SchemaFor(&schemaArgs{})
+	tests := []struct {
+		name string
+		raw  string
+		want []SchemaFieldError
+	}{
+		{name: "valid", raw: `{"run_id":"r","count":2,"items":[{"path":"a","mode":"write"}],"meta":{"x":[1]}}`},
+		{name: "null array", raw: `{"run_id":"r","items":null}`},
+		{name: "missing required", raw: `{"items":[]}`, want: []SchemaFieldError{
+			{Path: "$.run_id", Expected: "string", Got: "missing"},
+		}},
+		{name: "wrong types", raw: `{"run_id":5,"dry_run":"yes","items":{}}`, want: []SchemaFieldError{
+			{Path: "$.dry_run", Expected: "boolean", Got: "string"},
+			{Path: "$.items", Expected: "array", Got: "object"},
+			{Path: "$.run_id", Expected: "string", Got: "number"},
+		}},
+		{name: "non integer", raw: `{"run_id":"r","count":1.5,"items":[]}`, want: []SchemaFieldError{
+			{Path: "$.count", Expected: "integer", Got: "number 1.5"},
+		}},
+		{name: "minimum", raw: `{"run_id":"r","count":0,"items":[]}`, want: []SchemaFieldError{
+			{Path: "$.count", Expected: ">= 1", Got: "0"},
+		}},
+		{name: "nested", raw: `{"run_id":"r","items":[{"path":"a"},{"mode":"move","extra":true}]}`, want: []SchemaFieldError{
+			{Path: "$.items[1].path", Expected: "string", Got: "missing"},
+			{Path: "$.items[1].extra", Expected: "no such field", Got: "boolean"},
+			{Path: "$.items[1].mode", Expected: "one of write|delete", Got: `"move"`},
+		}},
+		{name: "not an object", raw: `[]`, want: []SchemaFieldError{
+			{Path: "$", Expected: "object", Got: "array"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSchema(schema, json.RawMessage(tt.raw))
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var schemaErr *SchemaError
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("expected SchemaError, got %v", err)
+			}
+			if !reflect.DeepEqual(schemaErr.Fields, tt.want) {
+				t.Fatalf("unexpected fields:\n got %+v\nwant %+v", schemaErr.Fields, tt.want)
+			}
+			if MapError(err, 500).Code != "invalid_request_schema" || MapError(err, 500).HTTPStatus != 400 {
+				t.Fatalf("schema errors must map to invalid_request_schema/400")
+			}
+		})
+	}
+}
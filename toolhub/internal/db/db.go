@@ -5,6 +5,7 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -194,7 +195,7 @@ type ToolCall struct {
 	RunID              string    `json:"run_id"`
 	ToolName           string    `json:"tool_name"`
 	IdempotencyKey     *string   `json:"idempotency_key,omitempty"`
-	Status             string    `json:"status"`
+	Status             string    `json:"status" jsonschema:"enum=ok|fail|cancelled"`
 	RequestArtifactID  *string   `json:"request_artifact_id,omitempty"`
 	ResponseArtifactID *string   `json:"response_artifact_id,omitempty"`
 	EvidenceHash       string    `json:"evidence_hash"`
@@ -240,6 +241,23 @@ func (d *DB) GetSuccessfulToolCallByIdempotency(ctx context.Context, runID, tool
 	return tc, nil
 }
 
+// GetToolCall returns the recorded tool call, or nil if it does not exist.
+func (d *DB) GetToolCall(ctx context.Context, toolCallID string) (*ToolCall, error) {
+	tc := &ToolCall{}
+	err := d.conn.QueryRowContext(ctx,
+		`SELECT tool_call_id, run_id, tool_name, idempotency_key, status, request_artifact_id, response_artifact_id, evidence_hash, created_at
+		 FROM tool_calls WHERE tool_call_id = $1`,
+		toolCallID,
+	).Scan(&tc.ToolCallID, &tc.RunID, &tc.ToolName, &tc.IdempotencyKey, &tc.Status, &tc.RequestArtifactID, &tc.ResponseArtifactID, &tc.EvidenceHash, &tc.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get tool_call: %w", err)
+	}
+	return tc, nil
+}
+
 // ListToolCallsByRun returns all tool calls for a given run.
 func (d *DB) ListToolCallsByRun(ctx context.Context, runID string) ([]*ToolCall, error) {
 	return d.ListToolCallsByRunFiltered(ctx, runID, ToolCallListFilter{})
@@ -303,13 +321,15 @@ type Step struct {
 	StartedAt  *time.Time `json:"started_at,omitempty"`
 	FinishedAt *time.Time `json:"finished_at,omitempty"`
 	CreatedAt  time.Time  `json:"created_at"`
+	// JobID is set for steps taken by an asynchronous job.
+	JobID *string `json:"job_id,omitempty"`
 }
 
 func (d *DB) InsertStep(ctx context.Context, s *Step) error {
 	_, err := d.conn.ExecContext(ctx,
-		`INSERT INTO steps (step_id, run_id, name, type, status, started_at, finished_at, created_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		s.StepID, s.RunID, s.Name, s.Type, s.Status, s.StartedAt, s.FinishedAt, s.CreatedAt,
+		`INSERT INTO steps (step_id, run_id, name, type, status, started_at, finished_at, created_at, job_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		s.StepID, s.RunID, s.Name, s.Type, s.Status, s.StartedAt, s.FinishedAt, s.CreatedAt, s.JobID,
 	)
 	if err != nil {
 		return fmt.Errorf("insert step: %w", err)
@@ -318,9 +338,18 @@ func (d *DB) InsertStep(ctx context.Context, s *Step) error {
 }
 
 func (d *DB) ListStepsByRun(ctx context.Context, runID string) ([]*Step, error) {
+	return d.listSteps(ctx, `WHERE run_id = $1`, runID)
+}
+
+// ListStepsByJob returns the steps taken by a job, in order.
+func (d *DB) ListStepsByJob(ctx context.Context, jobID string) ([]*Step, error) {
+	return d.listSteps(ctx, `WHERE job_id = $1`, jobID)
+}
+
+func (d *DB) listSteps(ctx context.Context, where string, arg any) ([]*Step, error) {
 	rows, err := d.conn.QueryContext(ctx,
-		`SELECT step_id, run_id, name, type, status, started_at, finished_at, created_at
-		 FROM steps WHERE run_id = $1 ORDER BY created_at`, runID,
+		`SELECT step_id, run_id, name, type, status, started_at, finished_at, created_at, job_id
+		 FROM steps `+where+` ORDER BY created_at`, arg,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("list steps: %w", err)
@@ -332,7 +361,8 @@ func (d *DB) ListStepsByRun(ctx context.Context, runID string) ([]*Step, error)
 		s := &Step{}
 		var started sql.NullTime
 		var finished sql.NullTime
-		if err := rows.Scan(&s.StepID, &s.RunID, &s.Name, &s.Type, &s.Status, &started, &finished, &s.CreatedAt); err != nil {
+		var jobID sql.NullString
+		if err := rows.Scan(&s.StepID, &s.RunID, &s.Name, &s.Type, &s.Status, &started, &finished, &s.CreatedAt, &jobID); err != nil {
 			return nil, fmt.Errorf("scan step: %w", err)
 		}
 		if started.Valid {
@@ -343,6 +373,10 @@ func (d *DB) ListStepsByRun(ctx context.Context, runID string) ([]*Step, error)
 			t := finished.Time
 			s.FinishedAt = &t
 		}
+		if jobID.Valid {
+			id := jobID.String
+			s.JobID = &id
+		}
 		out = append(out, s)
 	}
 	return out, rows.Err()
@@ -510,3 +544,336 @@ func (d *DB) ListApprovalsByRun(ctx context.Context, runID string) ([]*Approval,
 	}
 	return out, rows.Err()
 }
+
+type RepairSession struct {
+	SessionID     string    `json:"repair_session_id"`
+	RunID         string    `json:"run_id"`
+	Status        string    `json:"status"`
+	BaseBranch    string    `json:"base_branch"`
+	HeadBranch    string    `json:"head_branch"`
+	PRTitle       string    `json:"pr_title"`
+	PRBody        string    `json:"pr_body"`
+	MaxIterations int       `json:"max_iterations"`
+	IterationsRun int       `json:"iterations_run"`
+	HeadSHA       string    `json:"head_sha"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// FailedTests lists the IDs of the tests that failed on the last
+	// iteration; it is nil when that iteration had no parsed test results.
+	FailedTests []string `json:"failed_tests,omitempty"`
+	// QATargets names the QA targets every iteration must pass; nil means
+	// test and lint.
+	QATargets []string `json:"qa_targets,omitempty"`
+	// TargetedTests runs only the tests affected by the head branch's
+	// changes on every iteration.
+	TargetedTests bool `json:"targeted_tests,omitempty"`
+	// TestReruns reruns the failed tests of every iteration up to this many
+	// times; tests that pass on a rerun are flaky.
+	TestReruns int `json:"test_reruns,omitempty"`
+}
+
+// nullableJSON marshals v for a nullable JSONB column, storing nil as NULL.
+func nullableJSON(v []string) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+func (d *DB) InsertRepairSession(ctx context.Context, s *RepairSession) error {
+	failedTests, err := nullableJSON(s.FailedTests)
+	if err != nil {
+		return fmt.Errorf("marshal repair session failed tests: %w", err)
+	}
+	qaTargets, err := nullableJSON(s.QATargets)
+	if err != nil {
+		return fmt.Errorf("marshal repair session qa targets: %w", err)
+	}
+	_, err = d.conn.ExecContext(ctx,
+		`INSERT INTO repair_sessions (session_id, run_id, status, base_branch, head_branch, pr_title, pr_body, max_iterations, iterations_run, head_sha, failed_tests, qa_targets, targeted_tests, test_reruns, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+		s.SessionID, s.RunID, s.Status, s.BaseBranch, s.HeadBranch, s.PRTitle, s.PRBody, s.MaxIterations, s.IterationsRun, s.HeadSHA, failedTests, qaTargets, s.TargetedTests, s.TestReruns, s.CreatedAt, s.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert repair session: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) GetRepairSession(ctx context.Context, sessionID string) (*RepairSession, error) {
+	s := &RepairSession{}
+	var failedTests, qaTargets []byte
+	err := d.conn.QueryRowContext(ctx,
+		`SELECT session_id, run_id, status, base_branch, head_branch, pr_title, pr_body, max_iterations, iterations_run, head_sha, failed_tests, qa_targets, targeted_tests, test_reruns, created_at, updated_at
+		 FROM repair_sessions WHERE session_id = $1`, sessionID,
+	).Scan(&s.SessionID, &s.RunID, &s.Status, &s.BaseBranch, &s.HeadBranch, &s.PRTitle, &s.PRBody, &s.MaxIterations, &s.IterationsRun, &s.HeadSHA, &failedTests, &qaTargets, &s.TargetedTests, &s.TestReruns, &s.CreatedAt, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get repair session: %w", err)
+	}
+	if failedTests != nil {
+		if err := json.Unmarshal(failedTests, &s.FailedTests); err != nil {
+			return nil, fmt.Errorf("decode repair session failed tests: %w", err)
+		}
+	}
+	if qaTargets != nil {
+		if err := json.Unmarshal(qaTargets, &s.QATargets); err != nil {
+			return nil, fmt.Errorf("decode repair session qa targets: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// TransitionRepairSessionStatus moves a session from one status to another
+// and reports whether it was in the from status.
+func (d *DB) TransitionRepairSessionStatus(ctx context.Context, sessionID, from, to string, updatedAt time.Time) (bool, error) {
+	res, err := d.conn.ExecContext(ctx,
+		`UPDATE repair_sessions SET status = $3, updated_at = $4 WHERE session_id = $1 AND status = $2`,
+		sessionID, from, to, updatedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("transition repair session: %w", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rows affected repair session transition: %w", err)
+	}
+	return rows == 1, nil
+}
+
+// ReopenRunningRepairSessions moves every running repair session back to
+// open. It is called at startup, when no iteration can still be running.
+func (d *DB) ReopenRunningRepairSessions(ctx context.Context, at time.Time) (int64, error) {
+	res, err := d.conn.ExecContext(ctx,
+		`UPDATE repair_sessions SET status = 'open', updated_at = $1 WHERE status = 'running'`, at,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("reopen repair sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected reopen repair sessions: %w", err)
+	}
+	return n, nil
+}
+
+func (d *DB) UpdateRepairSession(ctx context.Context, s *RepairSession) error {
+	failedTests, err := nullableJSON(s.FailedTests)
+	if err != nil {
+		return fmt.Errorf("marshal repair session failed tests: %w", err)
+	}
+	_, err = d.conn.ExecContext(ctx,
+		`UPDATE repair_sessions SET status = $2, iterations_run = $3, head_sha = $4, failed_tests = $5, updated_at = $6 WHERE session_id = $1`,
+		s.SessionID, s.Status, s.IterationsRun, s.HeadSHA, failedTests, s.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("update repair session: %w", err)
+	}
+	return nil
+}
+
+// FlakyTest is the rerun history of a test in a repository: how often it
+// passed on a rerun after failing, and how often it kept failing.
+type FlakyTest struct {
+	Repo        string     `json:"repo"`
+	TestID      string     `json:"test_id"`
+	FlakyCount  int        `json:"flaky_count"`
+	FailedCount int        `json:"failed_count"`
+	FirstSeenAt time.Time  `json:"first_seen_at"`
+	LastFlakyAt *time.Time `json:"last_flaky_at,omitempty"`
+	LastSeenAt  time.Time  `json:"last_seen_at"`
+}
+
+// RecordTestOutcomes adds one rerun outcome per test to the history of
+// repo: flaky tests passed on a rerun, failed ones failed on every rerun.
+func (d *DB) RecordTestOutcomes(ctx context.Context, repo string, flaky, failed []string, at time.Time) error {
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin record test outcomes: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, outcome := range []struct {
+		ids   []string
+		flaky int
+	}{{flaky, 1}, {failed, 0}} {
+		for _, id := range outcome.ids {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO flaky_tests (repo, test_id, flaky_count, failed_count, first_seen_at, last_flaky_at, last_seen_at)
+				 VALUES ($1, $2, $3, 1 - $3, $4, CASE WHEN $3 = 1 THEN $4::timestamptz END, $4)
+				 ON CONFLICT (repo, test_id) DO UPDATE SET
+				   flaky_count = flaky_tests.flaky_count + EXCLUDED.flaky_count,
+				   failed_count = flaky_tests.failed_count + EXCLUDED.failed_count,
+				   last_flaky_at = COALESCE(EXCLUDED.last_flaky_at, flaky_tests.last_flaky_at),
+				   last_seen_at = EXCLUDED.last_seen_at`,
+				repo, id, outcome.flaky, at,
+			); err != nil {
+				return fmt.Errorf("record test outcome: %w", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit test outcomes: %w", err)
+	}
+	return nil
+}
+
+// ListFlakyTests returns the tests of repo that were flaky at least once,
+// most often flaky first.
+func (d *DB) ListFlakyTests(ctx context.Context, repo string, limit int) ([]FlakyTest, error) {
+	rows, err := d.conn.QueryContext(ctx,
+		`SELECT repo, test_id, flaky_count, failed_count, first_seen_at, last_flaky_at, last_seen_at
+		 FROM flaky_tests WHERE repo = $1 AND flaky_count > 0
+		 ORDER BY flaky_count DESC, last_flaky_at DESC, test_id LIMIT $2`, repo, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list flaky tests: %w", err)
+	}
+	defer rows.Close()
+
+	out := []FlakyTest{}
+	for rows.Next() {
+		var ft FlakyTest
+		var lastFlaky sql.NullTime
+		if err := rows.Scan(&ft.Repo, &ft.TestID, &ft.FlakyCount, &ft.FailedCount, &ft.FirstSeenAt, &lastFlaky, &ft.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("scan flaky test: %w", err)
+		}
+		if lastFlaky.Valid {
+			t := lastFlaky.Time
+			ft.LastFlakyAt = &t
+		}
+		out = append(out, ft)
+	}
+	return out, rows.Err()
+}
+
+// Job is a tool call running in the background. Result holds the response
+// body of a finished call, HTTPStatus its status; a call that failed
+// instead records ErrorCode and ErrorMessage.
+type Job struct {
+	JobID        string          `json:"job_id"`
+	RunID        string          `json:"run_id"`
+	ToolName     string          `json:"tool_name"`
+	Status       string          `json:"status" jsonschema:"enum=running|succeeded|failed|cancelled|interrupted"`
+	StepID       *string         `json:"step_id,omitempty"`
+	HTTPStatus   int             `json:"http_status,omitempty"`
+	Result       json.RawMessage `json:"-"`
+	ErrorCode    string          `json:"error_code,omitempty"`
+	ErrorMessage string          `json:"error_message,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	FinishedAt   *time.Time      `json:"finished_at,omitempty"`
+	// Steps lists the steps the job has taken so far.
+	Steps []*Step `json:"steps,omitempty"`
+}
+
+func (d *DB) InsertJob(ctx context.Context, j *Job) error {
+	_, err := d.conn.ExecContext(ctx,
+		`INSERT INTO jobs (job_id, run_id, tool_name, status, step_id, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		j.JobID, j.RunID, j.ToolName, j.Status, j.StepID, j.CreatedAt, j.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert job: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) GetJob(ctx context.Context, jobID string) (*Job, error) {
+	j := &Job{}
+	var stepID sql.NullString
+	var result []byte
+	var finished sql.NullTime
+	err := d.conn.QueryRowContext(ctx,
+		`SELECT job_id, run_id, tool_name, status, step_id, http_status, result, error_code, error_message, created_at, updated_at, finished_at
+		 FROM jobs WHERE job_id = $1`, jobID,
+	).Scan(&j.JobID, &j.RunID, &j.ToolName, &j.Status, &stepID, &j.HTTPStatus, &result, &j.ErrorCode, &j.ErrorMessage, &j.CreatedAt, &j.UpdatedAt, &finished)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get job: %w", err)
+	}
+	if stepID.Valid {
+		id := stepID.String
+		j.StepID = &id
+	}
+	if result != nil {
+		j.Result = result
+	}
+	if finished.Valid {
+		t := finished.Time
+		j.FinishedAt = &t
+	}
+	return j, nil
+}
+
+// FinishJob stores the outcome of a job. The status only changes while the
+// job is running, so a job cancelled in the database keeps that status.
+func (d *DB) FinishJob(ctx context.Context, j *Job) error {
+	var result any
+	if j.Result != nil {
+		result = []byte(j.Result)
+	}
+	_, err := d.conn.ExecContext(ctx,
+		`UPDATE jobs SET status = CASE WHEN status = 'running' THEN $2 ELSE status END,
+		 http_status = $3, result = $4, error_code = $5, error_message = $6, updated_at = $7, finished_at = $8
+		 WHERE job_id = $1`,
+		j.JobID, j.Status, j.HTTPStatus, result, j.ErrorCode, j.ErrorMessage, j.UpdatedAt, j.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("finish job: %w", err)
+	}
+	return nil
+}
+
+// TransitionJobStatus ends a running job without an outcome and reports
+// whether it was still running.
+func (d *DB) TransitionJobStatus(ctx context.Context, jobID, status string, finishedAt time.Time) (bool, error) {
+	res, err := d.conn.ExecContext(ctx,
+		`UPDATE jobs SET status = $2, updated_at = $3, finished_at = $3 WHERE job_id = $1 AND status = 'running'`,
+		jobID, status, finishedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("transition job: %w", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rows affected job transition: %w", err)
+	}
+	return rows == 1, nil
+}
+
+// InterruptRunningJobs marks every running job, and the running steps it
+// took, as interrupted. It is called at startup, when no job can still be
+// running.
+func (d *DB) InterruptRunningJobs(ctx context.Context, at time.Time) (int64, error) {
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin interrupt jobs: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE steps SET status = 'interrupted', finished_at = $1
+		 WHERE status = 'running' AND job_id IN (SELECT job_id FROM jobs WHERE status = 'running')`, at,
+	); err != nil {
+		return 0, fmt.Errorf("interrupt job steps: %w", err)
+	}
+	res, err := tx.ExecContext(ctx,
+		`UPDATE jobs SET status = 'interrupted', updated_at = $1, finished_at = $1 WHERE status = 'running'`, at,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("interrupt jobs: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected interrupt jobs: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit interrupt jobs: %w", err)
+	}
+	return n, nil
+}
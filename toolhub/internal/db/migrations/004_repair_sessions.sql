@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS repair_sessions (
+  session_id TEXT PRIMARY KEY,
+  run_id TEXT NOT NULL REFERENCES runs(run_id) ON DELETE CASCADE,
+  status TEXT NOT NULL,
+  base_branch TEXT NOT NULL,
+  head_branch TEXT NOT NULL,
+  pr_title TEXT NOT NULL,
+  pr_body TEXT NOT NULL DEFAULT '',
+  max_iterations INTEGER NOT NULL,
+  iterations_run INTEGER NOT NULL DEFAULT 0,
+  head_sha TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_repair_sessions_run_created
+  ON repair_sessions(run_id, created_at);
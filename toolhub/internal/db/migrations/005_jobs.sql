@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS jobs (
+  job_id TEXT PRIMARY KEY,
+  run_id TEXT NOT NULL REFERENCES runs(run_id) ON DELETE CASCADE,
+  tool_name TEXT NOT NULL,
+  status TEXT NOT NULL,
+  step_id TEXT REFERENCES steps(step_id) ON DELETE SET NULL,
+  http_status INTEGER NOT NULL DEFAULT 0,
+  result JSONB,
+  error_code TEXT NOT NULL DEFAULT '',
+  error_message TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_run_created
+  ON jobs(run_id, created_at);
+
+ALTER TABLE steps ADD COLUMN IF NOT EXISTS job_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_steps_job
+  ON steps(job_id, created_at) WHERE job_id IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE repair_sessions ADD COLUMN IF NOT EXISTS failed_tests JSONB;
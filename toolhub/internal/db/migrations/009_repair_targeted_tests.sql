@@ -0,0 +1 @@
+ALTER TABLE repair_sessions ADD COLUMN IF NOT EXISTS targeted_tests BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS flaky_tests (
+  repo TEXT NOT NULL,
+  test_id TEXT NOT NULL,
+  flaky_count INTEGER NOT NULL DEFAULT 0,
+  failed_count INTEGER NOT NULL DEFAULT 0,
+  first_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_flaky_at TIMESTAMPTZ,
+  last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (repo, test_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_flaky_tests_repo_count
+  ON flaky_tests(repo, flaky_count DESC) WHERE flaky_count > 0;
@@ -0,0 +1 @@
+ALTER TABLE repair_sessions ADD COLUMN IF NOT EXISTS test_reruns INTEGER NOT NULL DEFAULT 0;
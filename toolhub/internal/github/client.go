@@ -12,6 +12,7 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"sync"
@@ -30,6 +31,10 @@ type Client struct {
 	mu    sync.Mutex
 	token string
 	expAt time.Time
+
+	identityMu sync.Mutex
+	botName    string
+	botEmail   string
 }
 
 func NewClient(appID, installationID int64, keyPath string) (*Client, error) {
@@ -138,6 +143,12 @@ func (c *Client) ensureInstallationID(ctx context.Context) error {
 	return nil
 }
 
+// InstallationToken returns a cached installation access token, refreshing
+// it shortly before it expires.
+func (c *Client) InstallationToken(ctx context.Context) (string, error) {
+	return c.installationToken(ctx)
+}
+
 func (c *Client) installationToken(ctx context.Context) (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -184,6 +195,72 @@ func (c *Client) installationToken(ctx context.Context) (string, error) {
 	return c.token, nil
 }
 
+type appInfo struct {
+	Slug string `json:"slug"`
+}
+
+type userInfo struct {
+	ID int64 `json:"id"`
+}
+
+// BotIdentity returns the git author name and email of the App's bot user,
+// "<slug>[bot]" and "<id>+<slug>[bot]@users.noreply.github.com", which
+// GitHub links to the App on commits.
+func (c *Client) BotIdentity(ctx context.Context) (string, string, error) {
+	c.identityMu.Lock()
+	defer c.identityMu.Unlock()
+	if c.botName != "" {
+		return c.botName, c.botEmail, nil
+	}
+
+	jwtStr, err := c.makeJWT()
+	if err != nil {
+		return "", "", fmt.Errorf("sign JWT: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.github.com/app", nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwtStr)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("get app: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", "", fmt.Errorf("get app HTTP %d: %s", resp.StatusCode, body)
+	}
+	var app appInfo
+	if err := json.NewDecoder(resp.Body).Decode(&app); err != nil {
+		return "", "", fmt.Errorf("decode app: %w", err)
+	}
+	if app.Slug == "" {
+		return "", "", fmt.Errorf("get app: empty slug")
+	}
+
+	login := app.Slug + "[bot]"
+	userResp, err := c.doAPI(ctx, http.MethodGet, "https://api.github.com/users/"+url.PathEscape(login), nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer userResp.Body.Close()
+	if userResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(userResp.Body)
+		telemetry.IncGitHubAPIError("get bot user", userResp.StatusCode)
+		return "", "", &APIError{Operation: "get bot user", StatusCode: userResp.StatusCode, Body: string(body)}
+	}
+	var user userInfo
+	if err := json.NewDecoder(userResp.Body).Decode(&user); err != nil {
+		return "", "", fmt.Errorf("decode bot user: %w", err)
+	}
+
+	c.botName = login
+	c.botEmail = fmt.Sprintf("%d+%s@users.noreply.github.com", user.ID, login)
+	return c.botName, c.botEmail, nil
+}
+
 func (c *Client) doAPI(ctx context.Context, method, url string, body any) (*http.Response, error) {
 	token, err := c.installationToken(ctx)
 	if err != nil {
@@ -237,11 +314,18 @@ type PullRequest struct {
 	HTMLURL   string `json:"html_url"`
 	Merged    bool   `json:"merged"`
 	Mergeable *bool  `json:"mergeable,omitempty"`
-	Base      struct {
+	User      struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Base struct {
 		Ref string `json:"ref"`
 	} `json:"base"`
 	Head struct {
-		Ref string `json:"ref"`
+		Ref  string `json:"ref"`
+		SHA  string `json:"sha"`
+		Repo *struct {
+			FullName string `json:"full_name"`
+		} `json:"repo"`
 	} `json:"head"`
 }
 
@@ -264,21 +348,21 @@ type PullRequestFile struct {
 	PreviousFilename string `json:"previous_filename,omitempty"`
 }
 
-func SummarizePRFiles(files []PullRequestFile) map[string]any {
-	totalAdditions := 0
-	totalDeletions := 0
-	totalChanges := 0
+type PRFilesSummary struct {
+	TotalFiles     int `json:"total_files"`
+	TotalAdditions int `json:"total_additions"`
+	TotalDeletions int `json:"total_deletions"`
+	TotalChanges   int `json:"total_changes"`
+}
+
+func SummarizePRFiles(files []PullRequestFile) PRFilesSummary {
+	summary := PRFilesSummary{TotalFiles: len(files)}
 	for _, f := range files {
-		totalAdditions += f.Additions
-		totalDeletions += f.Deletions
-		totalChanges += f.Changes
-	}
-	return map[string]any{
-		"total_files":     len(files),
-		"total_additions": totalAdditions,
-		"total_deletions": totalDeletions,
-		"total_changes":   totalChanges,
+		summary.TotalAdditions += f.Additions
+		summary.TotalDeletions += f.Deletions
+		summary.TotalChanges += f.Changes
 	}
+	return summary
 }
 
 type APIError struct {
@@ -1,9 +1,13 @@
 package github
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -34,3 +38,48 @@ func TestParseRSAPrivateKeyPKCS1AndPKCS8(t *testing.T) {
 		t.Fatal("parsed pkcs8 key does not match original")
 	}
 }
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestBotIdentity(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	calls := 0
+	c := &Client{appID: 1, installationID: 7, privateKey: key, httpClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		switch r.URL.EscapedPath() {
+		case "/app":
+			return jsonResponse(http.StatusOK, `{"slug":"toolhub"}`), nil
+		case "/app/installations/7/access_tokens":
+			return jsonResponse(http.StatusCreated, `{"token":"tok","expires_at":"2099-01-01T00:00:00Z"}`), nil
+		case "/users/toolhub%5Bbot%5D":
+			if r.Header.Get("Authorization") != "token tok" {
+				t.Fatalf("users lookup auth = %q", r.Header.Get("Authorization"))
+			}
+			return jsonResponse(http.StatusOK, `{"id":42}`), nil
+		}
+		t.Fatalf("unexpected request %s", r.URL)
+		return nil, nil
+	})}}
+
+	for range 2 {
+		name, email, err := c.BotIdentity(context.Background())
+		if err != nil {
+			t.Fatalf("BotIdentity: %v", err)
+		}
+		if name != "toolhub[bot]" || email != "42+toolhub[bot]@users.noreply.github.com" {
+			t.Fatalf("identity = %q <%s>", name, email)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("requests = %d, want 3 (identity is cached)", calls)
+	}
+}
@@ -0,0 +1,185 @@
+package github
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/toolhub/toolhub/internal/telemetry"
+)
+
+// Git Data API: https://docs.github.com/en/rest/git. Commits created here
+// without an explicit author are attributed to the App and signed by GitHub.
+
+type GitVerification struct {
+	Verified bool   `json:"verified"`
+	Reason   string `json:"reason"`
+}
+
+type GitCommit struct {
+	SHA          string           `json:"sha"`
+	Tree         GitObject        `json:"tree"`
+	Verification *GitVerification `json:"verification,omitempty"`
+}
+
+type GitTree struct {
+	SHA       string         `json:"sha"`
+	Entries   []GitTreeEntry `json:"tree"`
+	Truncated bool           `json:"truncated"`
+}
+
+// GitTreeEntry is one path of a tree. When creating a tree, a nil SHA
+// deletes the path.
+type GitTreeEntry struct {
+	Path string  `json:"path"`
+	Mode string  `json:"mode"`
+	Type string  `json:"type"`
+	SHA  *string `json:"sha"`
+}
+
+type CreateGitCommitInput struct {
+	Message string   `json:"message"`
+	Tree    string   `json:"tree"`
+	Parents []string `json:"parents"`
+}
+
+// GitObject references a blob, tree or commit.
+type GitObject struct {
+	SHA string `json:"sha"`
+}
+
+func (c *Client) CreateBlob(ctx context.Context, owner, repo string, content []byte) (string, error) {
+	var blob GitObject
+	err := c.gitData(ctx, "create blob", http.MethodPost, fmt.Sprintf("https://api.github.com/repos/%s/%s/git/blobs", owner, repo), map[string]string{
+		"content":  base64.StdEncoding.EncodeToString(content),
+		"encoding": "base64",
+	}, http.StatusCreated, &blob)
+	return blob.SHA, err
+}
+
+func (c *Client) CreateTree(ctx context.Context, owner, repo, baseTree string, entries []GitTreeEntry) (string, error) {
+	var tree GitObject
+	err := c.gitData(ctx, "create tree", http.MethodPost, fmt.Sprintf("https://api.github.com/repos/%s/%s/git/trees", owner, repo), map[string]any{
+		"base_tree": baseTree,
+		"tree":      entries,
+	}, http.StatusCreated, &tree)
+	return tree.SHA, err
+}
+
+func (c *Client) CreateCommit(ctx context.Context, owner, repo string, in CreateGitCommitInput) (*GitCommit, error) {
+	var commit GitCommit
+	if err := c.gitData(ctx, "create commit", http.MethodPost, fmt.Sprintf("https://api.github.com/repos/%s/%s/git/commits", owner, repo), in, http.StatusCreated, &commit); err != nil {
+		return nil, err
+	}
+	return &commit, nil
+}
+
+// GetCommit returns a commit with GitHub's signature verification.
+func (c *Client) GetCommit(ctx context.Context, owner, repo, sha string) (*GitCommit, error) {
+	var commit GitCommit
+	if err := c.gitData(ctx, "get commit", http.MethodGet, fmt.Sprintf("https://api.github.com/repos/%s/%s/git/commits/%s", owner, repo, sha), nil, http.StatusOK, &commit); err != nil {
+		return nil, err
+	}
+	return &commit, nil
+}
+
+// CreateRef creates ref, a full name such as refs/heads/feature.
+func (c *Client) CreateRef(ctx context.Context, owner, repo, ref, sha string) error {
+	return c.gitData(ctx, "create ref", http.MethodPost, fmt.Sprintf("https://api.github.com/repos/%s/%s/git/refs", owner, repo), map[string]string{
+		"ref": ref,
+		"sha": sha,
+	}, http.StatusCreated, nil)
+}
+
+// GetRef returns the commit SHA ref points at; ref is a full name such as
+// refs/heads/main. A missing ref is an *APIError with status 404, see
+// IsNotFound.
+func (c *Client) GetRef(ctx context.Context, owner, repo, ref string) (string, error) {
+	var out struct {
+		Object GitObject `json:"object"`
+	}
+	err := c.gitData(ctx, "get ref", http.MethodGet, fmt.Sprintf("https://api.github.com/repos/%s/%s/git/%s", owner, repo, refPath(ref, "ref/")), nil, http.StatusOK, &out)
+	return out.Object.SHA, err
+}
+
+// UpdateRef moves ref to sha. Without force the update must be a
+// fast-forward.
+func (c *Client) UpdateRef(ctx context.Context, owner, repo, ref, sha string, force bool) error {
+	return c.gitData(ctx, "update ref", http.MethodPatch, fmt.Sprintf("https://api.github.com/repos/%s/%s/git/%s", owner, repo, refPath(ref, "refs/")), map[string]any{
+		"sha":   sha,
+		"force": force,
+	}, http.StatusOK, nil)
+}
+
+func (c *Client) DeleteRef(ctx context.Context, owner, repo, ref string) error {
+	return c.gitData(ctx, "delete ref", http.MethodDelete, fmt.Sprintf("https://api.github.com/repos/%s/%s/git/%s", owner, repo, refPath(ref, "refs/")), nil, http.StatusNoContent, nil)
+}
+
+// GetTree lists a tree, with every nested path when recursive is set.
+func (c *Client) GetTree(ctx context.Context, owner, repo, sha string, recursive bool) (*GitTree, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/trees/%s", owner, repo, sha)
+	if recursive {
+		url += "?recursive=1"
+	}
+	var tree GitTree
+	if err := c.gitData(ctx, "get tree", http.MethodGet, url, nil, http.StatusOK, &tree); err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+func (c *Client) GetBlob(ctx context.Context, owner, repo, sha string) ([]byte, error) {
+	var blob struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	if err := c.gitData(ctx, "get blob", http.MethodGet, fmt.Sprintf("https://api.github.com/repos/%s/%s/git/blobs/%s", owner, repo, sha), nil, http.StatusOK, &blob); err != nil {
+		return nil, err
+	}
+	if blob.Encoding != "base64" {
+		return []byte(blob.Content), nil
+	}
+	// GitHub wraps base64 content at 60 columns.
+	return base64.StdEncoding.DecodeString(strings.ReplaceAll(blob.Content, "\n", ""))
+}
+
+// IsNotFound reports whether err is a GitHub 404.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// refPath turns refs/heads/x into <prefix>heads/x for the ref endpoints,
+// which differ in using "ref/" to read and "refs/" to write.
+func refPath(ref, prefix string) string {
+	return prefix + strings.TrimPrefix(ref, "refs/")
+}
+
+func (c *Client) gitData(ctx context.Context, op, method, url string, body any, wantStatus int, out any) error {
+	resp, err := c.doAPI(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != wantStatus {
+		respBody, readErr := io.ReadAll(resp.Body)
+		if readErr != nil {
+			return fmt.Errorf("%s HTTP %d and read body failed: %w", op, resp.StatusCode, readErr)
+		}
+		telemetry.IncGitHubAPIError(op, resp.StatusCode)
+		return &APIError{Operation: op, StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package github
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/toolhub/toolhub/internal/telemetry"
+)
+
+// ReviewComment is an inline comment of a pull request review, on a line
+// of the file as changed by the pull request.
+type ReviewComment struct {
+	Path string `json:"path"`
+	Line int    `json:"line" jsonschema:"minimum=1"`
+	// StartLine makes the comment span StartLine..Line.
+	StartLine int    `json:"start_line,omitempty"`
+	Body      string `json:"body"`
+}
+
+type CreateReviewInput struct {
+	CommitID string          `json:"commit_id,omitempty"`
+	Body     string          `json:"body,omitempty"`
+	Event    string          `json:"event"`
+	Comments []ReviewComment `json:"comments,omitempty"`
+}
+
+type Review struct {
+	ID      int64  `json:"id"`
+	State   string `json:"state"`
+	HTMLURL string `json:"html_url"`
+}
+
+// CreatePullRequestReview submits a review with inline comments. Comments
+// must be on lines of the pull request diff; see CommentableLines.
+func (c *Client) CreatePullRequestReview(ctx context.Context, owner, repo string, prNumber int, in CreateReviewInput) (*Review, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d/reviews", owner, repo, prNumber)
+	type reviewComment struct {
+		Path      string `json:"path"`
+		Line      int    `json:"line"`
+		StartLine int    `json:"start_line,omitempty"`
+		Side      string `json:"side"`
+		Body      string `json:"body"`
+	}
+	payload := struct {
+		CommitID string          `json:"commit_id,omitempty"`
+		Body     string          `json:"body,omitempty"`
+		Event    string          `json:"event"`
+		Comments []reviewComment `json:"comments,omitempty"`
+	}{CommitID: in.CommitID, Body: in.Body, Event: in.Event}
+	for _, rc := range in.Comments {
+		payload.Comments = append(payload.Comments, reviewComment{Path: rc.Path, Line: rc.Line, StartLine: rc.StartLine, Side: "RIGHT", Body: rc.Body})
+	}
+
+	resp, err := c.doAPI(ctx, http.MethodPost, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, readErr := io.ReadAll(resp.Body)
+		if readErr != nil {
+			return nil, fmt.Errorf("create pr review HTTP %d and read body failed: %w", resp.StatusCode, readErr)
+		}
+		telemetry.IncGitHubAPIError("create pr review", resp.StatusCode)
+		return nil, &APIError{Operation: "create pr review", StatusCode: resp.StatusCode, Body: string(b)}
+	}
+
+	var review Review
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return nil, fmt.Errorf("decode pr review: %w", err)
+	}
+	return &review, nil
+}
+
+// CommentableLines returns the lines of the new file that a review comment
+// can be placed on: the added and context lines of the file's patch, as
+// listed by ListPullRequestFiles.
+func CommentableLines(patch string) map[int]bool {
+	lines := make(map[int]bool)
+	line := 0
+	scanner := bufio.NewScanner(strings.NewReader(patch))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "@@"):
+			// @@ -a,b +c,d @@: the hunk's new lines start at c.
+			line = 0
+			fields := strings.Fields(text)
+			if len(fields) >= 3 && strings.HasPrefix(fields[2], "+") {
+				start, _, _ := strings.Cut(fields[2][1:], ",")
+				if n, err := strconv.Atoi(start); err == nil {
+					line = n
+				}
+			}
+		case line == 0:
+		case strings.HasPrefix(text, "-"):
+		case strings.HasPrefix(text, `\`):
+			// \ No newline at end of file
+		default:
+			lines[line] = true
+			line++
+		}
+	}
+	return lines
+}
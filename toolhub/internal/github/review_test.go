@@ -0,0 +1,37 @@
+package github
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommentableLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  []int
+	}{
+		{
+			name: "modified hunks",
+			patch: "@@ -1,4 +1,5 @@\n package a\n-var x = 1\n+var x = 2\n+var y = 3\n \n func f() {}\n" +
+				"@@ -20,2 +21,2 @@ func g() {\n-\treturn 1\n+\treturn 2\n }\n\\ No newline at end of file",
+			want: []int{1, 2, 3, 4, 5, 21, 22},
+		},
+		{name: "new file", patch: "@@ -0,0 +1,2 @@\n+a\n+b", want: []int{1, 2}},
+		{name: "single line hunk", patch: "@@ -3 +3 @@\n-old\n+new", want: []int{3}},
+		{name: "binary", patch: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for line := 1; line <= 30; line++ {
+				if CommentableLines(tt.patch)[line] {
+					got = append(got, line)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("lines = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
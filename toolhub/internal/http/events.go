@@ -0,0 +1,94 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toolhub/toolhub/internal/core"
+)
+
+// ToolStreamResult is the data of the final result event of a streamed tool
+// call: the status and body the call would have responded with.
+type ToolStreamResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+func wantsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// eventStream streams the progress of a tool call as server-sent events.
+// The stream starts with the first progress event, so a call rejected
+// before it runs, or one that reports no progress, gets a plain response.
+// A started stream ends with a result event carrying the response.
+type eventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+
+	mu      sync.Mutex
+	started bool
+	done    bool
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	return &eventStream{w: w, rc: http.NewResponseController(w)}
+}
+
+func (s *eventStream) progress(ev core.ProgressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	if !s.started {
+		s.started = true
+		// Tool calls stream for longer than the server write timeout.
+		_ = s.rc.SetWriteDeadline(time.Time{})
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.w.WriteHeader(http.StatusOK)
+	}
+	s.writeEvent("progress", ev)
+}
+
+// finish ends the stream with the response written by respond and reports
+// whether it did; if the stream never started, respond should write to the
+// client directly instead.
+func (s *eventStream) finish(respond func(w http.ResponseWriter)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	if !s.started {
+		return false
+	}
+	rec := &recordedResponse{header: http.Header{}, status: http.StatusOK}
+	respond(rec)
+	s.writeEvent("result", ToolStreamResult{Status: rec.status, Body: bytes.TrimSpace(rec.body.Bytes())})
+	return true
+}
+
+func (s *eventStream) writeEvent(name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data)
+	_ = s.rc.Flush()
+}
+
+// recordedResponse captures a response for the result event.
+type recordedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recordedResponse) Header() http.Header         { return r.header }
+func (r *recordedResponse) Write(p []byte) (int, error) { return r.body.Write(p) }
+func (r *recordedResponse) WriteHeader(status int)      { r.status = status }
@@ -0,0 +1,74 @@
+package http
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toolhub/toolhub/internal/core"
+)
+
+type progressArgs struct {
+	Repo string `json:"repo"`
+}
+
+func newProgressServer() *Server {
+	reg := core.NewRegistry(nil, nil, core.NewPolicy("o/r", "runs.progress"), nil)
+	reg.Register(core.ToolSpec{
+		Name:     "runs.progress",
+		Route:    &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/progress", OperationID: "progress"},
+		NewArgs:  func() any { return &progressArgs{} },
+		Progress: true,
+		Policy:   core.ToolPolicy{NoRun: true},
+		Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+			if call.Args.(*progressArgs).Repo == "" {
+				return nil, core.BadToolRequest("repo is required")
+			}
+			core.ReportProgress(ctx, core.ProgressEvent{Type: core.ProgressOutput, Tool: "qa.test", Stream: "stdout", Data: "ok\n"})
+			return &core.ToolOutcome{Result: call.Args, Raw: true}, nil
+		},
+	})
+	return NewServer(":0", reg, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BuildInfo{})
+}
+
+func TestToolHandlerEventStream(t *testing.T) {
+	srv := newProgressServer()
+
+	tests := []struct {
+		name            string
+		accept          string
+		body            string
+		wantContentType string
+		wantBody        []string
+	}{
+		{name: "plain", body: `{"repo":"o/r"}`, wantContentType: "application/json", wantBody: []string{`{"repo":"o/r"}`}},
+		{name: "stream", accept: "text/event-stream", body: `{"repo":"o/r"}`, wantContentType: "text/event-stream", wantBody: []string{
+			"event: progress\ndata: {\"type\":\"output\",\"tool\":\"qa.test\",\"stream\":\"stdout\",\"data\":\"ok\\n\"}\n\n",
+			"event: result\ndata: {\"status\":200,\"body\":{\"repo\":\"o/r\"}}\n\n",
+		}},
+		{name: "rejected before progress", accept: "text/event-stream", body: `{}`, wantContentType: "application/json", wantBody: []string{`"code":"invalid_request_schema"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/progress", strings.NewReader(tt.body))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			srv.srv.Handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Fatalf("content type = %q, want %q (body %s)", got, tt.wantContentType, rec.Body)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Fatalf("body %q does not contain %q", rec.Body, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/toolhub/toolhub/internal/core"
+	"github.com/toolhub/toolhub/internal/db"
+	"github.com/toolhub/toolhub/internal/openapi"
+)
+
+// ErrorResponse is the body of every non-envelope error response.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Errors lists field-level details when Code is invalid_request_schema.
+	Errors            []core.SchemaFieldError `json:"errors,omitempty"`
+	RetryAfterSeconds int                     `json:"retry_after_seconds,omitempty"`
+}
+
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+type VersionInfo struct {
+	Version         string `json:"version"`
+	GitCommit       string `json:"git_commit"`
+	BuildTime       string `json:"build_time"`
+	ContractVersion string `json:"contract_version"`
+}
+
+// route is a non-tool endpoint. The same table registers the handlers and
+// documents them in openapi.yaml.
+type route struct {
+	op     openapi.Operation
+	handle func(s *Server, w http.ResponseWriter, r *http.Request)
+}
+
+var toolCallFilterParams = []openapi.Param{
+	{Name: "status", In: "query", Description: "Optional status filter.", Schema: map[string]any{"type": "string", "enum": []string{"ok", "fail", "cancelled"}}},
+	{Name: "tool_name", In: "query", Description: "Optional exact tool name filter."},
+	{Name: "created_after", In: "query", Description: "Optional inclusive lower bound for created_at (RFC3339).", Schema: map[string]any{"type": "string", "format": "date-time"}},
+	{Name: "created_before", In: "query", Description: "Optional inclusive upper bound for created_at (RFC3339).", Schema: map[string]any{"type": "string", "format": "date-time"}},
+}
+
+func errResponse(status int, description string) openapi.Response {
+	return openapi.Response{Status: status, Description: description, Body: ErrorResponse{}}
+}
+
+var routes = []route{
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/healthz", OperationID: "healthCheck", Summary: "Health check",
+			Responses: []openapi.Response{{Status: http.StatusOK, Description: "OK", Body: HealthStatus{}}}},
+		handle: (*Server).handleHealthz,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/metrics", OperationID: "getMetrics", Summary: "Prometheus metrics",
+			Responses: []openapi.Response{{Status: http.StatusOK, Description: "Metrics in Prometheus text format", ContentType: "text/plain", Body: ""}}},
+		handle: (*Server).handleMetrics,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/version", OperationID: "getVersion", Summary: "Build metadata",
+			Responses: []openapi.Response{{Status: http.StatusOK, Description: "Version info", Body: VersionInfo{}}}},
+		handle: (*Server).handleVersion,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/api/v1/runs/{runID}", OperationID: "getRun", Summary: "Get run",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Run details", Body: db.Run{}},
+				errResponse(http.StatusNotFound, "Run not found"),
+			}},
+		handle: (*Server).handleGetRun,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/approvals", OperationID: "createApproval", Summary: "Create manual approval request",
+			Request: CreateApprovalRequest{},
+			Responses: []openapi.Response{
+				{Status: http.StatusCreated, Description: "Approval request created", Body: db.Approval{}},
+				errResponse(http.StatusBadRequest, "Invalid request"),
+				{Status: http.StatusForbidden, Description: "Path policy violation", Body: core.ToolEnvelope{}},
+				errResponse(http.StatusNotFound, "Run not found"),
+			}},
+		handle: (*Server).handleCreateApproval,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/approvals", OperationID: "listApprovals", Summary: "List approvals for run",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Approval list", Body: []db.Approval{}},
+				errResponse(http.StatusNotFound, "Run not found"),
+			}},
+		handle: (*Server).handleListApprovals,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/approvals/{approvalID}", OperationID: "getApproval", Summary: "Get approval by run and id",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Approval details", Body: db.Approval{}},
+				errResponse(http.StatusNotFound, "Run or approval not found"),
+			}},
+		handle: (*Server).handleGetApproval,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/approvals/{approvalID}/approve", OperationID: "approveApproval", Summary: "Approve pending approval request",
+			Request: ResolveApprovalRequest{},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Approval updated", Body: db.Approval{}},
+				errResponse(http.StatusBadRequest, "Invalid request"),
+				errResponse(http.StatusNotFound, "Run or approval not found"),
+			}},
+		handle: (*Server).handleApproveApproval,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/approvals/{approvalID}/reject", OperationID: "rejectApproval", Summary: "Reject pending approval request",
+			Request: ResolveApprovalRequest{},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Approval updated", Body: db.Approval{}},
+				errResponse(http.StatusBadRequest, "Invalid request"),
+				errResponse(http.StatusNotFound, "Run or approval not found"),
+			}},
+		handle: (*Server).handleRejectApproval,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/tool-calls", OperationID: "listToolCalls", Summary: "List tool calls for run",
+			Params: toolCallFilterParams,
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Tool calls for the run", Body: []db.ToolCall{}},
+				errResponse(http.StatusBadRequest, "Invalid filter"),
+				errResponse(http.StatusNotFound, "Run not found"),
+			}},
+		handle: (*Server).handleListToolCalls,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/artifacts", OperationID: "listArtifacts", Summary: "List artifacts for run",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Artifacts for the run", Body: []db.Artifact{}},
+				errResponse(http.StatusNotFound, "Run not found"),
+			}},
+		handle: (*Server).handleListArtifacts,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/artifacts/{artifactID}", OperationID: "getArtifact", Summary: "Get artifact metadata by run and id",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Artifact metadata", Body: db.Artifact{}},
+				errResponse(http.StatusNotFound, "Run or artifact not found"),
+			}},
+		handle: (*Server).handleGetArtifact,
+	},
+	{
+		op: openapi.Operation{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/artifacts/{artifactID}/content", OperationID: "getArtifactContent", Summary: "Stream artifact content by run and id",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Artifact content stream (Content-Type is the stored artifact content type)", ContentType: "application/octet-stream", Body: ""},
+				errResponse(http.StatusNotFound, "Run or artifact not found"),
+			}},
+		handle: (*Server).handleGetArtifactContent,
+	},
+}
+
+// apiInfo is the header of the generated openapi.yaml.
+var apiInfo = openapi.Info{
+	Title:       "ToolHub HTTP API",
+	Version:     "0.2.0",
+	Description: "HTTP contract for ToolHub Phase A.5 + Phase B start. Generated by toolhub/cmd/openapigen; do not edit by hand.",
+	Servers:     []string{"http://localhost:8080"},
+}
+
+// OpenAPIDocument renders openapi.yaml for the given tool specs.
+func OpenAPIDocument(specs []*core.ToolSpec) []byte {
+	return openapi.Generate(apiInfo, Operations(specs))
+}
+
+// Operations documents every HTTP endpoint: the fixed routes above plus
+// the routed tools of the registry.
+func Operations(specs []*core.ToolSpec) []openapi.Operation {
+	ops := make([]openapi.Operation, 0, len(routes)+len(specs))
+	for _, rt := range routes {
+		ops = append(ops, rt.op)
+	}
+	for _, spec := range specs {
+		if spec.Route != nil {
+			ops = append(ops, toolOperation(spec))
+		}
+	}
+	return ops
+}
+
+func toolOperation(spec *core.ToolSpec) openapi.Operation {
+	route := spec.Route
+	op := openapi.Operation{
+		Method:      route.Method,
+		Path:        route.Path,
+		OperationID: route.OperationID,
+		Summary:     spec.Description,
+	}
+	for _, p := range route.Params {
+		param := openapi.Param{Name: p.Name, In: "path", Required: true}
+		if p.Integer {
+			param.Schema = map[string]any{"type": "integer", "minimum": float64(1)}
+		}
+		op.Params = append(op.Params, param)
+		op.RequestOmit = append(op.RequestOmit, p.Arg)
+	}
+	if !spec.Policy.NoRun {
+		op.Params = append(op.Params, openapi.Param{
+			Name:        "Idempotency-Key",
+			In:          "header",
+			Description: "Optional idempotency key for explicit replay semantics.",
+		})
+	}
+	if spec.Progress {
+		op.Params = append(op.Params, openapi.Param{
+			Name:        "Accept",
+			In:          "header",
+			Description: "text/event-stream streams progress events while the tool runs, then a result event with the response status and body.",
+		})
+	}
+	if route.Method != http.MethodGet && spec.NewArgs != nil {
+		op.Request = spec.NewArgs()
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := openapi.Response{Status: status, Body: spec.Result}
+	if route.Raw {
+		success.Description = "Result of " + spec.Name
+	} else {
+		success.Description = "Tool response envelope of " + spec.Name
+		success.Envelope = true
+		success.Headers = []openapi.Param{{
+			Name:        "Idempotency-Replayed",
+			Description: "true when the response was served from an idempotent replay.",
+			Schema:      map[string]any{"type": "string"},
+		}}
+	}
+	op.Responses = []openapi.Response{success}
+	if spec.Async {
+		op.Responses = append(op.Responses, openapi.Response{
+			Status:      http.StatusAccepted,
+			Description: "Started as a background job (async=true); fetch the response from the job's result",
+			Body:        db.Job{},
+			Envelope:    true,
+		})
+	}
+	op.Responses = append(op.Responses,
+		errResponse(http.StatusBadRequest, "Invalid request"),
+		errResponse(http.StatusForbidden, "Tool, repository or path not allowed by policy"),
+		errResponse(http.StatusTooManyRequests, "Rate limit or daily quota exceeded"),
+	)
+	if !spec.Policy.NoRun {
+		op.Responses = append(op.Responses, errResponse(http.StatusNotFound, "Run or approval not found"))
+	}
+	if strings.HasPrefix(spec.Name, "github.") || strings.HasPrefix(spec.Name, "code.") {
+		op.Responses = append(op.Responses, errResponse(http.StatusBadGateway, "Upstream GitHub or git failure"))
+	}
+	return op
+}
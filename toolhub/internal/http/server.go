@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,30 +10,25 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/toolhub/toolhub/internal/codeops"
 	"github.com/toolhub/toolhub/internal/core"
 	"github.com/toolhub/toolhub/internal/db"
 	gh "github.com/toolhub/toolhub/internal/github"
-	"github.com/toolhub/toolhub/internal/qa"
 	"github.com/toolhub/toolhub/internal/telemetry"
 )
 
 type Server struct {
-	runs                *core.RunService
-	audit               *core.AuditService
-	policy              *core.Policy
-	gh                  *gh.Client
-	srv                 *http.Server
-	logger              *slog.Logger
-	mode                core.BatchMode
-	build               BuildInfo
-	qa                  *qa.Runner
-	code                *codeops.Runner
-	repairMaxIterations int
+	runs   *core.RunService
+	audit  *core.AuditService
+	policy *core.Policy
+	tools  *core.Registry
+	srv    *http.Server
+	logger *slog.Logger
+	build  BuildInfo
 }
 
 type BuildInfo struct {
@@ -50,6 +46,10 @@ type ctxKey string
 
 const ctxKeyRequestID ctxKey = "request_id"
 
+// principalHeader identifies the calling agent or service for per-principal
+// rate limits and quotas.
+const principalHeader = "X-ToolHub-Principal"
+
 // RequestIDFromContext extracts the request_id from context, or returns empty string.
 func RequestIDFromContext(ctx context.Context) string {
 	if v, ok := ctx.Value(ctxKeyRequestID).(string); ok {
@@ -58,48 +58,30 @@ func RequestIDFromContext(ctx context.Context) string {
 	return ""
 }
 
-func NewServer(addr string, runs *core.RunService, audit *core.AuditService, policy *core.Policy, ghClient *gh.Client, qaRunner *qa.Runner, codeRunner *codeops.Runner, logger *slog.Logger, mode core.BatchMode, repairMaxIterations int, build BuildInfo) *Server {
-	if repairMaxIterations <= 0 {
-		repairMaxIterations = 3
-	}
+func NewServer(addr string, tools *core.Registry, runs *core.RunService, audit *core.AuditService, policy *core.Policy, logger *slog.Logger, build BuildInfo) *Server {
 	s := &Server{
-		runs:                runs,
-		audit:               audit,
-		policy:              policy,
-		gh:                  ghClient,
-		qa:                  qaRunner,
-		code:                codeRunner,
-		logger:              logger,
-		mode:                mode,
-		build:               build,
-		repairMaxIterations: repairMaxIterations,
+		runs:   runs,
+		audit:  audit,
+		policy: policy,
+		tools:  tools,
+		logger: logger,
+		build:  build,
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /healthz", s.handleHealthz)
-	mux.HandleFunc("GET /metrics", s.handleMetrics)
-	mux.HandleFunc("GET /version", s.handleVersion)
-	mux.HandleFunc("POST /api/v1/runs", s.handleCreateRun)
-	mux.HandleFunc("GET /api/v1/runs/{runID}", s.handleGetRun)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/approvals", s.handleCreateApproval)
-	mux.HandleFunc("GET /api/v1/runs/{runID}/approvals", s.handleListApprovals)
-	mux.HandleFunc("GET /api/v1/runs/{runID}/approvals/{approvalID}", s.handleGetApproval)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/approvals/{approvalID}/approve", s.handleApproveApproval)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/approvals/{approvalID}/reject", s.handleRejectApproval)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/code/patch", s.handleGeneratePatch)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/code/branch-pr", s.handleCodeBranchPR)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/code/repair-loop", s.handleCodeRepairLoop)
-	mux.HandleFunc("GET /api/v1/runs/{runID}/tool-calls", s.handleListToolCalls)
-	mux.HandleFunc("GET /api/v1/runs/{runID}/artifacts", s.handleListArtifacts)
-	mux.HandleFunc("GET /api/v1/runs/{runID}/artifacts/{artifactID}", s.handleGetArtifact)
-	mux.HandleFunc("GET /api/v1/runs/{runID}/artifacts/{artifactID}/content", s.handleGetArtifactContent)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/issues", s.handleCreateIssue)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/issues/batch", s.handleBatchCreateIssues)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/qa/test", s.handleQATest)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/qa/lint", s.handleQALint)
-	mux.HandleFunc("GET /api/v1/runs/{runID}/prs/{prNumber}", s.handleGetPR)
-	mux.HandleFunc("GET /api/v1/runs/{runID}/prs/{prNumber}/files", s.handleListPRFiles)
-	mux.HandleFunc("POST /api/v1/runs/{runID}/prs/{prNumber}/comment", s.handleCreatePRComment)
+	for _, rt := range routes {
+		handle := rt.handle
+		mux.HandleFunc(rt.op.Method+" "+rt.op.Path, func(w http.ResponseWriter, r *http.Request) {
+			handle(s, w, r)
+		})
+	}
+	if tools != nil {
+		for _, spec := range tools.Specs() {
+			if spec.Route != nil {
+				mux.Handle(spec.Route.Method+" "+spec.Route.Path, s.toolHandler(spec))
+			}
+		}
+	}
 
 	s.srv = &http.Server{
 		Addr:         addr,
@@ -125,7 +107,7 @@ func (s *Server) Shutdown(ctx context.Context) error {
 }
 
 func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, HealthStatus{Status: "ok"})
 }
 
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
@@ -135,53 +117,24 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{
-		"version":          s.build.Version,
-		"git_commit":       s.build.GitCommit,
-		"build_time":       s.build.BuildTime,
-		"contract_version": s.build.ContractVersion,
+	writeJSON(w, http.StatusOK, VersionInfo{
+		Version:         s.build.Version,
+		GitCommit:       s.build.GitCommit,
+		BuildTime:       s.build.BuildTime,
+		ContractVersion: s.build.ContractVersion,
 	})
 }
 
-type createRunBody struct {
-	Repo    string `json:"repo"`
-	Purpose string `json:"purpose"`
-}
-
-type createApprovalBody struct {
+type CreateApprovalRequest struct {
 	Scope   string   `json:"scope"`
 	Paths   []string `json:"paths,omitempty"`
 	Payload any      `json:"payload,omitempty"`
 }
 
-type resolveApprovalBody struct {
+type ResolveApprovalRequest struct {
 	Approver string `json:"approver"`
 }
 
-func (s *Server) handleCreateRun(w http.ResponseWriter, r *http.Request) {
-	var body createRunBody
-	if err := decodeJSONBody(w, r, &body); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
-		return
-	}
-
-	if err := s.policy.CheckRepo(body.Repo); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-
-	run, err := s.runs.CreateRun(r.Context(), core.CreateRunRequest{
-		Repo:    body.Repo,
-		Purpose: body.Purpose,
-	})
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	writeJSON(w, http.StatusCreated, run)
-}
-
 func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
 	runID := r.PathValue("runID")
 	run, err := s.runs.GetRun(r.Context(), runID)
@@ -208,7 +161,7 @@ func (s *Server) handleCreateApproval(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var body createApprovalBody
+	var body CreateApprovalRequest
 	if err := decodeJSONBody(w, r, &body); err != nil {
 		writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
 		return
@@ -316,7 +269,7 @@ func (s *Server) handleResolveApproval(w http.ResponseWriter, r *http.Request, s
 		return
 	}
 
-	var body resolveApprovalBody
+	var body ResolveApprovalRequest
 	if err := decodeJSONBody(w, r, &body); err != nil {
 		writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
 		return
@@ -367,8 +320,8 @@ func parseToolCallListFilters(r *http.Request) (db.ToolCallListFilter, error) {
 		ToolName: strings.TrimSpace(q.Get("tool_name")),
 	}
 
-	if filter.Status != "" && filter.Status != "ok" && filter.Status != "fail" {
-		return db.ToolCallListFilter{}, fmt.Errorf("status must be one of: ok, fail")
+	if filter.Status != "" && filter.Status != "ok" && filter.Status != "fail" && filter.Status != core.ToolCallCancelled {
+		return db.ToolCallListFilter{}, fmt.Errorf("status must be one of: ok, fail, cancelled")
 	}
 
 	if rawAfter := strings.TrimSpace(q.Get("created_after")); rawAfter != "" {
@@ -481,1333 +434,186 @@ func (s *Server) handleGetArtifactContent(w http.ResponseWriter, r *http.Request
 	}
 }
 
-type createIssueBody struct {
-	Title  string   `json:"title"`
-	Body   string   `json:"body"`
-	Labels []string `json:"labels,omitempty"`
-	DryRun bool     `json:"dry_run,omitempty"`
-}
-
-type dryRunIssuePreview struct {
-	Repo   string   `json:"repo"`
-	Title  string   `json:"title"`
-	Body   string   `json:"body"`
-	Labels []string `json:"labels,omitempty"`
-}
-
-type prCommentBody struct {
-	Body   string `json:"body"`
-	DryRun bool   `json:"dry_run,omitempty"`
-}
-
-type qaBody struct {
-	DryRun bool `json:"dry_run,omitempty"`
-}
-
-type codePatchBody struct {
-	Path            string `json:"path"`
-	OriginalContent string `json:"original_content"`
-	ModifiedContent string `json:"modified_content"`
-	DryRun          bool   `json:"dry_run,omitempty"`
-}
-
-type codeBranchPRBody struct {
-	ApprovalID    string               `json:"approval_id"`
-	BaseBranch    string               `json:"base_branch"`
-	HeadBranch    string               `json:"head_branch"`
-	CommitMessage string               `json:"commit_message"`
-	PRTitle       string               `json:"pr_title"`
-	PRBody        string               `json:"pr_body,omitempty"`
-	Files         []codeops.FileChange `json:"files"`
-	DryRun        bool                 `json:"dry_run,omitempty"`
-}
-
-type codeRepairLoopBody struct {
-	ApprovalID    string               `json:"approval_id"`
-	BaseBranch    string               `json:"base_branch"`
-	HeadBranch    string               `json:"head_branch"`
-	CommitMessage string               `json:"commit_message"`
-	PRTitle       string               `json:"pr_title"`
-	PRBody        string               `json:"pr_body,omitempty"`
-	Files         []codeops.FileChange `json:"files"`
-	MaxIterations int                  `json:"max_iterations,omitempty"`
-	DryRun        bool                 `json:"dry_run,omitempty"`
-}
-
-func (s *Server) handleGeneratePatch(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration("code.patch.generate", time.Since(start)) }()
-
-	runID := r.PathValue("runID")
-	run, err := s.runs.GetRun(r.Context(), runID)
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if run == nil {
-		writeErr(w, http.StatusNotFound, "run not found")
-		return
-	}
-	if err := s.policy.CheckTool("code.patch.generate"); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-
-	var body codePatchBody
-	if err := decodeJSONBody(w, r, &body); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
-		return
-	}
-	if strings.TrimSpace(body.Path) == "" {
-		writeErr(w, http.StatusBadRequest, "path is required")
-		return
-	}
-	if err := s.policy.CheckPaths([]string{body.Path}); err != nil {
-		if writePathPolicyViolation(w, err) {
+// toolHandler serves a registry tool over HTTP. Path wildcards are merged
+// into the JSON body so both transports hand the registry the same arguments.
+func (s *Server) toolHandler(spec *core.ToolSpec) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := map[string]json.RawMessage{}
+		if r.Method != http.MethodGet {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
+				return
+			}
+			if len(bytes.TrimSpace(body)) > 0 {
+				if err := json.Unmarshal(body, &args); err != nil {
+					writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
+					return
+				}
+			}
+		}
+		for _, p := range spec.Route.Params {
+			raw := r.PathValue(p.Name)
+			if p.Integer {
+				n, err := strconv.Atoi(raw)
+				if err != nil || n <= 0 {
+					writeErr(w, http.StatusBadRequest, "invalid "+p.Name)
+					return
+				}
+				args[p.Arg], _ = json.Marshal(n)
+				continue
+			}
+			args[p.Arg], _ = json.Marshal(raw)
+		}
+		raw, err := json.Marshal(args)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-
-	patchText := core.GenerateUnifiedDiff(body.Path, body.OriginalContent, body.ModifiedContent)
-	lineDelta := core.CountContentLines(body.ModifiedContent) - core.CountContentLines(body.OriginalContent)
-
-	response := map[string]any{
-		"path":       body.Path,
-		"patch":      patchText,
-		"line_delta": lineDelta,
-	}
 
-	tc, extraIDs, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-		RunID:    runID,
-		ToolName: "code.patch.generate",
-		Request:  body,
-		Response: response,
-		Err:      nil,
-		ExtraArtifacts: []core.ExtraArtifact{
-			{Name: "code.patch.generate.patch.diff", ContentType: "text/x-diff", Body: []byte(patchText)},
-		},
+		ctx := r.Context()
+		var stream *eventStream
+		if spec.Progress && wantsEventStream(r) {
+			stream = newEventStream(w)
+			ctx = core.WithProgress(ctx, stream.progress)
+		}
+		resp, err := s.tools.Invoke(ctx, spec.Name, raw, core.InvokeOptions{
+			IdempotencyKey: r.Header.Get("Idempotency-Key"),
+			Logger:         s.logger.With("request_id", RequestIDFromContext(r.Context())),
+		})
+		respond := func(w http.ResponseWriter) {
+			if err != nil {
+				writeToolErr(w, err)
+				return
+			}
+			if resp.Replayed {
+				w.Header().Set("Idempotency-Replayed", "true")
+			}
+			writeJSON(w, resp.Status, resp.Body)
+		}
+		if stream != nil && stream.finish(respond) {
+			return
+		}
+		respond(w)
 	})
-	if auditErr != nil {
-		writeErr(w, http.StatusInternalServerError, "audit record failed: "+auditErr.Error())
-		return
-	}
+}
 
-	result := map[string]any{
-		"path":       body.Path,
-		"patch":      patchText,
-		"line_delta": lineDelta,
-	}
-	if len(extraIDs) > 0 {
-		result["patch_artifact_id"] = extraIDs[0]
+func writeToolErr(w http.ResponseWriter, err error) {
+	var schemaErr *core.SchemaError
+	var reqErr *core.ToolRequestError
+	var execErr *core.ToolExecError
+	switch {
+	case errors.As(err, &schemaErr):
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Code:    schemaErr.ErrorCode(),
+			Message: schemaErr.Error(),
+			Errors:  schemaErr.Fields,
+		})
+	case errors.As(err, &reqErr):
+		writeErr(w, reqErr.Status, reqErr.Message)
+	case errors.As(err, &execErr):
+		writeMappedErr(w, execErr.Err, execErr.FallbackStatus)
+	default:
+		writeMappedErr(w, err, http.StatusInternalServerError)
 	}
-
-	writeJSON(w, http.StatusOK, core.ToolEnvelope{
-		OK: true,
-		Meta: core.ToolMeta{
-			RunID:        runID,
-			ToolCallID:   tc.ToolCallID,
-			EvidenceHash: tc.EvidenceHash,
-			DryRun:       body.DryRun,
-		},
-		Result: result,
-	})
 }
 
-func (s *Server) handleCodeBranchPR(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration("code.branch_pr.create", time.Since(start)) }()
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
 
-	runID := r.PathValue("runID")
-	run, err := s.runs.GetRun(r.Context(), runID)
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if run == nil {
-		writeErr(w, http.StatusNotFound, "run not found")
-		return
-	}
-	if err := s.policy.CheckTool("code.branch_pr.create"); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-	if s.code == nil {
-		writeErr(w, http.StatusInternalServerError, "code runner is not configured")
-		return
+func writeErr(w http.ResponseWriter, status int, msg string) {
+	code := "internal_error"
+	switch {
+	case status == http.StatusBadRequest:
+		code = "invalid_request_schema"
+	case status == http.StatusForbidden:
+		code = "forbidden"
+	case status == http.StatusNotFound:
+		code = "not_found"
+	case status >= 500 && status < 600:
+		code = "upstream_error"
 	}
+	writeJSON(w, status, ErrorResponse{Code: code, Message: msg})
+}
 
-	var body codeBranchPRBody
-	if err := decodeJSONBody(w, r, &body); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
-		return
-	}
-	if strings.TrimSpace(body.ApprovalID) == "" {
-		writeErr(w, http.StatusBadRequest, "approval_id is required")
-		return
-	}
-	approval, err := s.audit.GetApproval(r.Context(), body.ApprovalID)
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if approval == nil || approval.RunID != runID {
-		writeErr(w, http.StatusNotFound, "approval not found")
-		return
-	}
-	if approval.Status != "approved" {
-		writeErr(w, http.StatusForbidden, "approval is not approved")
-		return
-	}
-	if approval.Scope != "code_write" {
-		writeErr(w, http.StatusForbidden, "approval scope must be code_write")
+func writeMappedErr(w http.ResponseWriter, err error, fallbackStatus int) {
+	var apiErr *gh.APIError
+	if errors.As(err, &apiErr) {
+		mapped := core.MapError(apiErr, fallbackStatus)
+		writeJSON(w, mapped.HTTPStatus, ErrorResponse{Code: mapped.Code, Message: mapped.Message})
 		return
 	}
-
-	paths := make([]string, 0, len(body.Files))
-	for _, f := range body.Files {
-		paths = append(paths, f.Path)
-	}
-	if err := s.policy.CheckPaths(paths); err != nil {
-		if writePathPolicyViolation(w, err) {
-			return
-		}
-		writeErr(w, http.StatusForbidden, err.Error())
+	mapped := core.MapError(err, fallbackStatus)
+	if mapped.RetryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(mapped.RetryAfterSeconds))
+		writeJSON(w, mapped.HTTPStatus, ErrorResponse{Code: mapped.Code, Message: mapped.Message, RetryAfterSeconds: mapped.RetryAfterSeconds})
 		return
 	}
+	writeJSON(w, mapped.HTTPStatus, ErrorResponse{Code: mapped.Code, Message: mapped.Message})
+}
 
-	patches := make([]string, 0, len(body.Files))
-	for _, f := range body.Files {
-		patches = append(patches, core.GenerateUnifiedDiff(f.Path, f.OriginalContent, f.ModifiedContent))
+func writePathPolicyViolation(w http.ResponseWriter, err error) bool {
+	pv, ok := err.(*core.PolicyViolation)
+	if !ok {
+		return false
 	}
-	combinedPatch := strings.Join(patches, "\n")
-
-	codeResult, runErr := s.code.Execute(r.Context(), codeops.Request{
-		BaseBranch:    body.BaseBranch,
-		HeadBranch:    body.HeadBranch,
-		CommitMessage: body.CommitMessage,
-		Files:         body.Files,
-		DryRun:        body.DryRun,
+	writeJSON(w, http.StatusForbidden, core.ToolEnvelope{
+		OK:    false,
+		Error: &core.ToolError{Code: string(pv.Code), Message: pv.Error()},
 	})
-	if codeResult == nil {
-		codeResult = &codeops.Result{}
-	}
-	if runErr != nil {
-		telemetry.IncRepairCompleted("code_error")
-	}
-	if runErr != nil {
-		telemetry.IncRepairCompleted("code_error")
-	}
+	return true
+}
 
-	result := map[string]any{
-		"base_branch":      body.BaseBranch,
-		"head_branch":      body.HeadBranch,
-		"planned_commands": codeResult.PlannedCommands,
-		"commit_hash":      codeResult.CommitHash,
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return err
 	}
-
-	if runErr == nil && !body.DryRun {
-		owner, repo := splitRepo(run.Repo)
-		pr, prErr := s.gh.CreatePullRequest(r.Context(), owner, repo, gh.CreatePullRequestInput{
-			Title: body.PRTitle,
-			Head:  body.HeadBranch,
-			Base:  body.BaseBranch,
-			Body:  body.PRBody,
-		})
-		if prErr != nil {
-			runErr = prErr
-		} else {
-			result["pull_request"] = pr
-		}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return fmt.Errorf("request body must contain a single JSON object")
 	}
+	return nil
+}
 
-	tc, extraIDs, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-		RunID:    runID,
-		ToolName: "code.branch_pr.create",
-		Request:  body,
-		Response: result,
-		Err:      runErr,
-		ExtraArtifacts: []core.ExtraArtifact{
-			{Name: "code.branch_pr.create.patch.diff", ContentType: "text/x-diff", Body: []byte(combinedPatch)},
-		},
-	})
-	if auditErr != nil {
-		writeErr(w, http.StatusInternalServerError, "audit record failed: "+auditErr.Error())
-		return
-	}
-	if len(extraIDs) > 0 {
-		result["patch_artifact_id"] = extraIDs[0]
-	}
+func withLogging(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := uuid.New().String()
+		ctx := context.WithValue(r.Context(), ctxKeyRequestID, requestID)
+		ctx = core.WithPrincipal(ctx, r.Header.Get(principalHeader))
+		r = r.WithContext(ctx)
 
-	if runErr != nil {
-		writeMappedErr(w, runErr, http.StatusBadGateway)
-		return
-	}
+		w.Header().Set("X-Request-ID", requestID)
 
-	writeJSON(w, http.StatusOK, core.ToolEnvelope{
-		OK:     true,
-		Meta:   core.ToolMeta{RunID: runID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: body.DryRun},
-		Result: result,
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: 200}
+		next.ServeHTTP(sw, r)
+		logger.Info("http request",
+			"request_id", requestID,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration", fmt.Sprintf("%dms", time.Since(start).Milliseconds()),
+		)
 	})
 }
 
-func (s *Server) handleCodeRepairLoop(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration("code.repair_loop", time.Since(start)) }()
-
-	runID := r.PathValue("runID")
-	run, err := s.runs.GetRun(r.Context(), runID)
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if run == nil {
-		writeErr(w, http.StatusNotFound, "run not found")
-		return
-	}
-	if err := s.policy.CheckTool("code.repair_loop"); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-	if s.code == nil {
-		writeErr(w, http.StatusInternalServerError, "code runner is not configured")
-		return
-	}
-	if s.qa == nil {
-		writeErr(w, http.StatusInternalServerError, "qa runner is not configured")
-		return
-	}
-
-	var body codeRepairLoopBody
-	if err := decodeJSONBody(w, r, &body); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
-		return
-	}
-	if body.MaxIterations <= 0 {
-		body.MaxIterations = s.repairMaxIterations
-	}
-	if body.MaxIterations > s.repairMaxIterations {
-		writeErr(w, http.StatusBadRequest, fmt.Sprintf("max_iterations cannot exceed %d", s.repairMaxIterations))
-		return
-	}
-	if strings.TrimSpace(body.ApprovalID) == "" {
-		writeErr(w, http.StatusBadRequest, "approval_id is required")
-		return
-	}
-
-	approval, err := s.audit.GetApproval(r.Context(), body.ApprovalID)
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if approval == nil || approval.RunID != runID {
-		writeErr(w, http.StatusNotFound, "approval not found")
-		return
-	}
-	if approval.Status != "approved" {
-		writeErr(w, http.StatusForbidden, "approval is not approved")
-		return
-	}
-	if approval.Scope != "code_write" {
-		writeErr(w, http.StatusForbidden, "approval scope must be code_write")
-		return
-	}
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
 
-	paths := make([]string, 0, len(body.Files))
-	for _, f := range body.Files {
-		paths = append(paths, f.Path)
-	}
-	if err := s.policy.CheckPaths(paths); err != nil {
-		if writePathPolicyViolation(w, err) {
-			return
-		}
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
 
-	step, err := s.audit.StartStep(r.Context(), runID, "code_repair_loop", "repair_loop")
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if err := s.audit.RecordDecision(r.Context(), runID, &step.StepID, "system", "repair_loop_started", map[string]any{"max_iterations": body.MaxIterations}); err != nil {
-		s.logger.Error("audit record decision failed", "err", err, "run_id", runID, "decision_type", "repair_loop_started")
-	}
-
-	codeResult, runErr := s.code.Execute(r.Context(), codeops.Request{
-		BaseBranch:    body.BaseBranch,
-		HeadBranch:    body.HeadBranch,
-		CommitMessage: body.CommitMessage,
-		Files:         body.Files,
-		DryRun:        body.DryRun,
-	})
-	if codeResult == nil {
-		codeResult = &codeops.Result{}
-	}
-
-	iterationsRun := 0
-	qaPassed := false
-	qaAttempts := make([]map[string]any, 0, body.MaxIterations)
-	var lastTestErr error
-	var lastLintErr error
-	var lastTestReport qa.Report
-	var lastLintReport qa.Report
-
-	result := map[string]any{
-		"iterations_requested": body.MaxIterations,
-		"iterations_run":       iterationsRun,
-		"base_branch":          body.BaseBranch,
-		"head_branch":          body.HeadBranch,
-		"planned_commands":     codeResult.PlannedCommands,
-		"commit_hash":          codeResult.CommitHash,
-		"qa_passed":            qaPassed,
-		"status":               "completed",
-	}
-
-	if runErr == nil && !body.DryRun {
-		for i := 1; i <= body.MaxIterations; i++ {
-			iterationsRun = i
-
-			testReport, testErr := s.qa.Run(r.Context(), qa.KindTest, false)
-			lintReport, lintErr := s.qa.Run(r.Context(), qa.KindLint, false)
-			testStatus := qa.DeriveStatus(testReport, testErr, false)
-			lintStatus := qa.DeriveStatus(lintReport, lintErr, false)
-
-			telemetry.IncRepairQAResult("test", core.MapQAStatusToMetric(testStatus))
-			telemetry.IncRepairQAResult("lint", core.MapQAStatusToMetric(lintStatus))
-
-			lastTestErr = testErr
-			lastLintErr = lintErr
-			lastTestReport = testReport
-			lastLintReport = lintReport
-
-			attempt := map[string]any{
-				"iteration":   i,
-				"test_status": string(testStatus),
-				"lint_status": string(lintStatus),
-				"test_report": testReport,
-				"lint_report": lintReport,
-			}
-			if testErr != nil {
-				attempt["test_error"] = testErr.Error()
-			}
-			if lintErr != nil {
-				attempt["lint_error"] = lintErr.Error()
-			}
-			qaAttempts = append(qaAttempts, attempt)
-			if err := s.audit.RecordDecision(r.Context(), runID, &step.StepID, "system", "repair_loop_iteration", attempt); err != nil {
-				s.logger.Error("audit record decision failed", "err", err, "run_id", runID, "decision_type", "repair_loop_iteration")
-			}
-
-			if testErr == nil && lintErr == nil {
-				telemetry.IncRepairIteration("pass")
-				qaPassed = true
-				break
-			}
-			telemetry.IncRepairIteration("fail")
-		}
-
-		if !qaPassed {
-			result["status"] = "failed"
-			result["qa_failure_reason"] = fmt.Sprintf("qa checks failed after %d iteration(s)", iterationsRun)
-			category := core.DeriveQAFailureCategory(lastTestErr, lastLintErr, &lastTestReport, &lastLintReport)
-			result["qa_failure_category"] = category
-
-			rollback, rollbackErr := s.code.RollbackBranch(r.Context(), body.BaseBranch, body.HeadBranch, false)
-			if rollback != nil {
-				result["rollback_planned_commands"] = rollback.PlannedCommands
-			}
-			if rollbackErr != nil {
-				result["rollback_error"] = rollbackErr.Error()
-				telemetry.IncRepairRollback("failure")
-				telemetry.IncRepairCompleted("rollback_error")
-			} else {
-				telemetry.IncRepairRollback("success")
-				telemetry.IncRepairCompleted("qa_failed")
-			}
-			runErr = fmt.Errorf("qa checks failed")
-		}
-	}
-
-	result["iterations_run"] = iterationsRun
-	result["qa_passed"] = qaPassed
-	if len(qaAttempts) > 0 {
-		result["qa_attempts"] = qaAttempts
-	}
-
-	if runErr == nil && !body.DryRun && qaPassed {
-		owner, repo := splitRepo(run.Repo)
-		pr, prErr := s.gh.CreatePullRequest(r.Context(), owner, repo, gh.CreatePullRequestInput{
-			Title: body.PRTitle,
-			Head:  body.HeadBranch,
-			Base:  body.BaseBranch,
-			Body:  body.PRBody,
-		})
-		if prErr != nil {
-			runErr = prErr
-		} else {
-			result["pull_request"] = pr
-			telemetry.IncRepairCompleted("success")
-		}
-	}
-
-	if runErr != nil {
-		result["status"] = "failed"
-	} else if body.DryRun {
-		result["status"] = "dry_run"
-	}
-
-	tc, _, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-		RunID:    runID,
-		ToolName: "code.repair_loop",
-		Request:  body,
-		Response: result,
-		Err:      runErr,
-	})
-	if auditErr != nil {
-		writeErr(w, http.StatusInternalServerError, "audit record failed: "+auditErr.Error())
-		return
-	}
-
-	decisionType := "repair_loop_completed"
-	stepStatus := "completed"
-	if runErr != nil {
-		decisionType = "repair_loop_failed"
-		stepStatus = "failed"
-	}
-	if err := s.audit.RecordDecision(r.Context(), runID, &step.StepID, "system", decisionType, result); err != nil {
-		s.logger.Error("audit record decision failed", "err", err, "run_id", runID, "decision_type", decisionType)
-	}
-	if err := s.audit.FinishStep(r.Context(), step.StepID, stepStatus); err != nil {
-		s.logger.Error("audit finish step failed", "err", err, "run_id", runID, "step_id", step.StepID)
-	}
-
-	if runErr != nil {
-		writeMappedErr(w, runErr, http.StatusBadGateway)
-		return
-	}
-
-	writeJSON(w, http.StatusOK, core.ToolEnvelope{
-		OK:     true,
-		Meta:   core.ToolMeta{RunID: runID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: body.DryRun},
-		Result: result,
-	})
-}
-
-func (s *Server) handleQATest(w http.ResponseWriter, r *http.Request) {
-	s.handleQA(w, r, qa.KindTest)
-}
-
-func (s *Server) handleQALint(w http.ResponseWriter, r *http.Request) {
-	s.handleQA(w, r, qa.KindLint)
-}
-
-func (s *Server) handleQA(w http.ResponseWriter, r *http.Request, kind qa.Kind) {
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration(string(kind), time.Since(start)) }()
-
-	runID := r.PathValue("runID")
-	run, err := s.runs.GetRun(r.Context(), runID)
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if run == nil {
-		writeErr(w, http.StatusNotFound, "run not found")
-		return
-	}
-	if err := s.policy.CheckTool(string(kind)); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-
-	var body qaBody
-	if err := decodeJSONBody(w, r, &body); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
-		return
-	}
-
-	report, runErr := s.qa.Run(r.Context(), kind, body.DryRun)
-	if runErr != nil && report.Command == "" {
-		_, _, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-			RunID:    runID,
-			ToolName: string(kind),
-			Request:  body,
-			Response: nil,
-			Err:      runErr,
-		})
-		if auditErr != nil {
-			s.logger.Error("audit record failed",
-				"request_id", RequestIDFromContext(r.Context()),
-				"err", auditErr,
-			)
-		}
-		s.logger.Error("tool call failed",
-			"request_id", RequestIDFromContext(r.Context()),
-			"run_id", runID,
-			"tool_name", string(kind),
-			"err", runErr,
-		)
-		writeMappedErr(w, runErr, http.StatusBadRequest)
-		return
-	}
-
-	reportJSON, reportJSONErr := json.Marshal(report)
-	if reportJSONErr != nil {
-		writeErr(w, http.StatusInternalServerError, "marshal qa report failed: "+reportJSONErr.Error())
-		return
-	}
-
-	tc, extraArtifactIDs, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-		RunID:    runID,
-		ToolName: string(kind),
-		Request:  body,
-		Response: map[string]any{"report": report},
-		Err:      runErr,
-		ExtraArtifacts: []core.ExtraArtifact{
-			{Name: fmt.Sprintf("%s.stdout.txt", kind), ContentType: "text/plain", Body: []byte(report.Stdout)},
-			{Name: fmt.Sprintf("%s.stderr.txt", kind), ContentType: "text/plain", Body: []byte(report.Stderr)},
-			{Name: fmt.Sprintf("%s.report.json", kind), ContentType: "application/json", Body: reportJSON},
-		},
-	})
-	if auditErr != nil {
-		writeErr(w, http.StatusInternalServerError, "audit record failed: "+auditErr.Error())
-		return
-	}
-
-	qaArtifacts := &core.QAArtifacts{}
-	if len(extraArtifactIDs) > 0 {
-		qaArtifacts.StdoutArtifactID = extraArtifactIDs[0]
-	}
-	if len(extraArtifactIDs) > 1 {
-		qaArtifacts.StderrArtifactID = extraArtifactIDs[1]
-	}
-	if len(extraArtifactIDs) > 2 {
-		qaArtifacts.ReportArtifactID = extraArtifactIDs[2]
-	}
-
-	status := qa.DeriveStatus(report, runErr, body.DryRun)
-	if runErr != nil {
-		s.logger.Error("tool call failed",
-			"request_id", RequestIDFromContext(r.Context()),
-			"run_id", runID,
-			"tool_call_id", tc.ToolCallID,
-			"tool_name", string(kind),
-			"repo", run.Repo,
-			"err", runErr,
-		)
-	} else {
-		s.logger.Info("tool call completed",
-			"request_id", RequestIDFromContext(r.Context()),
-			"run_id", runID,
-			"tool_call_id", tc.ToolCallID,
-			"tool_name", string(kind),
-			"repo", run.Repo,
-			"dry_run", body.DryRun,
-		)
-	}
-
-	writeJSON(w, http.StatusOK, core.ToolEnvelope{
-		OK: runErr == nil,
-		Meta: core.ToolMeta{
-			RunID:        runID,
-			ToolCallID:   tc.ToolCallID,
-			EvidenceHash: tc.EvidenceHash,
-			DryRun:       body.DryRun,
-			QAArtifacts:  qaArtifacts,
-		},
-		Result: map[string]any{"status": string(status), "report": report, "summary": qa.GenerateSummary(status, report)},
-		Error: func() *core.ToolError {
-			if runErr == nil {
-				return nil
-			}
-			return &core.ToolError{Code: string(status), Message: runErr.Error()}
-		}(),
-	})
-}
-
-func (s *Server) handleGetPR(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration("github.pr.get", time.Since(start)) }()
-
-	runID := r.PathValue("runID")
-	prNumberRaw := r.PathValue("prNumber")
-	prNumber := 0
-	if _, err := fmt.Sscanf(prNumberRaw, "%d", &prNumber); err != nil || prNumber <= 0 {
-		writeErr(w, http.StatusBadRequest, "invalid prNumber")
-		return
-	}
-
-	run, err := s.runs.GetRun(r.Context(), runID)
-	if err != nil {
-		writeMappedErr(w, err, http.StatusInternalServerError)
-		return
-	}
-	if err := s.policy.CheckTool("github.pr.get"); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-	if run == nil {
-		writeErr(w, http.StatusNotFound, "run not found")
-		return
-	}
-
-	owner, repo := splitRepo(run.Repo)
-	pr, ghErr := s.gh.GetPullRequest(r.Context(), owner, repo, prNumber)
-
-	tc, _, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-		RunID:    runID,
-		ToolName: "github.pr.get",
-		Request:  map[string]any{"pr_number": prNumber},
-		Response: pr,
-		Err:      ghErr,
-	})
-	if auditErr != nil {
-		writeErr(w, http.StatusInternalServerError, "audit record failed: "+auditErr.Error())
-		return
-	}
-
-	s.logger.Info("tool call completed",
-		"request_id", RequestIDFromContext(r.Context()),
-		"run_id", runID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", "github.pr.get",
-		"repo", run.Repo,
-		"pr_number", prNumber,
-	)
-
-	if ghErr != nil {
-		writeMappedErr(w, ghErr, http.StatusBadGateway)
-		return
-	}
-
-	writeJSON(w, http.StatusOK, core.ToolEnvelope{
-		OK: true,
-		Meta: core.ToolMeta{
-			RunID:        runID,
-			ToolCallID:   tc.ToolCallID,
-			EvidenceHash: tc.EvidenceHash,
-			DryRun:       false,
-		},
-		Result: pr,
-	})
-}
-
-func (s *Server) handleListPRFiles(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration("github.pr.files.list", time.Since(start)) }()
-
-	runID := r.PathValue("runID")
-	prNumberRaw := r.PathValue("prNumber")
-	prNumber := 0
-	if _, err := fmt.Sscanf(prNumberRaw, "%d", &prNumber); err != nil || prNumber <= 0 {
-		writeErr(w, http.StatusBadRequest, "invalid prNumber")
-		return
-	}
-
-	run, err := s.runs.GetRun(r.Context(), runID)
-	if err != nil {
-		writeMappedErr(w, err, http.StatusInternalServerError)
-		return
-	}
-	if run == nil {
-		writeErr(w, http.StatusNotFound, "run not found")
-		return
-	}
-	if err := s.policy.CheckTool("github.pr.files.list"); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-
-	owner, repo := splitRepo(run.Repo)
-	files, ghErr := s.gh.ListPullRequestFiles(r.Context(), owner, repo, prNumber)
-
-	tc, _, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-		RunID:    runID,
-		ToolName: "github.pr.files.list",
-		Request:  map[string]any{"pr_number": prNumber},
-		Response: map[string]any{"files": files, "count": len(files)},
-		Err:      ghErr,
-	})
-	if auditErr != nil {
-		writeErr(w, http.StatusInternalServerError, "audit record failed: "+auditErr.Error())
-		return
-	}
-
-	s.logger.Info("tool call completed",
-		"request_id", RequestIDFromContext(r.Context()),
-		"run_id", runID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", "github.pr.files.list",
-		"repo", run.Repo,
-		"pr_number", prNumber,
-	)
-
-	if ghErr != nil {
-		writeMappedErr(w, ghErr, http.StatusBadGateway)
-		return
-	}
-
-	writeJSON(w, http.StatusOK, core.ToolEnvelope{
-		OK: true,
-		Meta: core.ToolMeta{
-			RunID:        runID,
-			ToolCallID:   tc.ToolCallID,
-			EvidenceHash: tc.EvidenceHash,
-			DryRun:       false,
-		},
-		Result: map[string]any{"files": files, "count": len(files), "summary": gh.SummarizePRFiles(files)},
-	})
-}
-
-func (s *Server) handleCreateIssue(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration("github.issues.create", time.Since(start)) }()
-
-	runID := r.PathValue("runID")
-
-	run, err := s.runs.GetRun(r.Context(), runID)
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if run == nil {
-		writeErr(w, http.StatusNotFound, "run not found")
-		return
-	}
-
-	if err := s.policy.CheckTool("github.issues.create"); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-
-	var body createIssueBody
-	if err := decodeJSONBody(w, r, &body); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
-		return
-	}
-	if err := core.ValidateIssueInput(body.Title, body.Body, body.Labels); err != nil {
-		writeErr(w, http.StatusBadRequest, err.Error())
-		return
-	}
-
-	toolName := "github.issues.create"
-	headerIdemKey := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
-	idemKey := headerIdemKey
-	if idemKey == "" {
-		idemKey, err = core.MakeIssueIdempotencyKey(runID, toolName, body.Title, body.Body, body.Labels, nil)
-		if err != nil {
-			writeErr(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-	}
-
-	var replayIssue gh.Issue
-	var tc *db.ToolCall
-	var replayed bool
-	if headerIdemKey != "" {
-		tc, replayed, err = s.audit.ReplayResponseWithRequestCheck(r.Context(), runID, toolName, idemKey, body, &replayIssue)
-	} else {
-		tc, replayed, err = s.audit.ReplayResponse(r.Context(), runID, toolName, idemKey, &replayIssue)
-	}
-	if err != nil {
-		writeMappedErr(w, err, http.StatusInternalServerError)
-		return
-	}
-	if replayed {
-		w.Header().Set("Idempotency-Replayed", "true")
-		writeJSON(w, http.StatusOK, core.ToolEnvelope{
-			OK: true,
-			Meta: core.ToolMeta{
-				RunID:        runID,
-				ToolCallID:   tc.ToolCallID,
-				EvidenceHash: tc.EvidenceHash,
-				DryRun:       false,
-				Replayed:     true,
-			},
-			Result: &replayIssue,
-		})
-		return
-	}
-
-	owner, repo := splitRepo(run.Repo)
-
-	var issue *gh.Issue
-	var ghErr error
-	if body.DryRun {
-		issue = nil
-	} else {
-		issue, ghErr = s.gh.CreateIssue(r.Context(), owner, repo, gh.CreateIssueInput{
-			Title:  body.Title,
-			Body:   body.Body,
-			Labels: body.Labels,
-		})
-	}
-
-	tc, _, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-		RunID:    runID,
-		ToolName: toolName,
-		IdemKey:  &idemKey,
-		Request:  body,
-		Response: map[string]any{
-			"issue": issue,
-			"preview": dryRunIssuePreview{
-				Repo:   run.Repo,
-				Title:  body.Title,
-				Body:   body.Body,
-				Labels: body.Labels,
-			},
-		},
-		Err: ghErr,
-	})
-	if auditErr != nil {
-		writeErr(w, http.StatusInternalServerError, "audit record failed: "+auditErr.Error())
-		return
-	}
-
-	toolLogger := s.logger.With(
-		"request_id", RequestIDFromContext(r.Context()),
-		"run_id", runID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", toolName,
-		"repo", run.Repo,
-		"dry_run", body.DryRun,
-	)
-
-	if ghErr != nil {
-		toolLogger.Error("tool call failed", "err", ghErr)
-		writeMappedErr(w, ghErr, http.StatusBadGateway)
-		return
-	}
-
-	toolLogger.Info("tool call completed")
-
-	result := any(issue)
-	if body.DryRun {
-		result = map[string]any{
-			"would_create": dryRunIssuePreview{
-				Repo:   run.Repo,
-				Title:  body.Title,
-				Body:   body.Body,
-				Labels: body.Labels,
-			},
-		}
-	}
-
-	writeJSON(w, http.StatusOK, core.ToolEnvelope{
-		OK: ghErr == nil,
-		Meta: core.ToolMeta{
-			RunID:        runID,
-			ToolCallID:   tc.ToolCallID,
-			EvidenceHash: tc.EvidenceHash,
-			DryRun:       body.DryRun,
-		},
-		Result: result,
-	})
-}
-
-type batchCreateBody struct {
-	Issues []createIssueBody `json:"issues"`
-	DryRun bool              `json:"dry_run,omitempty"`
-}
-
-type batchResultJSON struct {
-	Index    int       `json:"index"`
-	Issue    *gh.Issue `json:"issue,omitempty"`
-	Replayed bool      `json:"replayed,omitempty"`
-	Error    string    `json:"error,omitempty"`
-}
-
-type batchResponseJSON struct {
-	Status       string            `json:"status"`
-	Mode         core.BatchMode    `json:"mode"`
-	Total        int               `json:"total"`
-	Processed    int               `json:"processed"`
-	Errors       int               `json:"errors"`
-	Replayed     int               `json:"replayed"`
-	CreatedFresh int               `json:"created_fresh"`
-	StoppedAt    *int              `json:"stopped_at,omitempty"`
-	FailedReason string            `json:"failed_reason,omitempty"`
-	Results      []batchResultJSON `json:"results"`
-}
-
-func (s *Server) handleBatchCreateIssues(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration("github.issues.batch_create", time.Since(start)) }()
-
-	runID := r.PathValue("runID")
-
-	run, err := s.runs.GetRun(r.Context(), runID)
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if run == nil {
-		writeErr(w, http.StatusNotFound, "run not found")
-		return
-	}
-
-	if err := s.policy.CheckTool("github.issues.batch_create"); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-
-	var body batchCreateBody
-	if err := decodeJSONBody(w, r, &body); err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid json: "+err.Error())
-		return
-	}
-
-	if len(body.Issues) == 0 {
-		writeErr(w, http.StatusBadRequest, "issues array is empty")
-		return
-	}
-	if len(body.Issues) > core.MaxBatchSize {
-		writeErr(w, http.StatusBadRequest, fmt.Sprintf("issues exceed %d items", core.MaxBatchSize))
-		return
-	}
-	for i, iss := range body.Issues {
-		if err := core.ValidateIssueInput(iss.Title, iss.Body, iss.Labels); err != nil {
-			writeErr(w, http.StatusBadRequest, fmt.Sprintf("issue %d: %s", i, err.Error()))
-			return
-		}
-	}
-
-	owner, repo := splitRepo(run.Repo)
-
-	out := make([]batchResultJSON, len(body.Issues))
-	replayedCount := 0
-	errCount := 0
-
-	processed := 0
-	for i, in := range body.Issues {
-		processed = i + 1
-		i2 := i
-		idemKey, err := core.MakeIssueIdempotencyKey(runID, "github.issues.batch_create", in.Title, in.Body, in.Labels, &i2)
-		if err != nil {
-			writeErr(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		var replayIssue gh.Issue
-		tc, replayed, err := s.audit.ReplayResponse(r.Context(), runID, "github.issues.batch_create", idemKey, &replayIssue)
-		if err != nil {
-			writeMappedErr(w, err, http.StatusInternalServerError)
-			return
-		}
-
-		if replayed {
-			out[i] = batchResultJSON{Index: i, Issue: &replayIssue, Replayed: true}
-			s.logger.Info("tool call replayed",
-				"request_id", RequestIDFromContext(r.Context()),
-				"run_id", runID,
-				"tool_call_id", tc.ToolCallID,
-				"tool_name", "github.issues.batch_create",
-				"repo", run.Repo,
-			)
-			replayedCount++
-			continue
-		}
-
-		var issue *gh.Issue
-		var ghErr error
-		if !body.DryRun {
-			issue, ghErr = s.gh.CreateIssue(r.Context(), owner, repo, gh.CreateIssueInput{
-				Title: in.Title, Body: in.Body, Labels: in.Labels,
-			})
-		}
-
-		tc, _, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-			RunID:    runID,
-			ToolName: "github.issues.batch_create",
-			IdemKey:  &idemKey,
-			Request:  in,
-			Response: map[string]any{
-				"issue": issue,
-				"preview": dryRunIssuePreview{
-					Repo:   run.Repo,
-					Title:  in.Title,
-					Body:   in.Body,
-					Labels: in.Labels,
-				},
-			},
-			Err: ghErr,
-		})
-		if auditErr != nil {
-			writeErr(w, http.StatusInternalServerError, fmt.Sprintf("audit record failed at index %d: %s", i, auditErr.Error()))
-			return
-		}
-
-		s.logger.Info("tool call completed",
-			"request_id", RequestIDFromContext(r.Context()),
-			"run_id", runID,
-			"tool_call_id", tc.ToolCallID,
-			"tool_name", "github.issues.batch_create",
-			"repo", run.Repo,
-			"index", i,
-			"dry_run", body.DryRun,
-		)
-
-		out[i] = batchResultJSON{Index: i, Issue: issue}
-		if ghErr != nil {
-			out[i].Error = ghErr.Error()
-			errCount++
-			if s.mode == core.BatchModeStrict {
-				stoppedAt := i
-				status := core.DeriveBatchStatus(processed, replayedCount, errCount)
-				writeJSON(w, http.StatusOK, batchResponseJSON{
-					Status:       status,
-					Mode:         s.mode,
-					Total:        len(body.Issues),
-					Processed:    processed,
-					Errors:       errCount,
-					Replayed:     replayedCount,
-					CreatedFresh: processed - replayedCount,
-					StoppedAt:    &stoppedAt,
-					FailedReason: ghErr.Error(),
-					Results:      out[:processed],
-				})
-				return
-			}
-		}
-	}
-
-	status := core.DeriveBatchStatus(len(body.Issues), replayedCount, errCount)
-
-	writeJSON(w, http.StatusOK, core.ToolEnvelope{
-		OK: errCount == 0,
-		Meta: core.ToolMeta{
-			RunID:        runID,
-			ToolCallID:   "",
-			EvidenceHash: "",
-			DryRun:       body.DryRun,
-		},
-		Result: batchResponseJSON{
-			Status:       status,
-			Mode:         s.mode,
-			Total:        len(body.Issues),
-			Processed:    len(body.Issues),
-			Errors:       errCount,
-			Replayed:     replayedCount,
-			CreatedFresh: len(body.Issues) - replayedCount,
-			Results:      out,
-		},
-	})
-}
-
-func (s *Server) handleCreatePRComment(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration("github.pr.comment.create", time.Since(start)) }()
-
-	runID := r.PathValue("runID")
-	prNumberRaw := r.PathValue("prNumber")
-	prNumber := 0
-	if _, err := fmt.Sscanf(prNumberRaw, "%d", &prNumber); err != nil || prNumber <= 0 {
-		writeErr(w, http.StatusBadRequest, "invalid prNumber")
-		return
-	}
-
-	run, err := s.runs.GetRun(r.Context(), runID)
-	if err != nil {
-		writeMappedErr(w, err, http.StatusInternalServerError)
-		return
-	}
-	if run == nil {
-		writeErr(w, http.StatusNotFound, "run not found")
-		return
-	}
-
-	if err := s.policy.CheckTool("github.pr.comment.create"); err != nil {
-		writeErr(w, http.StatusForbidden, err.Error())
-		return
-	}
-
-	var body prCommentBody
-	if err := decodeJSONBody(w, r, &body); err != nil {
-		writeMappedErr(w, fmt.Errorf("invalid json: %w", err), http.StatusBadRequest)
-		return
-	}
-	if strings.TrimSpace(body.Body) == "" {
-		writeErr(w, http.StatusBadRequest, "body is required")
-		return
-	}
-
-	toolName := "github.pr.comment.create"
-	keyLabels := []string{fmt.Sprintf("pr:%d", prNumber)}
-	headerIdemKey := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
-	idemKey := headerIdemKey
-	if idemKey == "" {
-		idemKey, err = core.MakeIssueIdempotencyKey(runID, toolName, fmt.Sprintf("pr-%d", prNumber), body.Body, keyLabels, nil)
-		if err != nil {
-			writeMappedErr(w, err, http.StatusInternalServerError)
-			return
-		}
-	}
-
-	var replay map[string]any
-	var tcReplay *db.ToolCall
-	var replayed bool
-	if headerIdemKey != "" {
-		tcReplay, replayed, err = s.audit.ReplayResponseWithRequestCheck(r.Context(), runID, toolName, idemKey, body, &replay)
-	} else {
-		tcReplay, replayed, err = s.audit.ReplayResponse(r.Context(), runID, toolName, idemKey, &replay)
-	}
-	if err != nil {
-		writeMappedErr(w, err, http.StatusInternalServerError)
-		return
-	}
-	if replayed {
-		w.Header().Set("Idempotency-Replayed", "true")
-		writeJSON(w, http.StatusOK, core.ToolEnvelope{
-			OK:     true,
-			Meta:   core.ToolMeta{RunID: runID, ToolCallID: tcReplay.ToolCallID, EvidenceHash: tcReplay.EvidenceHash, DryRun: false, Replayed: true},
-			Result: replay,
-		})
-		return
-	}
-
-	owner, repo := splitRepo(run.Repo)
-	var comment *gh.Comment
-	var ghErr error
-	if !body.DryRun {
-		comment, ghErr = s.gh.CreatePRComment(r.Context(), owner, repo, prNumber, body.Body)
-	}
-
-	tc, _, auditErr := s.audit.Record(r.Context(), core.RecordInput{
-		RunID:    runID,
-		ToolName: toolName,
-		IdemKey:  &idemKey,
-		Request:  body,
-		Response: map[string]any{
-			"comment": comment,
-			"preview": map[string]any{"repo": run.Repo, "pr_number": prNumber, "body": body.Body},
-		},
-		Err: ghErr,
-	})
-	if auditErr != nil {
-		writeErr(w, http.StatusInternalServerError, "audit record failed: "+auditErr.Error())
-		return
-	}
-
-	s.logger.Info("tool call completed",
-		"request_id", RequestIDFromContext(r.Context()),
-		"run_id", runID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", toolName,
-		"repo", run.Repo,
-		"dry_run", body.DryRun,
-		"pr_number", prNumber,
-	)
-
-	if ghErr != nil {
-		writeMappedErr(w, ghErr, http.StatusBadGateway)
-		return
-	}
-
-	result := any(comment)
-	if body.DryRun {
-		result = map[string]any{"would_comment": map[string]any{"repo": run.Repo, "pr_number": prNumber, "body": body.Body}}
-	}
-	writeJSON(w, http.StatusOK, core.ToolEnvelope{
-		OK:     true,
-		Meta:   core.ToolMeta{RunID: runID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: body.DryRun},
-		Result: result,
-	})
-}
-
-func splitRepo(fullRepo string) (string, string) {
-	parts := strings.SplitN(fullRepo, "/", 2)
-	if len(parts) != 2 {
-		return fullRepo, ""
-	}
-	return parts[0], parts[1]
-}
-
-func writeJSON(w http.ResponseWriter, status int, v any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(v)
-}
-
-func writeErr(w http.ResponseWriter, status int, msg string) {
-	code := "internal_error"
-	switch {
-	case status == http.StatusBadRequest:
-		code = "invalid_request_schema"
-	case status == http.StatusForbidden:
-		code = "forbidden"
-	case status == http.StatusNotFound:
-		code = "not_found"
-	case status >= 500 && status < 600:
-		code = "upstream_error"
-	}
-	writeJSON(w, status, map[string]string{"code": code, "message": msg})
-}
-
-func writeMappedErr(w http.ResponseWriter, err error, fallbackStatus int) {
-	var apiErr *gh.APIError
-	if errors.As(err, &apiErr) {
-		mapped := core.MapError(apiErr, fallbackStatus)
-		writeJSON(w, mapped.HTTPStatus, map[string]string{"code": mapped.Code, "message": mapped.Message})
-		return
-	}
-	mapped := core.MapError(err, fallbackStatus)
-	writeJSON(w, mapped.HTTPStatus, map[string]string{"code": mapped.Code, "message": mapped.Message})
-}
-
-func writePathPolicyViolation(w http.ResponseWriter, err error) bool {
-	pv, ok := err.(*core.PolicyViolation)
-	if !ok {
-		return false
-	}
-	writeJSON(w, http.StatusForbidden, core.ToolEnvelope{
-		OK:    false,
-		Error: &core.ToolError{Code: string(pv.Code), Message: pv.Error()},
-	})
-	return true
-}
-
-func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst any) error {
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
-	if err := dec.Decode(dst); err != nil {
-		return err
-	}
-	if err := dec.Decode(&struct{}{}); err != io.EOF {
-		return fmt.Errorf("request body must contain a single JSON object")
-	}
-	return nil
-}
-
-func withLogging(logger *slog.Logger, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := uuid.New().String()
-		ctx := context.WithValue(r.Context(), ctxKeyRequestID, requestID)
-		r = r.WithContext(ctx)
-
-		w.Header().Set("X-Request-ID", requestID)
-
-		start := time.Now()
-		sw := &statusWriter{ResponseWriter: w, status: 200}
-		next.ServeHTTP(sw, r)
-		logger.Info("http request",
-			"request_id", requestID,
-			"method", r.Method,
-			"path", r.URL.Path,
-			"status", sw.status,
-			"duration", fmt.Sprintf("%dms", time.Since(start).Milliseconds()),
-		)
-	})
-}
-
-type statusWriter struct {
-	http.ResponseWriter
-	status int
-}
-
-func (w *statusWriter) WriteHeader(code int) {
-	w.status = code
-	w.ResponseWriter.WriteHeader(code)
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
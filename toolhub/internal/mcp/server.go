@@ -9,17 +9,11 @@ import (
 	"io"
 	"log/slog"
 	"net"
-	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/google/uuid"
-	"github.com/toolhub/toolhub/internal/codeops"
 	"github.com/toolhub/toolhub/internal/core"
-	gh "github.com/toolhub/toolhub/internal/github"
-	"github.com/toolhub/toolhub/internal/qa"
-	"github.com/toolhub/toolhub/internal/telemetry"
 )
 
 type ctxKey string
@@ -27,37 +21,20 @@ type ctxKey string
 const ctxKeyTraceID ctxKey = "trace_id"
 
 type Server struct {
-	runs                *core.RunService
-	audit               *core.AuditService
-	policy              *core.Policy
-	gh                  *gh.Client
-	qa                  *qa.Runner
-	code                *codeops.Runner
-	addr                string
-	logger              *slog.Logger
-	mode                core.BatchMode
-	repairMaxIterations int
+	tools  *core.Registry
+	addr   string
+	logger *slog.Logger
 
 	ln     net.Listener
 	mu     sync.Mutex
 	closed bool
 }
 
-func NewServer(addr string, runs *core.RunService, audit *core.AuditService, policy *core.Policy, ghClient *gh.Client, qaRunner *qa.Runner, codeRunner *codeops.Runner, logger *slog.Logger, mode core.BatchMode, repairMaxIterations int) *Server {
-	if repairMaxIterations <= 0 {
-		repairMaxIterations = 3
-	}
+func NewServer(addr string, tools *core.Registry, logger *slog.Logger) *Server {
 	return &Server{
-		runs:                runs,
-		audit:               audit,
-		policy:              policy,
-		gh:                  ghClient,
-		qa:                  qaRunner,
-		code:                codeRunner,
-		addr:                addr,
-		logger:              logger,
-		mode:                mode,
-		repairMaxIterations: repairMaxIterations,
+		tools:  tools,
+		addr:   addr,
+		logger: logger,
 	}
 }
 
@@ -75,9 +52,16 @@ type jsonRPCResponse struct {
 	Error   *rpcError `json:"error,omitempty"`
 }
 
+type jsonRPCNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
 type rpcError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
 }
 
 func (s *Server) ListenAndServe() error {
@@ -119,8 +103,18 @@ func (s *Server) Shutdown(_ context.Context) error {
 
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
+	out := &connWriter{w: conn}
+	calls := newCallSet()
+	var wg sync.WaitGroup
+	defer func() {
+		// Calls still running when the client goes away are cancelled, as
+		// HTTP calls are when their request is.
+		calls.cancelAll(errConnClosed)
+		wg.Wait()
+	}()
 	scanner := bufio.NewScanner(conn)
 	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
+	principal := ""
 
 	for scanner.Scan() {
 		line := scanner.Bytes()
@@ -130,7 +124,7 @@ func (s *Server) handleConn(conn net.Conn) {
 
 		var req jsonRPCRequest
 		if err := json.Unmarshal(line, &req); err != nil {
-			s.writeResponse(conn, jsonRPCResponse{
+			s.writeResponse(out, jsonRPCResponse{
 				JSONRPC: "2.0",
 				ID:      nil,
 				Error:   &rpcError{Code: -32700, Message: "parse error"},
@@ -138,1440 +132,279 @@ func (s *Server) handleConn(conn net.Conn) {
 			continue
 		}
 
+		if req.ID == nil && strings.HasPrefix(req.Method, "notifications/") {
+			// Notifications get no response.
+			if req.Method == "notifications/cancelled" {
+				calls.cancel(req.Params)
+			}
+			continue
+		}
+		if req.Method == "initialize" {
+			principal = initializePrincipal(req.Params)
+		}
+
 		traceID := uuid.New().String()
 		ctx := context.WithValue(context.Background(), ctxKeyTraceID, traceID)
-		resp := s.dispatch(ctx, req)
-		s.writeResponse(conn, resp)
-	}
-}
-
-func (s *Server) writeResponse(w io.Writer, resp jsonRPCResponse) {
-	data, _ := json.Marshal(resp)
-	data = append(data, '\n')
-	w.Write(data)
-}
-
-func (s *Server) dispatch(ctx context.Context, req jsonRPCRequest) jsonRPCResponse {
-	base := jsonRPCResponse{JSONRPC: "2.0", ID: req.ID}
-
-	switch req.Method {
-	case "initialize":
-		base.Result = map[string]any{
-			"protocolVersion": "2024-11-05",
-			"capabilities":    map[string]any{"tools": map[string]any{"listChanged": false}},
-			"serverInfo":      map[string]any{"name": "toolhub", "version": "0.1.0", "contract_version": core.ContractVersion},
+		ctx = core.WithPrincipal(ctx, principal)
+		if req.Method != "tools/call" {
+			s.writeResponse(out, s.dispatch(ctx, out, req))
+			continue
 		}
-		return base
-
-	case "tools/list":
-		base.Result = map[string]any{"tools": s.toolDefinitions()}
-		return base
-
-	case "tools/call":
-		return s.handleToolCall(ctx, req, base)
-
-	default:
-		base.Error = &rpcError{Code: -32601, Message: fmt.Sprintf("method not found: %s", req.Method)}
-		return base
+		// Tool calls run concurrently so the connection stays readable for
+		// cancellations while they run.
+		ctx, done := calls.start(ctx, req.ID)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := s.dispatch(ctx, out, req)
+			if done() {
+				// The client abandoned the request; it expects no response.
+				return
+			}
+			s.writeResponse(out, resp)
+		}()
 	}
 }
 
-func (s *Server) toolDefinitions() []map[string]any {
-	return ToolDefinitions()
-}
+var errConnClosed = errors.New("mcp connection closed")
 
-func ToolDefinitions() []map[string]any {
-	return []map[string]any{
-		{
-			"name":        "runs_create",
-			"description": "Create a new ToolHub run for a repository",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"repo":    map[string]string{"type": "string", "description": "owner/repo"},
-					"purpose": map[string]string{"type": "string", "description": "Why this run exists"},
-				},
-				"required": []string{"repo", "purpose"},
-			},
-		},
-		{
-			"name":        "github_issues_create",
-			"description": "Create a GitHub issue within a run",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":  map[string]string{"type": "string"},
-					"title":   map[string]string{"type": "string"},
-					"body":    map[string]string{"type": "string"},
-					"labels":  map[string]any{"type": "array", "items": map[string]string{"type": "string"}},
-					"dry_run": map[string]string{"type": "boolean"},
-				},
-				"required": []string{"run_id", "title", "body"},
-			},
-		},
-		{
-			"name":        "github_issues_batch_create",
-			"description": "Create multiple GitHub issues within a run",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":  map[string]string{"type": "string"},
-					"dry_run": map[string]string{"type": "boolean"},
-					"issues": map[string]any{
-						"type": "array",
-						"items": map[string]any{
-							"type": "object",
-							"properties": map[string]any{
-								"title":  map[string]string{"type": "string"},
-								"body":   map[string]string{"type": "string"},
-								"labels": map[string]any{"type": "array", "items": map[string]string{"type": "string"}},
-							},
-							"required": []string{"title", "body"},
-						},
-					},
-				},
-				"required": []string{"run_id", "issues"},
-			},
-		},
-		{
-			"name":        "github_pr_comment_create",
-			"description": "Create a PR summary comment within a run",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":    map[string]string{"type": "string"},
-					"pr_number": map[string]string{"type": "integer"},
-					"body":      map[string]string{"type": "string"},
-					"dry_run":   map[string]string{"type": "boolean"},
-				},
-				"required": []string{"run_id", "pr_number", "body"},
-			},
-		},
-		{
-			"name":        "github_pr_get",
-			"description": "Get pull request metadata within a run",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":    map[string]string{"type": "string"},
-					"pr_number": map[string]string{"type": "integer"},
-				},
-				"required": []string{"run_id", "pr_number"},
-			},
-		},
-		{
-			"name":        "github_pr_files_list",
-			"description": "List pull request files within a run",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":    map[string]string{"type": "string"},
-					"pr_number": map[string]string{"type": "integer"},
-				},
-				"required": []string{"run_id", "pr_number"},
-			},
-		},
-		{
-			"name":        "qa_test",
-			"description": "Execute configured test command and capture output",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":  map[string]string{"type": "string"},
-					"dry_run": map[string]string{"type": "boolean"},
-				},
-				"required": []string{"run_id"},
-			},
-		},
-		{
-			"name":        "qa_lint",
-			"description": "Execute configured lint command and capture output",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":  map[string]string{"type": "string"},
-					"dry_run": map[string]string{"type": "boolean"},
-				},
-				"required": []string{"run_id"},
-			},
-		},
-		{
-			"name":        "code_patch_generate",
-			"description": "Generate unified patch/diff without modifying repository",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":           map[string]string{"type": "string"},
-					"path":             map[string]string{"type": "string"},
-					"original_content": map[string]string{"type": "string"},
-					"modified_content": map[string]string{"type": "string"},
-					"dry_run":          map[string]string{"type": "boolean"},
-				},
-				"required": []string{"run_id", "path", "original_content", "modified_content"},
-			},
-		},
-		{
-			"name":        "code_branch_pr_create",
-			"description": "Create branch, commit changes, push branch, and open PR (requires approved approval_id)",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":         map[string]string{"type": "string"},
-					"approval_id":    map[string]string{"type": "string"},
-					"base_branch":    map[string]string{"type": "string"},
-					"head_branch":    map[string]string{"type": "string"},
-					"commit_message": map[string]string{"type": "string"},
-					"pr_title":       map[string]string{"type": "string"},
-					"pr_body":        map[string]string{"type": "string"},
-					"dry_run":        map[string]string{"type": "boolean"},
-					"files": map[string]any{
-						"type": "array",
-						"items": map[string]any{
-							"type": "object",
-							"properties": map[string]any{
-								"path":             map[string]string{"type": "string"},
-								"original_content": map[string]string{"type": "string"},
-								"modified_content": map[string]string{"type": "string"},
-							},
-							"required": []string{"path", "modified_content"},
-						},
-					},
-				},
-				"required": []string{"run_id", "approval_id", "base_branch", "head_branch", "commit_message", "pr_title", "files"},
-			},
-		},
-		{
-			"name":        "code_repair_loop",
-			"description": "Run controlled repair loop: branch/commit, QA retries, rollback on QA failure, and PR on success",
-			"inputSchema": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"run_id":         map[string]string{"type": "string"},
-					"approval_id":    map[string]string{"type": "string"},
-					"base_branch":    map[string]string{"type": "string"},
-					"head_branch":    map[string]string{"type": "string"},
-					"commit_message": map[string]string{"type": "string"},
-					"pr_title":       map[string]string{"type": "string"},
-					"pr_body":        map[string]string{"type": "string"},
-					"max_iterations": map[string]string{"type": "integer"},
-					"dry_run":        map[string]string{"type": "boolean"},
-					"files": map[string]any{
-						"type": "array",
-						"items": map[string]any{
-							"type": "object",
-							"properties": map[string]any{
-								"path":             map[string]string{"type": "string"},
-								"original_content": map[string]string{"type": "string"},
-								"modified_content": map[string]string{"type": "string"},
-							},
-							"required": []string{"path", "modified_content"},
-						},
-					},
-				},
-				"required": []string{"run_id", "approval_id", "base_branch", "head_branch", "commit_message", "pr_title", "files"},
-			},
-		},
-	}
+// callSet tracks the running tool calls of a connection by request id, for
+// notifications/cancelled.
+type callSet struct {
+	mu        sync.Mutex
+	cancels   map[string]context.CancelCauseFunc
+	cancelled map[string]bool
 }
 
-type toolCallParams struct {
-	Name      string          `json:"name"`
-	Arguments json.RawMessage `json:"arguments"`
+func newCallSet() *callSet {
+	return &callSet{cancels: make(map[string]context.CancelCauseFunc), cancelled: make(map[string]bool)}
 }
 
-func (s *Server) handleToolCall(ctx context.Context, req jsonRPCRequest, base jsonRPCResponse) jsonRPCResponse {
-	var params toolCallParams
-	if err := json.Unmarshal(req.Params, &params); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: "invalid params: " + err.Error()}
-		return base
-	}
-
-	start := time.Now()
-	defer func() { telemetry.ObserveToolDuration(params.Name, time.Since(start)) }()
-
-	switch params.Name {
-	case "runs_create":
-		return s.toolRunsCreate(ctx, params.Arguments, base)
-	case "github_issues_create":
-		return s.toolIssuesCreate(ctx, params.Arguments, base)
-	case "github_issues_batch_create":
-		return s.toolIssuesBatchCreate(ctx, params.Arguments, base)
-	case "github_pr_comment_create":
-		return s.toolPRCommentCreate(ctx, params.Arguments, base)
-	case "github_pr_get":
-		return s.toolPRGet(ctx, params.Arguments, base)
-	case "github_pr_files_list":
-		return s.toolPRFilesList(ctx, params.Arguments, base)
-	case "qa_test":
-		return s.toolQA(ctx, params.Arguments, base, qa.KindTest)
-	case "qa_lint":
-		return s.toolQA(ctx, params.Arguments, base, qa.KindLint)
-	case "code_patch_generate":
-		return s.toolCodePatchGenerate(ctx, params.Arguments, base)
-	case "code_branch_pr_create":
-		return s.toolCodeBranchPRCreate(ctx, params.Arguments, base)
-	case "code_repair_loop":
-		return s.toolCodeRepairLoop(ctx, params.Arguments, base)
-	default:
-		base.Error = &rpcError{Code: -32602, Message: fmt.Sprintf("unknown tool: %s", params.Name)}
-		return base
-	}
-}
-
-type runsCreateArgs struct {
-	Repo    string `json:"repo"`
-	Purpose string `json:"purpose"`
+func requestKey(id any) string {
+	return fmt.Sprintf("%T:%v", id, id)
 }
 
-func (s *Server) toolRunsCreate(ctx context.Context, raw json.RawMessage, base jsonRPCResponse) jsonRPCResponse {
-	var args runsCreateArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-
-	if err := s.policy.CheckRepo(args.Repo); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-
-	run, err := s.runs.CreateRun(ctx, core.CreateRunRequest{Repo: args.Repo, Purpose: args.Purpose})
-	if err != nil {
-		base.Error = &rpcError{Code: -32603, Message: err.Error()}
-		return base
+// start returns the context of the call with request id. done ends the
+// call and reports whether the client cancelled it.
+func (c *callSet) start(ctx context.Context, id any) (context.Context, func() bool) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	key := requestKey(id)
+	c.mu.Lock()
+	c.cancels[key] = cancel
+	c.mu.Unlock()
+	return ctx, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.cancels, key)
+		cancelled := c.cancelled[key]
+		delete(c.cancelled, key)
+		cancel(nil)
+		return cancelled
 	}
-
-	base.Result = run
-	return base
-}
-
-type issuesCreateArgs struct {
-	RunID  string   `json:"run_id"`
-	Title  string   `json:"title"`
-	Body   string   `json:"body"`
-	Labels []string `json:"labels,omitempty"`
-	DryRun bool     `json:"dry_run,omitempty"`
-}
-
-type batchResultJSON struct {
-	Index    int       `json:"index"`
-	Issue    *gh.Issue `json:"issue,omitempty"`
-	Replayed bool      `json:"replayed,omitempty"`
-	Error    string    `json:"error,omitempty"`
 }
 
-type batchResponseJSON struct {
-	Status       string            `json:"status"`
-	Mode         core.BatchMode    `json:"mode"`
-	Total        int               `json:"total"`
-	Processed    int               `json:"processed"`
-	Errors       int               `json:"errors"`
-	Replayed     int               `json:"replayed"`
-	CreatedFresh int               `json:"created_fresh"`
-	StoppedAt    *int              `json:"stopped_at,omitempty"`
-	FailedReason string            `json:"failed_reason,omitempty"`
-	Results      []batchResultJSON `json:"results"`
-}
-
-func (s *Server) toolIssuesCreate(ctx context.Context, raw json.RawMessage, base jsonRPCResponse) jsonRPCResponse {
-	traceID, _ := ctx.Value(ctxKeyTraceID).(string)
-
-	var args issuesCreateArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if err := core.ValidateIssueInput(args.Title, args.Body, args.Labels); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-
-	run, err := s.runs.GetRun(ctx, args.RunID)
-	if err != nil || run == nil {
-		base.Error = &rpcError{Code: -32602, Message: "run not found"}
-		return base
-	}
-	if err := s.policy.CheckTool("github.issues.create"); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-
-	toolName := "github.issues.create"
-	idemKey, err := core.MakeIssueIdempotencyKey(args.RunID, toolName, args.Title, args.Body, args.Labels, nil)
-	if err != nil {
-		base.Error = &rpcError{Code: -32603, Message: err.Error()}
-		return base
-	}
-
-	var replayIssue gh.Issue
-	tc, replayed, err := s.audit.ReplayResponse(ctx, args.RunID, toolName, idemKey, &replayIssue)
-	if err != nil {
-		mapped := core.MapError(err, 500)
-		base.Error = &rpcError{Code: -32603, Message: mapped.Code + ": " + mapped.Message}
-		return base
+// cancel handles notifications/cancelled. Unknown or finished requests are
+// ignored, as the notification may race with the response.
+func (c *callSet) cancel(raw json.RawMessage) {
+	var params struct {
+		RequestID any    `json:"requestId"`
+		Reason    string `json:"reason"`
 	}
-	if replayed {
-		base.Result = core.ToolEnvelope{OK: true, Meta: core.ToolMeta{RunID: args.RunID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: false, Replayed: true}, Result: &replayIssue}
-		return base
+	if err := json.Unmarshal(raw, &params); err != nil || params.RequestID == nil {
+		return
 	}
-
-	owner, repo := splitRepo(run.Repo)
-	var issue *gh.Issue
-	var ghErr error
-	if !args.DryRun {
-		issue, ghErr = s.gh.CreateIssue(ctx, owner, repo, gh.CreateIssueInput{
-			Title: args.Title, Body: args.Body, Labels: args.Labels,
-		})
-	}
-
-	tc, _, auditErr := s.audit.Record(ctx, core.RecordInput{
-		RunID: args.RunID, ToolName: toolName, IdemKey: &idemKey, Request: args, Response: issue, Err: ghErr,
-	})
-	if auditErr != nil {
-		base.Error = &rpcError{Code: -32603, Message: "audit record failed: " + auditErr.Error()}
-		return base
+	key := requestKey(params.RequestID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cancel, ok := c.cancels[key]
+	if !ok {
+		return
 	}
-
-	s.logger.Info("tool call completed",
-		"trace_id", traceID,
-		"run_id", args.RunID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", toolName,
-		"repo", run.Repo,
-		"dry_run", args.DryRun,
-	)
-
-	if ghErr != nil {
-		mapped := core.MapError(ghErr, 502)
-		base.Error = &rpcError{Code: -32603, Message: mapped.Code + ": " + mapped.Message}
-		return base
+	c.cancelled[key] = true
+	cause := core.ErrToolCallCancelled
+	if params.Reason != "" {
+		cause = fmt.Errorf("%w: %s", core.ErrToolCallCancelled, params.Reason)
 	}
-
-	result := any(issue)
-	if args.DryRun {
-		result = map[string]any{"would_create": map[string]any{"repo": run.Repo, "title": args.Title, "body": args.Body, "labels": args.Labels}}
-	}
-	base.Result = core.ToolEnvelope{OK: true, Meta: core.ToolMeta{RunID: args.RunID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: args.DryRun}, Result: result}
-	return base
-}
-
-type issuesBatchCreateArgs struct {
-	RunID  string `json:"run_id"`
-	DryRun bool   `json:"dry_run,omitempty"`
-	Issues []struct {
-		Title  string   `json:"title"`
-		Body   string   `json:"body"`
-		Labels []string `json:"labels,omitempty"`
-	} `json:"issues"`
+	cancel(cause)
 }
 
-func (s *Server) toolIssuesBatchCreate(ctx context.Context, raw json.RawMessage, base jsonRPCResponse) jsonRPCResponse {
-	traceID, _ := ctx.Value(ctxKeyTraceID).(string)
-
-	var args issuesBatchCreateArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if len(args.Issues) == 0 {
-		base.Error = &rpcError{Code: -32602, Message: "issues array is empty"}
-		return base
-	}
-	if len(args.Issues) > core.MaxBatchSize {
-		base.Error = &rpcError{Code: -32602, Message: fmt.Sprintf("issues exceed %d items", core.MaxBatchSize)}
-		return base
-	}
-	for i, in := range args.Issues {
-		if err := core.ValidateIssueInput(in.Title, in.Body, in.Labels); err != nil {
-			base.Error = &rpcError{Code: -32602, Message: fmt.Sprintf("issue %d: %s", i, err.Error())}
-			return base
-		}
-	}
-
-	run, err := s.runs.GetRun(ctx, args.RunID)
-	if err != nil || run == nil {
-		base.Error = &rpcError{Code: -32602, Message: "run not found"}
-		return base
-	}
-	if err := s.policy.CheckTool("github.issues.batch_create"); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-
-	owner, repo := splitRepo(run.Repo)
-
-	out := make([]batchResultJSON, len(args.Issues))
-	errCount := 0
-	replayedCount := 0
-	processed := 0
-	for i, in := range args.Issues {
-		processed = i + 1
-		i2 := i
-		idemKey, err := core.MakeIssueIdempotencyKey(args.RunID, "github.issues.batch_create", in.Title, in.Body, in.Labels, &i2)
-		if err != nil {
-			base.Error = &rpcError{Code: -32603, Message: err.Error()}
-			return base
-		}
-
-		var replayIssue gh.Issue
-		tc, replayed, err := s.audit.ReplayResponse(ctx, args.RunID, "github.issues.batch_create", idemKey, &replayIssue)
-		if err != nil {
-			base.Error = &rpcError{Code: -32603, Message: err.Error()}
-			return base
-		}
-		if replayed {
-			s.logger.Info("tool call replayed",
-				"trace_id", traceID,
-				"run_id", args.RunID,
-				"tool_call_id", tc.ToolCallID,
-				"tool_name", "github.issues.batch_create",
-				"repo", run.Repo,
-				"index", i,
-			)
-			replayedCount++
-			out[i] = batchResultJSON{Index: i, Issue: &replayIssue, Replayed: true}
-			continue
-		}
-
-		var issue *gh.Issue
-		var ghErr error
-		if !args.DryRun {
-			issue, ghErr = s.gh.CreateIssue(ctx, owner, repo, gh.CreateIssueInput{Title: in.Title, Body: in.Body, Labels: in.Labels})
-		}
-
-		tc, _, auditErr := s.audit.Record(ctx, core.RecordInput{
-			RunID: args.RunID, ToolName: "github.issues.batch_create", IdemKey: &idemKey,
-			Request: in, Response: issue, Err: ghErr,
-		})
-		if auditErr != nil {
-			base.Error = &rpcError{Code: -32603, Message: fmt.Sprintf("audit record failed at index %d: %s", i, auditErr.Error())}
-			return base
-		}
-
-		s.logger.Info("tool call completed",
-			"trace_id", traceID,
-			"run_id", args.RunID,
-			"tool_call_id", tc.ToolCallID,
-			"tool_name", "github.issues.batch_create",
-			"repo", run.Repo,
-			"index", i,
-			"dry_run", args.DryRun,
-		)
-
-		if ghErr != nil {
-			errCount++
-			out[i] = batchResultJSON{Index: i, Issue: issue, Error: ghErr.Error()}
-			if s.mode == core.BatchModeStrict {
-				stoppedAt := i
-				status := core.DeriveBatchStatus(processed, replayedCount, errCount)
-				base.Result = core.ToolEnvelope{OK: false, Meta: core.ToolMeta{RunID: args.RunID, DryRun: args.DryRun}, Result: batchResponseJSON{
-					Status:       status,
-					Mode:         s.mode,
-					Total:        len(args.Issues),
-					Processed:    processed,
-					Errors:       errCount,
-					Replayed:     replayedCount,
-					CreatedFresh: processed - replayedCount,
-					StoppedAt:    &stoppedAt,
-					FailedReason: ghErr.Error(),
-					Results:      out[:processed],
-				}}
-				return base
-			}
-		} else {
-			out[i] = batchResultJSON{Index: i, Issue: issue}
-		}
+func (c *callSet) cancelAll(cause error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cancel := range c.cancels {
+		cancel(cause)
 	}
-
-	status := core.DeriveBatchStatus(len(args.Issues), replayedCount, errCount)
-
-	base.Result = core.ToolEnvelope{OK: errCount == 0, Meta: core.ToolMeta{RunID: args.RunID, DryRun: args.DryRun}, Result: batchResponseJSON{
-		Status:       status,
-		Mode:         s.mode,
-		Total:        len(args.Issues),
-		Processed:    len(args.Issues),
-		Errors:       errCount,
-		Replayed:     replayedCount,
-		CreatedFresh: len(args.Issues) - replayedCount,
-		Results:      out,
-	}}
-	return base
-}
-
-type prCommentArgs struct {
-	RunID    string `json:"run_id"`
-	PRNumber int    `json:"pr_number"`
-	Body     string `json:"body"`
-	DryRun   bool   `json:"dry_run,omitempty"`
 }
 
-type prReadArgs struct {
-	RunID    string `json:"run_id"`
-	PRNumber int    `json:"pr_number"`
+// connWriter serializes the messages of a connection: tool calls send
+// progress notifications while they run, from several goroutines.
+type connWriter struct {
+	mu sync.Mutex
+	w  io.Writer
 }
 
-type qaArgs struct {
-	RunID  string `json:"run_id"`
-	DryRun bool   `json:"dry_run,omitempty"`
+func (c *connWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Write(p)
 }
 
-type codePatchArgs struct {
-	RunID           string `json:"run_id"`
-	Path            string `json:"path"`
-	OriginalContent string `json:"original_content"`
-	ModifiedContent string `json:"modified_content"`
-	DryRun          bool   `json:"dry_run,omitempty"`
-}
-
-type codeBranchPRArgs struct {
-	RunID         string               `json:"run_id"`
-	ApprovalID    string               `json:"approval_id"`
-	BaseBranch    string               `json:"base_branch"`
-	HeadBranch    string               `json:"head_branch"`
-	CommitMessage string               `json:"commit_message"`
-	PRTitle       string               `json:"pr_title"`
-	PRBody        string               `json:"pr_body,omitempty"`
-	Files         []codeops.FileChange `json:"files"`
-	DryRun        bool                 `json:"dry_run,omitempty"`
-}
-
-type codeRepairLoopArgs struct {
-	RunID         string               `json:"run_id"`
-	ApprovalID    string               `json:"approval_id"`
-	BaseBranch    string               `json:"base_branch"`
-	HeadBranch    string               `json:"head_branch"`
-	CommitMessage string               `json:"commit_message"`
-	PRTitle       string               `json:"pr_title"`
-	PRBody        string               `json:"pr_body,omitempty"`
-	Files         []codeops.FileChange `json:"files"`
-	MaxIterations int                  `json:"max_iterations,omitempty"`
-	DryRun        bool                 `json:"dry_run,omitempty"`
+func (s *Server) writeResponse(w io.Writer, resp jsonRPCResponse) {
+	writeMessage(w, resp)
 }
 
-func (s *Server) toolCodePatchGenerate(ctx context.Context, raw json.RawMessage, base jsonRPCResponse) jsonRPCResponse {
-	traceID, _ := ctx.Value(ctxKeyTraceID).(string)
-
-	var args codePatchArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if strings.TrimSpace(args.RunID) == "" {
-		base.Error = &rpcError{Code: -32602, Message: "run_id is required"}
-		return base
-	}
-	if strings.TrimSpace(args.Path) == "" {
-		base.Error = &rpcError{Code: -32602, Message: "path is required"}
-		return base
-	}
-
-	run, err := s.runs.GetRun(ctx, args.RunID)
-	if err != nil || run == nil {
-		base.Error = &rpcError{Code: -32602, Message: "run not found"}
-		return base
-	}
-	if err := s.policy.CheckTool("code.patch.generate"); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if err := s.policy.CheckPaths([]string{args.Path}); err != nil {
-		if setPathPolicyViolationResult(&base, args.RunID, args.DryRun, err) {
-			return base
-		}
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-
-	patchText := core.GenerateUnifiedDiff(args.Path, args.OriginalContent, args.ModifiedContent)
-	lineDelta := core.CountContentLines(args.ModifiedContent) - core.CountContentLines(args.OriginalContent)
-
-	response := map[string]any{
-		"path":       args.Path,
-		"patch":      patchText,
-		"line_delta": lineDelta,
-	}
-
-	tc, extraIDs, auditErr := s.audit.Record(ctx, core.RecordInput{
-		RunID:    args.RunID,
-		ToolName: "code.patch.generate",
-		Request:  args,
-		Response: response,
-		Err:      nil,
-		ExtraArtifacts: []core.ExtraArtifact{
-			{Name: "code.patch.generate.patch.diff", ContentType: "text/x-diff", Body: []byte(patchText)},
-		},
-	})
-	if auditErr != nil {
-		base.Error = &rpcError{Code: -32603, Message: "audit record failed: " + auditErr.Error()}
-		return base
-	}
-
-	s.logger.Info("tool call completed",
-		"trace_id", traceID,
-		"run_id", args.RunID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", "code.patch.generate",
-		"repo", run.Repo,
-	)
-
-	result := map[string]any{
-		"path":       args.Path,
-		"patch":      patchText,
-		"line_delta": lineDelta,
-	}
-	if len(extraIDs) > 0 {
-		result["patch_artifact_id"] = extraIDs[0]
-	}
-
-	base.Result = core.ToolEnvelope{
-		OK:     true,
-		Meta:   core.ToolMeta{RunID: args.RunID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: args.DryRun},
-		Result: result,
-	}
-	return base
+// writeMessage writes msg as one line, in a single Write.
+func writeMessage(w io.Writer, msg any) {
+	data, _ := json.Marshal(msg)
+	data = append(data, '\n')
+	w.Write(data)
 }
 
-func (s *Server) toolCodeBranchPRCreate(ctx context.Context, raw json.RawMessage, base jsonRPCResponse) jsonRPCResponse {
-	traceID, _ := ctx.Value(ctxKeyTraceID).(string)
-
-	var args codeBranchPRArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if strings.TrimSpace(args.RunID) == "" || strings.TrimSpace(args.ApprovalID) == "" {
-		base.Error = &rpcError{Code: -32602, Message: "run_id and approval_id are required"}
-		return base
-	}
-
-	run, err := s.runs.GetRun(ctx, args.RunID)
-	if err != nil || run == nil {
-		base.Error = &rpcError{Code: -32602, Message: "run not found"}
-		return base
-	}
-	if err := s.policy.CheckTool("code.branch_pr.create"); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if s.code == nil {
-		base.Error = &rpcError{Code: -32603, Message: "code runner is not configured"}
-		return base
-	}
-
-	approval, err := s.audit.GetApproval(ctx, args.ApprovalID)
-	if err != nil {
-		base.Error = &rpcError{Code: -32603, Message: err.Error()}
-		return base
-	}
-	if approval == nil || approval.RunID != args.RunID {
-		base.Error = &rpcError{Code: -32602, Message: "approval not found"}
-		return base
-	}
-	if approval.Status != "approved" {
-		base.Error = &rpcError{Code: -32602, Message: "approval is not approved"}
-		return base
-	}
-	if approval.Scope != "code_write" {
-		base.Error = &rpcError{Code: -32602, Message: "approval scope must be code_write"}
-		return base
-	}
+func (s *Server) dispatch(ctx context.Context, out io.Writer, req jsonRPCRequest) jsonRPCResponse {
+	base := jsonRPCResponse{JSONRPC: "2.0", ID: req.ID}
 
-	paths := make([]string, 0, len(args.Files))
-	for _, f := range args.Files {
-		paths = append(paths, f.Path)
-	}
-	if err := s.policy.CheckPaths(paths); err != nil {
-		if setPathPolicyViolationResult(&base, args.RunID, args.DryRun, err) {
-			return base
+	switch req.Method {
+	case "initialize":
+		base.Result = map[string]any{
+			"protocolVersion": "2024-11-05",
+			"capabilities":    map[string]any{"tools": map[string]any{"listChanged": false}},
+			"serverInfo":      map[string]any{"name": "toolhub", "version": "0.1.0", "contract_version": core.ContractVersion},
 		}
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
 		return base
-	}
-
-	patches := make([]string, 0, len(args.Files))
-	for _, f := range args.Files {
-		patches = append(patches, core.GenerateUnifiedDiff(f.Path, f.OriginalContent, f.ModifiedContent))
-	}
-	combinedPatch := strings.Join(patches, "\n")
-
-	codeResult, runErr := s.code.Execute(ctx, codeops.Request{
-		BaseBranch:    args.BaseBranch,
-		HeadBranch:    args.HeadBranch,
-		CommitMessage: args.CommitMessage,
-		Files:         args.Files,
-		DryRun:        args.DryRun,
-	})
-	if codeResult == nil {
-		codeResult = &codeops.Result{}
-	}
-	if runErr != nil {
-		telemetry.IncRepairCompleted("code_error")
-	}
-	if runErr != nil {
-		telemetry.IncRepairCompleted("code_error")
-	}
-
-	result := map[string]any{
-		"base_branch":      args.BaseBranch,
-		"head_branch":      args.HeadBranch,
-		"planned_commands": codeResult.PlannedCommands,
-		"commit_hash":      codeResult.CommitHash,
-	}
 
-	if runErr == nil && !args.DryRun {
-		owner, repo := splitRepo(run.Repo)
-		pr, prErr := s.gh.CreatePullRequest(ctx, owner, repo, gh.CreatePullRequestInput{
-			Title: args.PRTitle,
-			Head:  args.HeadBranch,
-			Base:  args.BaseBranch,
-			Body:  args.PRBody,
-		})
-		if prErr != nil {
-			runErr = prErr
-		} else {
-			result["pull_request"] = pr
-		}
-	}
-
-	tc, extraIDs, auditErr := s.audit.Record(ctx, core.RecordInput{
-		RunID:    args.RunID,
-		ToolName: "code.branch_pr.create",
-		Request:  args,
-		Response: result,
-		Err:      runErr,
-		ExtraArtifacts: []core.ExtraArtifact{
-			{Name: "code.branch_pr.create.patch.diff", ContentType: "text/x-diff", Body: []byte(combinedPatch)},
-		},
-	})
-	if auditErr != nil {
-		base.Error = &rpcError{Code: -32603, Message: "audit record failed: " + auditErr.Error()}
+	case "tools/list":
+		base.Result = map[string]any{"tools": s.toolDefinitions()}
 		return base
-	}
-	if len(extraIDs) > 0 {
-		result["patch_artifact_id"] = extraIDs[0]
-	}
 
-	s.logger.Info("tool call completed",
-		"trace_id", traceID,
-		"run_id", args.RunID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", "code.branch_pr.create",
-		"repo", run.Repo,
-	)
+	case "tools/call":
+		return s.handleToolCall(ctx, out, req, base)
 
-	if runErr != nil {
-		mapped := core.MapError(runErr, 502)
-		base.Error = &rpcError{Code: -32603, Message: mapped.Code + ": " + mapped.Message}
+	default:
+		base.Error = &rpcError{Code: -32601, Message: fmt.Sprintf("method not found: %s", req.Method)}
 		return base
 	}
-
-	base.Result = core.ToolEnvelope{
-		OK:     true,
-		Meta:   core.ToolMeta{RunID: args.RunID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: args.DryRun},
-		Result: result,
-	}
-	return base
 }
 
-func (s *Server) toolCodeRepairLoop(ctx context.Context, raw json.RawMessage, base jsonRPCResponse) jsonRPCResponse {
-	traceID, _ := ctx.Value(ctxKeyTraceID).(string)
-
-	var args codeRepairLoopArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if strings.TrimSpace(args.RunID) == "" || strings.TrimSpace(args.ApprovalID) == "" {
-		base.Error = &rpcError{Code: -32602, Message: "run_id and approval_id are required"}
-		return base
-	}
-	if args.MaxIterations <= 0 {
-		args.MaxIterations = s.repairMaxIterations
-	}
-	if args.MaxIterations > s.repairMaxIterations {
-		base.Error = &rpcError{Code: -32602, Message: fmt.Sprintf("max_iterations cannot exceed %d", s.repairMaxIterations)}
-		return base
-	}
-
-	run, err := s.runs.GetRun(ctx, args.RunID)
-	if err != nil || run == nil {
-		base.Error = &rpcError{Code: -32602, Message: "run not found"}
-		return base
-	}
-	if err := s.policy.CheckTool("code.repair_loop"); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if s.code == nil {
-		base.Error = &rpcError{Code: -32603, Message: "code runner is not configured"}
-		return base
-	}
-	if s.qa == nil {
-		base.Error = &rpcError{Code: -32603, Message: "qa runner is not configured"}
-		return base
-	}
-
-	approval, err := s.audit.GetApproval(ctx, args.ApprovalID)
-	if err != nil {
-		base.Error = &rpcError{Code: -32603, Message: err.Error()}
-		return base
-	}
-	if approval == nil || approval.RunID != args.RunID {
-		base.Error = &rpcError{Code: -32602, Message: "approval not found"}
-		return base
-	}
-	if approval.Status != "approved" {
-		base.Error = &rpcError{Code: -32602, Message: "approval is not approved"}
-		return base
-	}
-	if approval.Scope != "code_write" {
-		base.Error = &rpcError{Code: -32602, Message: "approval scope must be code_write"}
-		return base
-	}
-
-	paths := make([]string, 0, len(args.Files))
-	for _, f := range args.Files {
-		paths = append(paths, f.Path)
-	}
-	if err := s.policy.CheckPaths(paths); err != nil {
-		if setPathPolicyViolationResult(&base, args.RunID, args.DryRun, err) {
-			return base
-		}
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-
-	step, err := s.audit.StartStep(ctx, args.RunID, "code_repair_loop", "repair_loop")
-	if err != nil {
-		base.Error = &rpcError{Code: -32603, Message: err.Error()}
-		return base
-	}
-	if err := s.audit.RecordDecision(ctx, args.RunID, &step.StepID, "system", "repair_loop_started", map[string]any{"max_iterations": args.MaxIterations}); err != nil {
-		s.logger.Error("audit record decision failed", "err", err, "run_id", args.RunID, "decision_type", "repair_loop_started")
-	}
-
-	codeResult, runErr := s.code.Execute(ctx, codeops.Request{
-		BaseBranch:    args.BaseBranch,
-		HeadBranch:    args.HeadBranch,
-		CommitMessage: args.CommitMessage,
-		Files:         args.Files,
-		DryRun:        args.DryRun,
-	})
-	if codeResult == nil {
-		codeResult = &codeops.Result{}
-	}
-
-	iterationsRun := 0
-	qaPassed := false
-	qaAttempts := make([]map[string]any, 0, args.MaxIterations)
-	var lastTestErr error
-	var lastLintErr error
-	var lastTestReport qa.Report
-	var lastLintReport qa.Report
-
-	result := map[string]any{
-		"iterations_requested": args.MaxIterations,
-		"iterations_run":       iterationsRun,
-		"base_branch":          args.BaseBranch,
-		"head_branch":          args.HeadBranch,
-		"planned_commands":     codeResult.PlannedCommands,
-		"commit_hash":          codeResult.CommitHash,
-		"qa_passed":            qaPassed,
-		"status":               "completed",
-	}
-
-	if runErr == nil && !args.DryRun {
-		for i := 1; i <= args.MaxIterations; i++ {
-			iterationsRun = i
-
-			testReport, testErr := s.qa.Run(ctx, qa.KindTest, false)
-			lintReport, lintErr := s.qa.Run(ctx, qa.KindLint, false)
-			testStatus := qa.DeriveStatus(testReport, testErr, false)
-			lintStatus := qa.DeriveStatus(lintReport, lintErr, false)
-
-			telemetry.IncRepairQAResult("test", core.MapQAStatusToMetric(testStatus))
-			telemetry.IncRepairQAResult("lint", core.MapQAStatusToMetric(lintStatus))
-
-			lastTestErr = testErr
-			lastLintErr = lintErr
-			lastTestReport = testReport
-			lastLintReport = lintReport
-
-			attempt := map[string]any{
-				"iteration":   i,
-				"test_status": string(testStatus),
-				"lint_status": string(lintStatus),
-				"test_report": testReport,
-				"lint_report": lintReport,
-			}
-			if testErr != nil {
-				attempt["test_error"] = testErr.Error()
-			}
-			if lintErr != nil {
-				attempt["lint_error"] = lintErr.Error()
-			}
-			qaAttempts = append(qaAttempts, attempt)
-			if err := s.audit.RecordDecision(ctx, args.RunID, &step.StepID, "system", "repair_loop_iteration", attempt); err != nil {
-				s.logger.Error("audit record decision failed", "err", err, "run_id", args.RunID, "decision_type", "repair_loop_iteration")
-			}
-
-			if testErr == nil && lintErr == nil {
-				telemetry.IncRepairIteration("pass")
-				qaPassed = true
-				break
-			}
-			telemetry.IncRepairIteration("fail")
-		}
-
-		if !qaPassed {
-			result["status"] = "failed"
-			result["qa_failure_reason"] = fmt.Sprintf("qa checks failed after %d iteration(s)", iterationsRun)
-			category := core.DeriveQAFailureCategory(lastTestErr, lastLintErr, &lastTestReport, &lastLintReport)
-			result["qa_failure_category"] = category
-
-			rollback, rollbackErr := s.code.RollbackBranch(ctx, args.BaseBranch, args.HeadBranch, false)
-			if rollback != nil {
-				result["rollback_planned_commands"] = rollback.PlannedCommands
-			}
-			if rollbackErr != nil {
-				result["rollback_error"] = rollbackErr.Error()
-				telemetry.IncRepairRollback("failure")
-				telemetry.IncRepairCompleted("rollback_error")
-			} else {
-				telemetry.IncRepairRollback("success")
-				telemetry.IncRepairCompleted("qa_failed")
-			}
-			runErr = fmt.Errorf("qa checks failed")
-		}
-	}
-
-	result["iterations_run"] = iterationsRun
-	result["qa_passed"] = qaPassed
-	if len(qaAttempts) > 0 {
-		result["qa_attempts"] = qaAttempts
-	}
+func (s *Server) toolDefinitions() []map[string]any {
+	return ToolDefinitions(s.tools.Specs())
+}
 
-	if runErr == nil && !args.DryRun && qaPassed {
-		owner, repo := splitRepo(run.Repo)
-		pr, prErr := s.gh.CreatePullRequest(ctx, owner, repo, gh.CreatePullRequestInput{
-			Title: args.PRTitle,
-			Head:  args.HeadBranch,
-			Base:  args.BaseBranch,
-			Body:  args.PRBody,
+// ToolDefinitions renders registry specs as MCP tools/list entries.
+func ToolDefinitions(specs []*core.ToolSpec) []map[string]any {
+	defs := make([]map[string]any, 0, len(specs))
+	for _, spec := range specs {
+		defs = append(defs, map[string]any{
+			"name":        spec.MCPName(),
+			"description": spec.Description,
+			"inputSchema": spec.InputSchema,
 		})
-		if prErr != nil {
-			runErr = prErr
-		} else {
-			result["pull_request"] = pr
-			telemetry.IncRepairCompleted("success")
-		}
-	}
-
-	if runErr != nil {
-		result["status"] = "failed"
-	} else if args.DryRun {
-		result["status"] = "dry_run"
-	}
-
-	tc, _, auditErr := s.audit.Record(ctx, core.RecordInput{
-		RunID:    args.RunID,
-		ToolName: "code.repair_loop",
-		Request:  args,
-		Response: result,
-		Err:      runErr,
-	})
-	if auditErr != nil {
-		base.Error = &rpcError{Code: -32603, Message: "audit record failed: " + auditErr.Error()}
-		return base
-	}
-
-	decisionType := "repair_loop_completed"
-	stepStatus := "completed"
-	if runErr != nil {
-		decisionType = "repair_loop_failed"
-		stepStatus = "failed"
-	}
-	if err := s.audit.RecordDecision(ctx, args.RunID, &step.StepID, "system", decisionType, result); err != nil {
-		s.logger.Error("audit record decision failed", "err", err, "run_id", args.RunID, "decision_type", decisionType)
 	}
-	if err := s.audit.FinishStep(ctx, step.StepID, stepStatus); err != nil {
-		s.logger.Error("audit finish step failed", "err", err, "run_id", args.RunID, "step_id", step.StepID)
-	}
-
-	s.logger.Info("tool call completed",
-		"trace_id", traceID,
-		"run_id", args.RunID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", "code.repair_loop",
-		"repo", run.Repo,
-	)
-
-	if runErr != nil {
-		mapped := core.MapError(runErr, 502)
-		base.Error = &rpcError{Code: -32603, Message: mapped.Code + ": " + mapped.Message}
-		return base
-	}
-
-	base.Result = core.ToolEnvelope{
-		OK:     true,
-		Meta:   core.ToolMeta{RunID: args.RunID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: args.DryRun},
-		Result: result,
-	}
-	return base
+	return defs
 }
 
-func (s *Server) toolPRCommentCreate(ctx context.Context, raw json.RawMessage, base jsonRPCResponse) jsonRPCResponse {
-	traceID, _ := ctx.Value(ctxKeyTraceID).(string)
-
-	var args prCommentArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if args.PRNumber <= 0 {
-		base.Error = &rpcError{Code: -32602, Message: "pr_number must be positive"}
-		return base
-	}
-	if strings.TrimSpace(args.Body) == "" {
-		base.Error = &rpcError{Code: -32602, Message: "body is required"}
-		return base
-	}
-
-	run, err := s.runs.GetRun(ctx, args.RunID)
-	if err != nil || run == nil {
-		base.Error = &rpcError{Code: -32602, Message: "run not found"}
-		return base
-	}
-	if err := s.policy.CheckTool("github.pr.comment.create"); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-
-	toolName := "github.pr.comment.create"
-	labels := []string{"pr:" + strconv.Itoa(args.PRNumber)}
-	idemKey, err := core.MakeIssueIdempotencyKey(args.RunID, toolName, fmt.Sprintf("pr-%d", args.PRNumber), args.Body, labels, nil)
-	if err != nil {
-		base.Error = &rpcError{Code: -32603, Message: err.Error()}
-		return base
-	}
-
-	var replay any
-	tcReplay, replayed, err := s.audit.ReplayResponse(ctx, args.RunID, toolName, idemKey, &replay)
-	if err != nil {
-		base.Error = &rpcError{Code: -32603, Message: err.Error()}
-		return base
-	}
-	if replayed {
-		base.Result = core.ToolEnvelope{OK: true, Meta: core.ToolMeta{RunID: args.RunID, ToolCallID: tcReplay.ToolCallID, EvidenceHash: tcReplay.EvidenceHash, DryRun: false, Replayed: true}, Result: replay}
-		return base
-	}
-
-	owner, repo := splitRepo(run.Repo)
-	var comment *gh.Comment
-	var ghErr error
-	if !args.DryRun {
-		comment, ghErr = s.gh.CreatePRComment(ctx, owner, repo, args.PRNumber, args.Body)
-	}
-
-	tc, _, auditErr := s.audit.Record(ctx, core.RecordInput{
-		RunID: args.RunID, ToolName: toolName, IdemKey: &idemKey,
-		Request: args,
-		Response: map[string]any{
-			"comment": comment,
-			"preview": map[string]any{"repo": run.Repo, "pr_number": args.PRNumber, "body": args.Body},
-		},
-		Err: ghErr,
-	})
-	if auditErr != nil {
-		base.Error = &rpcError{Code: -32603, Message: "audit record failed: " + auditErr.Error()}
-		return base
-	}
-
-	s.logger.Info("tool call completed",
-		"trace_id", traceID,
-		"run_id", args.RunID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", toolName,
-		"repo", run.Repo,
-		"dry_run", args.DryRun,
-		"pr_number", args.PRNumber,
-	)
-
-	if ghErr != nil {
-		mapped := core.MapError(ghErr, 502)
-		base.Error = &rpcError{Code: -32603, Message: mapped.Code + ": " + mapped.Message}
-		return base
-	}
-
-	result := any(comment)
-	if args.DryRun {
-		result = map[string]any{"would_comment": map[string]any{"repo": run.Repo, "pr_number": args.PRNumber, "body": args.Body}}
-	}
-	base.Result = core.ToolEnvelope{OK: true, Meta: core.ToolMeta{RunID: args.RunID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: args.DryRun}, Result: result}
-	return base
+type toolCallParams struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+	Meta      struct {
+		ProgressToken any `json:"progressToken"`
+	} `json:"_meta"`
 }
 
-func (s *Server) toolPRGet(ctx context.Context, raw json.RawMessage, base jsonRPCResponse) jsonRPCResponse {
-	traceID, _ := ctx.Value(ctxKeyTraceID).(string)
-
-	var args prReadArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if args.PRNumber <= 0 {
-		base.Error = &rpcError{Code: -32602, Message: "pr_number must be positive"}
-		return base
-	}
-
-	run, err := s.runs.GetRun(ctx, args.RunID)
-	if err != nil || run == nil {
-		base.Error = &rpcError{Code: -32602, Message: "run not found"}
-		return base
-	}
-	if err := s.policy.CheckTool("github.pr.get"); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
+func (s *Server) handleToolCall(ctx context.Context, out io.Writer, req jsonRPCRequest, base jsonRPCResponse) jsonRPCResponse {
+	var params toolCallParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		base.Error = &rpcError{Code: -32602, Message: "invalid params: " + err.Error()}
 		return base
 	}
 
-	owner, repo := splitRepo(run.Repo)
-	pr, ghErr := s.gh.GetPullRequest(ctx, owner, repo, args.PRNumber)
-
-	tc, _, auditErr := s.audit.Record(ctx, core.RecordInput{
-		RunID: args.RunID, ToolName: "github.pr.get",
-		Request:  map[string]any{"pr_number": args.PRNumber},
-		Response: pr,
-		Err:      ghErr,
-	})
-	if auditErr != nil {
-		base.Error = &rpcError{Code: -32603, Message: "audit record failed: " + auditErr.Error()}
+	spec, ok := s.tools.LookupMCP(params.Name)
+	if !ok {
+		base.Error = &rpcError{Code: -32602, Message: fmt.Sprintf("unknown tool: %s", params.Name)}
 		return base
 	}
 
-	s.logger.Info("tool call completed",
-		"trace_id", traceID,
-		"run_id", args.RunID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", "github.pr.get",
-		"repo", run.Repo,
-		"pr_number", args.PRNumber,
-	)
-
-	if ghErr != nil {
-		mapped := core.MapError(ghErr, 502)
-		base.Error = &rpcError{Code: -32603, Message: mapped.Code + ": " + mapped.Message}
-		return base
+	if token := params.Meta.ProgressToken; token != nil {
+		ctx = core.WithProgress(ctx, progressNotifier(out, token))
 	}
-
-	base.Result = core.ToolEnvelope{OK: true, Meta: core.ToolMeta{RunID: args.RunID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: false}, Result: pr}
-	return base
-}
-
-func (s *Server) toolPRFilesList(ctx context.Context, raw json.RawMessage, base jsonRPCResponse) jsonRPCResponse {
 	traceID, _ := ctx.Value(ctxKeyTraceID).(string)
-
-	var args prReadArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if args.PRNumber <= 0 {
-		base.Error = &rpcError{Code: -32602, Message: "pr_number must be positive"}
-		return base
-	}
-
-	run, err := s.runs.GetRun(ctx, args.RunID)
-	if err != nil || run == nil {
-		base.Error = &rpcError{Code: -32602, Message: "run not found"}
-		return base
-	}
-
-	owner, repo := splitRepo(run.Repo)
-	files, ghErr := s.gh.ListPullRequestFiles(ctx, owner, repo, args.PRNumber)
-
-	tc, _, auditErr := s.audit.Record(ctx, core.RecordInput{
-		RunID: args.RunID, ToolName: "github.pr.files.list",
-		Request:  map[string]any{"pr_number": args.PRNumber},
-		Response: map[string]any{"files": files, "count": len(files)},
-		Err:      ghErr,
+	resp, err := s.tools.Invoke(ctx, spec.Name, params.Arguments, core.InvokeOptions{
+		Logger: s.logger.With("trace_id", traceID),
 	})
-	if auditErr != nil {
-		base.Error = &rpcError{Code: -32603, Message: "audit record failed: " + auditErr.Error()}
-		return base
-	}
-
-	s.logger.Info("tool call completed",
-		"trace_id", traceID,
-		"run_id", args.RunID,
-		"tool_call_id", tc.ToolCallID,
-		"tool_name", "github.pr.files.list",
-		"repo", run.Repo,
-		"pr_number", args.PRNumber,
-	)
-
-	if ghErr != nil {
-		mapped := core.MapError(ghErr, 502)
-		base.Error = &rpcError{Code: -32603, Message: mapped.Code + ": " + mapped.Message}
+	if err != nil {
+		base.Error = toolRPCError(err)
 		return base
 	}
-
-	base.Result = core.ToolEnvelope{OK: true, Meta: core.ToolMeta{RunID: args.RunID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: false}, Result: map[string]any{"files": files, "count": len(files), "summary": gh.SummarizePRFiles(files)}}
+	base.Result = resp.Body
 	return base
 }
 
-func (s *Server) toolQA(ctx context.Context, raw json.RawMessage, base jsonRPCResponse, kind qa.Kind) jsonRPCResponse {
-	traceID, _ := ctx.Value(ctxKeyTraceID).(string)
-
-	var args qaArgs
-	if err := json.Unmarshal(raw, &args); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-	if strings.TrimSpace(args.RunID) == "" {
-		base.Error = &rpcError{Code: -32602, Message: "run_id is required"}
-		return base
-	}
-
-	run, err := s.runs.GetRun(ctx, args.RunID)
-	if err != nil || run == nil {
-		base.Error = &rpcError{Code: -32602, Message: "run not found"}
-		return base
-	}
-	if err := s.policy.CheckTool(string(kind)); err != nil {
-		base.Error = &rpcError{Code: -32602, Message: err.Error()}
-		return base
-	}
-
-	report, runErr := s.qa.Run(ctx, kind, args.DryRun)
-	if runErr != nil && report.Command == "" {
-		_, _, auditErr := s.audit.Record(ctx, core.RecordInput{
-			RunID:    args.RunID,
-			ToolName: string(kind),
-			Request:  args,
-			Response: nil,
-			Err:      runErr,
+// progressNotifier sends the progress events of a tool call as
+// notifications/progress for the request's progress token. progress counts
+// the events; event carries the ToolHub progress event itself.
+func progressNotifier(out io.Writer, token any) core.ProgressFunc {
+	var mu sync.Mutex
+	progress := 0
+	return func(ev core.ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress++
+		writeMessage(out, jsonRPCNotification{
+			JSONRPC: "2.0",
+			Method:  "notifications/progress",
+			Params: map[string]any{
+				"progressToken": token,
+				"progress":      progress,
+				"message":       ev.Message(),
+				"event":         ev,
+			},
 		})
-		if auditErr != nil {
-			s.logger.Error("audit record failed",
-				"trace_id", traceID,
-				"err", auditErr,
-			)
-		}
-		s.logger.Error("tool call failed",
-			"trace_id", traceID,
-			"run_id", args.RunID,
-			"tool_name", string(kind),
-			"err", runErr,
-		)
-		base.Error = &rpcError{Code: -32602, Message: runErr.Error()}
-		return base
-	}
-
-	reportJSON, reportJSONErr := json.Marshal(report)
-	if reportJSONErr != nil {
-		base.Error = &rpcError{Code: -32603, Message: "marshal qa report failed: " + reportJSONErr.Error()}
-		return base
-	}
-
-	tc, extraArtifactIDs, auditErr := s.audit.Record(ctx, core.RecordInput{
-		RunID: args.RunID, ToolName: string(kind),
-		Request:  args,
-		Response: map[string]any{"report": report},
-		Err:      runErr,
-		ExtraArtifacts: []core.ExtraArtifact{
-			{Name: fmt.Sprintf("%s.stdout.txt", kind), ContentType: "text/plain", Body: []byte(report.Stdout)},
-			{Name: fmt.Sprintf("%s.stderr.txt", kind), ContentType: "text/plain", Body: []byte(report.Stderr)},
-			{Name: fmt.Sprintf("%s.report.json", kind), ContentType: "application/json", Body: reportJSON},
-		},
-	})
-	if auditErr != nil {
-		base.Error = &rpcError{Code: -32603, Message: "audit record failed: " + auditErr.Error()}
-		return base
-	}
-
-	qaArtifacts := &core.QAArtifacts{}
-	if len(extraArtifactIDs) > 0 {
-		qaArtifacts.StdoutArtifactID = extraArtifactIDs[0]
-	}
-	if len(extraArtifactIDs) > 1 {
-		qaArtifacts.StderrArtifactID = extraArtifactIDs[1]
-	}
-	if len(extraArtifactIDs) > 2 {
-		qaArtifacts.ReportArtifactID = extraArtifactIDs[2]
-	}
-
-	if runErr != nil {
-		s.logger.Error("tool call failed",
-			"trace_id", traceID,
-			"run_id", args.RunID,
-			"tool_call_id", tc.ToolCallID,
-			"tool_name", string(kind),
-			"repo", run.Repo,
-			"err", runErr,
-		)
-	} else {
-		s.logger.Info("tool call completed",
-			"trace_id", traceID,
-			"run_id", args.RunID,
-			"tool_call_id", tc.ToolCallID,
-			"tool_name", string(kind),
-			"repo", run.Repo,
-			"dry_run", args.DryRun,
-		)
 	}
-
-	status := qa.DeriveStatus(report, runErr, args.DryRun)
-	base.Result = core.ToolEnvelope{
-		OK:   runErr == nil,
-		Meta: core.ToolMeta{RunID: args.RunID, ToolCallID: tc.ToolCallID, EvidenceHash: tc.EvidenceHash, DryRun: args.DryRun, QAArtifacts: qaArtifacts},
-		Result: map[string]any{
-			"status":  string(status),
-			"report":  report,
-			"summary": qa.GenerateSummary(status, report),
-		},
-		Error: func() *core.ToolError {
-			if runErr == nil {
-				return nil
-			}
-			return &core.ToolError{Code: string(status), Message: runErr.Error()}
-		}(),
-	}
-	return base
 }
 
-func splitRepo(fullRepo string) (string, string) {
-	parts := strings.SplitN(fullRepo, "/", 2)
-	if len(parts) != 2 {
-		return fullRepo, ""
+// toolRPCError maps registry errors onto JSON-RPC errors: rejected requests
+// are invalid params (schema failures list the offending fields in data),
+// execution failures carry the mapped ToolHub error code.
+func toolRPCError(err error) *rpcError {
+	var schemaErr *core.SchemaError
+	if errors.As(err, &schemaErr) {
+		return &rpcError{
+			Code:    -32602,
+			Message: schemaErr.Error(),
+			Data:    map[string]any{"code": schemaErr.ErrorCode(), "errors": schemaErr.Fields},
+		}
 	}
-	return parts[0], parts[1]
-}
-
-func mcpContent(text string) map[string]any {
-	return map[string]any{
-		"content": []map[string]string{
-			{"type": "text", "text": text},
-		},
+	var reqErr *core.ToolRequestError
+	if errors.As(err, &reqErr) {
+		if reqErr.Status >= 500 {
+			return &rpcError{Code: -32603, Message: reqErr.Message}
+		}
+		return &rpcError{Code: -32602, Message: reqErr.Message}
 	}
-}
 
-func setPathPolicyViolationResult(base *jsonRPCResponse, runID string, dryRun bool, err error) bool {
-	pv, ok := err.(*core.PolicyViolation)
-	if !ok {
-		return false
+	fallback := 500
+	var execErr *core.ToolExecError
+	if errors.As(err, &execErr) {
+		err = execErr.Err
+		fallback = execErr.FallbackStatus
 	}
-	base.Result = core.ToolEnvelope{
-		OK:    false,
-		Meta:  core.ToolMeta{RunID: runID, DryRun: dryRun},
-		Error: &core.ToolError{Code: string(pv.Code), Message: pv.Error()},
+	mapped := core.MapError(err, fallback)
+	rpcErr := &rpcError{Code: -32603, Message: mapped.Code + ": " + mapped.Message}
+	if mapped.RetryAfterSeconds > 0 {
+		rpcErr.Data = map[string]any{"code": mapped.Code, "retry_after_seconds": mapped.RetryAfterSeconds}
 	}
-	return true
+	return rpcErr
 }
 
-func asAPIError(err error) *gh.APIError {
-	var apiErr *gh.APIError
-	if errors.As(err, &apiErr) {
-		return apiErr
+// initializePrincipal extracts the client name announced in initialize; it is
+// used as the principal for rate limits on the rest of the connection.
+func initializePrincipal(raw json.RawMessage) string {
+	var params struct {
+		ClientInfo struct {
+			Name string `json:"name"`
+		} `json:"clientInfo"`
 	}
-	return nil
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(params.ClientInfo.Name)
 }
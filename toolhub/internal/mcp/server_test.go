@@ -0,0 +1,136 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toolhub/toolhub/internal/core"
+)
+
+func TestToolCallProgressNotifications(t *testing.T) {
+	reg := core.NewRegistry(nil, nil, core.NewPolicy("o/r", "runs.progress"), nil)
+	reg.Register(core.ToolSpec{
+		Name:    "runs.progress",
+		NewArgs: func() any { return &struct{}{} },
+		Policy:  core.ToolPolicy{NoRun: true},
+		Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+			for _, status := range []string{"started", "passed"} {
+				core.ReportProgress(ctx, core.ProgressEvent{Type: core.ProgressRepairIteration, Iteration: 1, MaxIterations: 2, Status: status})
+			}
+			return &core.ToolOutcome{Result: map[string]bool{"done": true}, Raw: true}, nil
+		},
+	})
+	s := NewServer(":0", reg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name  string
+		meta  string
+		wantN int
+	}{
+		{name: "with token", meta: `,"_meta":{"progressToken":"tok-1"}`, wantN: 2},
+		{name: "without token", wantN: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			req := jsonRPCRequest{JSONRPC: "2.0", ID: 1, Method: "tools/call", Params: json.RawMessage(`{"name":"runs_progress","arguments":{}` + tt.meta + `}`)}
+			resp := s.dispatch(context.Background(), &out, req)
+			if resp.Error != nil {
+				t.Fatalf("tools/call error: %+v", resp.Error)
+			}
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if out.Len() == 0 {
+				lines = nil
+			}
+			if len(lines) != tt.wantN {
+				t.Fatalf("got %d notifications, want %d: %q", len(lines), tt.wantN, out.String())
+			}
+			for i, line := range lines {
+				var n struct {
+					Method string `json:"method"`
+					Params struct {
+						ProgressToken string             `json:"progressToken"`
+						Progress      int                `json:"progress"`
+						Message       string             `json:"message"`
+						Event         core.ProgressEvent `json:"event"`
+					} `json:"params"`
+				}
+				if err := json.Unmarshal([]byte(line), &n); err != nil {
+					t.Fatalf("decode notification: %v", err)
+				}
+				if n.Method != "notifications/progress" || n.Params.ProgressToken != "tok-1" || n.Params.Progress != i+1 || n.Params.Event.Iteration != 1 {
+					t.Fatalf("notification %d = %s", i, line)
+				}
+			}
+		})
+	}
+}
+
+func TestNotificationsCancelled(t *testing.T) {
+	causes := make(chan error, 1)
+	reg := core.NewRegistry(nil, nil, core.NewPolicy("o/r", "runs.wait,runs.ping"), nil)
+	reg.Register(core.ToolSpec{
+		Name:    "runs.wait",
+		NewArgs: func() any { return &struct{}{} },
+		Policy:  core.ToolPolicy{NoRun: true},
+		Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+			<-ctx.Done()
+			causes <- context.Cause(ctx)
+			return nil, ctx.Err()
+		},
+	})
+	reg.Register(core.ToolSpec{
+		Name:    "runs.ping",
+		NewArgs: func() any { return &struct{}{} },
+		Policy:  core.ToolPolicy{NoRun: true},
+		Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+			return &core.ToolOutcome{Result: "pong", Raw: true}, nil
+		},
+	})
+	s := NewServer(":0", reg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleConn(server)
+	responses := bufio.NewScanner(client)
+	send := func(msg string) {
+		t.Helper()
+		if _, err := io.WriteString(client, msg+"\n"); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	send(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"runs_wait","arguments":{}}}`)
+	send(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7,"reason":"user abort"}}`)
+	select {
+	case cause := <-causes:
+		if !errors.Is(cause, core.ErrToolCallCancelled) || !strings.Contains(cause.Error(), "user abort") {
+			t.Fatalf("cause = %v", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tool call was not cancelled")
+	}
+
+	// The cancelled request gets no response; the next one does.
+	send(`{"jsonrpc":"2.0","id":8,"method":"tools/call","params":{"name":"runs_ping","arguments":{}}}`)
+	if !responses.Scan() {
+		t.Fatalf("read response: %v", responses.Err())
+	}
+	var resp jsonRPCResponse
+	if err := json.Unmarshal(responses.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.ID != float64(8) || resp.Result != "pong" {
+		t.Fatalf("response = %s", responses.Bytes())
+	}
+}
@@ -0,0 +1,194 @@
+// Package openapi renders the OpenAPI document of the HTTP API from the
+// route table and the Go types of request and response bodies.
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/toolhub/toolhub/internal/core"
+)
+
+// Info is the static header of the document.
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+	Servers     []string
+}
+
+// Operation documents one HTTP route.
+type Operation struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	// Params lists path, query and header parameters. Path wildcards that
+	// are not listed are documented as required strings.
+	Params []Param
+	// Request is a zero value of the JSON request body; nil means no body.
+	Request any
+	// RequestOmit names request fields supplied by path parameters.
+	RequestOmit []string
+	Responses   []Response
+}
+
+// Param is a path, query or header parameter.
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	Schema      map[string]any
+}
+
+// Response documents one status code of an operation.
+type Response struct {
+	Status      int
+	Description string
+	// ContentType defaults to application/json.
+	ContentType string
+	// Body is a zero value of the response body; nil means no content.
+	Body any
+	// Envelope wraps Body in core.ToolEnvelope as its result.
+	Envelope bool
+	Headers  []Param
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Generate renders the document as YAML. The output is deterministic so it
+// can be committed and compared against the code.
+func Generate(info Info, ops []Operation) []byte {
+	return marshalYAML(Build(info, ops))
+}
+
+// Build assembles the document. Named Go types become components.
+func Build(info Info, ops []Operation) Object {
+	schemas := core.NewSchemaBuilder()
+
+	paths := map[string]Object{}
+	var order []string
+	for _, op := range ops {
+		if _, ok := paths[op.Path]; !ok {
+			order = append(order, op.Path)
+		}
+		paths[op.Path] = append(paths[op.Path], Field{strings.ToLower(op.Method), buildOperation(schemas, op)})
+	}
+	sort.Strings(order)
+	pathsObj := Object{}
+	for _, p := range order {
+		pathsObj = append(pathsObj, Field{p, paths[p]})
+	}
+
+	infoObj := Object{{"title", info.Title}, {"version", info.Version}}
+	if info.Description != "" {
+		infoObj = append(infoObj, Field{"description", info.Description})
+	}
+	servers := make([]any, 0, len(info.Servers))
+	for _, url := range info.Servers {
+		servers = append(servers, Object{{"url", url}})
+	}
+
+	return Object{
+		{"openapi", "3.0.3"},
+		{"info", infoObj},
+		{"servers", servers},
+		{"paths", pathsObj},
+		{"components", Object{{"schemas", schemas.Components()}}},
+	}
+}
+
+func buildOperation(schemas *core.SchemaBuilder, op Operation) Object {
+	out := Object{{"summary", op.Summary}, {"operationId", op.OperationID}}
+
+	var params []any
+	listed := map[string]bool{}
+	for _, p := range op.Params {
+		if p.In == "path" {
+			listed[p.Name] = true
+		}
+	}
+	for _, m := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+		if !listed[m[1]] {
+			params = append(params, paramObject(Param{Name: m[1], In: "path", Required: true}))
+		}
+	}
+	for _, p := range op.Params {
+		params = append(params, paramObject(p))
+	}
+	if len(params) > 0 {
+		out = append(out, Field{"parameters", params})
+	}
+
+	if op.Request != nil {
+		out = append(out, Field{"requestBody", Object{
+			{"required", true},
+			{"content", Object{{"application/json", Object{{"schema", schemas.Inline(op.Request, op.RequestOmit...)}}}}},
+		}})
+	}
+
+	responses := Object{}
+	sorted := append([]Response(nil), op.Responses...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Status < sorted[j].Status })
+	for _, r := range sorted {
+		responses = append(responses, Field{strconv.Itoa(r.Status), responseObject(schemas, r)})
+	}
+	out = append(out, Field{"responses", responses})
+	return out
+}
+
+func paramObject(p Param) Object {
+	schema := p.Schema
+	if schema == nil {
+		schema = map[string]any{"type": "string"}
+	}
+	obj := Object{{"in", p.In}, {"name", p.Name}, {"required", p.Required || p.In == "path"}, {"schema", schema}}
+	if p.Description != "" {
+		obj = append(obj, Field{"description", p.Description})
+	}
+	return obj
+}
+
+func responseObject(schemas *core.SchemaBuilder, r Response) Object {
+	desc := r.Description
+	if desc == "" {
+		desc = http.StatusText(r.Status)
+	}
+	obj := Object{{"description", desc}}
+	if len(r.Headers) > 0 {
+		headers := Object{}
+		for _, h := range r.Headers {
+			header := Object{{"schema", h.Schema}}
+			if h.Description != "" {
+				header = append(header, Field{"description", h.Description})
+			}
+			headers = append(headers, Field{h.Name, header})
+		}
+		obj = append(obj, Field{"headers", headers})
+	}
+	if r.Body == nil && !r.Envelope {
+		return obj
+	}
+
+	var schema map[string]any
+	switch {
+	case r.Envelope && r.Body != nil:
+		schema = map[string]any{"allOf": []any{
+			schemas.Schema(core.ToolEnvelope{}),
+			map[string]any{"type": "object", "properties": map[string]any{"result": schemas.Schema(r.Body)}},
+		}}
+	case r.Envelope:
+		schema = schemas.Schema(core.ToolEnvelope{})
+	default:
+		schema = schemas.Schema(r.Body)
+	}
+	contentType := r.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return append(obj, Field{"content", Object{{contentType, Object{{"schema", schema}}}}})
+}
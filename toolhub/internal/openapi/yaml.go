@@ -0,0 +1,164 @@
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Object is a mapping whose keys are written in order. Plain maps are
+// written with sorted keys.
+type Object []Field
+
+type Field struct {
+	Key   string
+	Value any
+}
+
+// marshalYAML writes the small subset of YAML the document needs: block
+// mappings and sequences of scalars, with flow style only for empty
+// collections.
+func marshalYAML(v any) []byte {
+	var b bytes.Buffer
+	writeNode(&b, 0, v)
+	return b.Bytes()
+}
+
+func writeNode(b *bytes.Buffer, indent int, v any) {
+	switch fields := toFields(v); {
+	case fields != nil:
+		for _, f := range fields {
+			writeIndent(b, indent)
+			b.WriteString(yamlScalar(f.Key))
+			b.WriteString(":")
+			writeValue(b, indent, f.Value)
+		}
+	default:
+		for _, item := range toItems(v) {
+			// Render the item one level deeper, then hang its first line
+			// off the dash.
+			var sub bytes.Buffer
+			if flow, ok := flowString(item); ok {
+				writeIndent(&sub, indent+2)
+				sub.WriteString(flow)
+				sub.WriteString("\n")
+			} else {
+				writeNode(&sub, indent+2, item)
+			}
+			writeIndent(b, indent)
+			b.WriteString("- ")
+			b.Write(sub.Bytes()[indent+2:])
+		}
+	}
+}
+
+func writeValue(b *bytes.Buffer, indent int, v any) {
+	if flow, ok := flowString(v); ok {
+		b.WriteString(" ")
+		b.WriteString(flow)
+		b.WriteString("\n")
+		return
+	}
+	b.WriteString("\n")
+	writeNode(b, indent+2, v)
+}
+
+// flowString renders scalars and empty collections on a single line.
+func flowString(v any) (string, bool) {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Map:
+		return "{}", len(toFields(v)) == 0
+	case reflect.Slice, reflect.Array:
+		if _, ok := v.(Object); ok {
+			return "{}", len(toFields(v)) == 0
+		}
+		return "[]", len(toItems(v)) == 0
+	}
+	return scalarString(v), true
+}
+
+func writeIndent(b *bytes.Buffer, n int) {
+	b.WriteString(strings.Repeat(" ", n))
+}
+
+// toFields returns the entries of a mapping in output order, or nil when v
+// is not a mapping.
+func toFields(v any) []Field {
+	if obj, ok := v.(Object); ok {
+		if obj == nil {
+			return []Field{}
+		}
+		return obj
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map {
+		return nil
+	}
+	fields := make([]Field, 0, rv.Len())
+	for _, k := range rv.MapKeys() {
+		fields = append(fields, Field{fmt.Sprint(k.Interface()), rv.MapIndex(k).Interface()})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields
+}
+
+func toItems(v any) []any {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil
+	}
+	items := make([]any, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items
+}
+
+func scalarString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return yamlScalar(x)
+	case bool:
+		return strconv.FormatBool(x)
+	case int:
+		return strconv.Itoa(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		return yamlScalar(fmt.Sprint(x))
+	}
+}
+
+// yamlScalar quotes strings that a YAML parser would not read back as the
+// same plain string.
+func yamlScalar(s string) string {
+	if strings.ContainsAny(s, "\n\t\"\\") {
+		return strconv.Quote(s)
+	}
+	if needsQuotes(s) {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return s
+}
+
+func needsQuotes(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) {
+		return true
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null", "~":
+		return true
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	if strings.ContainsRune("-?:,[]{}#&*!|>'%@`", rune(s[0])) {
+		return true
+	}
+	return strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":")
+}
@@ -0,0 +1,81 @@
+package openapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestYAMLScalarQuoting(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{"/api/v1/runs/{runID}", "/api/v1/runs/{runID}"},
+		{"200", "'200'"},
+		{"true", "'true'"},
+		{"", "''"},
+		{"#/components/schemas/Run", "'#/components/schemas/Run'"},
+		{"key: value", "'key: value'"},
+		{"it's", "it's"},
+		{"y", "'y'"},
+		{"[x]", "'[x]'"},
+		{"two\nlines", `"two\nlines"`},
+	}
+	for _, tt := range tests {
+		if got := yamlScalar(tt.in); got != tt.want {
+			t.Fatalf("yamlScalar(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMarshalYAML(t *testing.T) {
+	doc := Object{
+		{"b", 1},
+		{"a", map[string]any{"z": true, "items": []string{"x"}, "empty": map[string]any{}}},
+		{"list", []any{Object{{"k", "v"}, {"num", 1.5}}, "s"}},
+		{"none", []string{}},
+	}
+	want := `b: 1
+a:
+  empty: {}
+  items:
+    - x
+  z: true
+list:
+  - k: v
+    num: 1.5
+  - s
+none: []
+`
+	if got := string(marshalYAML(doc)); got != want {
+		t.Fatalf("unexpected yaml:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type testBody struct {
+	Name string `json:"name"`
+}
+
+func TestGenerateOperation(t *testing.T) {
+	out := string(Generate(Info{Title: "T", Version: "1"}, []Operation{{
+		Method:      http.MethodPost,
+		Path:        "/things/{id}",
+		OperationID: "createThing",
+		Summary:     "Create thing",
+		Request:     testBody{},
+		Responses:   []Response{{Status: http.StatusCreated, Body: testBody{}, Envelope: true}},
+	}}))
+	for _, want := range []string{
+		"  /things/{id}:\n    post:\n",
+		"        - in: path\n          name: id\n          required: true\n",
+		"        '201':\n          description: Created\n",
+		"                  - $ref: '#/components/schemas/ToolEnvelope'\n",
+		"    testBody:\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("generated document missing %q:\n%s", want, out)
+		}
+	}
+}
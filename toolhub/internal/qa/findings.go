@@ -0,0 +1,404 @@
+package qa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Lint finding formats understood by the built-in parsers.
+const (
+	FindingFormatGolangciLintJSON = "golangci_lint_json"
+	FindingFormatESLintJSON       = "eslint_json"
+	FindingFormatRuffJSON         = "ruff_json"
+	FindingFormatSARIF            = "sarif"
+)
+
+// Finding severities, named as SARIF levels.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNote    = "note"
+)
+
+// Finding is one lint finding. Path is relative to the work directory with
+// forward slashes, as in the repository.
+type Finding struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	EndLine  int    `json:"end_line,omitempty"`
+	Rule     string `json:"rule,omitempty"`
+	Severity string `json:"severity" jsonschema:"enum=error|warning|note"`
+	Message  string `json:"message"`
+	// Tool is the linter that reported the finding, e.g. eslint, or the
+	// tool named by SARIF input.
+	Tool string `json:"tool,omitempty"`
+}
+
+// LintFindings holds the findings parsed from a lint command.
+type LintFindings struct {
+	Format   string    `json:"format"`
+	Errors   int       `json:"errors"`
+	Warnings int       `json:"warnings"`
+	Notes    int       `json:"notes"`
+	Findings []Finding `json:"findings"`
+	// FilteredOut counts the findings dropped for files outside the
+	// change.
+	FilteredOut int `json:"filtered_out,omitempty"`
+}
+
+func newLintFindings(format string, findings []Finding) *LintFindings {
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Path != findings[j].Path {
+			return findings[i].Path < findings[j].Path
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	res := &LintFindings{Format: format, Findings: findings}
+	for _, f := range findings {
+		switch f.Severity {
+		case SeverityError:
+			res.Errors++
+		case SeverityWarning:
+			res.Warnings++
+		default:
+			res.Notes++
+		}
+	}
+	return res
+}
+
+// Filter returns the findings on the given repository paths; the others are
+// counted in FilteredOut.
+func (l *LintFindings) Filter(paths []string) *LintFindings {
+	keep := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		keep[filepath.ToSlash(filepath.Clean(p))] = true
+	}
+	var kept []Finding
+	for _, f := range l.Findings {
+		if keep[f.Path] {
+			kept = append(kept, f)
+		}
+	}
+	out := newLintFindings(l.Format, kept)
+	out.FilteredOut = l.FilteredOut + len(l.Findings) - len(kept)
+	return out
+}
+
+// FindingParser parses the output of a lint command in one format. Paths
+// may be absolute or relative to the work directory.
+type FindingParser func(data []byte) ([]Finding, error)
+
+var (
+	findingParsersMu sync.RWMutex
+	findingParsers   = map[string]FindingParser{
+		FindingFormatGolangciLintJSON: parseGolangciLintJSON,
+		FindingFormatESLintJSON:       parseESLintJSON,
+		FindingFormatRuffJSON:         parseRuffJSON,
+		FindingFormatSARIF:            parseSARIF,
+	}
+)
+
+// RegisterFindingParser makes parser available as a lint finding format, in
+// addition to or replacing the built-in ones.
+func RegisterFindingParser(format string, parser FindingParser) {
+	findingParsersMu.Lock()
+	defer findingParsersMu.Unlock()
+	findingParsers[format] = parser
+}
+
+func lookupFindingParser(format string) (FindingParser, bool) {
+	findingParsersMu.RLock()
+	defer findingParsersMu.RUnlock()
+	parser, ok := findingParsers[format]
+	return parser, ok
+}
+
+// ParseFindings parses data with the parser registered for format and makes
+// the finding paths relative to workDir.
+func ParseFindings(format string, data []byte, workDir string) (*LintFindings, error) {
+	parser, ok := lookupFindingParser(format)
+	if !ok {
+		return nil, fmt.Errorf("unknown lint finding format %q", format)
+	}
+	findings, err := parser(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s lint findings: %w", format, err)
+	}
+	for i := range findings {
+		findings[i].Path = relativePath(findings[i].Path, workDir)
+		if findings[i].Severity == "" {
+			findings[i].Severity = SeverityWarning
+		}
+	}
+	return newLintFindings(format, findings), nil
+}
+
+func relativePath(path, workDir string) string {
+	if strings.HasPrefix(path, "file://") {
+		if u, err := url.Parse(path); err == nil {
+			path = u.Path
+		}
+	}
+	if filepath.IsAbs(path) && workDir != "" {
+		if rel, err := filepath.Rel(workDir, path); err == nil && filepath.IsLocal(rel) {
+			path = rel
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+func parseGolangciLintJSON(data []byte) ([]Finding, error) {
+	var out struct {
+		Issues []struct {
+			FromLinter string
+			Text       string
+			Severity   string
+			Pos        struct {
+				Filename string
+				Line     int
+				Column   int
+			}
+			LineRange *struct{ To int }
+		}
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	findings := make([]Finding, 0, len(out.Issues))
+	for _, issue := range out.Issues {
+		f := Finding{
+			Path:     issue.Pos.Filename,
+			Line:     issue.Pos.Line,
+			Column:   issue.Pos.Column,
+			Rule:     issue.FromLinter,
+			Severity: normalizeSeverity(issue.Severity),
+			Message:  issue.Text,
+			Tool:     "golangci-lint",
+		}
+		if f.Severity == "" {
+			// golangci-lint leaves severity empty unless configured; its
+			// findings fail the run.
+			f.Severity = SeverityError
+		}
+		if issue.LineRange != nil && issue.LineRange.To > f.Line {
+			f.EndLine = issue.LineRange.To
+		}
+		findings = append(findings, f)
+	}
+	return findings, nil
+}
+
+func parseESLintJSON(data []byte) ([]Finding, error) {
+	var files []struct {
+		FilePath string `json:"filePath"`
+		Messages []struct {
+			RuleID   string `json:"ruleId"`
+			Severity int    `json:"severity"`
+			Message  string `json:"message"`
+			Line     int    `json:"line"`
+			Column   int    `json:"column"`
+			EndLine  int    `json:"endLine"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	for _, file := range files {
+		for _, msg := range file.Messages {
+			severity := SeverityWarning
+			if msg.Severity == 2 {
+				severity = SeverityError
+			}
+			findings = append(findings, Finding{
+				Path:     file.FilePath,
+				Line:     msg.Line,
+				Column:   msg.Column,
+				EndLine:  msg.EndLine,
+				Rule:     msg.RuleID,
+				Severity: severity,
+				Message:  msg.Message,
+				Tool:     "eslint",
+			})
+		}
+	}
+	return findings, nil
+}
+
+func parseRuffJSON(data []byte) ([]Finding, error) {
+	var diagnostics []struct {
+		Code     string `json:"code"`
+		Message  string `json:"message"`
+		Filename string `json:"filename"`
+		Location struct {
+			Row    int `json:"row"`
+			Column int `json:"column"`
+		} `json:"location"`
+		EndLocation struct {
+			Row int `json:"row"`
+		} `json:"end_location"`
+	}
+	if err := json.Unmarshal(data, &diagnostics); err != nil {
+		return nil, err
+	}
+	findings := make([]Finding, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		f := Finding{
+			Path:     d.Filename,
+			Line:     d.Location.Row,
+			Column:   d.Location.Column,
+			Rule:     d.Code,
+			Severity: SeverityError,
+			Message:  d.Message,
+			Tool:     "ruff",
+		}
+		if d.EndLocation.Row > f.Line {
+			f.EndLine = d.EndLocation.Row
+		}
+		findings = append(findings, f)
+	}
+	return findings, nil
+}
+
+// sarifLog is the subset of SARIF 2.1.0 that is read and written.
+type sarifLog struct {
+	Schema  string     `json:"$schema,omitempty"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name string `json:"name"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifResult struct {
+	RuleID  string `json:"ruleId,omitempty"`
+	Level   string `json:"level,omitempty"`
+	Message struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine,omitempty"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+}
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+func parseSARIF(data []byte) ([]Finding, error) {
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, err
+	}
+	if log.Version != "" && log.Version != "2.1.0" {
+		return nil, fmt.Errorf("unsupported sarif version %q", log.Version)
+	}
+	var findings []Finding
+	for _, run := range log.Runs {
+		for _, res := range run.Results {
+			f := Finding{
+				Rule:     res.RuleID,
+				Severity: normalizeSeverity(res.Level),
+				Message:  res.Message.Text,
+				Tool:     run.Tool.Driver.Name,
+			}
+			if f.Severity == "" {
+				// SARIF's default level.
+				f.Severity = SeverityWarning
+			}
+			if len(res.Locations) > 0 {
+				loc := res.Locations[0].PhysicalLocation
+				f.Path = loc.ArtifactLocation.URI
+				if loc.Region != nil {
+					f.Line, f.Column = loc.Region.StartLine, loc.Region.StartColumn
+					if loc.Region.EndLine > f.Line {
+						f.EndLine = loc.Region.EndLine
+					}
+				}
+			}
+			if f.Path == "" {
+				// Findings without a file, e.g. on the whole project, cannot be
+				// placed in a change.
+				continue
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+func normalizeSeverity(level string) string {
+	switch strings.ToLower(level) {
+	case "error", "fatal":
+		return SeverityError
+	case "warning", "warn":
+		return SeverityWarning
+	case "note", "info", "none":
+		return SeverityNote
+	default:
+		return ""
+	}
+}
+
+// SARIF renders findings as a SARIF 2.1.0 log with one run per tool, or a
+// single empty run when there are none.
+func SARIF(findings []Finding) ([]byte, error) {
+	log := sarifLog{Schema: sarifSchema, Version: "2.1.0", Runs: []sarifRun{}}
+	runs := make(map[string]int)
+	for _, f := range findings {
+		tool := f.Tool
+		if tool == "" {
+			tool = "lint"
+		}
+		idx, ok := runs[tool]
+		if !ok {
+			idx = len(log.Runs)
+			runs[tool] = idx
+			var run sarifRun
+			run.Tool.Driver.Name = tool
+			run.Results = []sarifResult{}
+			log.Runs = append(log.Runs, run)
+		}
+		res := sarifResult{RuleID: f.Rule, Level: f.Severity}
+		res.Message.Text = f.Message
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = f.Path
+		if f.Line > 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{StartLine: f.Line, StartColumn: f.Column, EndLine: f.EndLine}
+		}
+		res.Locations = []sarifLocation{loc}
+		log.Runs[idx].Results = append(log.Runs[idx].Results, res)
+	}
+	if len(log.Runs) == 0 {
+		// A clean lint run still produces a log, with an empty run.
+		var run sarifRun
+		run.Tool.Driver.Name = "lint"
+		run.Results = []sarifResult{}
+		log.Runs = append(log.Runs, run)
+	}
+	return json.MarshalIndent(log, "", "  ")
+}
@@ -0,0 +1,148 @@
+package qa
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFindings(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		data      string
+		want      []Finding
+		wantError string
+	}{
+		{
+			name:   "golangci-lint",
+			format: FindingFormatGolangciLintJSON,
+			data: `{"Issues":[
+  {"FromLinter":"errcheck","Text":"Error return value is not checked","Severity":"","Pos":{"Filename":"pkg/b.go","Line":12,"Column":3}},
+  {"FromLinter":"gocritic","Text":"ifElseChain","Severity":"warning","Pos":{"Filename":"pkg/a.go","Line":4,"Column":1},"LineRange":{"From":4,"To":9}}
+],"Report":{}}`,
+			want: []Finding{
+				{Path: "pkg/a.go", Line: 4, Column: 1, EndLine: 9, Rule: "gocritic", Severity: SeverityWarning, Message: "ifElseChain", Tool: "golangci-lint"},
+				{Path: "pkg/b.go", Line: 12, Column: 3, Rule: "errcheck", Severity: SeverityError, Message: "Error return value is not checked", Tool: "golangci-lint"},
+			},
+		},
+		{
+			name:   "eslint",
+			format: FindingFormatESLintJSON,
+			data: `[{"filePath":"/work/src/app.js","messages":[
+  {"ruleId":"no-unused-vars","severity":2,"message":"'x' is defined but never used.","line":3,"column":7,"endLine":3},
+  {"ruleId":"eqeqeq","severity":1,"message":"Expected '==='.","line":8,"column":9}
+]},{"filePath":"/work/src/clean.js","messages":[]}]`,
+			want: []Finding{
+				{Path: "src/app.js", Line: 3, Column: 7, EndLine: 3, Rule: "no-unused-vars", Severity: SeverityError, Message: "'x' is defined but never used.", Tool: "eslint"},
+				{Path: "src/app.js", Line: 8, Column: 9, Rule: "eqeqeq", Severity: SeverityWarning, Message: "Expected '==='.", Tool: "eslint"},
+			},
+		},
+		{
+			name:   "ruff",
+			format: FindingFormatRuffJSON,
+			data:   `[{"code":"F401","message":"'os' imported but unused","filename":"/work/app/main.py","location":{"row":1,"column":8},"end_location":{"row":1,"column":10}}]`,
+			want: []Finding{
+				{Path: "app/main.py", Line: 1, Column: 8, Rule: "F401", Severity: SeverityError, Message: "'os' imported but unused", Tool: "ruff"},
+			},
+		},
+		{
+			name:   "sarif",
+			format: FindingFormatSARIF,
+			data: `{"version":"2.1.0","runs":[{"tool":{"driver":{"name":"semgrep"}},"results":[
+  {"ruleId":"go.lang.sql","level":"note","message":{"text":"raw SQL"},"locations":[{"physicalLocation":{"artifactLocation":{"uri":"file:///work/db/q.go"},"region":{"startLine":5,"startColumn":2,"endLine":7}}}]},
+  {"ruleId":"repo.wide","message":{"text":"no location"}}
+]}]}`,
+			want: []Finding{
+				{Path: "db/q.go", Line: 5, Column: 2, EndLine: 7, Rule: "go.lang.sql", Severity: SeverityNote, Message: "raw SQL", Tool: "semgrep"},
+			},
+		},
+		{
+			name:      "sarif version",
+			format:    FindingFormatSARIF,
+			data:      `{"version":"1.0.0","runs":[]}`,
+			wantError: "unsupported sarif version",
+		},
+		{
+			name:      "not json",
+			format:    FindingFormatESLintJSON,
+			data:      "2 problems",
+			wantError: "parse eslint_json lint findings",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseFindings(tt.format, []byte(tt.data), "/work")
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("err = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !reflect.DeepEqual(res.Findings, tt.want) {
+				t.Fatalf("findings = %+v\nwant       %+v", res.Findings, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindingsFilterAndSARIF(t *testing.T) {
+	all := newLintFindings(FindingFormatRuffJSON, []Finding{
+		{Path: "a.py", Line: 1, Rule: "F401", Severity: SeverityError, Message: "unused", Tool: "ruff"},
+		{Path: "b.py", Line: 2, Rule: "E501", Severity: SeverityWarning, Message: "long", Tool: "ruff"},
+		{Path: "c.py", Line: 3, Rule: "E711", Severity: SeverityError, Message: "none", Tool: "ruff"},
+	})
+	changed := all.Filter([]string{"./a.py", "b.py"})
+	if len(changed.Findings) != 2 || changed.FilteredOut != 1 || changed.Errors != 1 || changed.Warnings != 1 {
+		t.Fatalf("filtered = %+v", changed)
+	}
+
+	data, err := SARIF(changed.Findings)
+	if err != nil {
+		t.Fatalf("render sarif: %v", err)
+	}
+	back, err := ParseFindings(FindingFormatSARIF, data, "")
+	if err != nil {
+		t.Fatalf("parse rendered sarif: %v", err)
+	}
+	if !reflect.DeepEqual(back.Findings, changed.Findings) {
+		t.Fatalf("round trip = %+v, want %+v", back.Findings, changed.Findings)
+	}
+
+	empty, err := SARIF(nil)
+	if err != nil || !strings.Contains(string(empty), `"results": []`) {
+		t.Fatalf("empty sarif = %s, %v", empty, err)
+	}
+}
+
+func TestRunnerParsesFindings(t *testing.T) {
+	r, err := NewRunner(Config{
+		WorkDir:            t.TempDir(),
+		TestCmd:            "true",
+		LintCmd:            `printf '%s' '[{"code":"F401","message":"unused","filename":"a.py","location":{"row":1,"column":1}}]'`,
+		Timeout:            5 * time.Second,
+		AllowedExecutables: []string{"true", "printf"},
+		LintResultFormat:   FindingFormatRuffJSON,
+	})
+	if err != nil {
+		t.Fatalf("new runner: %v", err)
+	}
+	report, err := r.Run(context.Background(), KindLint, false)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if report.Findings == nil || report.Findings.Errors != 1 || report.Findings.Findings[0].Path != "a.py" {
+		t.Fatalf("findings = %+v, error = %q", report.Findings, report.FindingsError)
+	}
+	if got := GenerateSummary(StatusPass, report); !strings.Contains(got, "1 errors, 0 warnings") {
+		t.Fatalf("summary = %q", got)
+	}
+	if report, _ := r.Run(context.Background(), KindTest, false); report.Findings != nil || report.TestResults != nil {
+		t.Fatalf("test run parsed results: %+v", report)
+	}
+}
@@ -0,0 +1,181 @@
+package qa
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TestRerun is one rerun of the tests that were still failing.
+type TestRerun struct {
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	// Passed and Failed are the IDs of the rerun tests that passed and
+	// failed again.
+	Passed []string `json:"passed"`
+	Failed []string `json:"failed"`
+	// Error says why the rerun could not run or produced no results.
+	Error string `json:"error,omitempty"`
+}
+
+// RerunBuilder turns the arguments of a test command into a command that
+// runs only the failed tests, for results in one format. Arguments are
+// passed to the executable without a shell.
+type RerunBuilder func(args []string, failed []TestCase) ([]string, error)
+
+var (
+	rerunBuildersMu sync.RWMutex
+	rerunBuilders   = map[string]RerunBuilder{
+		ResultFormatGoTestJSON: rerunGoTests,
+		ResultFormatPytestJSON: rerunPytest,
+	}
+)
+
+// RegisterRerunBuilder makes failed tests with results in format
+// rerunnable, in addition to or replacing the built-in builders.
+func RegisterRerunBuilder(format string, builder RerunBuilder) {
+	rerunBuildersMu.Lock()
+	defer rerunBuildersMu.Unlock()
+	rerunBuilders[format] = builder
+}
+
+func lookupRerunBuilder(format string) (RerunBuilder, bool) {
+	rerunBuildersMu.RLock()
+	defer rerunBuildersMu.RUnlock()
+	builder, ok := rerunBuilders[format]
+	return builder, ok
+}
+
+// rerunFailed reruns the failed tests of report, run with args, up to
+// times times or until none fails, and marks those that pass on a rerun as
+// flaky. The run's own status is unaffected.
+func rerunFailed(ctx context.Context, t *target, wd string, args []string, report *Report, times int) {
+	results := report.TestResults
+	builder, ok := lookupRerunBuilder(t.resultFormat)
+	if !ok {
+		report.TestRerunsError = fmt.Sprintf("tests in %s format cannot be rerun", t.resultFormat)
+		return
+	}
+	failing := map[string]bool{}
+	for _, id := range results.FailedIDs() {
+		failing[id] = true
+	}
+	flaky := map[string]bool{}
+	for attempt := 0; attempt < times && len(failing) > 0; attempt++ {
+		var failed []TestCase
+		for _, tc := range results.Tests {
+			if failing[tc.ID()] {
+				failed = append(failed, tc)
+			}
+		}
+		rerunArgs, err := builder(append([]string(nil), args...), failed)
+		if err == nil && (len(rerunArgs) == 0 || rerunArgs[0] != args[0]) {
+			err = fmt.Errorf("rerun builder changed the executable")
+		}
+		if err != nil {
+			report.TestRerunsError = err.Error()
+			break
+		}
+		if t.resultFile != "" {
+			_ = os.Remove(filepath.Join(wd, t.resultFile))
+		}
+		rerunCmd := joinCommandLine(rerunArgs)
+		run, runErr := t.exec(ctx, rerunCmd, rerunArgs, wd)
+		rerun := TestRerun{Command: rerunCmd, ExitCode: run.ExitCode, DurationMS: run.DurationMS, Passed: []string{}, Failed: []string{}}
+		if ctx.Err() != nil {
+			rerun.Error = cancelledError(ctx).Error()
+			report.TestReruns = append(report.TestReruns, rerun)
+			break
+		}
+		attachResults(&run, KindTest, t.resultFormat, t.resultFile, wd)
+		if run.TestResults == nil {
+			rerun.Error = run.TestResultsError
+			if runErr != nil {
+				rerun.Error = runErr.Error() + ": " + rerun.Error
+			}
+			report.TestReruns = append(report.TestReruns, rerun)
+			break
+		}
+		for _, tc := range run.TestResults.Tests {
+			id := tc.ID()
+			switch {
+			case !failing[id]:
+			case tc.Status == TestPass:
+				rerun.Passed = append(rerun.Passed, id)
+				flaky[id] = true
+				delete(failing, id)
+			case tc.Status == TestFail:
+				rerun.Failed = append(rerun.Failed, id)
+			}
+		}
+		sort.Strings(rerun.Passed)
+		sort.Strings(rerun.Failed)
+		report.TestReruns = append(report.TestReruns, rerun)
+	}
+	for i, tc := range results.Tests {
+		if flaky[tc.ID()] {
+			results.Tests[i].Flaky = true
+			results.Flaky++
+		}
+	}
+}
+
+// rerunGoTests reruns the failed top-level tests of a go test command in
+// their packages with -run; the command's own packages and -run flags are
+// replaced. Packages that failed without a failing test, e.g. because they
+// did not build, are not rerun.
+func rerunGoTests(args []string, failed []TestCase) ([]string, error) {
+	cmd, err := parseGoTestCommand(args)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs, names []string
+	for _, tc := range failed {
+		if tc.Name == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(tc.Name, "/")
+		if !slices.Contains(names, regexp.QuoteMeta(name)) {
+			names = append(names, regexp.QuoteMeta(name))
+		}
+		if !slices.Contains(pkgs, tc.Suite) {
+			pkgs = append(pkgs, tc.Suite)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no failed go test can be rerun")
+	}
+	sort.Strings(pkgs)
+	sort.Strings(names)
+
+	out := append([]string(nil), args[:cmd.test+1]...)
+	out = append(out, "-run=^("+strings.Join(names, "|")+")$")
+	out = append(out, pkgs...)
+	for j := cmd.test + 1; j < len(args); j++ {
+		if !slices.Contains(cmd.packages, j) && !slices.Contains(cmd.run, j) {
+			out = append(out, args[j])
+		}
+	}
+	return out, nil
+}
+
+// rerunPytest reruns the failed tests of a pytest command with --lf, from
+// the failures pytest recorded in its cache on the previous run.
+func rerunPytest(args []string, failed []TestCase) ([]string, error) {
+	for i, arg := range args {
+		if arg == "no:cacheprovider" && i > 0 && args[i-1] == "-p" || arg == "-pno:cacheprovider" {
+			return nil, fmt.Errorf("pytest reruns need the cacheprovider plugin")
+		}
+	}
+	if slices.Contains(args, "--lf") || slices.Contains(args, "--last-failed") {
+		return args, nil
+	}
+	return append(args, "--lf"), nil
+}
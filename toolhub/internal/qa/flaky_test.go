@@ -0,0 +1,126 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRerunGoTests(t *testing.T) {
+	failed := []TestCase{
+		{Suite: "example.com/m/a", Name: "TestA/sub", Status: TestFail},
+		{Suite: "example.com/m/a", Name: "TestA", Status: TestFail},
+		{Suite: "example.com/m/b", Name: "TestB", Status: TestFail},
+		{Suite: "example.com/m/c", Status: TestFail},
+	}
+	args := []string{"go", "-C", "mod", "test", "-json", "-run", "Test", "./...", "-count=1", "-args", "-v"}
+	got, err := rerunGoTests(args, failed)
+	if err != nil {
+		t.Fatalf("rerun: %v", err)
+	}
+	want := "go -C mod test -run=^(TestA|TestB)$ example.com/m/a example.com/m/b -json -count=1 -args -v"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("args = %q, want %q", strings.Join(got, " "), want)
+	}
+
+	if _, err := rerunGoTests(args, failed[3:]); err == nil {
+		t.Fatal("package failure without tests was rerun")
+	}
+	if got, err := rerunPytest([]string{"pytest", "-q"}, nil); err != nil || strings.Join(got, " ") != "pytest -q --lf" {
+		t.Fatalf("pytest rerun = %q, %v", got, err)
+	}
+	if _, err := rerunPytest([]string{"pytest", "-p", "no:cacheprovider"}, nil); err == nil {
+		t.Fatal("pytest without cache was rerun")
+	}
+}
+
+func TestRunnerRerunsFailedTests(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"mod/go.mod": "module example.com/m\n\ngo 1.21\n",
+		// TestFlaky fails until its second run; TestBroken always fails.
+		"mod/a/a_test.go": `package a
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFlaky(t *testing.T) {
+	if _, err := os.Stat("ran"); err != nil {
+		os.WriteFile("ran", nil, 0o644)
+		t.Fatal("first run fails")
+	}
+}
+
+func TestBroken(t *testing.T) { t.Fatal("always fails") }
+
+func TestOK(t *testing.T) {}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewRunner(Config{WorkDir: dir, TestCmd: "go -C mod test -json ./...", TestResultFormat: ResultFormatGoTestJSON, TestMaxReruns: 2})
+	if err != nil {
+		t.Fatalf("new runner: %v", err)
+	}
+
+	report, err := r.RunWith(context.Background(), KindTest, dir, RunOptions{RerunFailed: 5}, false)
+	var qaErr *QAError
+	if !errors.As(err, &qaErr) || qaErr.ErrCode != ErrCodeExecFailed {
+		t.Fatalf("err = %v, want %s", err, ErrCodeExecFailed)
+	}
+	res := report.TestResults
+	if res == nil || res.Failed != 2 || res.Flaky != 1 || res.FlakyOnly() {
+		t.Fatalf("results = %+v", res)
+	}
+	if ids := strings.Join(res.FailedIDs(), ","); ids != "example.com/m/a::TestBroken" {
+		t.Fatalf("failed ids = %s", ids)
+	}
+	if len(report.TestReruns) != 2 {
+		t.Fatalf("reruns = %+v", report.TestReruns)
+	}
+	first, second := report.TestReruns[0], report.TestReruns[1]
+	if strings.Join(first.Passed, ",") != "example.com/m/a::TestFlaky" || strings.Join(first.Failed, ",") != "example.com/m/a::TestBroken" {
+		t.Fatalf("first rerun = %+v", first)
+	}
+	if !strings.Contains(second.Command, "-run=^(TestBroken)$") || len(second.Passed) != 0 {
+		t.Fatalf("second rerun = %+v", second)
+	}
+	if !strings.Contains(GenerateSummary(StatusFail, report), "1 flaky") {
+		t.Fatalf("summary = %s", GenerateSummary(StatusFail, report))
+	}
+
+	report, _ = r.RunInDir(context.Background(), KindTest, dir, false)
+	if report.TestReruns != nil {
+		t.Fatalf("reruns without RerunFailed = %+v", report.TestReruns)
+	}
+}
+
+func TestTestResultsFlakyOnly(t *testing.T) {
+	res := newTestResults(ResultFormatGoTestJSON, []TestCase{
+		{Suite: "p", Name: "TestA", Status: TestFail, Flaky: true},
+		{Suite: "p", Name: "TestB", Status: TestPass},
+	})
+	res.Flaky = 1
+	if !res.FlakyOnly() || len(res.FailedIDs()) != 0 {
+		t.Fatalf("flaky only = %v, failed = %v", res.FlakyOnly(), res.FailedIDs())
+	}
+	if fixed, regressed := CompareFailures(nil, res); len(fixed) != 0 || len(regressed) != 0 {
+		t.Fatalf("fixed = %v, regressed = %v", fixed, regressed)
+	}
+	var none *TestResults
+	if none.FlakyOnly() {
+		t.Fatal("nil results are flaky only")
+	}
+}
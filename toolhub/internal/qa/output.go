@@ -0,0 +1,148 @@
+package qa
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// truncationNotice ends output cut at MaxOutputBytes.
+const truncationNotice = "\n[output truncated]"
+
+// maxResultOutputBytes bounds the stdout kept, past MaxOutputBytes, to parse
+// test results or lint findings from.
+const maxResultOutputBytes = 64 << 20
+
+// OutputFunc receives command output while a QA command runs. stdout and
+// stderr are written concurrently, so it must be safe for concurrent use.
+type OutputFunc func(stream, data string)
+
+type outputFuncKey struct{}
+
+// WithOutputFunc returns a context whose QA runs stream their output to fn.
+// A nil fn stops streaming for runs started with the returned context.
+func WithOutputFunc(ctx context.Context, fn OutputFunc) context.Context {
+	return context.WithValue(ctx, outputFuncKey{}, fn)
+}
+
+func outputFuncFromContext(ctx context.Context) OutputFunc {
+	fn, _ := ctx.Value(outputFuncKey{}).(OutputFunc)
+	return fn
+}
+
+// commandOutput wires the stdout and stderr of a QA command into buffers for
+// the report and, when ctx carries an OutputFunc, into live streams. The
+// concatenated stream data equals the report output, truncation notice
+// included. flush must be called once the command has exited.
+func commandOutput(ctx context.Context, maxBytes int, stdoutBuf, stderrBuf *cappedBuffer) (stdout, stderr io.Writer, flush func()) {
+	fn := outputFuncFromContext(ctx)
+	if fn == nil {
+		return stdoutBuf, stderrBuf, func() {}
+	}
+	out := newOutputStream(StreamStdout, maxBytes, fn)
+	errOut := newOutputStream(StreamStderr, maxBytes, fn)
+	return io.MultiWriter(stdoutBuf, out), io.MultiWriter(stderrBuf, errOut), func() {
+		out.flush()
+		errOut.flush()
+	}
+}
+
+// cappedBuffer keeps the first limit bytes written to it and drops the
+// rest, so a command cannot make the server hold all of its output.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	limit   int
+	dropped bool
+}
+
+// newOutputBuffers returns the buffers for the stdout and stderr of a
+// command whose report shows maxBytes of each: stderr only needs to show
+// that it went past them, stdout is kept for result parsing as well.
+func newOutputBuffers(maxBytes int) (stdout, stderr *cappedBuffer) {
+	report := maxResultOutputBytes
+	if maxBytes > 0 {
+		report = maxBytes + 1
+	}
+	return &cappedBuffer{limit: max(report, maxResultOutputBytes)}, &cappedBuffer{limit: report}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.limit - b.buf.Len(); room < n {
+		b.dropped = true
+		p = p[:max(room, 0)]
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *cappedBuffer) String() string { return b.buf.String() }
+
+// outputStream forwards one output stream with the semantics of
+// truncateOutput. Output is only known to exceed maxBytes once it does, so
+// the tail that the truncation notice would replace is held back until the
+// stream either overflows (the notice is sent instead) or ends (the tail is
+// sent as is).
+type outputStream struct {
+	name     string
+	fn       OutputFunc
+	keep     int
+	maxBytes int
+
+	mu        sync.Mutex
+	written   int
+	held      []byte
+	truncated bool
+}
+
+func newOutputStream(name string, maxBytes int, fn OutputFunc) *outputStream {
+	keep := maxBytes - len(truncationNotice)
+	if keep < 0 {
+		keep = 0
+	}
+	return &outputStream{name: name, fn: fn, keep: keep, maxBytes: maxBytes}
+}
+
+func (s *outputStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.truncated || len(p) == 0 {
+		return len(p), nil
+	}
+	n := len(p)
+	if s.written < s.keep {
+		take := min(s.keep-s.written, len(p))
+		s.fn(s.name, string(p[:take]))
+		s.written += take
+		p = p[take:]
+	}
+	s.held = append(s.held, p...)
+	if s.written+len(s.held) > s.maxBytes {
+		s.truncated = true
+		s.held = nil
+		notice := truncationNotice
+		if s.maxBytes < len(notice) {
+			notice = notice[:s.maxBytes]
+		}
+		if notice != "" {
+			s.fn(s.name, notice)
+		}
+	}
+	return n, nil
+}
+
+func (s *outputStream) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.truncated && len(s.held) > 0 {
+		s.fn(s.name, string(s.held))
+		s.written += len(s.held)
+	}
+	s.held = nil
+}
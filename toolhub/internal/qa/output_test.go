@@ -0,0 +1,101 @@
+package qa
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutputStreamMatchesTruncation(t *testing.T) {
+	long := strings.Repeat("0123456789", 10)
+	cases := []struct {
+		name     string
+		text     string
+		maxBytes int
+		chunk    int
+	}{
+		{name: "under limit", text: "hello\n", maxBytes: 64, chunk: 2},
+		{name: "exactly limit", text: long[:40], maxBytes: 40, chunk: 7},
+		{name: "within notice of limit", text: long[:35], maxBytes: 40, chunk: 3},
+		{name: "over limit", text: long, maxBytes: 40, chunk: 9},
+		{name: "over limit single write", text: long, maxBytes: 40, chunk: len(long)},
+		{name: "limit below notice", text: long, maxBytes: 5, chunk: 4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got strings.Builder
+			s := newOutputStream(StreamStdout, tc.maxBytes, func(stream, data string) {
+				if stream != StreamStdout {
+					t.Fatalf("stream = %q", stream)
+				}
+				got.WriteString(data)
+			})
+			for i := 0; i < len(tc.text); i += tc.chunk {
+				end := min(i+tc.chunk, len(tc.text))
+				if n, err := s.Write([]byte(tc.text[i:end])); err != nil || n != end-i {
+					t.Fatalf("write = %d, %v", n, err)
+				}
+			}
+			s.flush()
+			want, _ := truncateOutput(tc.text, tc.maxBytes)
+			if got.String() != want {
+				t.Fatalf("streamed %q, want %q", got.String(), want)
+			}
+		})
+	}
+}
+
+func TestRunnerStreamsOutput(t *testing.T) {
+	r, err := NewRunner(Config{WorkDir: ".", TestCmd: "echo streamed", LintCmd: "go test ./...", Timeout: 5 * time.Second, AllowedExecutables: []string{"echo", "go"}})
+	if err != nil {
+		t.Fatalf("new runner should not fail: %v", err)
+	}
+	var mu sync.Mutex
+	streamed := map[string]string{}
+	ctx := WithOutputFunc(context.Background(), func(stream, data string) {
+		mu.Lock()
+		defer mu.Unlock()
+		streamed[stream] += data
+	})
+	report, err := r.Run(ctx, KindTest, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if streamed[StreamStdout] != report.Stdout || streamed[StreamStderr] != report.Stderr {
+		t.Fatalf("streamed %q, report stdout %q stderr %q", streamed, report.Stdout, report.Stderr)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{limit: 8}
+	for _, chunk := range []string{"abc", "defgh", "ijk"} {
+		if n, err := b.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("write %q = %d, %v", chunk, n, err)
+		}
+	}
+	if b.String() != "abcdefgh" || !b.dropped {
+		t.Fatalf("buffer = %q, dropped %v", b.String(), b.dropped)
+	}
+
+	stdout, stderr := newOutputBuffers(30)
+	if stdout.limit != maxResultOutputBytes || stderr.limit != 31 {
+		t.Fatalf("limits = %d, %d", stdout.limit, stderr.limit)
+	}
+}
+
+func TestAttachResultsSkipsCappedOutput(t *testing.T) {
+	line := `{"Action":"pass","Package":"p","Test":"TestA"}` + "\n"
+	report := Report{rawStdout: line, rawStdoutCapped: true}
+	attachResults(&report, KindTest, ResultFormatGoTestJSON, "", ".")
+	if report.TestResults != nil || !strings.Contains(report.TestResultsError, "not parsed") {
+		t.Fatalf("results = %+v, error %q", report.TestResults, report.TestResultsError)
+	}
+
+	report = Report{rawStdout: line}
+	attachResults(&report, KindTest, ResultFormatGoTestJSON, "", ".")
+	if report.TestResults == nil || report.TestResultsError != "" {
+		t.Fatalf("results = %+v, error %q", report.TestResults, report.TestResultsError)
+	}
+}
@@ -0,0 +1,271 @@
+package qa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RepoConfigFile is the file a repository commits its QA configuration in,
+// read from the base branch.
+const RepoConfigFile = ".toolhub.yml"
+
+// Where the commands of a runner come from, as reported in
+// Report.ConfigSource.
+const (
+	ConfigSourceServer     = "server"
+	ConfigSourcePolicy     = "policy"
+	ConfigSourceRepository = "repository"
+)
+
+// RepoConfig is the QA configuration of one repository. Its targets replace
+// the server's target of the same name, test and lint included, or add new
+// ones; see Runner.WithRepoConfig for what they may change.
+type RepoConfig struct {
+	Targets []TargetConfig
+}
+
+// ParseRepoConfig reads a repository's .toolhub.yml, YAML or JSON. Its qa
+// section maps target names to their settings:
+//
+//	qa:
+//	  test:
+//	    command: pytest -q --junitxml=junit.xml
+//	    result_format: junit
+//	    result_file: junit.xml
+//	  typecheck:
+//	    command: npx tsc --noEmit
+//	    timeout_seconds: 120
+//
+// Other top-level sections are ignored.
+func ParseRepoConfig(data []byte) (*RepoConfig, error) {
+	doc, err := decodeConfig(data)
+	if err != nil {
+		return nil, repoConfigError("%v", err)
+	}
+	root, ok := doc.(map[string]any)
+	if doc != nil && !ok {
+		return nil, repoConfigError("expected a mapping at the top level")
+	}
+	return repoConfigFromValue(root["qa"])
+}
+
+// ParseRepoConfigs reads the central QA policy file, YAML or JSON: its
+// repos section maps full repository names to a configuration in the form
+// of .toolhub.yml.
+//
+//	repos:
+//	  acme/web:
+//	    qa:
+//	      test:
+//	        command: npm test
+func ParseRepoConfigs(data []byte) (map[string]*RepoConfig, error) {
+	doc, err := decodeConfig(data)
+	if err != nil {
+		return nil, repoConfigError("%v", err)
+	}
+	root, ok := doc.(map[string]any)
+	if doc != nil && !ok {
+		return nil, repoConfigError("expected a mapping at the top level")
+	}
+	repos, ok := root["repos"].(map[string]any)
+	if root["repos"] != nil && !ok {
+		return nil, repoConfigError("repos must be a mapping of repository names")
+	}
+	out := make(map[string]*RepoConfig, len(repos))
+	for repo, v := range repos {
+		if strings.Count(repo, "/") != 1 {
+			return nil, repoConfigError("repository %q must be owner/name", repo)
+		}
+		section, ok := v.(map[string]any)
+		if v != nil && !ok {
+			return nil, repoConfigError("%s: expected a mapping", repo)
+		}
+		rc, err := repoConfigFromValue(section["qa"])
+		if err != nil {
+			return nil, repoConfigError("%s: %v", repo, err)
+		}
+		out[repo] = rc
+	}
+	return out, nil
+}
+
+func decodeConfig(data []byte) (any, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var doc any
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+	return parseYAML(data)
+}
+
+func repoConfigFromValue(v any) (*RepoConfig, error) {
+	rc := &RepoConfig{}
+	if v == nil {
+		return rc, nil
+	}
+	targets, ok := v.(map[string]any)
+	if !ok {
+		return nil, repoConfigError("qa must be a mapping of target names")
+	}
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		settings, ok := targets[name].(map[string]any)
+		if !ok {
+			return nil, repoConfigError("qa.%s must be a mapping", name)
+		}
+		tc := TargetConfig{Name: name}
+		for key, val := range settings {
+			var err error
+			switch key {
+			case "command":
+				tc.Command, err = configString(val)
+			case "timeout_seconds":
+				var secs int
+				secs, err = configInt(val)
+				tc.Timeout = time.Duration(secs) * time.Second
+			case "max_output_bytes":
+				tc.MaxOutputBytes, err = configInt(val)
+			case "allowed_executables":
+				tc.AllowedExecutables, err = configStrings(val)
+			case "backend":
+				tc.Backend, err = configString(val)
+			case "result_format":
+				tc.ResultFormat, err = configString(val)
+			case "result_file":
+				tc.ResultFile, err = configString(val)
+			default:
+				err = fmt.Errorf("unknown setting")
+			}
+			if err != nil {
+				return nil, repoConfigError("qa.%s.%s: %v", name, key, err)
+			}
+		}
+		rc.Targets = append(rc.Targets, tc)
+	}
+	return rc, nil
+}
+
+func configString(v any) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("expected a string")
+	}
+}
+
+func configInt(v any) (int, error) {
+	switch x := v.(type) {
+	case string:
+		n, err := strconv.Atoi(x)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("expected a non-negative integer")
+		}
+		return n, nil
+	case float64:
+		if x < 0 || x != float64(int(x)) {
+			return 0, fmt.Errorf("expected a non-negative integer")
+		}
+		return int(x), nil
+	default:
+		return 0, fmt.Errorf("expected a non-negative integer")
+	}
+}
+
+func configStrings(v any) ([]string, error) {
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a list of strings")
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a list of strings")
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func repoConfigError(format string, args ...any) error {
+	return &QAError{ErrCode: ErrCodeRepoConfigInvalid, Detail: "invalid qa repo config: " + fmt.Sprintf(format, args...)}
+}
+
+// WithRepoConfig returns a runner that uses rc on top of this runner's
+// targets and reports source as its ConfigSource. The server configuration
+// has the final say: commands must use executables this runner allows for
+// the target (the QA_ALLOWED_EXECUTABLES list for new targets), a
+// repository's allowed_executables can only narrow that list, timeouts and
+// output limits cannot exceed the server's, and a sandboxed target cannot
+// be moved to the local backend. The runner shares this runner's
+// concurrency limit.
+func (r *Runner) WithRepoConfig(rc *RepoConfig, source string) (*Runner, error) {
+	derived := &Runner{
+		cfg:                r.cfg,
+		allowedExecutables: r.allowedExecutables,
+		semaphore:          r.semaphore,
+		targets:            make(map[string]*target, len(r.targets)+len(rc.Targets)),
+		order:              append([]string(nil), r.order...),
+		source:             source,
+	}
+	for name, t := range r.targets {
+		derived.targets[name] = t
+	}
+	for _, tc := range rc.Targets {
+		if !targetNamePattern.MatchString(tc.Name) {
+			return nil, repoConfigError("invalid qa target name %q", tc.Name)
+		}
+		base, exists := r.targets[tc.Name]
+		t, err := r.repoTarget(base, tc)
+		if err != nil {
+			return nil, err
+		}
+		derived.targets[tc.Name] = t
+		if !exists {
+			derived.order = append(derived.order, tc.Name)
+		}
+	}
+	return derived, nil
+}
+
+// repoTarget builds the target of a repository setting within the limits
+// of base, the server's target of that name, or of the runner's defaults.
+func (r *Runner) repoTarget(base *target, tc TargetConfig) (*target, error) {
+	limits := &target{timeout: r.cfg.Timeout, maxOutputBytes: r.cfg.MaxOutputBytes, allowed: r.allowedExecutables, backend: r.cfg.Backend}
+	if base != nil {
+		limits = base
+	}
+	if tc.Timeout > limits.timeout {
+		return nil, repoConfigError("qa.%s.timeout_seconds exceeds the server limit of %d", tc.Name, int(limits.timeout/time.Second))
+	}
+	if tc.MaxOutputBytes > limits.maxOutputBytes {
+		return nil, repoConfigError("qa.%s.max_output_bytes exceeds the server limit of %d", tc.Name, limits.maxOutputBytes)
+	}
+	for _, exe := range tc.AllowedExecutables {
+		if !limits.allowed[strings.TrimSpace(exe)] {
+			return nil, &QAError{ErrCode: ErrCodeCommandNotAllowed, Detail: fmt.Sprintf("qa.%s: executable %q is not in the server allowlist", tc.Name, exe)}
+		}
+	}
+	backend := strings.TrimSpace(tc.Backend)
+	if backend == "local" && limits.backend == "sandbox" {
+		return nil, repoConfigError("qa.%s cannot move a sandboxed target to the local backend", tc.Name)
+	}
+	cfg := r.cfg
+	cfg.Timeout, cfg.MaxOutputBytes, cfg.Backend = limits.timeout, limits.maxOutputBytes, limits.backend
+	return newTarget(cfg, limits.allowed, tc)
+}
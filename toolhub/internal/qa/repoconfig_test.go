@@ -0,0 +1,150 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRepoConfig(t *testing.T) {
+	rc, err := ParseRepoConfig([]byte(`
+ci: ignored
+qa:
+  test:
+    command: go test -json ./...
+    result_format: go_test_json
+  typecheck:
+    command: npx tsc --noEmit
+    timeout_seconds: 120
+    allowed_executables: [npx]
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rc.Targets) != 2 || rc.Targets[0].Name != "test" || rc.Targets[1].Name != "typecheck" {
+		t.Fatalf("targets = %+v", rc.Targets)
+	}
+	if rc.Targets[1].Timeout != 2*time.Minute || len(rc.Targets[1].AllowedExecutables) != 1 || rc.Targets[0].ResultFormat != ResultFormatGoTestJSON {
+		t.Fatalf("targets = %+v", rc.Targets)
+	}
+
+	json, err := ParseRepoConfig([]byte(`{"qa": {"build": {"command": "make build", "timeout_seconds": 60}}}`))
+	if err != nil || len(json.Targets) != 1 || json.Targets[0].Timeout != time.Minute {
+		t.Fatalf("json config = %+v, %v", json, err)
+	}
+
+	for _, bad := range []string{
+		"qa: [test]\n",
+		"qa:\n  test:\n    cmd: go test\n",
+		"qa:\n  test:\n    timeout_seconds: soon\n",
+		"- a\n",
+	} {
+		_, err := ParseRepoConfig([]byte(bad))
+		var qaErr *QAError
+		if !errors.As(err, &qaErr) || qaErr.ErrCode != ErrCodeRepoConfigInvalid {
+			t.Fatalf("%q: err = %v, want %s", bad, err, ErrCodeRepoConfigInvalid)
+		}
+	}
+}
+
+func TestParseRepoConfigs(t *testing.T) {
+	configs, err := ParseRepoConfigs([]byte(`
+repos:
+  acme/web:
+    qa:
+      test:
+        command: npm test
+  acme/api:
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(configs) != 2 || len(configs["acme/web"].Targets) != 1 || len(configs["acme/api"].Targets) != 0 {
+		t.Fatalf("configs = %+v", configs)
+	}
+	if _, err := ParseRepoConfigs([]byte("repos:\n  web:\n    qa: {}\n")); err == nil {
+		t.Fatal("expected an error for a repository without owner")
+	}
+}
+
+func TestRunnerWithRepoConfig(t *testing.T) {
+	r, err := NewRunner(Config{
+		WorkDir:            ".",
+		Timeout:            time.Minute,
+		MaxOutputBytes:     4096,
+		AllowedExecutables: []string{"go", "npm", "true"},
+		Targets:            []TargetConfig{{Name: "e2e", Command: "npm run e2e", Backend: "sandbox"}},
+	})
+	if err != nil {
+		t.Fatalf("new runner: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		target  TargetConfig
+		errCode string
+	}{
+		{name: "override test", target: TargetConfig{Name: "test", Command: "npm test", Timeout: 30 * time.Second}},
+		{name: "new target", target: TargetConfig{Name: "build", Command: "npm run build", AllowedExecutables: []string{"npm"}}},
+		{name: "executable not allowed", target: TargetConfig{Name: "test", Command: "pytest"}, errCode: ErrCodeCommandNotAllowed},
+		{name: "widen allowlist", target: TargetConfig{Name: "build", Command: "pytest", AllowedExecutables: []string{"pytest"}}, errCode: ErrCodeCommandNotAllowed},
+		{name: "narrowed allowlist", target: TargetConfig{Name: "build", Command: "go build ./...", AllowedExecutables: []string{"npm"}}, errCode: ErrCodeCommandNotAllowed},
+		{name: "longer timeout", target: TargetConfig{Name: "test", Command: "npm test", Timeout: time.Hour}, errCode: ErrCodeRepoConfigInvalid},
+		{name: "more output", target: TargetConfig{Name: "lint", Command: "npm run lint", MaxOutputBytes: 1 << 20}, errCode: ErrCodeRepoConfigInvalid},
+		{name: "leave sandbox", target: TargetConfig{Name: "e2e", Command: "npm run e2e", Backend: "local"}, errCode: ErrCodeRepoConfigInvalid},
+		{name: "shell operator", target: TargetConfig{Name: "test", Command: "npm test; curl x"}, errCode: ErrCodeCommandInvalid},
+		{name: "bad name", target: TargetConfig{Name: "Build", Command: "npm run build"}, errCode: ErrCodeRepoConfigInvalid},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			derived, err := r.WithRepoConfig(&RepoConfig{Targets: []TargetConfig{tc.target}}, ConfigSourceRepository)
+			if tc.errCode == "" {
+				if err != nil {
+					t.Fatalf("with repo config: %v", err)
+				}
+				if !derived.HasTarget(tc.target.Name) || derived.Source() != ConfigSourceRepository {
+					t.Fatalf("derived runner lacks %s", tc.target.Name)
+				}
+				return
+			}
+			var qaErr *QAError
+			if !errors.As(err, &qaErr) || qaErr.ErrCode != tc.errCode {
+				t.Fatalf("err = %v, want %s", err, tc.errCode)
+			}
+		})
+	}
+	if r.HasTarget("build") {
+		t.Fatal("repo config changed the server runner")
+	}
+}
+
+func TestRunnerForRepo(t *testing.T) {
+	r, err := NewRunner(Config{
+		WorkDir:            ".",
+		AllowedExecutables: []string{"go", "true"},
+		RepoConfigs: map[string]*RepoConfig{
+			"acme/web": {Targets: []TargetConfig{{Name: "test", Command: "true"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("new runner: %v", err)
+	}
+	report, err := r.ForRepo("acme/web").Run(context.Background(), KindTest, false)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if report.Command != "true" || report.ConfigSource != ConfigSourcePolicy {
+		t.Fatalf("report = %+v", report)
+	}
+	if r.ForRepo("acme/api") != r {
+		t.Fatal("repositories without a config should use the server runner")
+	}
+
+	_, err = NewRunner(Config{WorkDir: ".", RepoConfigs: map[string]*RepoConfig{
+		"acme/web": {Targets: []TargetConfig{{Name: "test", Command: "curl example.com"}}},
+	}})
+	if err == nil {
+		t.Fatal("expected the policy file to be checked against the allowlist")
+	}
+}
@@ -0,0 +1,383 @@
+package qa
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Test result formats understood by the built-in parsers.
+const (
+	ResultFormatGoTestJSON = "go_test_json"
+	ResultFormatJUnit      = "junit"
+	ResultFormatPytestJSON = "pytest_json"
+)
+
+// maxTestMessageBytes caps the failure message kept per test; the full
+// output stays in the report's stdout.
+const maxTestMessageBytes = 4 * 1024
+
+// TestStatus is the outcome of a single test.
+type TestStatus string
+
+const (
+	TestPass TestStatus = "pass"
+	TestFail TestStatus = "fail"
+	TestSkip TestStatus = "skip"
+)
+
+// TestCase is the result of one test.
+type TestCase struct {
+	// Suite groups the test: the Go package, the JUnit classname or suite.
+	// It is empty for pytest, whose node ID is the name.
+	Suite      string     `json:"suite,omitempty"`
+	Name       string     `json:"name"`
+	Status     TestStatus `json:"status" jsonschema:"enum=pass|fail|skip"`
+	DurationMS int64      `json:"duration_ms"`
+	// Message is the failure or skip message, capped at 4 KiB.
+	Message string `json:"message,omitempty"`
+	// Flaky marks a failed test that passed when it was rerun.
+	Flaky bool `json:"flaky,omitempty"`
+}
+
+// ID identifies the test across runs, e.g. to compare repair iterations.
+// A Go package that failed without a failing test, such as on a build
+// error, is identified by its package alone.
+func (c TestCase) ID() string {
+	switch {
+	case c.Suite == "":
+		return c.Name
+	case c.Name == "":
+		return c.Suite
+	default:
+		return c.Suite + "::" + c.Name
+	}
+}
+
+// TestResults holds the per-test results parsed from a test command.
+type TestResults struct {
+	Format  string `json:"format"`
+	Passed  int    `json:"passed"`
+	Failed  int    `json:"failed"`
+	Skipped int    `json:"skipped"`
+	// Flaky counts the failed tests that passed on a rerun.
+	Flaky int        `json:"flaky,omitempty"`
+	Tests []TestCase `json:"tests"`
+}
+
+func newTestResults(format string, tests []TestCase) *TestResults {
+	res := &TestResults{Format: format, Tests: tests}
+	for i, tc := range tests {
+		res.Tests[i].Message, _ = truncateOutput(tc.Message, maxTestMessageBytes)
+		switch tc.Status {
+		case TestPass:
+			res.Passed++
+		case TestFail:
+			res.Failed++
+		case TestSkip:
+			res.Skipped++
+		}
+	}
+	return res
+}
+
+// FailedIDs returns the sorted IDs of the failed tests; flaky tests do not
+// count as failed.
+func (r *TestResults) FailedIDs() []string {
+	ids := []string{}
+	for _, tc := range r.Tests {
+		if tc.Status == TestFail && !tc.Flaky {
+			ids = append(ids, tc.ID())
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// FlakyOnly reports whether tests failed and every one of them passed on
+// a rerun.
+func (r *TestResults) FlakyOnly() bool {
+	return r != nil && r.Failed > 0 && r.Flaky == r.Failed
+}
+
+// CompareFailures compares current with the failed test IDs of a previous
+// run: fixed tests failed then and pass now, regressed tests fail now and
+// did not fail then. Flaky tests are neither.
+func CompareFailures(previous []string, current *TestResults) (fixed, regressed []string) {
+	before := make(map[string]bool, len(previous))
+	for _, id := range previous {
+		before[id] = true
+	}
+	for _, tc := range current.Tests {
+		switch {
+		case tc.Status == TestPass && before[tc.ID()]:
+			fixed = append(fixed, tc.ID())
+		case tc.Status == TestFail && !tc.Flaky && !before[tc.ID()]:
+			regressed = append(regressed, tc.ID())
+		}
+	}
+	sort.Strings(fixed)
+	sort.Strings(regressed)
+	return fixed, regressed
+}
+
+// ResultParser parses the output of a test command in one format into its
+// tests.
+type ResultParser func(data []byte) ([]TestCase, error)
+
+var (
+	resultParsersMu sync.RWMutex
+	resultParsers   = map[string]ResultParser{
+		ResultFormatGoTestJSON: parseGoTestJSON,
+		ResultFormatJUnit:      parseJUnit,
+		ResultFormatPytestJSON: parsePytestJSON,
+	}
+)
+
+// RegisterResultParser makes parser available as a test result format, in
+// addition to or replacing the built-in ones.
+func RegisterResultParser(format string, parser ResultParser) {
+	resultParsersMu.Lock()
+	defer resultParsersMu.Unlock()
+	resultParsers[format] = parser
+}
+
+func lookupResultParser(format string) (ResultParser, bool) {
+	resultParsersMu.RLock()
+	defer resultParsersMu.RUnlock()
+	parser, ok := resultParsers[format]
+	return parser, ok
+}
+
+// ParseTestResults parses data with the parser registered for format.
+func ParseTestResults(format string, data []byte) (*TestResults, error) {
+	parser, ok := lookupResultParser(format)
+	if !ok {
+		return nil, fmt.Errorf("unknown test result format %q", format)
+	}
+	tests, err := parser(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s test results: %w", format, err)
+	}
+	return newTestResults(format, tests), nil
+}
+
+// goTestEvent is a line of go test -json output (see go doc test2json).
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+func parseGoTestJSON(data []byte) ([]TestCase, error) {
+	type key struct{ pkg, test string }
+	output := make(map[key]*strings.Builder)
+	failedTests := make(map[string]bool)
+	var tests, pkgFailures []TestCase
+	events := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			// go test prints build and download messages around the events.
+			continue
+		}
+		var ev goTestEvent
+		if err := json.Unmarshal(line, &ev); err != nil || ev.Action == "" {
+			continue
+		}
+		events++
+		k := key{ev.Package, ev.Test}
+		switch ev.Action {
+		case "output":
+			b := output[k]
+			if b == nil {
+				b = &strings.Builder{}
+				output[k] = b
+			}
+			if b.Len() < 4*maxTestMessageBytes {
+				b.WriteString(ev.Output)
+			}
+		case "pass", "fail", "skip":
+			tc := TestCase{
+				Suite:      ev.Package,
+				Name:       ev.Test,
+				Status:     map[string]TestStatus{"pass": TestPass, "fail": TestFail, "skip": TestSkip}[ev.Action],
+				DurationMS: int64(ev.Elapsed * 1000),
+			}
+			if tc.Status != TestPass && output[k] != nil {
+				tc.Message = output[k].String()
+			}
+			delete(output, k)
+			if ev.Test != "" {
+				if tc.Status == TestFail {
+					failedTests[ev.Package] = true
+				}
+				tests = append(tests, tc)
+			} else if tc.Status == TestFail {
+				pkgFailures = append(pkgFailures, tc)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if events == 0 {
+		return nil, errors.New("no go test -json events in output")
+	}
+	// A package that failed without a failing test did not build or broke
+	// outside its tests; it is reported as a test of its own.
+	for _, tc := range pkgFailures {
+		if !failedTests[tc.Suite] {
+			tests = append(tests, tc)
+		}
+	}
+	return tests, nil
+}
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (m *junitMessage) String() string {
+	text := strings.TrimSpace(m.Text)
+	switch {
+	case m.Message == "":
+		return text
+	case text == "":
+		return m.Message
+	default:
+		return m.Message + "\n" + text
+	}
+}
+
+// parseJUnit reads JUnit XML with a testsuites or a testsuite root; suites
+// may nest.
+func parseJUnit(data []byte) ([]TestCase, error) {
+	var root junitSuite
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	var tests []TestCase
+	var walk func(s junitSuite)
+	walk = func(s junitSuite) {
+		for _, c := range s.Cases {
+			tc := TestCase{Suite: c.ClassName, Name: c.Name, Status: TestPass}
+			if tc.Suite == "" {
+				tc.Suite = s.Name
+			}
+			if secs, err := strconv.ParseFloat(strings.ReplaceAll(c.Time, ",", ""), 64); err == nil {
+				tc.DurationMS = int64(secs * 1000)
+			}
+			switch {
+			case c.Failure != nil:
+				tc.Status, tc.Message = TestFail, c.Failure.String()
+			case c.Error != nil:
+				tc.Status, tc.Message = TestFail, c.Error.String()
+			case c.Skipped != nil:
+				tc.Status, tc.Message = TestSkip, c.Skipped.String()
+			}
+			tests = append(tests, tc)
+		}
+		for _, child := range s.Suites {
+			walk(child)
+		}
+	}
+	walk(root)
+	return tests, nil
+}
+
+// pytestReport is the part of a pytest-json-report file that is used.
+type pytestReport struct {
+	Tests []struct {
+		NodeID   string       `json:"nodeid"`
+		Outcome  string       `json:"outcome"`
+		Setup    *pytestPhase `json:"setup"`
+		Call     *pytestPhase `json:"call"`
+		Teardown *pytestPhase `json:"teardown"`
+	} `json:"tests"`
+}
+
+type pytestPhase struct {
+	Duration float64         `json:"duration"`
+	Longrepr json.RawMessage `json:"longrepr"`
+}
+
+func parsePytestJSON(data []byte) ([]TestCase, error) {
+	var report pytestReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	tests := make([]TestCase, 0, len(report.Tests))
+	for _, t := range report.Tests {
+		tc := TestCase{Name: t.NodeID}
+		switch t.Outcome {
+		case "passed", "xpassed":
+			tc.Status = TestPass
+		case "skipped", "xfailed":
+			tc.Status = TestSkip
+		default:
+			// failed, or error in setup or teardown.
+			tc.Status = TestFail
+		}
+		var seconds float64
+		for _, phase := range []*pytestPhase{t.Setup, t.Call, t.Teardown} {
+			if phase == nil {
+				continue
+			}
+			seconds += phase.Duration
+			if tc.Message == "" && tc.Status != TestPass {
+				tc.Message = pytestLongrepr(phase.Longrepr)
+			}
+		}
+		tc.DurationMS = int64(seconds * 1000)
+		tests = append(tests, tc)
+	}
+	return tests, nil
+}
+
+// pytestLongrepr renders a longrepr, which is a string for failures and a
+// [path, line, reason] list for skips.
+func pytestLongrepr(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var parts []any
+	if err := json.Unmarshal(raw, &parts); err == nil && len(parts) > 0 {
+		if reason, ok := parts[len(parts)-1].(string); ok {
+			return reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,190 @@
+package qa
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTestResults(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		data      string
+		want      []TestCase
+		wantError string
+	}{
+		{
+			name:   "go test json",
+			format: ResultFormatGoTestJSON,
+			data: `go: downloading example.com/dep v1.0.0
+{"Action":"run","Package":"example.com/a","Test":"TestOK"}
+{"Action":"pass","Package":"example.com/a","Test":"TestOK","Elapsed":0.25}
+{"Action":"run","Package":"example.com/a","Test":"TestBad"}
+{"Action":"output","Package":"example.com/a","Test":"TestBad","Output":"    a_test.go:9: want 2\n"}
+{"Action":"fail","Package":"example.com/a","Test":"TestBad","Elapsed":0.01}
+{"Action":"output","Package":"example.com/a","Test":"TestLater","Output":"    skipped: slow\n"}
+{"Action":"skip","Package":"example.com/a","Test":"TestLater"}
+{"Action":"fail","Package":"example.com/a","Elapsed":0.3}
+{"Action":"output","Package":"example.com/b","Output":"b.go:3: undefined: x\n"}
+{"Action":"fail","Package":"example.com/b","Elapsed":0}
+`,
+			want: []TestCase{
+				{Suite: "example.com/a", Name: "TestOK", Status: TestPass, DurationMS: 250},
+				{Suite: "example.com/a", Name: "TestBad", Status: TestFail, DurationMS: 10, Message: "    a_test.go:9: want 2\n"},
+				{Suite: "example.com/a", Name: "TestLater", Status: TestSkip, Message: "    skipped: slow\n"},
+				{Suite: "example.com/b", Status: TestFail, Message: "b.go:3: undefined: x\n"},
+			},
+		},
+		{
+			name:      "go test without json",
+			format:    ResultFormatGoTestJSON,
+			data:      "ok  \texample.com/a\t0.01s\n",
+			wantError: "no go test -json events",
+		},
+		{
+			name:   "junit",
+			format: ResultFormatJUnit,
+			data: `<?xml version="1.0"?>
+<testsuites>
+  <testsuite name="pkg">
+    <testcase classname="pkg.A" name="ok" time="1.5"/>
+    <testcase name="bad" time="0.002"><failure message="expected 1">trace</failure></testcase>
+    <testsuite name="nested">
+      <testcase classname="pkg.B" name="broken"><error message="boom"/></testcase>
+      <testcase classname="pkg.B" name="later"><skipped/></testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`,
+			want: []TestCase{
+				{Suite: "pkg.A", Name: "ok", Status: TestPass, DurationMS: 1500},
+				{Suite: "pkg", Name: "bad", Status: TestFail, DurationMS: 2, Message: "expected 1\ntrace"},
+				{Suite: "pkg.B", Name: "broken", Status: TestFail, Message: "boom"},
+				{Suite: "pkg.B", Name: "later", Status: TestSkip},
+			},
+		},
+		{
+			name:   "junit testsuite root",
+			format: ResultFormatJUnit,
+			data:   `<testsuite name="s"><testcase name="t" time="0.1"/></testsuite>`,
+			want:   []TestCase{{Suite: "s", Name: "t", Status: TestPass, DurationMS: 100}},
+		},
+		{
+			name:   "pytest json",
+			format: ResultFormatPytestJSON,
+			data: `{"tests": [
+  {"nodeid": "tests/test_a.py::test_ok", "outcome": "passed", "setup": {"duration": 0.001}, "call": {"duration": 0.1}, "teardown": {"duration": 0.001}},
+  {"nodeid": "tests/test_a.py::test_bad", "outcome": "failed", "call": {"duration": 0.2, "longrepr": "assert 1 == 2"}},
+  {"nodeid": "tests/test_a.py::test_fixture", "outcome": "error", "setup": {"duration": 0, "longrepr": "fixture 'db' not found"}},
+  {"nodeid": "tests/test_a.py::test_skip", "outcome": "skipped", "setup": {"duration": 0, "longrepr": ["tests/test_a.py", 9, "Skipped: later"]}}
+]}`,
+			want: []TestCase{
+				{Name: "tests/test_a.py::test_ok", Status: TestPass, DurationMS: 102},
+				{Name: "tests/test_a.py::test_bad", Status: TestFail, DurationMS: 200, Message: "assert 1 == 2"},
+				{Name: "tests/test_a.py::test_fixture", Status: TestFail, Message: "fixture 'db' not found"},
+				{Name: "tests/test_a.py::test_skip", Status: TestSkip, Message: "Skipped: later"},
+			},
+		},
+		{
+			name:      "unknown format",
+			format:    "tap",
+			wantError: "unknown test result format",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseTestResults(tt.format, []byte(tt.data))
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("err = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !reflect.DeepEqual(res.Tests, tt.want) {
+				t.Fatalf("tests = %+v\nwant    %+v", res.Tests, tt.want)
+			}
+			var failed int
+			for _, tc := range tt.want {
+				if tc.Status == TestFail {
+					failed++
+				}
+			}
+			if res.Failed != failed || res.Passed+res.Failed+res.Skipped != len(tt.want) {
+				t.Fatalf("counts = %d/%d/%d", res.Passed, res.Failed, res.Skipped)
+			}
+		})
+	}
+}
+
+func TestCompareFailures(t *testing.T) {
+	current := &TestResults{Tests: []TestCase{
+		{Suite: "p", Name: "TestFixed", Status: TestPass},
+		{Suite: "p", Name: "TestStillBad", Status: TestFail},
+		{Suite: "p", Name: "TestNewBad", Status: TestFail},
+		{Suite: "p", Name: "TestOK", Status: TestPass},
+	}}
+	fixed, regressed := CompareFailures([]string{"p::TestFixed", "p::TestStillBad", "p::TestRemoved"}, current)
+	if !reflect.DeepEqual(fixed, []string{"p::TestFixed"}) || !reflect.DeepEqual(regressed, []string{"p::TestNewBad"}) {
+		t.Fatalf("fixed = %v, regressed = %v", fixed, regressed)
+	}
+	if got := current.FailedIDs(); !reflect.DeepEqual(got, []string{"p::TestNewBad", "p::TestStillBad"}) {
+		t.Fatalf("FailedIDs() = %v", got)
+	}
+}
+
+func TestRunnerParsesTestResults(t *testing.T) {
+	dir := t.TempDir()
+	junit := `<testsuite name="s"><testcase name="ok"/><testcase name="bad"><failure message="nope"/></testcase></testsuite>`
+	if err := os.WriteFile(filepath.Join(dir, "junit.xml"), []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		cmd       string
+		format    string
+		file      string
+		wantTests int
+		wantError string
+	}{
+		{name: "from file", cmd: "cp " + filepath.Join(t.TempDir(), "src.xml") + " junit.xml", format: ResultFormatJUnit, file: "junit.xml", wantTests: 2},
+		{name: "stale file removed", cmd: "true", format: ResultFormatJUnit, file: "junit.xml", wantError: "read qa.test results"},
+		{name: "from stdout", cmd: `printf '%s\n%s\n' '{"Action":"pass","Package":"p","Test":"TestOK"}' '{"Action":"fail","Package":"p","Test":"TestBad"}'`, format: ResultFormatGoTestJSON, wantTests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.HasPrefix(tt.cmd, "cp ") {
+				src := strings.Fields(tt.cmd)[1]
+				if err := os.WriteFile(src, []byte(junit), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r, err := NewRunner(Config{WorkDir: dir, TestCmd: tt.cmd, LintCmd: "true", Timeout: 5 * time.Second, AllowedExecutables: []string{"cp", "true", "printf"}, TestResultFormat: tt.format, TestResultFile: tt.file})
+			if err != nil {
+				t.Fatalf("new runner: %v", err)
+			}
+			report, err := r.Run(context.Background(), KindTest, false)
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if tt.wantError != "" {
+				if report.TestResults != nil || !strings.Contains(report.TestResultsError, tt.wantError) {
+					t.Fatalf("results = %+v, error = %q", report.TestResults, report.TestResultsError)
+				}
+				return
+			}
+			if report.TestResults == nil || len(report.TestResults.Tests) != tt.wantTests || report.TestResults.Failed != 1 {
+				t.Fatalf("results = %+v, error = %q", report.TestResults, report.TestResultsError)
+			}
+			if !strings.Contains(GenerateSummary(StatusPass, report), "1 passed, 1 failed, 0 skipped") {
+				t.Fatalf("summary = %q", GenerateSummary(StatusPass, report))
+			}
+		})
+	}
+}
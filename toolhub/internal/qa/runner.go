@@ -1,12 +1,12 @@
 package qa
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -14,6 +14,10 @@ import (
 	"github.com/toolhub/toolhub/internal/telemetry"
 )
 
+// killWaitDelay bounds how long Wait drains the output of a killed
+// command; children that inherited its pipes would otherwise block it.
+const killWaitDelay = 5 * time.Second
+
 type Kind string
 
 const (
@@ -33,6 +37,28 @@ type Config struct {
 	SandboxDockerBin   string
 	SandboxContainerWD string
 	AllowedExecutables []string
+	// TestResultFormat names the parser for per-test results of the test
+	// command, e.g. go_test_json; empty disables parsing. The results are
+	// read from TestResultFile, relative to the work directory, or from
+	// stdout when it is empty.
+	TestResultFormat string
+	TestResultFile   string
+	// LintResultFormat and LintResultFile do the same for the findings of
+	// the lint command, e.g. golangci_lint_json or sarif.
+	LintResultFormat string
+	LintResultFile   string
+	// Targets are the named checks beyond test and lint, each run as
+	// TargetKind(name).
+	Targets []TargetConfig
+	// TestMaxReruns caps RunOptions.RerunFailed; zero disables reruns.
+	TestMaxReruns int
+	// TestMapper names the mapper that selects the tests affected by a
+	// change for RunWith, e.g. go; empty makes targeted runs fall back
+	// to the full suite.
+	TestMapper string
+	// RepoConfigs holds the QA configuration of repositories by full name,
+	// from the central policy file; see Runner.ForRepo.
+	RepoConfigs map[string]*RepoConfig
 }
 
 type Report struct {
@@ -45,6 +71,29 @@ type Report struct {
 	StdoutTruncated  bool   `json:"stdout_truncated"`
 	StderrTruncated  bool   `json:"stderr_truncated"`
 	OutputLimitBytes int    `json:"output_limit_bytes"`
+	// ConfigSource says where the command came from: the server's QA_*
+	// settings, the central policy file or the repository's .toolhub.yml.
+	ConfigSource string `json:"config_source,omitempty" jsonschema:"enum=server|policy|repository"`
+	// TestResults holds the per-test results of a test command run with a
+	// configured result format; TestResultsError says why they are missing.
+	TestResults      *TestResults `json:"test_results,omitempty"`
+	TestResultsError string       `json:"test_results_error,omitempty"`
+	// TestSelection records the tests a targeted run selected, or why it
+	// ran the full suite.
+	TestSelection *TestSelection `json:"test_selection,omitempty"`
+	// TestReruns lists the reruns of failed tests; TestRerunsError says why
+	// they stopped early or could not run.
+	TestReruns      []TestRerun `json:"test_reruns,omitempty"`
+	TestRerunsError string      `json:"test_reruns_error,omitempty"`
+	// Findings holds the lint findings of a lint command run with a
+	// configured result format; FindingsError says why they are missing.
+	Findings      *LintFindings `json:"findings,omitempty"`
+	FindingsError string        `json:"findings_error,omitempty"`
+
+	// rawStdout is the stdout for result parsing, untruncated unless
+	// rawStdoutCapped says it went past maxResultOutputBytes.
+	rawStdout       string
+	rawStdoutCapped bool
 }
 
 // Status represents the outcome of a QA command execution.
@@ -54,8 +103,11 @@ const (
 	StatusPass    Status = "pass"
 	StatusFail    Status = "fail"
 	StatusTimeout Status = "timeout"
-	StatusError   Status = "error"
-	StatusDryRun  Status = "dry_run"
+	// StatusCancelled means the caller cancelled the run; the report holds
+	// the output produced until then.
+	StatusCancelled Status = "cancelled"
+	StatusError     Status = "error"
+	StatusDryRun    Status = "dry_run"
 )
 
 const (
@@ -65,9 +117,15 @@ const (
 	ErrCodeWorkdirInvalid      = "qa_workdir_invalid"
 	ErrCodeToolUnsupported     = "qa_tool_unsupported"
 	ErrCodeTimeout             = "qa_timeout"
+	ErrCodeCancelled           = "qa_cancelled"
 	ErrCodeExecFailed          = "qa_execution_failed"
 	ErrCodeConcurrencyExceeded = "qa_concurrency_exceeded"
 	ErrCodeBackendInvalid      = "qa_backend_invalid"
+	ErrCodeResultsInvalid      = "qa_results_invalid"
+	ErrCodeTargetInvalid       = "qa_target_invalid"
+	ErrCodeTargetNotFound      = "qa_target_not_found"
+	ErrCodeRepoConfigInvalid   = "qa_repo_config_invalid"
+	ErrCodeTestMapperInvalid   = "qa_test_mapper_invalid"
 )
 
 // QAError represents a typed QA error with a machine-readable code.
@@ -95,6 +153,8 @@ func DeriveStatus(report Report, err error, dryRun bool) Status {
 		switch qaErr.ErrCode {
 		case ErrCodeTimeout:
 			return StatusTimeout
+		case ErrCodeCancelled:
+			return StatusCancelled
 		case ErrCodeExecFailed:
 			return StatusFail
 		default:
@@ -109,13 +169,25 @@ func DeriveStatus(report Report, err error, dryRun bool) Status {
 }
 
 func GenerateSummary(status Status, report Report) string {
+	details := ""
+	if res := report.TestResults; res != nil {
+		details = fmt.Sprintf(", %d passed, %d failed, %d skipped", res.Passed, res.Failed, res.Skipped)
+		if res.Flaky > 0 {
+			details += fmt.Sprintf(", %d flaky", res.Flaky)
+		}
+	}
+	if res := report.Findings; res != nil {
+		details = fmt.Sprintf(", %d errors, %d warnings, %d notes", res.Errors, res.Warnings, res.Notes)
+	}
 	switch status {
 	case StatusPass:
-		return fmt.Sprintf("passed (exit_code=0, %dms)", report.DurationMS)
+		return fmt.Sprintf("passed (exit_code=0, %dms%s)", report.DurationMS, details)
 	case StatusFail:
-		return fmt.Sprintf("failed (exit_code=%d, %dms)", report.ExitCode, report.DurationMS)
+		return fmt.Sprintf("failed (exit_code=%d, %dms%s)", report.ExitCode, report.DurationMS, details)
 	case StatusTimeout:
 		return fmt.Sprintf("timed out after %dms", report.DurationMS)
+	case StatusCancelled:
+		return fmt.Sprintf("cancelled after %dms", report.DurationMS)
 	case StatusError:
 		return fmt.Sprintf("error (exit_code=%d)", report.ExitCode)
 	case StatusDryRun:
@@ -129,7 +201,13 @@ type Runner struct {
 	cfg                Config
 	allowedExecutables map[string]bool
 	semaphore          chan struct{}
-	sandbox            *SandboxRunner
+	// targets holds test, lint and the named targets by name; order lists
+	// them as configured.
+	targets map[string]*target
+	order   []string
+	source  string
+	// repos holds the runners of the repositories in Config.RepoConfigs.
+	repos map[string]*Runner
 }
 
 func NewRunner(cfg Config) (*Runner, error) {
@@ -175,19 +253,86 @@ func NewRunner(cfg Config) (*Runner, error) {
 	if err := validateConfiguredCommand(cfg.LintCmd, allowed); err != nil {
 		return nil, err
 	}
-	runner := &Runner{cfg: cfg, allowedExecutables: allowed, semaphore: make(chan struct{}, cfg.MaxConcurrency)}
-	if cfg.Backend == "sandbox" {
-		runner.sandbox = NewSandboxRunner(SandboxConfig{
-			Image:            cfg.SandboxImage,
-			DockerBinary:     cfg.SandboxDockerBin,
-			ContainerWorkDir: cfg.SandboxContainerWD,
-			Timeout:          cfg.Timeout,
-			MaxOutputBytes:   cfg.MaxOutputBytes,
-		})
+	if err := validateResultConfig("test", cfg.TestResultFormat, cfg.TestResultFile, func(format string) bool {
+		_, ok := lookupResultParser(format)
+		return ok
+	}); err != nil {
+		return nil, err
+	}
+	if err := validateResultConfig("lint", cfg.LintResultFormat, cfg.LintResultFile, func(format string) bool {
+		_, ok := lookupFindingParser(format)
+		return ok
+	}); err != nil {
+		return nil, err
+	}
+	if cfg.TestMapper != "" {
+		if _, ok := lookupTestMapper(cfg.TestMapper); !ok {
+			return nil, &QAError{ErrCode: ErrCodeTestMapperInvalid, Detail: fmt.Sprintf("unknown qa test mapper %q", cfg.TestMapper)}
+		}
+	}
+	runner := &Runner{cfg: cfg, allowedExecutables: allowed, semaphore: make(chan struct{}, cfg.MaxConcurrency), targets: map[string]*target{}, source: ConfigSourceServer}
+	configs := []TargetConfig{
+		{Name: TargetTest, Command: cfg.TestCmd, ResultFormat: cfg.TestResultFormat, ResultFile: cfg.TestResultFile},
+		{Name: TargetLint, Command: cfg.LintCmd, ResultFormat: cfg.LintResultFormat, ResultFile: cfg.LintResultFile},
+	}
+	for i, tc := range cfg.Targets {
+		if !targetNamePattern.MatchString(tc.Name) {
+			return nil, &QAError{ErrCode: ErrCodeTargetInvalid, Detail: fmt.Sprintf("invalid qa target name %q", tc.Name)}
+		}
+		if tc.Name == TargetTest || tc.Name == TargetLint {
+			return nil, &QAError{ErrCode: ErrCodeTargetInvalid, Detail: fmt.Sprintf("qa target %q is built in; configure its command with QA_%s_CMD", tc.Name, strings.ToUpper(tc.Name))}
+		}
+		for _, prev := range cfg.Targets[:i] {
+			if prev.Name == tc.Name {
+				return nil, &QAError{ErrCode: ErrCodeTargetInvalid, Detail: fmt.Sprintf("duplicate qa target %q", tc.Name)}
+			}
+		}
+		configs = append(configs, tc)
+	}
+	for _, tc := range configs {
+		t, err := newTarget(cfg, allowed, tc)
+		if err != nil {
+			return nil, err
+		}
+		runner.targets[t.name] = t
+		runner.order = append(runner.order, t.name)
+	}
+	runner.repos = make(map[string]*Runner, len(cfg.RepoConfigs))
+	for repo, rc := range cfg.RepoConfigs {
+		derived, err := runner.WithRepoConfig(rc, ConfigSourcePolicy)
+		if err != nil {
+			return nil, fmt.Errorf("qa config of %s: %w", repo, err)
+		}
+		runner.repos[repo] = derived
 	}
 	return runner, nil
 }
 
+// ForRepo returns the runner for repo: the one configured for it in the
+// central policy file, or this runner.
+func (r *Runner) ForRepo(repo string) *Runner {
+	if derived, ok := r.repos[repo]; ok {
+		return derived
+	}
+	return r
+}
+
+// WorkDir returns the configured work directory that Run uses.
+func (r *Runner) WorkDir() string {
+	return r.cfg.WorkDir
+}
+
+// Source returns where the runner's commands come from, e.g.
+// ConfigSourceServer.
+func (r *Runner) Source() string {
+	return r.source
+}
+
+// TestMaxReruns returns the most reruns of failed tests a run may ask for.
+func (r *Runner) TestMaxReruns() int {
+	return r.cfg.TestMaxReruns
+}
+
 func validateConfiguredCommand(cmdline string, allowedExecutables map[string]bool) error {
 	if err := validateCommandLine(cmdline); err != nil {
 		return err
@@ -206,6 +351,39 @@ func validateConfiguredCommand(cmdline string, allowedExecutables map[string]boo
 }
 
 func (r *Runner) Run(ctx context.Context, kind Kind, dryRun bool) (Report, error) {
+	return r.RunInDir(ctx, kind, r.cfg.WorkDir, dryRun)
+}
+
+// RunInDir runs the configured command for kind in workDir instead of the
+// configured work directory, e.g. in the worktree of a code operation.
+func (r *Runner) RunInDir(ctx context.Context, kind Kind, workDir string, dryRun bool) (Report, error) {
+	return r.run(ctx, kind, workDir, dryRun, RunOptions{})
+}
+
+// RunOptions adjust a run of the test target.
+type RunOptions struct {
+	// Changed, when non-nil, runs only the tests affected by these paths,
+	// relative to the work directory, as selected by the configured
+	// TestMapper. The full suite runs when they cannot be mapped;
+	// Report.TestSelection says which.
+	Changed []string
+	// RerunFailed reruns the failed tests up to this many times, at most
+	// Config.TestMaxReruns, and marks those that pass as flaky; see
+	// Report.TestReruns.
+	RerunFailed int
+}
+
+// RunWith runs kind in workDir with opts. Only the test target supports
+// options other than the zero value.
+func (r *Runner) RunWith(ctx context.Context, kind Kind, workDir string, opts RunOptions, dryRun bool) (Report, error) {
+	if kind != KindTest && (opts.Changed != nil || opts.RerunFailed > 0) {
+		return Report{}, &QAError{ErrCode: ErrCodeToolUnsupported, Detail: fmt.Sprintf("test selection and reruns are not supported for %s", kind)}
+	}
+	return r.run(ctx, kind, workDir, dryRun, opts)
+}
+
+// run runs kind in workDir with opts.
+func (r *Runner) run(ctx context.Context, kind Kind, workDir string, dryRun bool, opts RunOptions) (Report, error) {
 	select {
 	case r.semaphore <- struct{}{}:
 		defer func() { <-r.semaphore }()
@@ -213,10 +391,11 @@ func (r *Runner) Run(ctx context.Context, kind Kind, dryRun bool) (Report, error
 		return Report{}, &QAError{ErrCode: ErrCodeConcurrencyExceeded, Detail: "qa concurrency limit exceeded: " + ctx.Err().Error()}
 	}
 
-	cmdline, err := r.commandFor(kind)
+	t, err := r.targetFor(kind)
 	if err != nil {
 		return Report{}, err
 	}
+	cmdline := t.cmdline
 	if err := validateCommandLine(cmdline); err != nil {
 		return Report{}, err
 	}
@@ -227,66 +406,150 @@ func (r *Runner) Run(ctx context.Context, kind Kind, dryRun bool) (Report, error
 	if len(args) == 0 {
 		return Report{}, &QAError{ErrCode: ErrCodeCommandEmpty, Detail: "qa command is empty"}
 	}
-	if !r.allowedExecutables[args[0]] {
+	if !t.allowed[args[0]] {
 		return Report{}, &QAError{ErrCode: ErrCodeCommandNotAllowed, Detail: fmt.Sprintf("qa executable %q is not in allowlist", args[0])}
 	}
-	wd, err := absWorkDir(r.cfg.WorkDir)
+	wd, err := absWorkDir(workDir)
 	if err != nil {
 		return Report{}, err
 	}
 
 	if dryRun {
-		return Report{Command: cmdline, WorkDir: wd, ExitCode: 0, OutputLimitBytes: r.cfg.MaxOutputBytes}, nil
+		return Report{Command: cmdline, WorkDir: wd, ExitCode: 0, OutputLimitBytes: t.maxOutputBytes, ConfigSource: r.source}, nil
 	}
 
-	if r.cfg.Backend == "sandbox" {
-		return r.sandbox.RunCommand(ctx, cmdline, wd, false)
+	format, file := t.resultFormat, t.resultFile
+	if format != "" && file != "" {
+		// A result file left by an earlier run must not pass for this one.
+		_ = os.Remove(filepath.Join(wd, file))
+	}
+
+	var selection *TestSelection
+	if opts.Changed != nil {
+		cmdline, selection = r.selectTests(ctx, t, wd, args, opts.Changed)
+		if args, err = splitCommandLine(cmdline); err != nil {
+			return Report{}, err
+		}
 	}
 
-	return r.runLocal(ctx, cmdline, wd)
+	report, err := t.exec(ctx, cmdline, args, wd)
+	if report.Command != "" {
+		report.ConfigSource = r.source
+		report.TestSelection = selection
+	}
+	if format != "" && report.Command != "" {
+		attachResults(&report, kind, format, file, wd)
+	}
+	if reruns := min(opts.RerunFailed, r.cfg.TestMaxReruns); reruns > 0 && report.TestResults != nil && report.TestResults.Failed > 0 && ctx.Err() == nil {
+		var qaErr *QAError
+		if errors.As(err, &qaErr) && qaErr.ErrCode == ErrCodeExecFailed {
+			rerunFailed(ctx, t, wd, args, &report, reruns)
+		}
+	}
+	return report, err
 }
 
-func (r *Runner) runLocal(ctx context.Context, cmdline, wd string) (Report, error) {
-	args, err := splitCommandLine(cmdline)
+// exec runs the command of t, given as its command line and arguments, in
+// wd with t's backend.
+func (t *target) exec(ctx context.Context, cmdline string, args []string, wd string) (Report, error) {
+	if t.sandbox != nil {
+		return t.sandbox.runArgs(ctx, args, wd, false)
+	}
+	return runLocal(ctx, t, cmdline, args, wd)
+}
+
+// attachResults parses the per-test results or lint findings of a run into
+// report, whichever the parser registered for format produces. A run that
+// produced none, e.g. because it did not build, gets an error instead; the
+// run's own status is unaffected.
+func attachResults(report *Report, kind Kind, format, file, wd string) {
+	data := []byte(report.rawStdout)
+	var err error
+	if file == "" && report.rawStdoutCapped {
+		err = fmt.Errorf("%s output exceeds %d bytes; results were not parsed", kind, maxResultOutputBytes)
+	}
+	if file != "" {
+		data, err = os.ReadFile(filepath.Join(wd, file))
+		if err != nil {
+			err = fmt.Errorf("read %s results: %w", kind, err)
+		}
+	}
+	if _, ok := lookupFindingParser(format); ok {
+		if err == nil {
+			report.Findings, err = ParseFindings(format, data, wd)
+		}
+		if err != nil {
+			report.FindingsError = err.Error()
+		}
+		return
+	}
+	if err == nil {
+		report.TestResults, err = ParseTestResults(format, data)
+	}
 	if err != nil {
-		return Report{}, err
+		report.TestResultsError = err.Error()
+	}
+}
+
+func validateResultConfig(kind, format, file string, known func(string) bool) error {
+	if format == "" {
+		if file != "" {
+			return &QAError{ErrCode: ErrCodeResultsInvalid, Detail: fmt.Sprintf("qa %s result file is set without a result format", kind)}
+		}
+		return nil
+	}
+	if !known(format) {
+		return &QAError{ErrCode: ErrCodeResultsInvalid, Detail: fmt.Sprintf("unknown qa %s result format %q", kind, format)}
 	}
+	if file != "" && (filepath.IsAbs(file) || !filepath.IsLocal(file)) {
+		return &QAError{ErrCode: ErrCodeResultsInvalid, Detail: fmt.Sprintf("qa %s result file %q must be relative to the work directory", kind, file)}
+	}
+	return nil
+}
+
+func runLocal(ctx context.Context, t *target, cmdline string, args []string, wd string) (Report, error) {
 	if len(args) == 0 {
 		return Report{}, &QAError{ErrCode: ErrCodeCommandEmpty, Detail: "qa command is empty"}
 	}
 
-	execCtx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	execCtx, cancel := context.WithTimeout(ctx, t.timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(execCtx, args[0], args[1:]...)
 	cmd.Dir = wd
-	var stdoutBuf bytes.Buffer
-	var stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
+	cmd.WaitDelay = killWaitDelay
+	stdoutBuf, stderrBuf := newOutputBuffers(t.maxOutputBytes)
+	var flushOutput func()
+	cmd.Stdout, cmd.Stderr, flushOutput = commandOutput(ctx, t.maxOutputBytes, stdoutBuf, stderrBuf)
 
 	start := time.Now()
 	runErr := cmd.Run()
 	duration := time.Since(start)
+	flushOutput()
 
 	report := Report{
 		Command:          cmdline,
 		WorkDir:          wd,
 		ExitCode:         0,
 		DurationMS:       duration.Milliseconds(),
-		OutputLimitBytes: r.cfg.MaxOutputBytes,
+		OutputLimitBytes: t.maxOutputBytes,
 	}
-	report.Stdout, report.StdoutTruncated = truncateOutput(stdoutBuf.String(), r.cfg.MaxOutputBytes)
-	report.Stderr, report.StderrTruncated = truncateOutput(stderrBuf.String(), r.cfg.MaxOutputBytes)
+	report.Stdout, report.StdoutTruncated = truncateOutput(stdoutBuf.String(), t.maxOutputBytes)
+	report.Stderr, report.StderrTruncated = truncateOutput(stderrBuf.String(), t.maxOutputBytes)
+	report.rawStdout, report.rawStdoutCapped = stdoutBuf.String(), stdoutBuf.dropped
 
 	if runErr == nil {
 		return report, nil
 	}
 
+	if ctx.Err() != nil {
+		report.ExitCode = -1
+		return report, cancelledError(ctx)
+	}
 	if errors.Is(execCtx.Err(), context.DeadlineExceeded) {
 		report.ExitCode = -1
 		telemetry.IncQATimeout()
-		return report, &QAError{ErrCode: ErrCodeTimeout, Detail: fmt.Sprintf("qa command timed out after %s", r.cfg.Timeout)}
+		return report, &QAError{ErrCode: ErrCodeTimeout, Detail: fmt.Sprintf("qa command timed out after %s", t.timeout)}
 	}
 
 	var exitErr *exec.ExitError
@@ -299,15 +562,9 @@ func (r *Runner) runLocal(ctx context.Context, cmdline, wd string) (Report, erro
 	return report, runErr
 }
 
-func (r *Runner) commandFor(kind Kind) (string, error) {
-	switch kind {
-	case KindTest:
-		return r.cfg.TestCmd, nil
-	case KindLint:
-		return r.cfg.LintCmd, nil
-	default:
-		return "", &QAError{ErrCode: ErrCodeToolUnsupported, Detail: fmt.Sprintf("unsupported qa tool: %s", kind)}
-	}
+// cancelledError reports a run stopped because ctx was cancelled.
+func cancelledError(ctx context.Context) error {
+	return &QAError{ErrCode: ErrCodeCancelled, Detail: "qa command cancelled: " + context.Cause(ctx).Error()}
 }
 
 func absWorkDir(dir string) (string, error) {
@@ -400,7 +657,7 @@ func truncateOutput(text string, maxBytes int) (string, bool) {
 	if maxBytes <= 0 || len(text) <= maxBytes {
 		return text, false
 	}
-	notice := "\n[output truncated]"
+	notice := truncationNotice
 	if maxBytes <= len(notice) {
 		return notice[:maxBytes], true
 	}
@@ -80,6 +80,23 @@ func TestRunnerTimeout(t *testing.T) {
 	}
 }
 
+func TestRunnerCancel(t *testing.T) {
+	r, err := NewRunner(Config{WorkDir: ".", TestCmd: "sleep 5", LintCmd: "go test ./...", Timeout: 10 * time.Second, AllowedExecutables: []string{"sleep", "go"}})
+	if err != nil {
+		t.Fatalf("new runner should not fail: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	report, err := r.Run(ctx, KindTest, false)
+	if status := DeriveStatus(report, err, false); status != StatusCancelled {
+		t.Fatalf("status = %s (%v)", status, err)
+	}
+	if report.ExitCode != -1 || time.Since(start) > 2*time.Second {
+		t.Fatalf("exit code %d after %s", report.ExitCode, time.Since(start))
+	}
+}
+
 func TestRunnerOutputTruncation(t *testing.T) {
 	r, err := NewRunner(Config{WorkDir: ".", TestCmd: "go env GOMOD", LintCmd: "go test ./...", Timeout: 5 * time.Second, MaxOutputBytes: 30})
 	if err != nil {
@@ -186,6 +203,20 @@ func TestNewRunnerValidation(t *testing.T) {
 			wantCode:  ErrCodeBackendInvalid,
 			errSubstr: "unsupported qa backend",
 		},
+		{
+			name:      "unknown_test_result_format",
+			cfg:       Config{WorkDir: ".", TestCmd: "go test ./...", LintCmd: "go test ./...", AllowedExecutables: []string{"go"}, TestResultFormat: "tap"},
+			wantErr:   true,
+			wantCode:  ErrCodeResultsInvalid,
+			errSubstr: "unknown qa test result format",
+		},
+		{
+			name:      "test_result_file_outside_workdir",
+			cfg:       Config{WorkDir: ".", TestCmd: "go test ./...", LintCmd: "go test ./...", AllowedExecutables: []string{"go"}, TestResultFormat: ResultFormatJUnit, TestResultFile: "../junit.xml"},
+			wantErr:   true,
+			wantCode:  ErrCodeResultsInvalid,
+			errSubstr: "must be relative",
+		},
 	}
 
 	for _, tt := range tests {
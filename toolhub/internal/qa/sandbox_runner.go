@@ -1,15 +1,20 @@
 package qa
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os/exec"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+// containerKillTimeout bounds the docker kill of a cancelled or timed out
+// sandbox run.
+const containerKillTimeout = 10 * time.Second
+
 type SandboxConfig struct {
 	Image            string
 	DockerBinary     string
@@ -52,14 +57,23 @@ func (r *SandboxRunner) RunCommand(ctx context.Context, commandLine, hostWorkDir
 	if len(cmdArgs) == 0 {
 		return Report{}, &QAError{ErrCode: ErrCodeCommandEmpty, Detail: "qa command is empty"}
 	}
+	return r.runArgs(ctx, cmdArgs, hostWorkDir, dryRun)
+}
 
+// runArgs runs cmdArgs in a container without parsing a command line, for
+// commands built from arguments such as test reruns.
+func (r *SandboxRunner) runArgs(ctx context.Context, cmdArgs []string, hostWorkDir string, dryRun bool) (Report, error) {
 	wd, err := absWorkDir(hostWorkDir)
 	if err != nil {
 		return Report{}, err
 	}
 
+	// The container is named so a cancelled or timed out run can kill it:
+	// killing the docker client alone leaves the container running.
+	container := "toolhub-qa-" + uuid.New().String()
 	dockerArgs := []string{
 		"run", "--rm",
+		"--name", container,
 		"--network", "none",
 		"--cpus", "1",
 		"--memory", "512m",
@@ -84,20 +98,30 @@ func (r *SandboxRunner) RunCommand(ctx context.Context, commandLine, hostWorkDir
 	defer cancel()
 
 	cmd := exec.CommandContext(execCtx, r.cfg.DockerBinary, dockerArgs...)
-	var stdoutBuf bytes.Buffer
-	var stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
+	cmd.Cancel = func() error {
+		r.killContainer(container)
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = killWaitDelay
+	stdoutBuf, stderrBuf := newOutputBuffers(r.cfg.MaxOutputBytes)
+	var flushOutput func()
+	cmd.Stdout, cmd.Stderr, flushOutput = commandOutput(ctx, r.cfg.MaxOutputBytes, stdoutBuf, stderrBuf)
 
 	start := time.Now()
 	runErr := cmd.Run()
 	report.DurationMS = time.Since(start).Milliseconds()
+	flushOutput()
 	report.Stdout, report.StdoutTruncated = truncateOutput(stdoutBuf.String(), r.cfg.MaxOutputBytes)
 	report.Stderr, report.StderrTruncated = truncateOutput(stderrBuf.String(), r.cfg.MaxOutputBytes)
+	report.rawStdout, report.rawStdoutCapped = stdoutBuf.String(), stdoutBuf.dropped
 
 	if runErr == nil {
 		return report, nil
 	}
+	if ctx.Err() != nil {
+		report.ExitCode = -1
+		return report, cancelledError(ctx)
+	}
 	if errors.Is(execCtx.Err(), context.DeadlineExceeded) {
 		report.ExitCode = -1
 		return report, &QAError{ErrCode: ErrCodeTimeout, Detail: fmt.Sprintf("sandbox qa command timed out after %s", r.cfg.Timeout)}
@@ -110,3 +134,12 @@ func (r *SandboxRunner) RunCommand(ctx context.Context, commandLine, hostWorkDir
 	report.ExitCode = -1
 	return report, runErr
 }
+
+// killContainer stops a sandbox container whose run was cancelled or timed
+// out. Failures are ignored: the container may not have started yet, and
+// --rm removes it once it stops.
+func (r *SandboxRunner) killContainer(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerKillTimeout)
+	defer cancel()
+	_ = exec.CommandContext(ctx, r.cfg.DockerBinary, "kill", name).Run()
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -53,3 +54,34 @@ func TestSandboxRunnerRunWithFakeDocker(t *testing.T) {
 		t.Fatalf("unexpected stdout: %q", report.Stdout)
 	}
 }
+
+func TestSandboxRunnerCancelKillsContainer(t *testing.T) {
+	tmp := t.TempDir()
+	killed := filepath.Join(tmp, "killed")
+	fake := filepath.Join(tmp, "fake-docker.sh")
+	script := "#!/bin/sh\nif [ \"$1\" = kill ]; then printf '%s' \"$2\" > " + killed + "; exit 0; fi\necho started\nexec sleep 5\n"
+	if err := os.WriteFile(fake, []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake docker: %v", err)
+	}
+
+	r := NewSandboxRunner(SandboxConfig{DockerBinary: fake, Timeout: 10 * time.Second})
+	var once sync.Once
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = WithOutputFunc(ctx, func(stream, data string) { once.Do(cancel) })
+
+	report, err := r.RunCommand(ctx, "go test ./...", ".", false)
+	if status := DeriveStatus(report, err, false); status != StatusCancelled {
+		t.Fatalf("status = %s (%v)", status, err)
+	}
+	if report.Stdout != "started\n" {
+		t.Fatalf("expected partial stdout, got %q", report.Stdout)
+	}
+	name, err := os.ReadFile(killed)
+	if err != nil {
+		t.Fatalf("container was not killed: %v", err)
+	}
+	if !strings.HasPrefix(string(name), "toolhub-qa-") || !strings.Contains(report.Command, "--name "+string(name)) {
+		t.Fatalf("killed %q, command %q", name, report.Command)
+	}
+}
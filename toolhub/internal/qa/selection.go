@@ -0,0 +1,341 @@
+package qa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TestMapperGo is the built-in mapper for go test commands.
+const TestMapperGo = "go"
+
+// Test selection modes, as reported in TestSelection.Mode.
+const (
+	SelectionFull     = "full"
+	SelectionTargeted = "targeted"
+)
+
+// TestSelection records which tests a targeted run selected. A run falls
+// back to the full suite, with the reason, whenever the changed paths
+// cannot be mapped with confidence.
+type TestSelection struct {
+	Mode   string `json:"mode" jsonschema:"enum=full|targeted"`
+	Mapper string `json:"mapper,omitempty"`
+	// ChangedFiles are the paths the selection was made for.
+	ChangedFiles []string `json:"changed_files"`
+	// Units are the selected packages or test files, e.g. Go import paths.
+	Units          []string `json:"units,omitempty"`
+	FallbackReason string   `json:"fallback_reason,omitempty"`
+}
+
+// TestMapRequest is what a TestMapper selects tests for.
+type TestMapRequest struct {
+	// Dir is the work directory the test command runs in.
+	Dir string
+	// Args is the configured test command, split into arguments.
+	Args []string
+	// Changed are the changed paths, relative to Dir with forward slashes.
+	Changed []string
+}
+
+// TestMapping is the selection of a TestMapper: the units affected by the
+// change and the test command running only them.
+type TestMapping struct {
+	Units []string
+	Args  []string
+}
+
+// TestMapper maps changed paths to the tests they affect. An error makes
+// the run fall back to the full suite with the error as the reason, so a
+// mapper should fail rather than guess.
+type TestMapper func(ctx context.Context, req TestMapRequest) (TestMapping, error)
+
+var (
+	testMappersMu sync.RWMutex
+	testMappers   = map[string]TestMapper{
+		TestMapperGo: mapGoTests,
+	}
+)
+
+// RegisterTestMapper makes mapper available as a test selection mapper, in
+// addition to or replacing the built-in ones.
+func RegisterTestMapper(name string, mapper TestMapper) {
+	testMappersMu.Lock()
+	defer testMappersMu.Unlock()
+	testMappers[name] = mapper
+}
+
+func lookupTestMapper(name string) (TestMapper, bool) {
+	testMappersMu.RLock()
+	defer testMappersMu.RUnlock()
+	mapper, ok := testMappers[name]
+	return mapper, ok
+}
+
+// selectTests maps changed to the test command of t. It returns the
+// command line to run, the configured one on a fallback, and the
+// selection to report.
+func (r *Runner) selectTests(ctx context.Context, t *target, wd string, args, changed []string) (string, *TestSelection) {
+	sel := &TestSelection{Mode: SelectionFull, Mapper: r.cfg.TestMapper, ChangedFiles: changed}
+	if sel.ChangedFiles == nil {
+		sel.ChangedFiles = []string{}
+	}
+	fallback := func(format string, a ...any) (string, *TestSelection) {
+		sel.FallbackReason = fmt.Sprintf(format, a...)
+		return t.cmdline, sel
+	}
+	switch {
+	case r.cfg.TestMapper == "":
+		return fallback("no test mapper is configured")
+	case t.sandbox != nil:
+		return fallback("targeted tests need the local backend")
+	case len(changed) == 0:
+		return fallback("no changed files")
+	}
+	mapper, ok := lookupTestMapper(r.cfg.TestMapper)
+	if !ok {
+		return fallback("unknown test mapper %q", r.cfg.TestMapper)
+	}
+	for _, p := range changed {
+		if !filepath.IsLocal(filepath.FromSlash(p)) {
+			return fallback("changed path %q is not relative to the work directory", p)
+		}
+	}
+
+	mapCtx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	mapping, err := mapper(mapCtx, TestMapRequest{Dir: wd, Args: append([]string(nil), args...), Changed: changed})
+	if err != nil {
+		return fallback("%v", err)
+	}
+	if len(mapping.Units) == 0 {
+		return fallback("no tests are affected by the changed files")
+	}
+	if len(mapping.Args) == 0 || mapping.Args[0] != args[0] {
+		return fallback("test mapper changed the executable")
+	}
+	cmdline := joinCommandLine(mapping.Args)
+	if err := validateConfiguredCommand(cmdline, t.allowed); err != nil {
+		return fallback("selected command is invalid: %v", err)
+	}
+	sel.Mode, sel.Units = SelectionTargeted, mapping.Units
+	return cmdline, sel
+}
+
+// joinCommandLine is the inverse of splitCommandLine.
+func joinCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		var b strings.Builder
+		for _, r := range arg {
+			if strings.ContainsRune(" \t'\"\\", r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		quoted[i] = b.String()
+	}
+	return strings.Join(quoted, " ")
+}
+
+// goTestValueFlags are the go test and build flags that take a separate
+// value, which must not be mistaken for a package pattern.
+var goTestValueFlags = map[string]bool{
+	"asmflags": true, "bench": true, "benchtime": true, "blockprofile": true, "blockprofilerate": true,
+	"count": true, "coverpkg": true, "covermode": true, "coverprofile": true, "cpu": true,
+	"cpuprofile": true, "exec": true, "fuzz": true, "fuzzminimizetime": true, "fuzztime": true,
+	"gccgoflags": true, "gcflags": true, "ldflags": true, "list": true, "memprofile": true,
+	"memprofilerate": true, "mod": true, "modfile": true, "mutexprofile": true, "mutexprofilefraction": true,
+	"o": true, "outputdir": true, "overlay": true, "p": true, "parallel": true, "pgo": true,
+	"pkgdir": true, "run": true, "shuffle": true, "skip": true, "tags": true, "timeout": true,
+	"toolexec": true, "trace": true, "vet": true,
+}
+
+// goTestCommand locates the parts of a go test command line: the index of
+// the test subcommand, the indexes of its package arguments and of its -run
+// flags, with their separate values.
+type goTestCommand struct {
+	test     int
+	packages []int
+	run      []int
+}
+
+// parseGoTestCommand parses go [-C dir] test [flags] [packages] [flags]
+// [-args ...].
+func parseGoTestCommand(args []string) (goTestCommand, error) {
+	i := 1
+	for i < len(args) && strings.HasPrefix(args[i], "-") {
+		switch {
+		case args[i] == "-C" && i+1 < len(args):
+			i += 2
+		case strings.HasPrefix(args[i], "-C="):
+			i++
+		default:
+			return goTestCommand{}, fmt.Errorf("unsupported go flag %s before the test command", args[i])
+		}
+	}
+	if len(args) == 0 || args[0] != "go" || i >= len(args) || args[i] != "test" {
+		return goTestCommand{}, fmt.Errorf("not a go test command")
+	}
+	cmd := goTestCommand{test: i}
+	for j := i + 1; j < len(args); j++ {
+		arg := args[j]
+		if arg == "-args" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			cmd.packages = append(cmd.packages, j)
+			continue
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name == "run" {
+			cmd.run = append(cmd.run, j)
+		}
+		if !hasValue && goTestValueFlags[name] {
+			if name == "run" {
+				cmd.run = append(cmd.run, j+1)
+			}
+			j++
+		}
+	}
+	return cmd, nil
+}
+
+// goPackage is the part of go list -json output mapGoTests needs.
+type goPackage struct {
+	ImportPath   string
+	Dir          string
+	Deps         []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// mapGoTests selects the packages of a go test command affected by the
+// changed paths: the packages containing them and every package that
+// imports one of those, directly, through its dependencies or from its
+// tests. The command's relative package patterns (./..., ./pkg) are
+// replaced by the selected import paths. Changes outside the listed
+// packages fall back, except Markdown files; so do changes to go.mod,
+// go.sum and go.work.
+func mapGoTests(ctx context.Context, req TestMapRequest) (TestMapping, error) {
+	args := req.Args
+	cmd, err := parseGoTestCommand(args)
+	if err != nil {
+		return TestMapping{}, err
+	}
+	var patterns []int
+	for _, j := range cmd.packages {
+		if args[j] == "." || strings.HasPrefix(args[j], "./") {
+			patterns = append(patterns, j)
+		}
+	}
+	if len(patterns) == 0 {
+		return TestMapping{}, fmt.Errorf("go test command has no relative package pattern")
+	}
+	global := args[1:cmd.test]
+
+	listArgs := append(append([]string(nil), global...), "list", "-e", "-json=ImportPath,Dir,Deps,TestImports,XTestImports")
+	for _, j := range patterns {
+		listArgs = append(listArgs, args[j])
+	}
+	list := exec.CommandContext(ctx, "go", listArgs...)
+	list.Dir = req.Dir
+	list.WaitDelay = killWaitDelay
+	out, err := list.Output()
+	if err != nil {
+		return TestMapping{}, fmt.Errorf("go list failed: %v", err)
+	}
+	var pkgs []goPackage
+	dec := json.NewDecoder(strings.NewReader(string(out)))
+	for {
+		var pkg goPackage
+		if err := dec.Decode(&pkg); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return TestMapping{}, fmt.Errorf("parse go list output: %v", err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	byDir := make(map[string]string, len(pkgs))
+	for _, pkg := range pkgs {
+		rel, err := filepath.Rel(req.Dir, pkg.Dir)
+		if err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+		byDir[filepath.ToSlash(rel)] = pkg.ImportPath
+	}
+	changed := map[string]bool{}
+	for _, p := range req.Changed {
+		switch path.Base(p) {
+		case "go.mod", "go.sum", "go.work", "go.work.sum":
+			return TestMapping{}, fmt.Errorf("%s changed", p)
+		}
+		pkg, ok := goPackageOf(byDir, p)
+		switch {
+		case ok:
+			changed[pkg] = true
+		case strings.HasSuffix(p, ".md"):
+		default:
+			return TestMapping{}, fmt.Errorf("changed file %s is outside the tested packages", p)
+		}
+	}
+
+	affected := map[string]bool{}
+	for _, pkg := range pkgs {
+		if changed[pkg.ImportPath] || anyIn(pkg.Deps, changed) {
+			affected[pkg.ImportPath] = true
+		}
+	}
+	var units []string
+	for _, pkg := range pkgs {
+		if affected[pkg.ImportPath] || anyIn(pkg.TestImports, affected) || anyIn(pkg.XTestImports, affected) {
+			units = append(units, pkg.ImportPath)
+		}
+	}
+	sort.Strings(units)
+
+	selected := append([]string(nil), args[:patterns[0]]...)
+	selected = append(selected, units...)
+	for j := patterns[0] + 1; j < len(args); j++ {
+		if slices.Contains(patterns, j) {
+			continue
+		}
+		selected = append(selected, args[j])
+	}
+	return TestMapping{Units: units, Args: selected}, nil
+}
+
+// goPackageOf returns the package whose directory holds p, directly or
+// under its testdata directory.
+func goPackageOf(byDir map[string]string, p string) (string, bool) {
+	dir := path.Dir(p)
+	if pkg, ok := byDir[dir]; ok {
+		return pkg, true
+	}
+	for d := dir; d != "." && d != "/"; d = path.Dir(d) {
+		if path.Base(d) == "testdata" {
+			pkg, ok := byDir[path.Dir(d)]
+			return pkg, ok
+		}
+	}
+	return "", false
+}
+
+func anyIn(list []string, set map[string]bool) bool {
+	for _, s := range list {
+		if set[s] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestModule(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"mod/go.mod":            "module example.com/m\n\ngo 1.21\n",
+		"mod/a/a.go":            "package a\n\nfunc A() int { return 1 }\n",
+		"mod/b/b.go":            "package b\n\nimport \"example.com/m/a\"\n\nfunc B() int { return a.A() }\n",
+		"mod/c/c.go":            "package c\n\nfunc C() int { return 3 }\n",
+		"mod/c/testdata/in.txt": "input\n",
+		"mod/d/d.go":            "package d\n",
+		"mod/d/d_test.go":       "package d_test\n\nimport (\n\t\"testing\"\n\n\t\"example.com/m/b\"\n)\n\nfunc TestD(t *testing.T) { _ = b.B() }\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestMapGoTests(t *testing.T) {
+	dir := writeTestModule(t)
+	args := []string{"go", "-C", "mod", "test", "-count", "1", "./...", "-run", "Test"}
+
+	tests := []struct {
+		name      string
+		changed   []string
+		wantUnits string
+		wantErr   string
+	}{
+		{name: "dependents and test imports", changed: []string{"mod/a/a.go"}, wantUnits: "example.com/m/a,example.com/m/b,example.com/m/d"},
+		{name: "testdata", changed: []string{"mod/c/testdata/in.txt"}, wantUnits: "example.com/m/c"},
+		{name: "markdown only", changed: []string{"README.md"}, wantUnits: ""},
+		{name: "go.mod", changed: []string{"mod/go.mod"}, wantErr: "mod/go.mod changed"},
+		{name: "outside packages", changed: []string{"Makefile"}, wantErr: "outside the tested packages"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mapping, err := mapGoTests(context.Background(), TestMapRequest{Dir: dir, Args: args, Changed: tc.changed})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("map: %v", err)
+			}
+			if got := strings.Join(mapping.Units, ","); got != tc.wantUnits {
+				t.Fatalf("units = %s, want %s", got, tc.wantUnits)
+			}
+			want := append(append([]string{"go", "-C", "mod", "test", "-count", "1"}, mapping.Units...), "-run", "Test")
+			if strings.Join(mapping.Args, " ") != strings.Join(want, " ") {
+				t.Fatalf("args = %q, want %q", mapping.Args, want)
+			}
+		})
+	}
+
+	if _, err := mapGoTests(context.Background(), TestMapRequest{Dir: dir, Args: []string{"go", "vet", "./..."}, Changed: []string{"mod/a/a.go"}}); err == nil {
+		t.Fatal("go vet command was mapped")
+	}
+	if _, err := mapGoTests(context.Background(), TestMapRequest{Dir: dir, Args: []string{"go", "-C", "mod", "test", "example.com/m/..."}, Changed: []string{"mod/a/a.go"}}); err == nil {
+		t.Fatal("command without relative pattern was mapped")
+	}
+}
+
+func TestRunnerRunTargeted(t *testing.T) {
+	RegisterTestMapper("fake", func(ctx context.Context, req TestMapRequest) (TestMapping, error) {
+		if req.Changed[0] == "broken.go" {
+			return TestMapping{}, errors.New("cannot map broken.go")
+		}
+		return TestMapping{Units: []string{"unit one"}, Args: []string{"echo", "unit one"}}, nil
+	})
+	r, err := NewRunner(Config{WorkDir: ".", TestCmd: "echo ./...", LintCmd: "echo lint", AllowedExecutables: []string{"echo"}, TestMapper: "fake"})
+	if err != nil {
+		t.Fatalf("new runner: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		changed     []string
+		wantCommand string
+		wantMode    string
+		wantReason  string
+	}{
+		{name: "targeted", changed: []string{"a.go"}, wantCommand: `echo unit\ one`, wantMode: SelectionTargeted},
+		{name: "no changes", changed: []string{}, wantCommand: "echo ./...", wantMode: SelectionFull, wantReason: "no changed files"},
+		{name: "mapper error", changed: []string{"broken.go"}, wantCommand: "echo ./...", wantMode: SelectionFull, wantReason: "cannot map broken.go"},
+		{name: "outside work dir", changed: []string{"../x.go"}, wantCommand: "echo ./...", wantMode: SelectionFull, wantReason: "not relative"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := r.RunWith(context.Background(), KindTest, ".", RunOptions{Changed: tc.changed}, false)
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			sel := report.TestSelection
+			if report.Command != tc.wantCommand || sel == nil || sel.Mode != tc.wantMode || !strings.Contains(sel.FallbackReason, tc.wantReason) {
+				t.Fatalf("command = %q, selection = %+v", report.Command, sel)
+			}
+			if tc.wantMode == SelectionTargeted && strings.TrimSpace(report.Stdout) != "unit one" {
+				t.Fatalf("stdout = %q", report.Stdout)
+			}
+		})
+	}
+
+	report, err := r.RunInDir(context.Background(), KindTest, ".", false)
+	if err != nil || report.TestSelection != nil {
+		t.Fatalf("full run selection = %+v, %v", report.TestSelection, err)
+	}
+	var qaErr *QAError
+	if _, err := r.RunWith(context.Background(), KindLint, ".", RunOptions{Changed: []string{"a.go"}}, false); !errors.As(err, &qaErr) || qaErr.ErrCode != ErrCodeToolUnsupported {
+		t.Fatalf("lint err = %v, want %s", err, ErrCodeToolUnsupported)
+	}
+	if _, err := NewRunner(Config{WorkDir: ".", TestMapper: "nope"}); !errors.As(err, &qaErr) || qaErr.ErrCode != ErrCodeTestMapperInvalid {
+		t.Fatalf("unknown mapper err = %v, want %s", err, ErrCodeTestMapperInvalid)
+	}
+}
@@ -0,0 +1,208 @@
+package qa
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Built-in target names, configured by Config.TestCmd and Config.LintCmd.
+const (
+	TargetTest = "test"
+	TargetLint = "lint"
+)
+
+var targetNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// TargetConfig is a named QA check beyond test and lint, e.g. build,
+// typecheck or e2e. Zero limits, an empty allowlist and an empty backend
+// fall back to the runner's Config.
+type TargetConfig struct {
+	Name               string
+	Command            string
+	Timeout            time.Duration
+	MaxOutputBytes     int
+	AllowedExecutables []string
+	Backend            string
+	// ResultFormat names a test result or lint finding parser for the
+	// command's output, read from ResultFile or stdout.
+	ResultFormat string
+	ResultFile   string
+}
+
+// ParseTargets reads the JSON list of QA_TARGETS, e.g.
+// [{"name":"build","command":"go build ./...","timeout_seconds":120}].
+func ParseTargets(raw string) ([]TargetConfig, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var entries []struct {
+		Name               string   `json:"name"`
+		Command            string   `json:"command"`
+		TimeoutSeconds     int      `json:"timeout_seconds"`
+		MaxOutputBytes     int      `json:"max_output_bytes"`
+		AllowedExecutables []string `json:"allowed_executables"`
+		Backend            string   `json:"backend"`
+		ResultFormat       string   `json:"result_format"`
+		ResultFile         string   `json:"result_file"`
+	}
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		return nil, &QAError{ErrCode: ErrCodeTargetInvalid, Detail: fmt.Sprintf("parse qa targets: %v", err)}
+	}
+	targets := make([]TargetConfig, 0, len(entries))
+	for _, e := range entries {
+		targets = append(targets, TargetConfig{
+			Name:               e.Name,
+			Command:            e.Command,
+			Timeout:            time.Duration(e.TimeoutSeconds) * time.Second,
+			MaxOutputBytes:     e.MaxOutputBytes,
+			AllowedExecutables: e.AllowedExecutables,
+			Backend:            e.Backend,
+			ResultFormat:       e.ResultFormat,
+			ResultFile:         e.ResultFile,
+		})
+	}
+	return targets, nil
+}
+
+// TargetKind is the kind of the named target; the built-in targets are
+// KindTest and KindLint.
+func TargetKind(name string) Kind {
+	return Kind("qa." + name)
+}
+
+// Target is the name of the target kind runs, e.g. test for qa.test.
+func (k Kind) Target() string {
+	return strings.TrimPrefix(string(k), "qa.")
+}
+
+// TargetInfo describes a configured target to clients choosing one.
+type TargetInfo struct {
+	Name               string   `json:"name"`
+	Command            string   `json:"command"`
+	TimeoutSeconds     int      `json:"timeout_seconds"`
+	MaxOutputBytes     int      `json:"max_output_bytes"`
+	AllowedExecutables []string `json:"allowed_executables"`
+	Backend            string   `json:"backend" jsonschema:"enum=local|sandbox"`
+	ResultFormat       string   `json:"result_format,omitempty"`
+}
+
+// target is a resolved QA command with its own limits and backend.
+type target struct {
+	name           string
+	cmdline        string
+	timeout        time.Duration
+	maxOutputBytes int
+	allowed        map[string]bool
+	backend        string
+	sandbox        *SandboxRunner
+	resultFormat   string
+	resultFile     string
+}
+
+// newTarget validates tc and fills its unset limits from the runner's cfg.
+func newTarget(cfg Config, defaultAllowed map[string]bool, tc TargetConfig) (*target, error) {
+	t := &target{
+		name:           tc.Name,
+		cmdline:        tc.Command,
+		timeout:        tc.Timeout,
+		maxOutputBytes: tc.MaxOutputBytes,
+		allowed:        defaultAllowed,
+		backend:        strings.TrimSpace(tc.Backend),
+		resultFormat:   tc.ResultFormat,
+		resultFile:     tc.ResultFile,
+	}
+	if t.timeout <= 0 {
+		t.timeout = cfg.Timeout
+	}
+	if t.maxOutputBytes <= 0 {
+		t.maxOutputBytes = cfg.MaxOutputBytes
+	}
+	if t.backend == "" {
+		t.backend = cfg.Backend
+	}
+	if t.backend != "local" && t.backend != "sandbox" {
+		return nil, &QAError{ErrCode: ErrCodeBackendInvalid, Detail: fmt.Sprintf("unsupported qa backend for target %s: %s", t.name, t.backend)}
+	}
+	if len(tc.AllowedExecutables) > 0 {
+		t.allowed = make(map[string]bool, len(tc.AllowedExecutables))
+		for _, exe := range tc.AllowedExecutables {
+			if exe = strings.TrimSpace(exe); exe != "" {
+				t.allowed[exe] = true
+			}
+		}
+	}
+	if err := validateConfiguredCommand(t.cmdline, t.allowed); err != nil {
+		return nil, err
+	}
+	if err := validateResultConfig(t.name, t.resultFormat, t.resultFile, knownResultFormat); err != nil {
+		return nil, err
+	}
+	if t.backend == "sandbox" {
+		t.sandbox = NewSandboxRunner(SandboxConfig{
+			Image:            cfg.SandboxImage,
+			DockerBinary:     cfg.SandboxDockerBin,
+			ContainerWorkDir: cfg.SandboxContainerWD,
+			Timeout:          t.timeout,
+			MaxOutputBytes:   t.maxOutputBytes,
+		})
+	}
+	return t, nil
+}
+
+// knownResultFormat reports whether format names a test result or a lint
+// finding parser.
+func knownResultFormat(format string) bool {
+	if _, ok := lookupResultParser(format); ok {
+		return true
+	}
+	_, ok := lookupFindingParser(format)
+	return ok
+}
+
+func (t *target) info() TargetInfo {
+	allowed := make([]string, 0, len(t.allowed))
+	for exe := range t.allowed {
+		allowed = append(allowed, exe)
+	}
+	sort.Strings(allowed)
+	return TargetInfo{
+		Name:               t.name,
+		Command:            t.cmdline,
+		TimeoutSeconds:     int(t.timeout / time.Second),
+		MaxOutputBytes:     t.maxOutputBytes,
+		AllowedExecutables: allowed,
+		Backend:            t.backend,
+		ResultFormat:       t.resultFormat,
+	}
+}
+
+// Targets lists the configured targets: test and lint, then the named
+// targets in configuration order.
+func (r *Runner) Targets() []TargetInfo {
+	out := make([]TargetInfo, 0, len(r.order))
+	for _, name := range r.order {
+		out = append(out, r.targets[name].info())
+	}
+	return out
+}
+
+// HasTarget reports whether name is a configured target.
+func (r *Runner) HasTarget(name string) bool {
+	_, ok := r.targets[name]
+	return ok
+}
+
+func (r *Runner) targetFor(kind Kind) (*target, error) {
+	if !strings.HasPrefix(string(kind), "qa.") {
+		return nil, &QAError{ErrCode: ErrCodeToolUnsupported, Detail: fmt.Sprintf("unsupported qa tool: %s", kind)}
+	}
+	t, ok := r.targets[kind.Target()]
+	if !ok {
+		return nil, &QAError{ErrCode: ErrCodeTargetNotFound, Detail: fmt.Sprintf("unknown qa target: %s", kind.Target())}
+	}
+	return t, nil
+}
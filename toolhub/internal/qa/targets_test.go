@@ -0,0 +1,117 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTargets(t *testing.T) {
+	targets, err := ParseTargets(`[{"name":"build","command":"go build ./...","timeout_seconds":120,"allowed_executables":["go"]},{"name":"e2e","command":"make e2e","backend":"sandbox"}]`)
+	if err != nil {
+		t.Fatalf("parse targets: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("targets = %+v", targets)
+	}
+	if targets[0].Name != "build" || targets[0].Timeout != 2*time.Minute || len(targets[0].AllowedExecutables) != 1 {
+		t.Fatalf("build target = %+v", targets[0])
+	}
+	if targets[1].Backend != "sandbox" || targets[1].Timeout != 0 {
+		t.Fatalf("e2e target = %+v", targets[1])
+	}
+
+	if targets, err := ParseTargets(" "); err != nil || targets != nil {
+		t.Fatalf("empty = %v, %v", targets, err)
+	}
+	_, err = ParseTargets(`{"name":"build"}`)
+	var qaErr *QAError
+	if !errors.As(err, &qaErr) || qaErr.ErrCode != ErrCodeTargetInvalid {
+		t.Fatalf("err = %v, want %s", err, ErrCodeTargetInvalid)
+	}
+}
+
+func TestRunnerTargetValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  TargetConfig
+		errCode string
+	}{
+		{name: "valid", target: TargetConfig{Name: "build", Command: "go build ./..."}},
+		{name: "bad name", target: TargetConfig{Name: "Build", Command: "go build ./..."}, errCode: ErrCodeTargetInvalid},
+		{name: "built in", target: TargetConfig{Name: "test", Command: "go test ./..."}, errCode: ErrCodeTargetInvalid},
+		{name: "empty command", target: TargetConfig{Name: "build"}, errCode: ErrCodeCommandEmpty},
+		{name: "shell operator", target: TargetConfig{Name: "build", Command: "go build ./... && echo ok"}, errCode: ErrCodeCommandInvalid},
+		{name: "not in target allowlist", target: TargetConfig{Name: "build", Command: "go build ./...", AllowedExecutables: []string{"make"}}, errCode: ErrCodeCommandNotAllowed},
+		{name: "target allowlist", target: TargetConfig{Name: "fmt", Command: "gofmt -l .", AllowedExecutables: []string{"gofmt"}}},
+		{name: "bad backend", target: TargetConfig{Name: "build", Command: "go build ./...", Backend: "vm"}, errCode: ErrCodeBackendInvalid},
+		{name: "finding format", target: TargetConfig{Name: "vet", Command: "go vet ./...", ResultFormat: FindingFormatSARIF, ResultFile: "vet.sarif"}},
+		{name: "unknown format", target: TargetConfig{Name: "vet", Command: "go vet ./...", ResultFormat: "tap"}, errCode: ErrCodeResultsInvalid},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewRunner(Config{WorkDir: ".", Targets: []TargetConfig{tc.target}})
+			if tc.errCode == "" {
+				if err != nil {
+					t.Fatalf("new runner: %v", err)
+				}
+				return
+			}
+			var qaErr *QAError
+			if !errors.As(err, &qaErr) || qaErr.ErrCode != tc.errCode {
+				t.Fatalf("err = %v, want %s", err, tc.errCode)
+			}
+		})
+	}
+
+	_, err := NewRunner(Config{WorkDir: ".", Targets: []TargetConfig{{Name: "build", Command: "go build ./..."}, {Name: "build", Command: "go vet ./..."}}})
+	if err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Fatalf("duplicate target err = %v", err)
+	}
+}
+
+func TestRunnerRunsTargets(t *testing.T) {
+	r, err := NewRunner(Config{
+		WorkDir: ".",
+		Timeout: 5 * time.Second,
+		Targets: []TargetConfig{
+			{Name: "build", Command: "true", AllowedExecutables: []string{"true"}, MaxOutputBytes: 1024},
+			{Name: "slow", Command: "sleep 2", AllowedExecutables: []string{"sleep"}, Timeout: 50 * time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatalf("new runner: %v", err)
+	}
+
+	var names []string
+	for _, info := range r.Targets() {
+		names = append(names, info.Name)
+	}
+	if strings.Join(names, ",") != "test,lint,build,slow" {
+		t.Fatalf("targets = %v", names)
+	}
+	if !r.HasTarget("build") || r.HasTarget("e2e") {
+		t.Fatal("HasTarget does not match the configured targets")
+	}
+
+	report, err := r.Run(context.Background(), TargetKind("build"), false)
+	if err != nil {
+		t.Fatalf("run build: %v", err)
+	}
+	if report.Command != "true" || report.OutputLimitBytes != 1024 {
+		t.Fatalf("build report = %+v", report)
+	}
+
+	_, err = r.Run(context.Background(), TargetKind("slow"), false)
+	var qaErr *QAError
+	if !errors.As(err, &qaErr) || qaErr.ErrCode != ErrCodeTimeout {
+		t.Fatalf("slow err = %v, want %s", err, ErrCodeTimeout)
+	}
+
+	_, err = r.Run(context.Background(), TargetKind("e2e"), true)
+	if !errors.As(err, &qaErr) || qaErr.ErrCode != ErrCodeTargetNotFound {
+		t.Fatalf("unknown target err = %v, want %s", err, ErrCodeTargetNotFound)
+	}
+}
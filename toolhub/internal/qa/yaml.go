@@ -0,0 +1,303 @@
+package qa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML parses the YAML subset used by ToolHub configuration files:
+// block mappings and sequences, plain and quoted scalars, flow sequences of
+// scalars and comments. Anchors, tags, block scalars and flow mappings are
+// rejected. Scalars are returned as strings, null as nil, mappings as
+// map[string]any and sequences as []any.
+func parseYAML(data []byte) (any, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		text := stripYAMLComment(raw)
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.TrimSpace(trimmed) == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		if trimmed == "---" && len(lines) == 0 {
+			continue
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: strings.TrimRight(trimmed, " \t")})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.parseBlock(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) parseBlock(indent int) (any, error) {
+	if isYAMLSeqItem(p.lines[p.pos].text) {
+		return p.parseSeq(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseMap(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		if isYAMLSeqItem(l.text) {
+			return nil, fmt.Errorf("line %d: expected a key, got a list item", l.num)
+		}
+		key, rest, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", l.num)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, key)
+		}
+		p.pos++
+		v, err := p.parseValue(indent, rest, l.num, true)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) ([]any, error) {
+	seq := []any{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && !isYAMLSeqItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if _, _, ok := splitYAMLKey(rest); ok {
+			// "- key: value" starts a mapping indented to its key.
+			itemIndent := l.indent + len(l.text) - len(rest)
+			p.lines[p.pos] = yamlLine{num: l.num, indent: itemIndent, text: rest}
+			v, err := p.parseMap(itemIndent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		p.pos++
+		v, err := p.parseValue(indent, rest, l.num, false)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+	}
+	return seq, nil
+}
+
+// parseValue parses the value after a key or list dash: inline text, or
+// the block nested below the line. Under a key, a list may also start at
+// the key's own indentation.
+func (p *yamlParser) parseValue(indent int, rest string, num int, underKey bool) (any, error) {
+	if rest != "" {
+		return parseYAMLInline(rest, num)
+	}
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	switch {
+	case next.indent > indent:
+		return p.parseBlock(next.indent)
+	case underKey && next.indent == indent && isYAMLSeqItem(next.text):
+		return p.parseSeq(indent)
+	default:
+		return nil, nil
+	}
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" or "key:"; the key may be quoted.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	if text == "" {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		if end < 0 || end+1 >= len(text) || text[end+1] != ':' {
+			return "", "", false
+		}
+		key, err := parseYAMLScalar(text[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		after := text[end+2:]
+		if after != "" && after[0] != ' ' {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(after), true
+	}
+	if strings.ContainsAny(text[:1], "[{") {
+		return "", "", false
+	}
+	if i := strings.Index(text, ": "); i > 0 {
+		return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+2:]), true
+	}
+	if strings.HasSuffix(text, ":") && len(text) > 1 {
+		return strings.TrimSpace(text[:len(text)-1]), "", true
+	}
+	return "", "", false
+}
+
+func parseYAMLInline(text string, num int) (any, error) {
+	switch text[0] {
+	case '[':
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("line %d: unterminated flow sequence", num)
+		}
+		items := []any{}
+		for _, item := range splitFlowItems(text[1 : len(text)-1]) {
+			if item == "" {
+				continue
+			}
+			v, err := parseYAMLScalar(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", num, err)
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case '{':
+		if text == "{}" {
+			return map[string]any{}, nil
+		}
+		return nil, fmt.Errorf("line %d: flow mappings are not supported", num)
+	case '|', '>':
+		return nil, fmt.Errorf("line %d: block scalars are not supported", num)
+	case '&', '*', '!':
+		return nil, fmt.Errorf("line %d: anchors, aliases and tags are not supported", num)
+	}
+	v, err := parseYAMLScalar(text)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", num, err)
+	}
+	if v == "null" || v == "~" {
+		if text[0] != '"' && text[0] != '\'' {
+			return nil, nil
+		}
+	}
+	return v, nil
+}
+
+func parseYAMLScalar(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", nil
+	}
+	switch text[0] {
+	case '"':
+		if closingQuote(text) != len(text)-1 {
+			return "", fmt.Errorf("invalid quoted string %s", text)
+		}
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", text)
+		}
+		return s, nil
+	case '\'':
+		if closingQuote(text) != len(text)-1 {
+			return "", fmt.Errorf("invalid quoted string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	return text, nil
+}
+
+// closingQuote returns the index of the quote closing the string that
+// text starts with, or -1.
+func closingQuote(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case q == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitFlowItems splits the inside of a flow sequence at commas outside
+// quotes.
+func splitFlowItems(text string) []string {
+	var items []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			if end := closingQuote(text[i:]); end > 0 {
+				i += end
+			}
+		case ',':
+			items = append(items, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	return append(items, strings.TrimSpace(text[start:]))
+}
+
+// stripYAMLComment removes a # comment that starts the line or follows a
+// space, outside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t[,:-", rune(line[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
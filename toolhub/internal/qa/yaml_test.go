@@ -0,0 +1,77 @@
+package qa
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    any
+		wantErr string
+	}{
+		{
+			name: "nested mappings and lists",
+			in: `# QA settings
+qa:
+  test:
+    command: pytest -q   # fast suite
+    timeout_seconds: 300
+    allowed_executables: [pytest, "python3"]
+  lint:
+    command: 'ruff check --output-format json'
+    tags:
+    - a
+    - "b # not a comment"
+`,
+			want: map[string]any{"qa": map[string]any{
+				"test": map[string]any{"command": "pytest -q", "timeout_seconds": "300", "allowed_executables": []any{"pytest", "python3"}},
+				"lint": map[string]any{"command": "ruff check --output-format json", "tags": []any{"a", "b # not a comment"}},
+			}},
+		},
+		{
+			name: "list of mappings",
+			in: `---
+items:
+  - name: build
+    command: go build ./...
+  - name: vet
+  -
+    nested: ~
+`,
+			want: map[string]any{"items": []any{
+				map[string]any{"name": "build", "command": "go build ./..."},
+				map[string]any{"name": "vet"},
+				map[string]any{"nested": nil},
+			}},
+		},
+		{name: "quoted keys and escapes", in: `"acme/web": "a\tb"` + "\n'it''s': x\n", want: map[string]any{"acme/web": "a\tb", "it's": "x"}},
+		{name: "empty", in: "# nothing\n", want: nil},
+		{name: "duplicate key", in: "a: 1\na: 2\n", wantErr: "duplicate key"},
+		{name: "bad indentation", in: "a:\n    b: 1\n  c: 2\n", wantErr: "unexpected indentation"},
+		{name: "block scalar", in: "a: |\n  text\n", wantErr: "block scalars"},
+		{name: "anchor", in: "a: &x 1\n", wantErr: "anchors"},
+		{name: "tab indentation", in: "a:\n\tb: 1\n", wantErr: "tabs"},
+		{name: "not a mapping line", in: "a: 1\njust text\n", wantErr: "expected key"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tc.in))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %#v\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
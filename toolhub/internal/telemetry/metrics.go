@@ -21,6 +21,14 @@ type registry struct {
 	repairQAResults       map[string]map[string]int64
 	repairCompleted       map[string]int64
 	repairRollbacks       map[string]int64
+	rateLimitRejections   map[string]map[string]int64
+	rateLimitUsage        map[string]map[string]rateLimitUsage
+}
+
+type rateLimitUsage struct {
+	tokens     float64
+	dailyUsed  int
+	dailyLimit int
 }
 
 func newRegistry() *registry {
@@ -32,6 +40,8 @@ func newRegistry() *registry {
 		repairQAResults:     make(map[string]map[string]int64),
 		repairCompleted:     make(map[string]int64),
 		repairRollbacks:     make(map[string]int64),
+		rateLimitRejections: make(map[string]map[string]int64),
+		rateLimitUsage:      make(map[string]map[string]rateLimitUsage),
 	}
 }
 
@@ -111,6 +121,28 @@ func IncRepairRollback(status string) {
 	defaultRegistry.mu.Unlock()
 }
 
+func IncRateLimitRejection(toolName, reason string) {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+	if _, ok := defaultRegistry.rateLimitRejections[toolName]; !ok {
+		defaultRegistry.rateLimitRejections[toolName] = make(map[string]int64)
+	}
+	defaultRegistry.rateLimitRejections[toolName][reason]++
+}
+
+// SetRateLimitUsage records the state of the rate limit bucket a call of
+// tool used under rule. Buckets are labelled by rule and tool only: their
+// keys hold run IDs and client-supplied principals, which would make the
+// series unbounded.
+func SetRateLimitUsage(rule, tool string, tokens float64, dailyUsed, dailyLimit int) {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+	if _, ok := defaultRegistry.rateLimitUsage[rule]; !ok {
+		defaultRegistry.rateLimitUsage[rule] = make(map[string]rateLimitUsage)
+	}
+	defaultRegistry.rateLimitUsage[rule][tool] = rateLimitUsage{tokens: tokens, dailyUsed: dailyUsed, dailyLimit: dailyLimit}
+}
+
 func RenderPrometheus() string {
 	defaultRegistry.mu.Lock()
 	defer defaultRegistry.mu.Unlock()
@@ -122,7 +154,7 @@ func RenderPrometheus() string {
 	for _, tool := range toolNames {
 		statuses := sortedKeys(defaultRegistry.toolCalls[tool])
 		for _, status := range statuses {
-			sb.WriteString(fmt.Sprintf("toolhub_tool_calls_total{tool=\"%s\",status=\"%s\"} %d\n", tool, status, defaultRegistry.toolCalls[tool][status]))
+			sb.WriteString(fmt.Sprintf("toolhub_tool_calls_total{tool=\"%s\",status=\"%s\"} %d\n", escapeLabel(tool), escapeLabel(status), defaultRegistry.toolCalls[tool][status]))
 		}
 	}
 
@@ -131,7 +163,7 @@ func RenderPrometheus() string {
 	for _, tool := range sortedKeys(defaultRegistry.toolDurationBuckets) {
 		counts := defaultRegistry.toolDurationBuckets[tool]
 		for i, v := range counts {
-			sb.WriteString(fmt.Sprintf("toolhub_tool_duration_seconds_bucket{tool=\"%s\",le=\"%s\"} %d\n", tool, bucketLabels[i], v))
+			sb.WriteString(fmt.Sprintf("toolhub_tool_duration_seconds_bucket{tool=\"%s\",le=\"%s\"} %d\n", escapeLabel(tool), bucketLabels[i], v))
 		}
 	}
 
@@ -149,36 +181,68 @@ func RenderPrometheus() string {
 		}
 		sort.Ints(statusCodes)
 		for _, sc := range statusCodes {
-			sb.WriteString(fmt.Sprintf("toolhub_github_api_errors_total{operation=\"%s\",status_code=\"%d\"} %d\n", op, sc, defaultRegistry.githubAPIErrors[op][sc]))
+			sb.WriteString(fmt.Sprintf("toolhub_github_api_errors_total{operation=\"%s\",status_code=\"%d\"} %d\n", escapeLabel(op), sc, defaultRegistry.githubAPIErrors[op][sc]))
 		}
 	}
 
 	sb.WriteString("# TYPE toolhub_repair_loop_iterations_total counter\n")
 	for _, status := range sortedKeys(defaultRegistry.repairIterations) {
-		sb.WriteString(fmt.Sprintf("toolhub_repair_loop_iterations_total{status=\"%s\"} %d\n", status, defaultRegistry.repairIterations[status]))
+		sb.WriteString(fmt.Sprintf("toolhub_repair_loop_iterations_total{status=\"%s\"} %d\n", escapeLabel(status), defaultRegistry.repairIterations[status]))
 	}
 
 	sb.WriteString("# TYPE toolhub_repair_loop_qa_results_total counter\n")
 	for _, kind := range sortedKeys(defaultRegistry.repairQAResults) {
 		statuses := sortedKeys(defaultRegistry.repairQAResults[kind])
 		for _, status := range statuses {
-			sb.WriteString(fmt.Sprintf("toolhub_repair_loop_qa_results_total{kind=\"%s\",status=\"%s\"} %d\n", kind, status, defaultRegistry.repairQAResults[kind][status]))
+			sb.WriteString(fmt.Sprintf("toolhub_repair_loop_qa_results_total{kind=\"%s\",status=\"%s\"} %d\n", escapeLabel(kind), escapeLabel(status), defaultRegistry.repairQAResults[kind][status]))
 		}
 	}
 
 	sb.WriteString("# TYPE toolhub_repair_loop_completed_total counter\n")
 	for _, outcome := range sortedKeys(defaultRegistry.repairCompleted) {
-		sb.WriteString(fmt.Sprintf("toolhub_repair_loop_completed_total{outcome=\"%s\"} %d\n", outcome, defaultRegistry.repairCompleted[outcome]))
+		sb.WriteString(fmt.Sprintf("toolhub_repair_loop_completed_total{outcome=\"%s\"} %d\n", escapeLabel(outcome), defaultRegistry.repairCompleted[outcome]))
 	}
 
 	sb.WriteString("# TYPE toolhub_repair_loop_rollbacks_total counter\n")
 	for _, status := range sortedKeys(defaultRegistry.repairRollbacks) {
-		sb.WriteString(fmt.Sprintf("toolhub_repair_loop_rollbacks_total{status=\"%s\"} %d\n", status, defaultRegistry.repairRollbacks[status]))
+		sb.WriteString(fmt.Sprintf("toolhub_repair_loop_rollbacks_total{status=\"%s\"} %d\n", escapeLabel(status), defaultRegistry.repairRollbacks[status]))
+	}
+
+	sb.WriteString("# TYPE toolhub_rate_limit_rejections_total counter\n")
+	for _, tool := range sortedKeys(defaultRegistry.rateLimitRejections) {
+		for _, reason := range sortedKeys(defaultRegistry.rateLimitRejections[tool]) {
+			sb.WriteString(fmt.Sprintf("toolhub_rate_limit_rejections_total{tool=\"%s\",reason=\"%s\"} %d\n", escapeLabel(tool), escapeLabel(reason), defaultRegistry.rateLimitRejections[tool][reason]))
+		}
+	}
+
+	sb.WriteString("# TYPE toolhub_rate_limit_tokens_remaining gauge\n")
+	for _, rule := range sortedKeys(defaultRegistry.rateLimitUsage) {
+		for _, tool := range sortedKeys(defaultRegistry.rateLimitUsage[rule]) {
+			sb.WriteString(fmt.Sprintf("toolhub_rate_limit_tokens_remaining{rule=\"%s\",tool=\"%s\"} %g\n", escapeLabel(rule), escapeLabel(tool), defaultRegistry.rateLimitUsage[rule][tool].tokens))
+		}
+	}
+
+	sb.WriteString("# TYPE toolhub_rate_limit_daily_used gauge\n")
+	for _, rule := range sortedKeys(defaultRegistry.rateLimitUsage) {
+		for _, tool := range sortedKeys(defaultRegistry.rateLimitUsage[rule]) {
+			u := defaultRegistry.rateLimitUsage[rule][tool]
+			if u.dailyLimit == 0 {
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("toolhub_rate_limit_daily_used{rule=\"%s\",tool=\"%s\",limit=\"%d\"} %d\n", escapeLabel(rule), escapeLabel(tool), u.dailyLimit, u.dailyUsed))
+		}
 	}
 
 	return sb.String()
 }
 
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value for the Prometheus text format.
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
 func sortedKeys[T any](m map[string]T) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
@@ -0,0 +1,30 @@
+package telemetry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderPrometheus_RateLimitLabels(t *testing.T) {
+	defaultRegistry = newRegistry()
+
+	IncRateLimitRejection("qa.\"test\"\nx", "rate")
+	SetRateLimitUsage("per_tool", "qa.test", 2, 3, 10)
+	SetRateLimitUsage("per_tool", "qa.test", 1, 4, 10)
+
+	out := RenderPrometheus()
+	for _, want := range []string{
+		`toolhub_rate_limit_rejections_total{tool="qa.\"test\"\nx",reason="rate"} 1`,
+		`toolhub_rate_limit_tokens_remaining{rule="per_tool",tool="qa.test"} 1`,
+		`toolhub_rate_limit_daily_used{rule="per_tool",tool="qa.test",limit="10"} 4`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Fatalf("output missing %s:\n%s", want, out)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "toolhub_") {
+			t.Fatalf("label value broke the exposition format: %q", line)
+		}
+	}
+}
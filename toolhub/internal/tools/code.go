@@ -0,0 +1,392 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/toolhub/toolhub/internal/codeops"
+	"github.com/toolhub/toolhub/internal/core"
+	gh "github.com/toolhub/toolhub/internal/github"
+	"github.com/toolhub/toolhub/internal/telemetry"
+)
+
+type CodePatchArgs struct {
+	RunID           string `json:"run_id"`
+	Path            string `json:"path"`
+	OriginalContent string `json:"original_content"`
+	ModifiedContent string `json:"modified_content"`
+	ContextLines    *int   `json:"context_lines,omitempty" jsonschema:"description=Unchanged lines around each hunk (default 3),minimum=0"`
+	DryRun          bool   `json:"dry_run,omitempty"`
+}
+
+type CodeBranchPRArgs struct {
+	RunID         string               `json:"run_id"`
+	ApprovalID    string               `json:"approval_id"`
+	BaseBranch    string               `json:"base_branch"`
+	HeadBranch    string               `json:"head_branch"`
+	CommitMessage string               `json:"commit_message"`
+	PRTitle       string               `json:"pr_title"`
+	PRBody        string               `json:"pr_body,omitempty"`
+	Files         []codeops.FileChange `json:"files"`
+	DryRun        bool                 `json:"dry_run,omitempty"`
+}
+
+type CodeBranchUpdateArgs struct {
+	RunID         string               `json:"run_id"`
+	ApprovalID    string               `json:"approval_id"`
+	PRNumber      int                  `json:"pr_number" jsonschema:"minimum=1"`
+	CommitMessage string               `json:"commit_message"`
+	Files         []codeops.FileChange `json:"files"`
+	// ExpectedHeadSHA is the PR head the changes were made against.
+	ExpectedHeadSHA string `json:"expected_head_sha,omitempty" jsonschema:"description=Fail with head_branch_moved unless the PR head is still at this commit"`
+	ForceWithLease  bool   `json:"force_with_lease,omitempty" jsonschema:"description=Rebuild the branch from the PR base and replace its commits; pushed only while the head is unchanged"`
+	DryRun          bool   `json:"dry_run,omitempty"`
+}
+
+type PatchResult struct {
+	Path            string `json:"path"`
+	Patch           string `json:"patch"`
+	LineDelta       int    `json:"line_delta"`
+	PatchArtifactID string `json:"patch_artifact_id,omitempty"`
+}
+
+type BranchPRResult struct {
+	BaseBranch      string          `json:"base_branch"`
+	HeadBranch      string          `json:"head_branch"`
+	PlannedCommands []string        `json:"planned_commands"`
+	CommitHash      string          `json:"commit_hash"`
+	PullRequest     *gh.PullRequest `json:"pull_request,omitempty"`
+	PatchArtifactID string          `json:"patch_artifact_id,omitempty"`
+	// AppliedPatchArtifactID is the diff actually committed against the base
+	// branch; it differs from the submitted patch when hunks applied at an
+	// offset or changes were sent as edit blocks.
+	AppliedPatchArtifactID string                 `json:"applied_patch_artifact_id,omitempty"`
+	Conflicts              []codeops.HunkConflict `json:"conflicts,omitempty"`
+	StaleFiles             []codeops.StaleFile    `json:"stale_files,omitempty"`
+	Verification           *codeops.Verification  `json:"verification,omitempty"`
+}
+
+type BranchUpdateResult struct {
+	PullRequest     *gh.PullRequest `json:"pull_request"`
+	BaseBranch      string          `json:"base_branch"`
+	HeadBranch      string          `json:"head_branch"`
+	PlannedCommands []string        `json:"planned_commands"`
+	// PreviousHeadSHA is the head the commit was added to, or replaced with
+	// force_with_lease.
+	PreviousHeadSHA        string                 `json:"previous_head_sha,omitempty"`
+	CommitHash             string                 `json:"commit_hash"`
+	PatchArtifactID        string                 `json:"patch_artifact_id,omitempty"`
+	AppliedPatchArtifactID string                 `json:"applied_patch_artifact_id,omitempty"`
+	Conflicts              []codeops.HunkConflict `json:"conflicts,omitempty"`
+	StaleFiles             []codeops.StaleFile    `json:"stale_files,omitempty"`
+	Verification           *codeops.Verification  `json:"verification,omitempty"`
+}
+
+func (t *toolset) registerCode(reg *core.Registry) {
+	reg.Register(core.ToolSpec{
+		Name:        "code.patch.generate",
+		Description: "Generate unified patch/diff without modifying repository",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/code/patch", OperationID: "generateCodePatch", Params: []core.RouteParam{runIDParam}},
+		NewArgs:     func() any { return &CodePatchArgs{} },
+		Result:      PatchResult{},
+		Policy: core.ToolPolicy{
+			Validate: func(args any) error {
+				if strings.TrimSpace(args.(*CodePatchArgs).Path) == "" {
+					return core.BadToolRequest("path is required")
+				}
+				return nil
+			},
+			Paths: func(args any) []string { return []string{args.(*CodePatchArgs).Path} },
+		},
+		Execute: t.codePatchGenerate,
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "code.branch_pr.create",
+		Description: "Create branch, commit changes, push branch, and open PR (requires approved approval_id)",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/code/branch-pr", OperationID: "createCodeBranchPR", Params: []core.RouteParam{runIDParam}},
+		NewArgs:     func() any { return &CodeBranchPRArgs{} },
+		Result:      BranchPRResult{},
+		Policy: core.ToolPolicy{
+			ApprovalScope: "code_write",
+			Validate:      func(args any) error { return validateFileChanges(args.(*CodeBranchPRArgs).Files) },
+			Paths:         func(args any) []string { return filePaths(args.(*CodeBranchPRArgs).Files) },
+		},
+		Execute: t.codeBranchPRCreate,
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "code.branch.update",
+		Description: "Commit changes on the head branch of a pull request opened by ToolHub and push it fast-forward (requires approved approval_id)",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/code/prs/{prNumber}/branch", OperationID: "updateCodeBranch", Params: []core.RouteParam{runIDParam, prNumberParam}},
+		NewArgs:     func() any { return &CodeBranchUpdateArgs{} },
+		Result:      BranchUpdateResult{},
+		Policy: core.ToolPolicy{
+			ApprovalScope: "code_write",
+			Validate:      func(args any) error { return validateFileChanges(args.(*CodeBranchUpdateArgs).Files) },
+			Paths:         func(args any) []string { return filePaths(args.(*CodeBranchUpdateArgs).Files) },
+		},
+		Execute: t.codeBranchUpdate,
+	})
+}
+
+func validateFileChanges(files []codeops.FileChange) error {
+	for _, f := range files {
+		if _, err := f.Operation(); err != nil {
+			return core.BadToolRequest("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+func filePaths(files []codeops.FileChange) []string {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.Paths()...)
+	}
+	return paths
+}
+
+// combinedPatch renders the changes as submitted: generated diffs for full
+// contents, the patch text as given, and edit blocks in search/replace form.
+func combinedPatch(files []codeops.FileChange) string {
+	var b strings.Builder
+	for _, f := range files {
+		op, _ := f.Operation()
+		switch {
+		case op == codeops.OpDelete:
+			b.WriteString(core.GenerateUnifiedDiffWithOptions(f.Path, f.OriginalContent, "", core.DiffOptions{Context: core.DefaultDiffContext, DeletedFile: true}))
+		case (op == codeops.OpRename || op == codeops.OpChmod) && f.Patch == "" && len(f.Edits) == 0 && f.ModifiedContent == "":
+			// Header only: the content is unchanged.
+			path := f.Path
+			if op == codeops.OpRename {
+				path = f.NewPath
+			}
+			b.WriteString(core.GenerateUnifiedDiffWithOptions(path, f.OriginalContent, f.OriginalContent, core.DiffOptions{OldPath: f.Path, NewMode: f.FileMode}))
+		case f.Patch != "":
+			b.WriteString(f.Patch)
+			if !strings.HasSuffix(f.Patch, "\n") {
+				b.WriteString("\n")
+			}
+		case len(f.Edits) > 0:
+			for _, e := range f.Edits {
+				fmt.Fprintf(&b, "%s\n<<<<<<< SEARCH\n%s\n=======\n%s\n>>>>>>> REPLACE\n", f.Path, strings.TrimSuffix(e.Search, "\n"), strings.TrimSuffix(e.Replace, "\n"))
+			}
+		default:
+			b.WriteString(core.GenerateUnifiedDiff(f.Path, f.OriginalContent, f.ModifiedContent))
+		}
+	}
+	return b.String()
+}
+
+// codeArtifacts records the submitted changes and, once applied, the diff
+// that was committed.
+func codeArtifacts(tool string, files []codeops.FileChange, res *codeops.Result) []core.ExtraArtifact {
+	artifacts := []core.ExtraArtifact{
+		{Name: tool + ".patch.diff", ContentType: "text/x-diff", Body: []byte(combinedPatch(files))},
+	}
+	if res.AppliedPatch != "" {
+		artifacts = append(artifacts, core.ExtraArtifact{Name: tool + ".applied.diff", ContentType: "text/x-diff", Body: []byte(res.AppliedPatch)})
+	}
+	return artifacts
+}
+
+// setArtifactIDs exposes the extra artifacts recorded for the result, in
+// the order they were listed.
+func setArtifactIDs(dsts ...*string) func(*core.ToolEnvelope, []string) {
+	return func(_ *core.ToolEnvelope, ids []string) {
+		for i, id := range ids {
+			if i < len(dsts) {
+				*dsts[i] = id
+			}
+		}
+	}
+}
+
+func (t *toolset) codePatchGenerate(_ context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	args := call.Args.(*CodePatchArgs)
+	opts := core.DiffOptions{Context: core.DefaultDiffContext}
+	if args.ContextLines != nil {
+		opts.Context = *args.ContextLines
+	}
+	patchText := core.GenerateUnifiedDiffWithOptions(args.Path, args.OriginalContent, args.ModifiedContent, opts)
+	lineDelta := core.CountContentLines(args.ModifiedContent) - core.CountContentLines(args.OriginalContent)
+
+	result := &PatchResult{Path: args.Path, Patch: patchText, LineDelta: lineDelta}
+	return &core.ToolOutcome{
+		Result:   result,
+		Request:  args,
+		Response: PatchResult{Path: args.Path, Patch: patchText, LineDelta: lineDelta},
+		ExtraArtifacts: []core.ExtraArtifact{
+			{Name: "code.patch.generate.patch.diff", ContentType: "text/x-diff", Body: []byte(patchText)},
+		},
+		Finalize: setArtifactIDs(&result.PatchArtifactID),
+	}, nil
+}
+
+func (t *toolset) codeBranchPRCreate(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	if t.Code == nil {
+		return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "code runner is not configured"}
+	}
+	args := call.Args.(*CodeBranchPRArgs)
+
+	codeResult, runErr := t.Code.Execute(ctx, codeops.Request{
+		Repo:          call.Run.Repo,
+		BaseBranch:    args.BaseBranch,
+		HeadBranch:    args.HeadBranch,
+		CommitMessage: args.CommitMessage,
+		CoAuthor:      t.coAuthor(call),
+		Files:         args.Files,
+		DryRun:        args.DryRun,
+	})
+	if codeResult == nil {
+		codeResult = &codeops.Result{}
+	}
+	if runErr != nil {
+		telemetry.IncRepairCompleted("code_error")
+	}
+
+	result := &BranchPRResult{
+		BaseBranch:      args.BaseBranch,
+		HeadBranch:      args.HeadBranch,
+		PlannedCommands: codeResult.PlannedCommands,
+		CommitHash:      codeResult.CommitHash,
+		Conflicts:       codeResult.Conflicts,
+		StaleFiles:      codeResult.StaleFiles,
+		Verification:    codeResult.Verification,
+	}
+
+	if runErr == nil && !args.DryRun {
+		owner, repo := splitRepo(call.Run.Repo)
+		pr, prErr := t.GitHub.CreatePullRequest(ctx, owner, repo, gh.CreatePullRequestInput{
+			Title: args.PRTitle,
+			Head:  args.HeadBranch,
+			Base:  args.BaseBranch,
+			Body:  args.PRBody,
+		})
+		if prErr != nil {
+			runErr = prErr
+		} else {
+			result.PullRequest = pr
+		}
+	}
+
+	return &core.ToolOutcome{
+		Result:         result,
+		Err:            runErr,
+		ErrStatus:      http.StatusBadGateway,
+		Request:        args,
+		Response:       result,
+		ExtraArtifacts: codeArtifacts("code.branch_pr.create", args.Files, codeResult),
+		Finalize:       setArtifactIDs(&result.PatchArtifactID, &result.AppliedPatchArtifactID),
+	}, nil
+}
+
+func (t *toolset) codeBranchUpdate(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	if t.Code == nil {
+		return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "code runner is not configured"}
+	}
+	args := call.Args.(*CodeBranchUpdateArgs)
+	owner, repo := splitRepo(call.Run.Repo)
+
+	result := &BranchUpdateResult{}
+	codeResult := &codeops.Result{}
+	runErr := func() error {
+		pr, err := t.GitHub.GetPullRequest(ctx, owner, repo, args.PRNumber)
+		if err != nil {
+			return err
+		}
+		result.PullRequest = pr
+		result.BaseBranch = pr.Base.Ref
+		result.HeadBranch = pr.Head.Ref
+		bot, _, err := t.GitHub.BotIdentity(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkPROwned(pr, call.Run.Repo, bot); err != nil {
+			return err
+		}
+
+		res, err := t.Code.Execute(ctx, codeops.Request{
+			Repo:            call.Run.Repo,
+			BaseBranch:      pr.Base.Ref,
+			HeadBranch:      pr.Head.Ref,
+			CommitMessage:   args.CommitMessage,
+			CoAuthor:        t.coAuthor(call),
+			Files:           args.Files,
+			DryRun:          args.DryRun,
+			Update:          true,
+			ExpectedHeadSHA: args.ExpectedHeadSHA,
+			ForceWithLease:  args.ForceWithLease,
+		})
+		if res != nil {
+			codeResult = res
+		}
+		return err
+	}()
+
+	result.PlannedCommands = codeResult.PlannedCommands
+	result.PreviousHeadSHA = codeResult.PreviousHeadSHA
+	result.CommitHash = codeResult.CommitHash
+	result.Conflicts = codeResult.Conflicts
+	result.StaleFiles = codeResult.StaleFiles
+	result.Verification = codeResult.Verification
+
+	if runErr == nil && !args.DryRun {
+		decision := map[string]any{
+			"pr_number":         args.PRNumber,
+			"pr_url":            result.PullRequest.HTMLURL,
+			"head_branch":       result.HeadBranch,
+			"previous_head_sha": result.PreviousHeadSHA,
+			"commit_hash":       result.CommitHash,
+			"force_with_lease":  args.ForceWithLease,
+		}
+		if err := t.Audit.RecordDecision(ctx, call.Run.RunID, nil, "system", "pr_branch_updated", decision); err != nil {
+			call.Logger.Error("audit record decision failed", "err", err, "decision_type", "pr_branch_updated")
+		}
+	}
+
+	return &core.ToolOutcome{
+		Result:         result,
+		Err:            runErr,
+		ErrStatus:      http.StatusBadGateway,
+		Request:        args,
+		Response:       result,
+		ExtraArtifacts: codeArtifacts("code.branch.update", args.Files, codeResult),
+		Finalize:       setArtifactIDs(&result.PatchArtifactID, &result.AppliedPatchArtifactID),
+	}, nil
+}
+
+// PRRejectedError refuses a branch update of a pull request ToolHub does not
+// own or that is no longer open.
+type PRRejectedError struct {
+	Code    string
+	Message string
+}
+
+func (e *PRRejectedError) Error() string     { return e.Message }
+func (e *PRRejectedError) ErrorCode() string { return e.Code }
+
+// checkPROwned accepts open pull requests that the App's bot user opened
+// from a branch of repo itself.
+func checkPROwned(pr *gh.PullRequest, repo, bot string) error {
+	if pr.State != "open" {
+		return &PRRejectedError{Code: "pr_not_open", Message: fmt.Sprintf("pull request #%d is %s", pr.Number, pr.State)}
+	}
+	if !strings.EqualFold(pr.User.Login, bot) {
+		return &PRRejectedError{Code: "pr_not_owned", Message: fmt.Sprintf("pull request #%d was opened by %s, not by ToolHub", pr.Number, pr.User.Login)}
+	}
+	if pr.Head.Repo == nil || !strings.EqualFold(pr.Head.Repo.FullName, repo) {
+		return &PRRejectedError{Code: "pr_not_owned", Message: fmt.Sprintf("pull request #%d has its head branch outside %s", pr.Number, repo)}
+	}
+	return nil
+}
+
+func (t *toolset) coAuthor(call *core.ToolCall) string {
+	if !t.CoAuthorFromPrincipal {
+		return ""
+	}
+	return codeops.CoAuthor(call.Principal)
+}
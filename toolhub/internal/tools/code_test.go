@@ -0,0 +1,49 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+
+	gh "github.com/toolhub/toolhub/internal/github"
+)
+
+func TestCheckPROwned(t *testing.T) {
+	pr := func(state, author, headRepo string) *gh.PullRequest {
+		p := &gh.PullRequest{Number: 7, State: state}
+		p.User.Login = author
+		if headRepo != "" {
+			p.Head.Repo = &struct {
+				FullName string `json:"full_name"`
+			}{FullName: headRepo}
+		}
+		return p
+	}
+	tests := []struct {
+		name     string
+		pr       *gh.PullRequest
+		wantCode string
+	}{
+		{name: "opened by the app", pr: pr("open", "toolhub[bot]", "acme/app")},
+		{name: "repo name case differs", pr: pr("open", "ToolHub[bot]", "Acme/App")},
+		{name: "closed", pr: pr("closed", "toolhub[bot]", "acme/app"), wantCode: "pr_not_open"},
+		{name: "opened by a user", pr: pr("open", "octocat", "acme/app"), wantCode: "pr_not_owned"},
+		{name: "head in a fork", pr: pr("open", "toolhub[bot]", "octocat/app"), wantCode: "pr_not_owned"},
+		{name: "head repo deleted", pr: pr("open", "toolhub[bot]", ""), wantCode: "pr_not_owned"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkPROwned(tc.pr, "acme/app", "toolhub[bot]")
+			if tc.wantCode == "" {
+				if err != nil {
+					t.Fatalf("checkPROwned: %v", err)
+				}
+				return
+			}
+			var rejected *PRRejectedError
+			if !errors.As(err, &rejected) || rejected.Code != tc.wantCode {
+				t.Fatalf("err = %v, want %s", err, tc.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/toolhub/toolhub/internal/core"
+	"github.com/toolhub/toolhub/internal/db"
+	gh "github.com/toolhub/toolhub/internal/github"
+)
+
+type IssueCreateArgs struct {
+	RunID  string   `json:"run_id"`
+	Title  string   `json:"title"`
+	Body   string   `json:"body"`
+	Labels []string `json:"labels,omitempty"`
+	DryRun bool     `json:"dry_run,omitempty"`
+}
+
+type BatchIssue struct {
+	Title  string   `json:"title"`
+	Body   string   `json:"body"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+type IssueBatchCreateArgs struct {
+	RunID  string       `json:"run_id"`
+	Issues []BatchIssue `json:"issues"`
+	DryRun bool         `json:"dry_run,omitempty"`
+}
+
+type dryRunIssuePreview struct {
+	Repo   string   `json:"repo"`
+	Title  string   `json:"title"`
+	Body   string   `json:"body"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+type BatchItemResult struct {
+	Index    int       `json:"index"`
+	Issue    *gh.Issue `json:"issue,omitempty"`
+	Replayed bool      `json:"replayed,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type BatchResult struct {
+	Status       string            `json:"status"`
+	Mode         core.BatchMode    `json:"mode"`
+	Total        int               `json:"total"`
+	Processed    int               `json:"processed"`
+	Errors       int               `json:"errors"`
+	Replayed     int               `json:"replayed"`
+	CreatedFresh int               `json:"created_fresh"`
+	StoppedAt    *int              `json:"stopped_at,omitempty"`
+	FailedReason string            `json:"failed_reason,omitempty"`
+	Results      []BatchItemResult `json:"results"`
+}
+
+// issueResponse is the audited response of an issue create call; replays
+// decode the issue back out of it.
+type issueResponse struct {
+	Issue   *gh.Issue          `json:"issue"`
+	Preview dryRunIssuePreview `json:"preview"`
+}
+
+func (t *toolset) registerIssues(reg *core.Registry) {
+	reg.Register(core.ToolSpec{
+		Name:        "github.issues.create",
+		Description: "Create a GitHub issue within a run",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/issues", OperationID: "createIssue", Params: []core.RouteParam{runIDParam}},
+		NewArgs:     func() any { return &IssueCreateArgs{} },
+		Result:      gh.Issue{},
+		Policy: core.ToolPolicy{
+			Validate: func(args any) error {
+				a := args.(*IssueCreateArgs)
+				if err := core.ValidateIssueInput(a.Title, a.Body, a.Labels); err != nil {
+					return core.BadToolRequest("%s", err.Error())
+				}
+				return nil
+			},
+		},
+		Execute: t.issuesCreate,
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "github.issues.batch_create",
+		Description: "Create multiple GitHub issues within a run",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/issues/batch", OperationID: "batchCreateIssues", Params: []core.RouteParam{runIDParam}},
+		NewArgs:     func() any { return &IssueBatchCreateArgs{} },
+		Result:      BatchResult{},
+		Policy: core.ToolPolicy{
+			Validate: func(args any) error {
+				a := args.(*IssueBatchCreateArgs)
+				if len(a.Issues) == 0 {
+					return core.BadToolRequest("issues array is empty")
+				}
+				if len(a.Issues) > core.MaxBatchSize {
+					return core.BadToolRequest("issues exceed %d items", core.MaxBatchSize)
+				}
+				for i, in := range a.Issues {
+					if err := core.ValidateIssueInput(in.Title, in.Body, in.Labels); err != nil {
+						return core.BadToolRequest("issue %d: %s", i, err.Error())
+					}
+				}
+				return nil
+			},
+		},
+		Execute: t.issuesBatchCreate,
+	})
+}
+
+func (t *toolset) issuesCreate(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	args := call.Args.(*IssueCreateArgs)
+	run := call.Run
+
+	idemKey := call.IdempotencyKey
+	if idemKey == "" {
+		var err error
+		idemKey, err = core.MakeIssueIdempotencyKey(run.RunID, call.Name, args.Title, args.Body, args.Labels, nil)
+		if err != nil {
+			return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+		}
+	}
+
+	var replay json.RawMessage
+	tc, replayed, err := t.replay(ctx, call, idemKey, &replay)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return &core.ToolOutcome{Result: decodeReplayedIssue(replay), Replayed: tc}, nil
+	}
+
+	preview := dryRunIssuePreview{Repo: run.Repo, Title: args.Title, Body: args.Body, Labels: args.Labels}
+	var issue *gh.Issue
+	var ghErr error
+	if !args.DryRun {
+		owner, repo := splitRepo(run.Repo)
+		issue, ghErr = t.GitHub.CreateIssue(ctx, owner, repo, gh.CreateIssueInput{
+			Title:  args.Title,
+			Body:   args.Body,
+			Labels: args.Labels,
+		})
+	}
+
+	result := any(issue)
+	if args.DryRun {
+		result = map[string]any{"would_create": preview}
+	}
+	return &core.ToolOutcome{
+		Result:    result,
+		Err:       ghErr,
+		ErrStatus: http.StatusBadGateway,
+		Request:   args,
+		Response:  issueResponse{Issue: issue, Preview: preview},
+		IdemKey:   &idemKey,
+	}, nil
+}
+
+// replay looks up an earlier successful call with the same idempotency key.
+// Client-supplied keys also verify that the request payload is unchanged.
+func (t *toolset) replay(ctx context.Context, call *core.ToolCall, idemKey string, out any) (*db.ToolCall, bool, error) {
+	var (
+		tc       *db.ToolCall
+		replayed bool
+		err      error
+	)
+	if call.IdempotencyKey != "" {
+		tc, replayed, err = t.Audit.ReplayResponseWithRequestCheck(ctx, call.Run.RunID, call.Name, idemKey, call.Args, out)
+	} else {
+		tc, replayed, err = t.Audit.ReplayResponse(ctx, call.Run.RunID, call.Name, idemKey, out)
+	}
+	if err != nil {
+		mapped := core.MapError(err, http.StatusInternalServerError)
+		return nil, false, &core.ToolExecError{Err: err, FallbackStatus: mapped.HTTPStatus}
+	}
+	return tc, replayed, nil
+}
+
+// decodeReplayedIssue accepts both the {"issue": ...} response shape and the
+// bare issue recorded by older MCP calls.
+func decodeReplayedIssue(raw json.RawMessage) *gh.Issue {
+	var wrapped struct {
+		Issue *gh.Issue `json:"issue"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err == nil && wrapped.Issue != nil {
+		return wrapped.Issue
+	}
+	var issue gh.Issue
+	_ = json.Unmarshal(raw, &issue)
+	return &issue
+}
+
+func (t *toolset) issuesBatchCreate(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	args := call.Args.(*IssueBatchCreateArgs)
+	run := call.Run
+	owner, repo := splitRepo(run.Repo)
+
+	out := make([]BatchItemResult, len(args.Issues))
+	replayedCount := 0
+	errCount := 0
+
+	for i, in := range args.Issues {
+		processed := i + 1
+		i2 := i
+		idemKey, err := core.MakeIssueIdempotencyKey(run.RunID, call.Name, in.Title, in.Body, in.Labels, &i2)
+		if err != nil {
+			return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+		}
+
+		var replay json.RawMessage
+		tc, replayed, err := t.Audit.ReplayResponse(ctx, run.RunID, call.Name, idemKey, &replay)
+		if err != nil {
+			return nil, &core.ToolExecError{Err: err, FallbackStatus: http.StatusInternalServerError}
+		}
+		if replayed {
+			call.Logger.Info("tool call replayed", "tool_call_id", tc.ToolCallID, "index", i)
+			out[i] = BatchItemResult{Index: i, Issue: decodeReplayedIssue(replay), Replayed: true}
+			replayedCount++
+			continue
+		}
+
+		var issue *gh.Issue
+		var ghErr error
+		if !args.DryRun {
+			issue, ghErr = t.GitHub.CreateIssue(ctx, owner, repo, gh.CreateIssueInput{
+				Title: in.Title, Body: in.Body, Labels: in.Labels,
+			})
+		}
+
+		tc, _, auditErr := t.Audit.Record(ctx, core.RecordInput{
+			RunID:    run.RunID,
+			ToolName: call.Name,
+			IdemKey:  &idemKey,
+			Request:  in,
+			Response: issueResponse{
+				Issue:   issue,
+				Preview: dryRunIssuePreview{Repo: run.Repo, Title: in.Title, Body: in.Body, Labels: in.Labels},
+			},
+			Err: ghErr,
+		})
+		if auditErr != nil {
+			return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("audit record failed at index %d: %s", i, auditErr.Error())}
+		}
+		call.Logger.Info("tool call completed", "tool_call_id", tc.ToolCallID, "index", i, "dry_run", args.DryRun)
+
+		out[i] = BatchItemResult{Index: i, Issue: issue}
+		if ghErr != nil {
+			out[i].Error = ghErr.Error()
+			errCount++
+			if t.BatchMode == core.BatchModeStrict {
+				stoppedAt := i
+				return &core.ToolOutcome{
+					Audited: true,
+					Err:     ghErr,
+					SoftError: &core.ToolError{
+						Code:    core.MapError(ghErr, http.StatusBadGateway).Code,
+						Message: ghErr.Error(),
+					},
+					Result: BatchResult{
+						Status:       core.DeriveBatchStatus(processed, replayedCount, errCount),
+						Mode:         t.BatchMode,
+						Total:        len(args.Issues),
+						Processed:    processed,
+						Errors:       errCount,
+						Replayed:     replayedCount,
+						CreatedFresh: processed - replayedCount,
+						StoppedAt:    &stoppedAt,
+						FailedReason: ghErr.Error(),
+						Results:      out[:processed],
+					},
+				}, nil
+			}
+		}
+	}
+
+	outcome := &core.ToolOutcome{
+		Audited: true,
+		Result: BatchResult{
+			Status:       core.DeriveBatchStatus(len(args.Issues), replayedCount, errCount),
+			Mode:         t.BatchMode,
+			Total:        len(args.Issues),
+			Processed:    len(args.Issues),
+			Errors:       errCount,
+			Replayed:     replayedCount,
+			CreatedFresh: len(args.Issues) - replayedCount,
+			Results:      out,
+		},
+	}
+	if errCount > 0 {
+		outcome.Err = fmt.Errorf("%d of %d issues failed", errCount, len(args.Issues))
+		outcome.SoftError = &core.ToolError{Code: "batch_partial_failure", Message: outcome.Err.Error()}
+	}
+	return outcome, nil
+}
@@ -0,0 +1,83 @@
+package tools
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/toolhub/toolhub/internal/core"
+	"github.com/toolhub/toolhub/internal/db"
+)
+
+type JobArgs struct {
+	RunID string `json:"run_id"`
+	JobID string `json:"job_id"`
+}
+
+func (t *toolset) registerJobs(reg *core.Registry) {
+	jobPolicy := core.ToolPolicy{SkipAllowlist: true}
+
+	reg.Register(core.ToolSpec{
+		Name:        "jobs.get",
+		Description: "Get the status and steps of a background job started with async=true",
+		Route:       &core.ToolRoute{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/jobs/{jobID}", OperationID: "getJob", Params: []core.RouteParam{runIDParam, jobIDParam}, Raw: true},
+		NewArgs:     func() any { return &JobArgs{} },
+		Result:      db.Job{},
+		Policy:      jobPolicy,
+		Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+			if err := t.checkJobs(); err != nil {
+				return nil, err
+			}
+			job, err := t.Jobs.Get(ctx, call.Run.RunID, call.Args.(*JobArgs).JobID)
+			if err != nil {
+				return nil, err
+			}
+			return &core.ToolOutcome{Result: job, Raw: true}, nil
+		},
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "jobs.cancel",
+		Description: "Cancel a running background job; it reports cancelled once its tool call has stopped",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/jobs/{jobID}/cancel", OperationID: "cancelJob", Params: []core.RouteParam{runIDParam, jobIDParam}, Raw: true},
+		NewArgs:     func() any { return &JobArgs{} },
+		Result:      db.Job{},
+		Policy:      jobPolicy,
+		Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+			if err := t.checkJobs(); err != nil {
+				return nil, err
+			}
+			job, err := t.Jobs.Cancel(ctx, call.Run.RunID, call.Args.(*JobArgs).JobID)
+			if err != nil {
+				return nil, err
+			}
+			call.Logger.Info("job cancel requested", "job_id", job.JobID)
+			return &core.ToolOutcome{Result: job, Raw: true}, nil
+		},
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "jobs.result",
+		Description: "Get the response of a finished background job, as the tool call would have returned it",
+		Route:       &core.ToolRoute{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/jobs/{jobID}/result", OperationID: "getJobResult", Params: []core.RouteParam{runIDParam, jobIDParam}, Raw: true},
+		NewArgs:     func() any { return &JobArgs{} },
+		Result:      core.ToolEnvelope{},
+		Policy:      jobPolicy,
+		Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+			if err := t.checkJobs(); err != nil {
+				return nil, err
+			}
+			resp, err := t.Jobs.Result(ctx, call.Run.RunID, call.Args.(*JobArgs).JobID)
+			if err != nil {
+				return nil, err
+			}
+			return &core.ToolOutcome{Result: resp.Body, Raw: true, Status: resp.Status}, nil
+		},
+	})
+}
+
+func (t *toolset) checkJobs() error {
+	if t.Jobs == nil {
+		return &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "async jobs are not configured"}
+	}
+	return nil
+}
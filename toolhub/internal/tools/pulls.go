@@ -0,0 +1,260 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/toolhub/toolhub/internal/core"
+	gh "github.com/toolhub/toolhub/internal/github"
+	"github.com/toolhub/toolhub/internal/qa"
+)
+
+type PRCommentCreateArgs struct {
+	RunID    string `json:"run_id"`
+	PRNumber int    `json:"pr_number" jsonschema:"minimum=1"`
+	Body     string `json:"body"`
+	DryRun   bool   `json:"dry_run,omitempty"`
+}
+
+type PRReviewCreateArgs struct {
+	RunID    string             `json:"run_id"`
+	PRNumber int                `json:"pr_number" jsonschema:"minimum=1"`
+	Body     string             `json:"body,omitempty"`
+	Comments []gh.ReviewComment `json:"comments,omitempty"`
+	// Findings are lint findings as reported by qa.lint; each one on a line
+	// changed by the pull request becomes an inline comment.
+	Findings []qa.Finding `json:"findings,omitempty" jsonschema:"description=Lint findings from a qa.lint report; those on lines changed by the pull request become inline comments"`
+	DryRun   bool         `json:"dry_run,omitempty"`
+}
+
+// PRReviewResult reports a submitted review. Review is nil when nothing was
+// left to post or on a dry run.
+type PRReviewResult struct {
+	Review   *gh.Review         `json:"review,omitempty"`
+	Body     string             `json:"body"`
+	Comments []gh.ReviewComment `json:"comments"`
+	// SkippedFindings are the findings outside the lines changed by the
+	// pull request, which GitHub does not accept comments on.
+	SkippedFindings []qa.Finding `json:"skipped_findings,omitempty"`
+}
+
+type PRReadArgs struct {
+	RunID    string `json:"run_id"`
+	PRNumber int    `json:"pr_number" jsonschema:"minimum=1"`
+}
+
+type PRFilesResult struct {
+	Files   []gh.PullRequestFile `json:"files"`
+	Count   int                  `json:"count"`
+	Summary gh.PRFilesSummary    `json:"summary"`
+}
+
+func (t *toolset) registerPulls(reg *core.Registry) {
+	reg.Register(core.ToolSpec{
+		Name:        "github.pr.comment.create",
+		Description: "Create a PR summary comment within a run",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/prs/{prNumber}/comment", OperationID: "createPRComment", Params: []core.RouteParam{runIDParam, prNumberParam}},
+		NewArgs:     func() any { return &PRCommentCreateArgs{} },
+		Result:      gh.Comment{},
+		Policy: core.ToolPolicy{
+			Validate: func(args any) error {
+				if strings.TrimSpace(args.(*PRCommentCreateArgs).Body) == "" {
+					return core.BadToolRequest("body is required")
+				}
+				return nil
+			},
+		},
+		Execute: t.prCommentCreate,
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "github.pr.review.create",
+		Description: "Submit a PR review with inline comments, e.g. from qa.lint findings on the lines the PR changes",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/prs/{prNumber}/review", OperationID: "createPRReview", Params: []core.RouteParam{runIDParam, prNumberParam}},
+		NewArgs:     func() any { return &PRReviewCreateArgs{} },
+		Result:      PRReviewResult{},
+		Policy: core.ToolPolicy{
+			Validate: func(args any) error {
+				a := args.(*PRReviewCreateArgs)
+				if strings.TrimSpace(a.Body) == "" && len(a.Comments) == 0 && len(a.Findings) == 0 {
+					return core.BadToolRequest("body, comments or findings are required")
+				}
+				for _, c := range a.Comments {
+					if c.Path == "" || c.Line <= 0 || strings.TrimSpace(c.Body) == "" {
+						return core.BadToolRequest("comments need a path, a line and a body")
+					}
+				}
+				return nil
+			},
+		},
+		Execute: t.prReviewCreate,
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "github.pr.get",
+		Description: "Get pull request metadata within a run",
+		Route:       &core.ToolRoute{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/prs/{prNumber}", OperationID: "getPR", Params: []core.RouteParam{runIDParam, prNumberParam}},
+		NewArgs:     func() any { return &PRReadArgs{} },
+		Result:      gh.PullRequest{},
+		Execute:     t.prGet,
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "github.pr.files.list",
+		Description: "List pull request files within a run",
+		Route:       &core.ToolRoute{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/prs/{prNumber}/files", OperationID: "listPRFiles", Params: []core.RouteParam{runIDParam, prNumberParam}},
+		NewArgs:     func() any { return &PRReadArgs{} },
+		Result:      PRFilesResult{},
+		Execute:     t.prFilesList,
+	})
+}
+
+func (t *toolset) prCommentCreate(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	args := call.Args.(*PRCommentCreateArgs)
+	run := call.Run
+
+	idemKey := call.IdempotencyKey
+	if idemKey == "" {
+		var err error
+		keyLabels := []string{fmt.Sprintf("pr:%d", args.PRNumber)}
+		idemKey, err = core.MakeIssueIdempotencyKey(run.RunID, call.Name, fmt.Sprintf("pr-%d", args.PRNumber), args.Body, keyLabels, nil)
+		if err != nil {
+			return nil, &core.ToolExecError{Err: err, FallbackStatus: http.StatusInternalServerError}
+		}
+	}
+
+	var replay map[string]any
+	tc, replayed, err := t.replay(ctx, call, idemKey, &replay)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return &core.ToolOutcome{Result: replay, Replayed: tc}, nil
+	}
+
+	preview := map[string]any{"repo": run.Repo, "pr_number": args.PRNumber, "body": args.Body}
+	var comment *gh.Comment
+	var ghErr error
+	if !args.DryRun {
+		owner, repo := splitRepo(run.Repo)
+		comment, ghErr = t.GitHub.CreatePRComment(ctx, owner, repo, args.PRNumber, args.Body)
+	}
+
+	result := any(comment)
+	if args.DryRun {
+		result = map[string]any{"would_comment": preview}
+	}
+	return &core.ToolOutcome{
+		Result:    result,
+		Err:       ghErr,
+		ErrStatus: http.StatusBadGateway,
+		Request:   args,
+		Response:  map[string]any{"comment": comment, "preview": preview},
+		IdemKey:   &idemKey,
+	}, nil
+}
+
+func (t *toolset) prReviewCreate(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	args := call.Args.(*PRReviewCreateArgs)
+	run := call.Run
+	owner, repo := splitRepo(run.Repo)
+
+	result := PRReviewResult{Body: args.Body, Comments: append([]gh.ReviewComment{}, args.Comments...)}
+	if len(args.Findings) > 0 {
+		files, ghErr := t.GitHub.ListPullRequestFiles(ctx, owner, repo, args.PRNumber)
+		if ghErr != nil {
+			return &core.ToolOutcome{Err: ghErr, ErrStatus: http.StatusBadGateway, Request: args}, nil
+		}
+		changed := make(map[string]map[int]bool, len(files))
+		for _, f := range files {
+			changed[f.Filename] = gh.CommentableLines(f.Patch)
+		}
+		for _, f := range args.Findings {
+			if f.Line <= 0 || !changed[f.Path][f.Line] {
+				result.SkippedFindings = append(result.SkippedFindings, f)
+				continue
+			}
+			result.Comments = append(result.Comments, gh.ReviewComment{Path: f.Path, Line: f.Line, Body: findingComment(f)})
+		}
+	}
+	if strings.TrimSpace(result.Body) == "" && len(result.Comments) > 0 {
+		result.Body = fmt.Sprintf("%d review comment(s).", len(result.Comments))
+	}
+	if strings.TrimSpace(result.Body) == "" || args.DryRun {
+		// Every finding was outside the change: there is nothing to post.
+		return &core.ToolOutcome{Result: result, Request: args, Response: result}, nil
+	}
+
+	idemKey := call.IdempotencyKey
+	if idemKey == "" {
+		comments, err := json.Marshal(result.Comments)
+		if err != nil {
+			return nil, &core.ToolExecError{Err: err, FallbackStatus: http.StatusInternalServerError}
+		}
+		idemKey, err = core.MakeIssueIdempotencyKey(run.RunID, call.Name, fmt.Sprintf("pr-%d-review", args.PRNumber), result.Body+"\n"+string(comments), nil, nil)
+		if err != nil {
+			return nil, &core.ToolExecError{Err: err, FallbackStatus: http.StatusInternalServerError}
+		}
+	}
+	var replay PRReviewResult
+	tc, replayed, err := t.replay(ctx, call, idemKey, &replay)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return &core.ToolOutcome{Result: replay, Replayed: tc}, nil
+	}
+
+	var ghErr error
+	result.Review, ghErr = t.GitHub.CreatePullRequestReview(ctx, owner, repo, args.PRNumber, gh.CreateReviewInput{
+		Body:     result.Body,
+		Event:    "COMMENT",
+		Comments: result.Comments,
+	})
+	return &core.ToolOutcome{
+		Result:    result,
+		Err:       ghErr,
+		ErrStatus: http.StatusBadGateway,
+		Request:   args,
+		Response:  result,
+		IdemKey:   &idemKey,
+	}, nil
+}
+
+// findingComment renders a lint finding as the body of a review comment.
+func findingComment(f qa.Finding) string {
+	source := strings.Trim(f.Tool+" "+f.Rule, " ")
+	if source == "" {
+		return fmt.Sprintf("**%s**: %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("**%s** (%s): %s", f.Severity, source, f.Message)
+}
+
+func (t *toolset) prGet(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	args := call.Args.(*PRReadArgs)
+	owner, repo := splitRepo(call.Run.Repo)
+	pr, ghErr := t.GitHub.GetPullRequest(ctx, owner, repo, args.PRNumber)
+	return &core.ToolOutcome{
+		Result:    pr,
+		Err:       ghErr,
+		ErrStatus: http.StatusBadGateway,
+		Request:   map[string]any{"pr_number": args.PRNumber},
+		Response:  pr,
+	}, nil
+}
+
+func (t *toolset) prFilesList(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	args := call.Args.(*PRReadArgs)
+	owner, repo := splitRepo(call.Run.Repo)
+	files, ghErr := t.GitHub.ListPullRequestFiles(ctx, owner, repo, args.PRNumber)
+	return &core.ToolOutcome{
+		Result:    PRFilesResult{Files: files, Count: len(files), Summary: gh.SummarizePRFiles(files)},
+		Err:       ghErr,
+		ErrStatus: http.StatusBadGateway,
+		Request:   map[string]any{"pr_number": args.PRNumber},
+		Response:  map[string]any{"files": files, "count": len(files)},
+	}, nil
+}
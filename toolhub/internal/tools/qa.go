@@ -0,0 +1,345 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/toolhub/toolhub/internal/core"
+	"github.com/toolhub/toolhub/internal/db"
+	"github.com/toolhub/toolhub/internal/qa"
+	"github.com/toolhub/toolhub/internal/workspace"
+)
+
+type QAArgs struct {
+	RunID  string `json:"run_id"`
+	DryRun bool   `json:"dry_run,omitempty"`
+	Async  bool   `json:"async,omitempty"`
+	// ChangedFiles limits lint findings to these repository paths, e.g.
+	// the files of the change under review, and selects the tests of a
+	// targeted qa.test.
+	ChangedFiles []string `json:"changed_files,omitempty" jsonschema:"description=Repository paths of the change: only report lint findings on them and select the tests of a targeted qa.test"`
+	// Targeted runs only the tests affected by ChangedFiles.
+	Targeted bool `json:"targeted,omitempty" jsonschema:"description=qa.test only: run just the tests affected by changed_files, falling back to the full suite when they cannot be mapped"`
+	// RerunFailed reruns the failed tests up to this many times and marks
+	// those that pass on a rerun as flaky.
+	RerunFailed int `json:"rerun_failed,omitempty" jsonschema:"minimum=0,description=qa.test only: rerun failed tests up to this many times (at most QA_TEST_MAX_RERUNS) and mark those that pass on a rerun as flaky"`
+}
+
+// QARunArgs runs a named QA target, e.g. build or e2e.
+type QARunArgs struct {
+	RunID        string   `json:"run_id"`
+	Target       string   `json:"target" jsonschema:"description=Name of a configured QA target besides test and lint, see qa.targets.list"`
+	DryRun       bool     `json:"dry_run,omitempty"`
+	Async        bool     `json:"async,omitempty"`
+	ChangedFiles []string `json:"changed_files,omitempty" jsonschema:"description=Only report lint findings on these repository paths"`
+}
+
+type QATargetsListArgs struct {
+	RunID string `json:"run_id"`
+}
+
+type QATargetsResult struct {
+	Targets []qa.TargetInfo `json:"targets"`
+}
+
+type QAFlakyTestsListArgs struct {
+	RunID string `json:"run_id"`
+}
+
+type QAFlakyTestsResult struct {
+	Tests []db.FlakyTest `json:"tests"`
+}
+
+// flakyTestsListLimit caps qa.flaky_tests.list at the most often flaky
+// tests.
+const flakyTestsListLimit = 100
+
+type QAResult struct {
+	Status  qa.Status `json:"status" jsonschema:"enum=pass|fail|timeout|cancelled|error|dry_run"`
+	Report  qa.Report `json:"report"`
+	Summary string    `json:"summary" jsonschema:"description=Human-readable one-line QA outcome summary"`
+}
+
+func (t *toolset) registerQA(reg *core.Registry) {
+	for _, q := range []struct {
+		kind        qa.Kind
+		path        string
+		operationID string
+		description string
+	}{
+		{qa.KindTest, "/api/v1/runs/{runID}/qa/test", "runQATest", "Execute configured test command and capture output"},
+		{qa.KindLint, "/api/v1/runs/{runID}/qa/lint", "runQALint", "Execute configured lint command and capture output"},
+	} {
+		kind := q.kind
+		reg.Register(core.ToolSpec{
+			Name:        string(kind),
+			Description: q.description,
+			Route:       &core.ToolRoute{Method: http.MethodPost, Path: q.path, OperationID: q.operationID, Params: []core.RouteParam{runIDParam}},
+			NewArgs:     func() any { return &QAArgs{} },
+			Async:       true,
+			Progress:    true,
+			Result:      QAResult{},
+			Policy: core.ToolPolicy{
+				Validate: func(args any) error {
+					a := args.(*QAArgs)
+					if a.Targeted && kind != qa.KindTest {
+						return core.BadToolRequest("targeted is only supported by qa.test")
+					}
+					if a.RerunFailed != 0 && kind != qa.KindTest {
+						return core.BadToolRequest("rerun_failed is only supported by qa.test")
+					}
+					return t.validateTestReruns("rerun_failed", a.RerunFailed)
+				},
+			},
+			Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+				return t.runQA(ctx, call, kind, call.Args.(*QAArgs))
+			},
+		})
+	}
+
+	reg.Register(core.ToolSpec{
+		Name:        "qa.run",
+		Description: "Execute the configured command of a named QA target, e.g. build or e2e, and capture output",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/qa/targets/{target}", OperationID: "runQATarget", Params: []core.RouteParam{runIDParam, qaTargetParam}},
+		NewArgs:     func() any { return &QARunArgs{} },
+		Async:       true,
+		Progress:    true,
+		Result:      QAResult{},
+		Policy: core.ToolPolicy{
+			Validate: func(args any) error {
+				target := args.(*QARunArgs).Target
+				if target == "" {
+					return core.BadToolRequest("target is required")
+				}
+				// The built-in targets go through their own tools, so the
+				// allowlist and rate limits of those tools apply.
+				if target == qa.TargetTest || target == qa.TargetLint {
+					return core.BadToolRequest("target %q is built in; run it with %s", target, qa.TargetKind(target))
+				}
+				return nil
+			},
+		},
+		Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+			a := call.Args.(*QARunArgs)
+			args := &QAArgs{RunID: a.RunID, DryRun: a.DryRun, Async: a.Async, ChangedFiles: a.ChangedFiles}
+			return t.runQA(ctx, call, qa.TargetKind(a.Target), args)
+		},
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "qa.targets.list",
+		Description: "List the configured QA targets with their commands, limits and backends",
+		Route:       &core.ToolRoute{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/qa/targets", OperationID: "listQATargets", Params: []core.RouteParam{runIDParam}},
+		NewArgs:     func() any { return &QATargetsListArgs{} },
+		Result:      QATargetsResult{},
+		Execute:     t.qaTargetsList,
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "qa.flaky_tests.list",
+		Description: "List the tests of the run's repository that passed on a rerun after failing, most often flaky first",
+		Route:       &core.ToolRoute{Method: http.MethodGet, Path: "/api/v1/runs/{runID}/qa/flaky-tests", OperationID: "listQAFlakyTests", Params: []core.RouteParam{runIDParam}},
+		NewArgs:     func() any { return &QAFlakyTestsListArgs{} },
+		Result:      QAFlakyTestsResult{},
+		Execute:     t.qaFlakyTestsList,
+	})
+}
+
+func (t *toolset) qaTargetsList(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	if t.QA == nil {
+		return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "qa runner is not configured"}
+	}
+	result := QATargetsResult{Targets: t.QA.ForRepo(call.Run.Repo).Targets()}
+	return &core.ToolOutcome{Result: result, Request: call.Args, Response: result}, nil
+}
+
+func (t *toolset) qaFlakyTestsList(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	if t.FlakyTests == nil {
+		return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "flaky test history is not configured"}
+	}
+	tests, err := t.FlakyTests.List(ctx, call.Run.Repo, flakyTestsListLimit)
+	if err != nil {
+		return nil, err
+	}
+	result := QAFlakyTestsResult{Tests: tests}
+	return &core.ToolOutcome{Result: result, Request: call.Args, Response: result}, nil
+}
+
+func (t *toolset) runQA(ctx context.Context, call *core.ToolCall, kind qa.Kind, args *QAArgs) (*core.ToolOutcome, error) {
+	if t.QA == nil {
+		return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "qa runner is not configured"}
+	}
+
+	ctx = qaOutputProgress(ctx, kind)
+	opts := qa.RunOptions{RerunFailed: args.RerunFailed}
+	if args.Targeted {
+		opts.Changed = append([]string{}, args.ChangedFiles...)
+	}
+	var report qa.Report
+	var runErr error
+	if t.Workspaces != nil && !args.DryRun {
+		report, runErr = t.runQAInWorkspace(ctx, call.Run.Repo, kind, opts)
+	} else {
+		runner := t.QA.ForRepo(call.Run.Repo)
+		report, runErr = runQAIn(ctx, runner, kind, runner.WorkDir(), opts, args.DryRun)
+	}
+	if runErr != nil && report.Command == "" {
+		return &core.ToolOutcome{Err: runErr, ErrStatus: http.StatusBadRequest, Request: args}, nil
+	}
+	t.recordFlakyTests(ctx, call, call.Run.Repo, &report)
+
+	if report.Findings != nil && len(args.ChangedFiles) > 0 {
+		report.Findings = report.Findings.Filter(args.ChangedFiles)
+	}
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "marshal qa report failed: " + err.Error()}
+	}
+	artifacts := []core.ExtraArtifact{
+		{Name: fmt.Sprintf("%s.stdout.txt", kind), ContentType: "text/plain", Body: []byte(report.Stdout)},
+		{Name: fmt.Sprintf("%s.stderr.txt", kind), ContentType: "text/plain", Body: []byte(report.Stderr)},
+		{Name: fmt.Sprintf("%s.report.json", kind), ContentType: "application/json", Body: reportJSON},
+	}
+	if sarif := findingsSARIF(call, report.Findings); sarif != nil {
+		artifacts = append(artifacts, core.ExtraArtifact{Name: fmt.Sprintf("%s.findings.sarif", kind), ContentType: "application/sarif+json", Body: sarif})
+	}
+
+	status := qa.DeriveStatus(report, runErr, args.DryRun)
+	outcome := &core.ToolOutcome{
+		Result:         QAResult{Status: status, Report: report, Summary: qa.GenerateSummary(status, report)},
+		Err:            runErr,
+		Request:        args,
+		Response:       map[string]any{"report": report},
+		ExtraArtifacts: artifacts,
+		Finalize: func(env *core.ToolEnvelope, ids []string) {
+			env.Meta.QAArtifacts = qaArtifacts(ids)
+		},
+	}
+	if runErr != nil {
+		outcome.SoftError = &core.ToolError{Code: string(status), Message: runErr.Error()}
+	}
+	return outcome, nil
+}
+
+// qaOutputProgress streams the output of QA runs started with the returned
+// context as progress of the tool call.
+func qaOutputProgress(ctx context.Context, kind qa.Kind) context.Context {
+	if !core.HasProgress(ctx) {
+		return ctx
+	}
+	return qa.WithOutputFunc(ctx, func(stream, data string) {
+		core.ReportProgress(ctx, core.ProgressEvent{Type: core.ProgressOutput, Tool: string(kind), Stream: stream, Data: data})
+	})
+}
+
+func qaArtifacts(ids []string) *core.QAArtifacts {
+	out := &core.QAArtifacts{}
+	if len(ids) > 0 {
+		out.StdoutArtifactID = ids[0]
+	}
+	if len(ids) > 1 {
+		out.StderrArtifactID = ids[1]
+	}
+	if len(ids) > 2 {
+		out.ReportArtifactID = ids[2]
+	}
+	if len(ids) > 3 {
+		out.SARIFArtifactID = ids[3]
+	}
+	return out
+}
+
+// findingsSARIF renders parsed lint findings for the SARIF artifact; it
+// returns nil when there are none to render.
+func findingsSARIF(call *core.ToolCall, findings *qa.LintFindings) []byte {
+	if findings == nil {
+		return nil
+	}
+	body, err := qa.SARIF(findings.Findings)
+	if err != nil {
+		call.Logger.Error("render sarif failed", "err", err)
+		return nil
+	}
+	return body
+}
+
+// runQAInWorkspace runs QA in a fresh worktree of the run's repository,
+// checked out at the remote default branch, with the repository's QA
+// configuration; see runQAIn for opts.
+func (t *toolset) runQAInWorkspace(ctx context.Context, repo string, kind qa.Kind, opts qa.RunOptions) (qa.Report, error) {
+	lease, err := t.Workspaces.Acquire(ctx, repo)
+	if err != nil {
+		return qa.Report{}, err
+	}
+	defer lease.Release()
+	wt, err := workspace.AddWorktree(ctx, lease.GitDir, lease.DefaultRef(), "")
+	if err != nil {
+		return qa.Report{}, err
+	}
+	defer wt.Remove(ctx)
+	runner, err := t.repoQA(ctx, repo, wt.Dir, lease.DefaultRef())
+	if err != nil {
+		return qa.Report{}, err
+	}
+	return runQAIn(ctx, runner, kind, wt.Dir, opts, false)
+}
+
+// runQAIn runs kind in dir with runner. For the test target, opts select
+// the tests affected by a change and rerun failed ones; see qa.RunOptions.
+func runQAIn(ctx context.Context, runner *qa.Runner, kind qa.Kind, dir string, opts qa.RunOptions, dryRun bool) (qa.Report, error) {
+	if kind == qa.KindTest {
+		return runner.RunWith(ctx, kind, dir, opts, dryRun)
+	}
+	return runner.RunInDir(ctx, kind, dir, dryRun)
+}
+
+// validateTestReruns rejects a negative reruns argument, or one above the
+// server's QA_TEST_MAX_RERUNS.
+func (t *toolset) validateTestReruns(field string, reruns int) error {
+	if reruns < 0 {
+		return core.BadToolRequest("%s must not be negative", field)
+	}
+	max := 0
+	if t.QA != nil {
+		max = t.QA.TestMaxReruns()
+	}
+	if reruns > max {
+		return core.BadToolRequest("%s must be at most %d (QA_TEST_MAX_RERUNS)", field, max)
+	}
+	return nil
+}
+
+// recordFlakyTests adds the outcome of test reruns to the repository's
+// flakiness history; reports without reruns are skipped.
+func (t *toolset) recordFlakyTests(ctx context.Context, call *core.ToolCall, repo string, report *qa.Report) {
+	if t.FlakyTests == nil {
+		return
+	}
+	if err := t.FlakyTests.Record(ctx, repo, report); err != nil {
+		call.Logger.Error("record flaky tests failed", "err", err, "repo", repo)
+	}
+}
+
+// repoQA returns the QA runner for repo: the one of its policy file entry,
+// or, with QARepoConfigDiscovery, the one of the .toolhub.yml committed at
+// ref in dir's repository. Without either it is the server's runner.
+func (t *toolset) repoQA(ctx context.Context, repo, dir, ref string) (*qa.Runner, error) {
+	runner := t.QA.ForRepo(repo)
+	if runner.Source() != qa.ConfigSourceServer || !t.QARepoConfigDiscovery || dir == "" {
+		return runner, nil
+	}
+	data, found, err := workspace.ReadFileAt(ctx, dir, ref, qa.RepoConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", qa.RepoConfigFile, err)
+	}
+	if !found {
+		return runner, nil
+	}
+	rc, err := qa.ParseRepoConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return t.QA.WithRepoConfig(rc, qa.ConfigSourceRepository)
+}
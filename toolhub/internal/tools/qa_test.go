@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/toolhub/toolhub/internal/core"
+)
+
+func TestQARunRejectsBuiltInTargets(t *testing.T) {
+	// qa.test and qa.lint are left out of the allowlist, so qa.run must not
+	// run their commands.
+	policy := core.NewPolicy("o/r", "qa.run")
+	if policy.CheckTool("qa.test") == nil || policy.CheckTool("qa.run") != nil {
+		t.Fatal("policy must allow qa.run only")
+	}
+	reg := NewRegistry(Deps{Policy: policy})
+	spec, ok := reg.Lookup("qa.run")
+	if !ok {
+		t.Fatal("qa.run is not registered")
+	}
+
+	tests := []struct {
+		target  string
+		wantErr bool
+	}{
+		{target: "test", wantErr: true},
+		{target: "lint", wantErr: true},
+		{target: "", wantErr: true},
+		{target: "build"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.target, func(t *testing.T) {
+			err := spec.Policy.Validate(&QARunArgs{RunID: "run-1", Target: tc.target})
+			if !tc.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var reqErr *core.ToolRequestError
+			if !errors.As(err, &reqErr) || reqErr.Status != http.StatusBadRequest {
+				t.Fatalf("err = %v, want a bad request", err)
+			}
+		})
+	}
+}
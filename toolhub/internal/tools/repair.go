@@ -0,0 +1,711 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/toolhub/toolhub/internal/codeops"
+	"github.com/toolhub/toolhub/internal/core"
+	"github.com/toolhub/toolhub/internal/db"
+	gh "github.com/toolhub/toolhub/internal/github"
+	"github.com/toolhub/toolhub/internal/qa"
+	"github.com/toolhub/toolhub/internal/telemetry"
+	"github.com/toolhub/toolhub/internal/workspace"
+)
+
+type CodeRepairLoopArgs struct {
+	RunID         string               `json:"run_id"`
+	ApprovalID    string               `json:"approval_id"`
+	BaseBranch    string               `json:"base_branch"`
+	HeadBranch    string               `json:"head_branch"`
+	CommitMessage string               `json:"commit_message"`
+	PRTitle       string               `json:"pr_title"`
+	PRBody        string               `json:"pr_body,omitempty"`
+	Files         []codeops.FileChange `json:"files"`
+	MaxIterations int                  `json:"max_iterations,omitempty"`
+	// QATargets names the QA targets every iteration must pass.
+	QATargets []string `json:"qa_targets,omitempty" jsonschema:"description=QA targets that must pass on every iteration; defaults to test and lint"`
+	// TargetedTests runs only the tests affected by the head branch's
+	// changes against the base branch.
+	TargetedTests bool `json:"targeted_tests,omitempty" jsonschema:"description=Run only the tests affected by the head branch's changes on every iteration, falling back to the full suite when they cannot be mapped"`
+	// RerunFailedTests reruns the failed tests of every iteration; an
+	// iteration whose only failures are tests that passed on a rerun
+	// opens the PR instead of failing.
+	RerunFailedTests int  `json:"rerun_failed_tests,omitempty" jsonschema:"minimum=0,description=Rerun failed tests up to this many times on every iteration (at most QA_TEST_MAX_RERUNS); tests that pass on a rerun are flaky and do not fail the iteration"`
+	DryRun           bool `json:"dry_run,omitempty"`
+	Async            bool `json:"async,omitempty"`
+}
+
+type CodeRepairSubmitArgs struct {
+	RunID           string               `json:"run_id"`
+	ApprovalID      string               `json:"approval_id"`
+	RepairSessionID string               `json:"repair_session_id"`
+	CommitMessage   string               `json:"commit_message"`
+	Files           []codeops.FileChange `json:"files"`
+	DryRun          bool                 `json:"dry_run,omitempty"`
+	Async           bool                 `json:"async,omitempty"`
+}
+
+type CodeRepairAbandonArgs struct {
+	RunID           string `json:"run_id"`
+	RepairSessionID string `json:"repair_session_id"`
+	Reason          string `json:"reason,omitempty"`
+	DryRun          bool   `json:"dry_run,omitempty"`
+}
+
+// RepairAttempt is one QA iteration of a repair loop. The test and lint
+// fields are empty when the loop does not require those targets.
+type RepairAttempt struct {
+	Iteration  int       `json:"iteration"`
+	TestStatus qa.Status `json:"test_status,omitempty"`
+	LintStatus qa.Status `json:"lint_status,omitempty"`
+	TestReport qa.Report `json:"test_report"`
+	LintReport qa.Report `json:"lint_report"`
+	TestError  string    `json:"test_error,omitempty"`
+	LintError  string    `json:"lint_error,omitempty"`
+	// TestsFixed and TestsRegressed compare the parsed test results with
+	// the previous iteration: tests that failed then and pass now, and
+	// tests that fail now but did not then. Both need parsed results on
+	// the two iterations.
+	TestsFixed     []string `json:"tests_fixed,omitempty"`
+	TestsRegressed []string `json:"tests_regressed,omitempty"`
+	// Targets holds the runs of the required targets besides test and lint.
+	Targets []TargetAttempt `json:"targets,omitempty"`
+}
+
+// TargetAttempt is the run of a named QA target in a repair iteration.
+type TargetAttempt struct {
+	Target string    `json:"target"`
+	Status qa.Status `json:"status"`
+	Report qa.Report `json:"report"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// RepairLoopResult reports one iteration of a repair loop: the commit of
+// the initial call or of a submitted fix, and its QA run.
+type RepairLoopResult struct {
+	Status string `json:"status" jsonschema:"enum=completed|failed|awaiting_fix|cancelled|dry_run"`
+	// RepairSessionID identifies the loop in follow-up submit and abandon
+	// calls; the session is open while Status is awaiting_fix or cancelled.
+	RepairSessionID     string   `json:"repair_session_id,omitempty"`
+	IterationsRequested int      `json:"iterations_requested"`
+	IterationsRun       int      `json:"iterations_run"`
+	BaseBranch          string   `json:"base_branch"`
+	HeadBranch          string   `json:"head_branch"`
+	PlannedCommands     []string `json:"planned_commands"`
+	CommitHash          string   `json:"commit_hash"`
+	QAPassed            bool     `json:"qa_passed"`
+	QAFailureReason     string   `json:"qa_failure_reason,omitempty"`
+	QAFailureCategory   string   `json:"qa_failure_category,omitempty" jsonschema:"enum=test_failure|lint_failure|both_failure|target_failure|qa_timeout|qa_error|flaky_only"`
+	// QAAttempts holds the attempt of this iteration; earlier iterations are
+	// in the results of earlier calls.
+	QAAttempts              []RepairAttempt        `json:"qa_attempts,omitempty"`
+	RollbackPlannedCommands []string               `json:"rollback_planned_commands,omitempty"`
+	RollbackError           string                 `json:"rollback_error,omitempty"`
+	PullRequest             *gh.PullRequest        `json:"pull_request,omitempty"`
+	PatchArtifactID         string                 `json:"patch_artifact_id,omitempty"`
+	AppliedPatchArtifactID  string                 `json:"applied_patch_artifact_id,omitempty"`
+	QAReportArtifactID      string                 `json:"qa_report_artifact_id,omitempty"`
+	Conflicts               []codeops.HunkConflict `json:"conflicts,omitempty"`
+	StaleFiles              []codeops.StaleFile    `json:"stale_files,omitempty"`
+	Verification            *codeops.Verification  `json:"verification,omitempty"`
+}
+
+type RepairAbandonResult struct {
+	RepairSessionID         string   `json:"repair_session_id"`
+	Status                  string   `json:"status" jsonschema:"enum=abandoned|dry_run"`
+	HeadBranch              string   `json:"head_branch"`
+	IterationsRun           int      `json:"iterations_run"`
+	RollbackPlannedCommands []string `json:"rollback_planned_commands"`
+	RollbackError           string   `json:"rollback_error,omitempty"`
+}
+
+func (t *toolset) registerRepair(reg *core.Registry) {
+	reg.Register(core.ToolSpec{
+		Name:        "code.repair_loop",
+		Description: "Start a repair loop: branch/commit, run QA, open a PR on success; on failure the loop stays open for fixes until max_iterations, then rolls back",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/code/repair-loop", OperationID: "runCodeRepairLoop", Params: []core.RouteParam{runIDParam}},
+		NewArgs:     func() any { return &CodeRepairLoopArgs{} },
+		Result:      RepairLoopResult{},
+		Async:       true,
+		Progress:    true,
+		Policy: core.ToolPolicy{
+			ApprovalScope: "code_write",
+			Validate: func(args any) error {
+				a := args.(*CodeRepairLoopArgs)
+				if a.MaxIterations <= 0 {
+					a.MaxIterations = t.RepairMaxIterations
+				}
+				if a.MaxIterations > t.RepairMaxIterations {
+					return core.BadToolRequest("max_iterations cannot exceed %d", t.RepairMaxIterations)
+				}
+				if err := validateQATargets(a.QATargets); err != nil {
+					return err
+				}
+				if err := t.validateTestReruns("rerun_failed_tests", a.RerunFailedTests); err != nil {
+					return err
+				}
+				return validateFileChanges(a.Files)
+			},
+			Paths: func(args any) []string { return filePaths(args.(*CodeRepairLoopArgs).Files) },
+		},
+		Execute: t.codeRepairLoop,
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "code.repair_loop.submit",
+		Description: "Submit a fix to an open repair loop: commit it on the head branch and rerun QA as the next iteration (requires approved approval_id)",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/code/repair-loop/{sessionID}/submit", OperationID: "submitCodeRepairFix", Params: []core.RouteParam{runIDParam, repairSessionParam}},
+		NewArgs:     func() any { return &CodeRepairSubmitArgs{} },
+		Result:      RepairLoopResult{},
+		Async:       true,
+		Progress:    true,
+		Policy: core.ToolPolicy{
+			ApprovalScope: "code_write",
+			Validate:      func(args any) error { return validateFileChanges(args.(*CodeRepairSubmitArgs).Files) },
+			Paths:         func(args any) []string { return filePaths(args.(*CodeRepairSubmitArgs).Files) },
+		},
+		Execute: t.codeRepairSubmit,
+	})
+
+	reg.Register(core.ToolSpec{
+		Name:        "code.repair_loop.abandon",
+		Description: "Abandon an open repair loop and roll back its head branch",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/code/repair-loop/{sessionID}/abandon", OperationID: "abandonCodeRepairLoop", Params: []core.RouteParam{runIDParam, repairSessionParam}},
+		NewArgs:     func() any { return &CodeRepairAbandonArgs{} },
+		Result:      RepairAbandonResult{},
+		Execute:     t.codeRepairAbandon,
+	})
+}
+
+func (t *toolset) checkRepairDeps() error {
+	if t.Code == nil {
+		return &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "code runner is not configured"}
+	}
+	if t.QA == nil {
+		return &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "qa runner is not configured"}
+	}
+	if t.RepairSessions == nil {
+		return &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "repair sessions are not configured"}
+	}
+	return nil
+}
+
+func (t *toolset) codeRepairLoop(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	if err := t.checkRepairDeps(); err != nil {
+		return nil, err
+	}
+	args := call.Args.(*CodeRepairLoopArgs)
+	runID := call.Run.RunID
+	if err := t.checkQATargets(t.QA.ForRepo(call.Run.Repo), args.QATargets); err != nil {
+		return nil, err
+	}
+
+	step, err := t.Audit.StartStep(ctx, runID, "code_repair_loop", "repair_loop")
+	if err != nil {
+		return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if err := t.Audit.RecordDecision(ctx, runID, &step.StepID, "system", "repair_loop_started", map[string]any{"max_iterations": args.MaxIterations}); err != nil {
+		call.Logger.Error("audit record decision failed", "err", err, "decision_type", "repair_loop_started")
+	}
+
+	codeResult, runErr := t.Code.Execute(ctx, codeops.Request{
+		Repo:          call.Run.Repo,
+		BaseBranch:    args.BaseBranch,
+		HeadBranch:    args.HeadBranch,
+		CommitMessage: args.CommitMessage,
+		CoAuthor:      t.coAuthor(call),
+		Files:         args.Files,
+		DryRun:        args.DryRun,
+		KeepWorktree:  true,
+	})
+	if codeResult == nil {
+		codeResult = &codeops.Result{}
+	}
+
+	sess := &db.RepairSession{
+		RunID:         runID,
+		BaseBranch:    args.BaseBranch,
+		HeadBranch:    args.HeadBranch,
+		PRTitle:       args.PRTitle,
+		PRBody:        args.PRBody,
+		MaxIterations: args.MaxIterations,
+		HeadSHA:       codeResult.CommitHash,
+		QATargets:     args.QATargets,
+		TargetedTests: args.TargetedTests,
+		TestReruns:    args.RerunFailedTests,
+	}
+	result := newRepairLoopResult(sess, codeResult)
+
+	var attempt *RepairAttempt
+	switch {
+	case runErr != nil:
+		codeResult.Worktree.Remove(ctx)
+		telemetry.IncRepairCompleted("code_error")
+	case args.DryRun:
+		result.Status = "dry_run"
+	default:
+		if err := t.RepairSessions.Create(ctx, sess); err != nil {
+			codeResult.Worktree.Remove(ctx)
+			return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+		}
+		result.RepairSessionID = sess.SessionID
+		attempt, runErr = t.repairIteration(ctx, call, step.StepID, sess, codeResult.Worktree, codeResult.BaseRef, filePaths(args.Files), result)
+	}
+	if runErr != nil && result.Status != "cancelled" {
+		result.Status = "failed"
+	}
+	t.finishRepairStep(ctx, call, step.StepID, result, runErr)
+	return t.repairOutcome(call, "code.repair_loop", args, args.Files, codeResult, attempt, result, runErr), nil
+}
+
+func (t *toolset) codeRepairSubmit(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	if err := t.checkRepairDeps(); err != nil {
+		return nil, err
+	}
+	args := call.Args.(*CodeRepairSubmitArgs)
+	runID := call.Run.RunID
+
+	sess, err := t.RepairSessions.Get(ctx, runID, args.RepairSessionID)
+	if err != nil {
+		return nil, err
+	}
+	req := codeops.Request{
+		Repo:            call.Run.Repo,
+		BaseBranch:      sess.BaseBranch,
+		HeadBranch:      sess.HeadBranch,
+		CommitMessage:   args.CommitMessage,
+		CoAuthor:        t.coAuthor(call),
+		Files:           args.Files,
+		DryRun:          args.DryRun,
+		KeepWorktree:    true,
+		Update:          true,
+		ExpectedHeadSHA: sess.HeadSHA,
+	}
+	if args.DryRun {
+		if sess.Status != core.RepairSessionOpen {
+			return nil, &core.RepairSessionError{Code: "repair_session_closed", Message: fmt.Sprintf("repair session %q is %s, not open", sess.SessionID, sess.Status)}
+		}
+		codeResult, runErr := t.Code.Execute(ctx, req)
+		if codeResult == nil {
+			codeResult = &codeops.Result{}
+		}
+		result := newRepairLoopResult(sess, codeResult)
+		result.Status = "dry_run"
+		return t.repairOutcome(call, "code.repair_loop.submit", args, args.Files, codeResult, nil, result, runErr), nil
+	}
+	if err := t.RepairSessions.Claim(ctx, sess); err != nil {
+		return nil, err
+	}
+
+	step, err := t.Audit.StartStep(ctx, runID, "code_repair_loop", "repair_loop")
+	if err != nil {
+		t.reopenRepairSession(ctx, call, sess)
+		return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if err := t.Audit.RecordDecision(ctx, runID, &step.StepID, "system", "repair_loop_fix_submitted", map[string]any{"repair_session_id": sess.SessionID, "iteration": sess.IterationsRun + 1}); err != nil {
+		call.Logger.Error("audit record decision failed", "err", err, "decision_type", "repair_loop_fix_submitted")
+	}
+
+	codeResult, runErr := t.Code.Execute(ctx, req)
+	if codeResult == nil {
+		codeResult = &codeops.Result{}
+	}
+	result := newRepairLoopResult(sess, codeResult)
+
+	var attempt *RepairAttempt
+	if runErr != nil {
+		// A fix that does not apply does not use up an iteration; the agent
+		// can submit another one.
+		codeResult.Worktree.Remove(ctx)
+		t.reopenRepairSession(ctx, call, sess)
+		result.Status = "awaiting_fix"
+	} else {
+		sess.HeadSHA = codeResult.CommitHash
+		attempt, runErr = t.repairIteration(ctx, call, step.StepID, sess, codeResult.Worktree, codeResult.BaseRef, filePaths(args.Files), result)
+		if runErr != nil && result.Status != "cancelled" {
+			result.Status = "failed"
+		}
+	}
+	t.finishRepairStep(ctx, call, step.StepID, result, runErr)
+	return t.repairOutcome(call, "code.repair_loop.submit", args, args.Files, codeResult, attempt, result, runErr), nil
+}
+
+func (t *toolset) codeRepairAbandon(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	if err := t.checkRepairDeps(); err != nil {
+		return nil, err
+	}
+	args := call.Args.(*CodeRepairAbandonArgs)
+	runID := call.Run.RunID
+
+	sess, err := t.RepairSessions.Get(ctx, runID, args.RepairSessionID)
+	if err != nil {
+		return nil, err
+	}
+	result := &RepairAbandonResult{RepairSessionID: sess.SessionID, Status: "dry_run", HeadBranch: sess.HeadBranch, IterationsRun: sess.IterationsRun}
+	if args.DryRun {
+		if sess.Status != core.RepairSessionOpen {
+			return nil, &core.RepairSessionError{Code: "repair_session_closed", Message: fmt.Sprintf("repair session %q is %s, not open", sess.SessionID, sess.Status)}
+		}
+		rollback, rollbackErr := t.Code.RollbackBranch(ctx, call.Run.Repo, sess.BaseBranch, sess.HeadBranch, true)
+		if rollback != nil {
+			result.RollbackPlannedCommands = rollback.PlannedCommands
+		}
+		return &core.ToolOutcome{Result: result, Err: rollbackErr, ErrStatus: http.StatusBadGateway, Request: args, Response: result}, nil
+	}
+	if err := t.RepairSessions.Claim(ctx, sess); err != nil {
+		return nil, err
+	}
+
+	result.Status = "abandoned"
+	var runErr error
+	result.RollbackPlannedCommands, result.RollbackError, runErr = t.rollbackRepair(ctx, call, sess)
+	telemetry.IncRepairCompleted("abandoned")
+
+	sess.Status = core.RepairSessionAbandoned
+	if err := t.RepairSessions.Save(ctx, sess); err != nil {
+		call.Logger.Error("save repair session failed", "err", err, "repair_session_id", sess.SessionID)
+	}
+	decision := map[string]any{"repair_session_id": sess.SessionID, "iterations_run": sess.IterationsRun, "reason": args.Reason, "rollback_error": result.RollbackError}
+	if err := t.Audit.RecordDecision(ctx, runID, nil, "system", "repair_loop_abandoned", decision); err != nil {
+		call.Logger.Error("audit record decision failed", "err", err, "decision_type", "repair_loop_abandoned")
+	}
+	return &core.ToolOutcome{Result: result, Err: runErr, ErrStatus: http.StatusBadGateway, Request: args, Response: result}, nil
+}
+
+// repairIteration runs QA on the commit checked out in wt, which touched
+// the changed paths, as the next iteration of sess, with the repository's
+// QA configuration as of baseRef, so a fix cannot change the checks it has
+// to pass. It then settles the session: a pass opens the PR, a failure
+// leaves the session open for a fix, or rolls the head branch back when it
+// was the last iteration. A pass whose PR cannot be opened is rolled back
+// too. The session is saved either way.
+func (t *toolset) repairIteration(ctx context.Context, call *core.ToolCall, stepID string, sess *db.RepairSession, wt *workspace.Worktree, baseRef string, changed []string, result *RepairLoopResult) (*RepairAttempt, error) {
+	defer func() {
+		if err := wt.Remove(ctx); err != nil {
+			call.Logger.Error("remove code worktree failed", "err", err)
+		}
+	}()
+	sess.IterationsRun++
+	progress := core.ProgressEvent{
+		Type:            core.ProgressRepairIteration,
+		RepairSessionID: sess.SessionID,
+		Iteration:       sess.IterationsRun,
+		MaxIterations:   sess.MaxIterations,
+		Status:          "started",
+	}
+	core.ReportProgress(ctx, progress)
+	runner, configErr := t.repoQA(ctx, call.Run.Repo, wt.Dir, baseRef)
+	var testChanged []string
+	if sess.TargetedTests {
+		var err error
+		if testChanged, err = workspace.ChangedFiles(ctx, wt.Dir, baseRef, "HEAD"); err != nil {
+			call.Logger.Error("list head branch changes failed; running all tests", "err", err)
+		} else if testChanged == nil {
+			testChanged = []string{}
+		}
+	}
+	testOpts := qa.RunOptions{Changed: testChanged, RerunFailed: sess.TestReruns}
+	attempt, testErr, lintErr, targetErr := runRepairQA(ctx, runner, configErr, wt.Dir, sess.IterationsRun, changed, testOpts, repairTargets(sess))
+	results := attempt.TestReport.TestResults
+	if results != nil && sess.FailedTests != nil {
+		attempt.TestsFixed, attempt.TestsRegressed = qa.CompareFailures(sess.FailedTests, results)
+	}
+	if err := t.Audit.RecordDecision(ctx, sess.RunID, &stepID, "system", "repair_loop_iteration", attempt); err != nil {
+		call.Logger.Error("audit record decision failed", "err", err, "decision_type", "repair_loop_iteration")
+	}
+	// QA ran in the operation's worktree; it must be gone before a rollback
+	// deletes the branch checked out there.
+	if err := wt.Remove(ctx); err != nil {
+		call.Logger.Error("remove code worktree failed", "err", err)
+	}
+
+	if ctx.Err() != nil {
+		// A cancelled QA run does not use up the iteration: the session stays
+		// open for another submit or an abandon, and nothing is rolled back.
+		sess.IterationsRun--
+		result.IterationsRun = sess.IterationsRun
+		result.QAAttempts = []RepairAttempt{attempt}
+		result.Status = "cancelled"
+		progress.Status = "cancelled"
+		core.ReportProgress(ctx, progress)
+		t.reopenRepairSession(ctx, call, sess)
+		return &attempt, fmt.Errorf("repair iteration cancelled: %w", context.Cause(ctx))
+	}
+
+	t.recordFlakyTests(ctx, call, call.Run.Repo, &attempt.TestReport)
+	sess.FailedTests = nil
+	if results != nil {
+		sess.FailedTests = results.FailedIDs()
+	}
+	result.IterationsRun = sess.IterationsRun
+	result.QAAttempts = []RepairAttempt{attempt}
+	result.QAPassed = testErr == nil && lintErr == nil && targetErr == nil
+	// Tests that only failed because they are flaky say nothing about the
+	// change, so it passes, with the flaky tests reported.
+	if !result.QAPassed && repairFailureCategory(&attempt, configErr, testErr, lintErr, targetErr) == "flaky_only" {
+		result.QAPassed = true
+		result.QAFailureCategory = "flaky_only"
+		result.QAFailureReason = fmt.Sprintf("%d failed test(s) passed on a rerun and are flaky", results.Flaky)
+	}
+	progress.Status = "failed"
+	if result.QAPassed {
+		progress.Status = "passed"
+	}
+	core.ReportProgress(ctx, progress)
+
+	var runErr error
+	switch {
+	case result.QAPassed:
+		if result.QAFailureCategory == "flaky_only" {
+			telemetry.IncRepairIteration("flaky")
+		} else {
+			telemetry.IncRepairIteration("pass")
+		}
+		owner, repo := splitRepo(call.Run.Repo)
+		pr, prErr := t.GitHub.CreatePullRequest(ctx, owner, repo, gh.CreatePullRequestInput{
+			Title: sess.PRTitle,
+			Head:  sess.HeadBranch,
+			Base:  sess.BaseBranch,
+			Body:  sess.PRBody,
+		})
+		if prErr != nil {
+			// Without its PR the branch would be left behind by a session
+			// that claims to be done, so it goes like a failed last
+			// iteration.
+			sess.Status = core.RepairSessionFailed
+			var rollbackErr error
+			result.RollbackPlannedCommands, result.RollbackError, rollbackErr = t.rollbackRepair(ctx, call, sess)
+			if rollbackErr != nil {
+				telemetry.IncRepairCompleted("rollback_error")
+			} else {
+				telemetry.IncRepairCompleted("pr_error")
+			}
+			runErr = prErr
+		} else {
+			sess.Status = core.RepairSessionCompleted
+			result.PullRequest = pr
+			telemetry.IncRepairCompleted("success")
+		}
+	case sess.IterationsRun < sess.MaxIterations:
+		telemetry.IncRepairIteration("fail")
+		sess.Status = core.RepairSessionOpen
+		result.Status = "awaiting_fix"
+		result.QAFailureReason = fmt.Sprintf("qa checks failed on iteration %d of %d; submit a fix to continue", sess.IterationsRun, sess.MaxIterations)
+		result.QAFailureCategory = repairFailureCategory(&attempt, configErr, testErr, lintErr, targetErr)
+	default:
+		telemetry.IncRepairIteration("fail")
+		sess.Status = core.RepairSessionFailed
+		result.QAFailureReason = fmt.Sprintf("qa checks failed after %d iteration(s)", sess.IterationsRun)
+		result.QAFailureCategory = repairFailureCategory(&attempt, configErr, testErr, lintErr, targetErr)
+		var rollbackErr error
+		result.RollbackPlannedCommands, result.RollbackError, rollbackErr = t.rollbackRepair(ctx, call, sess)
+		if rollbackErr != nil {
+			telemetry.IncRepairCompleted("rollback_error")
+		} else {
+			telemetry.IncRepairCompleted("qa_failed")
+		}
+		runErr = fmt.Errorf("qa checks failed")
+	}
+
+	if err := t.RepairSessions.Save(ctx, sess); err != nil {
+		call.Logger.Error("save repair session failed", "err", err, "repair_session_id", sess.SessionID)
+	}
+	return &attempt, runErr
+}
+
+// runRepairQA runs the required QA targets in dir with runner; when the
+// repository's QA configuration is invalid, configErr fails every target.
+// Lint findings are limited to the changed paths of the iteration;
+// testOpts select and rerun the tests of the test target. targetErr is the
+// error of the first failed target besides test and lint.
+func runRepairQA(ctx context.Context, runner *qa.Runner, configErr error, dir string, iteration int, changed []string, testOpts qa.RunOptions, targets []string) (attempt RepairAttempt, testErr, lintErr, targetErr error) {
+	attempt.Iteration = iteration
+	for _, name := range targets {
+		kind := qa.TargetKind(name)
+		report, err := qa.Report{}, configErr
+		if configErr == nil {
+			var opts qa.RunOptions
+			if name == qa.TargetTest {
+				opts = testOpts
+			}
+			report, err = runQAIn(qaOutputProgress(ctx, kind), runner, kind, dir, opts, false)
+		}
+		if name == qa.TargetLint && report.Findings != nil {
+			report.Findings = report.Findings.Filter(changed)
+		}
+		status := qa.DeriveStatus(report, err, false)
+		telemetry.IncRepairQAResult(name, core.MapQAStatusToMetric(status))
+
+		switch name {
+		case qa.TargetTest:
+			attempt.TestStatus, attempt.TestReport, testErr = status, report, err
+			if err != nil {
+				attempt.TestError = err.Error()
+			}
+		case qa.TargetLint:
+			attempt.LintStatus, attempt.LintReport, lintErr = status, report, err
+			if err != nil {
+				attempt.LintError = err.Error()
+			}
+		default:
+			ta := TargetAttempt{Target: name, Status: status, Report: report}
+			if err != nil {
+				ta.Error = err.Error()
+				if targetErr == nil {
+					targetErr = err
+				}
+			}
+			attempt.Targets = append(attempt.Targets, ta)
+		}
+	}
+	return attempt, testErr, lintErr, targetErr
+}
+
+// repairTargets returns the QA targets every iteration of sess must pass.
+func repairTargets(sess *db.RepairSession) []string {
+	if sess.QATargets == nil {
+		return []string{qa.TargetTest, qa.TargetLint}
+	}
+	return sess.QATargets
+}
+
+func repairFailureCategory(attempt *RepairAttempt, configErr, testErr, lintErr, targetErr error) string {
+	if configErr != nil {
+		return "qa_error"
+	}
+	// A failed target is what fails the iteration when the tests only
+	// failed because they are flaky.
+	testPassed := testErr == nil || attempt.TestReport.TestResults.FlakyOnly()
+	if testPassed && lintErr == nil && targetErr != nil {
+		return core.DeriveTargetFailureCategory(targetErr)
+	}
+	return core.DeriveQAFailureCategory(testErr, lintErr, &attempt.TestReport, &attempt.LintReport)
+}
+
+// validateQATargets checks the qa_targets of a repair loop for empty and
+// duplicate entries; checkQATargets matches them against the repository's
+// targets.
+func validateQATargets(targets []string) error {
+	if targets == nil {
+		return nil
+	}
+	if len(targets) == 0 {
+		return core.BadToolRequest("qa_targets must not be empty")
+	}
+	seen := make(map[string]bool, len(targets))
+	for _, name := range targets {
+		if seen[name] {
+			return core.BadToolRequest("duplicate qa target %q", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// checkQATargets rejects targets runner does not configure. With
+// QARepoConfigDiscovery the repository's .toolhub.yml may still add them,
+// so they are only known once QA runs and fail there instead.
+func (t *toolset) checkQATargets(runner *qa.Runner, targets []string) error {
+	if t.QARepoConfigDiscovery && runner.Source() == qa.ConfigSourceServer {
+		return nil
+	}
+	for _, name := range targets {
+		if !runner.HasTarget(name) {
+			return core.BadToolRequest("unknown qa target %q", name)
+		}
+	}
+	return nil
+}
+
+// rollbackRepair deletes the head branch of sess. The error is also
+// returned as a string for the result.
+func (t *toolset) rollbackRepair(ctx context.Context, call *core.ToolCall, sess *db.RepairSession) ([]string, string, error) {
+	rollback, err := t.Code.RollbackBranch(ctx, call.Run.Repo, sess.BaseBranch, sess.HeadBranch, false)
+	var commands []string
+	if rollback != nil {
+		commands = rollback.PlannedCommands
+	}
+	if err != nil {
+		telemetry.IncRepairRollback("failure")
+		return commands, err.Error(), err
+	}
+	telemetry.IncRepairRollback("success")
+	return commands, "", nil
+}
+
+// reopenRepairSession hands a claimed session back for the next submit. It
+// also runs for cancelled calls, so it does not use their cancellation.
+func (t *toolset) reopenRepairSession(ctx context.Context, call *core.ToolCall, sess *db.RepairSession) {
+	sess.Status = core.RepairSessionOpen
+	if err := t.RepairSessions.Save(context.WithoutCancel(ctx), sess); err != nil {
+		call.Logger.Error("save repair session failed", "err", err, "repair_session_id", sess.SessionID)
+	}
+}
+
+func newRepairLoopResult(sess *db.RepairSession, codeResult *codeops.Result) *RepairLoopResult {
+	return &RepairLoopResult{
+		Status:              "completed",
+		RepairSessionID:     sess.SessionID,
+		IterationsRequested: sess.MaxIterations,
+		IterationsRun:       sess.IterationsRun,
+		BaseBranch:          sess.BaseBranch,
+		HeadBranch:          sess.HeadBranch,
+		PlannedCommands:     codeResult.PlannedCommands,
+		CommitHash:          codeResult.CommitHash,
+		Conflicts:           codeResult.Conflicts,
+		StaleFiles:          codeResult.StaleFiles,
+		Verification:        codeResult.Verification,
+	}
+}
+
+func (t *toolset) finishRepairStep(ctx context.Context, call *core.ToolCall, stepID string, result *RepairLoopResult, runErr error) {
+	// The step is finished even when the call was cancelled.
+	ctx = context.WithoutCancel(ctx)
+	decisionType := "repair_loop_completed"
+	stepStatus := "completed"
+	switch {
+	case result.Status == "cancelled":
+		decisionType = "repair_loop_cancelled"
+		stepStatus = "cancelled"
+	case runErr != nil:
+		decisionType = "repair_loop_failed"
+		stepStatus = "failed"
+	case result.Status == "awaiting_fix":
+		decisionType = "repair_loop_awaiting_fix"
+		stepStatus = "failed"
+	}
+	if err := t.Audit.RecordDecision(ctx, call.Run.RunID, &stepID, "system", decisionType, result); err != nil {
+		call.Logger.Error("audit record decision failed", "err", err, "decision_type", decisionType)
+	}
+	if err := t.Audit.FinishStep(ctx, stepID, stepStatus); err != nil {
+		call.Logger.Error("audit finish step failed", "err", err, "step_id", stepID)
+	}
+}
+
+// repairOutcome records the iteration's submitted and applied changes and
+// its QA reports as artifacts of the call.
+func (t *toolset) repairOutcome(call *core.ToolCall, tool string, args any, files []codeops.FileChange, codeResult *codeops.Result, attempt *RepairAttempt, result *RepairLoopResult, runErr error) *core.ToolOutcome {
+	artifacts := codeArtifacts(tool, files, codeResult)
+	dsts := []*string{&result.PatchArtifactID, &result.AppliedPatchArtifactID}[:len(artifacts)]
+	if attempt != nil {
+		body, err := json.Marshal(attempt)
+		if err != nil {
+			call.Logger.Error("marshal qa attempt failed", "err", err)
+		} else {
+			artifacts = append(artifacts, core.ExtraArtifact{Name: tool + ".qa.json", ContentType: "application/json", Body: body})
+			dsts = append(dsts, &result.QAReportArtifactID)
+		}
+	}
+	return &core.ToolOutcome{
+		Result:         result,
+		Err:            runErr,
+		ErrStatus:      http.StatusBadGateway,
+		Request:        args,
+		Response:       result,
+		ExtraArtifacts: artifacts,
+		Finalize:       setArtifactIDs(dsts...),
+	}
+}
@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/toolhub/toolhub/internal/qa"
+)
+
+func TestRepairFailureCategory(t *testing.T) {
+	failed := &qa.QAError{ErrCode: qa.ErrCodeExecFailed, Detail: "exit code 1"}
+	flaky := RepairAttempt{TestReport: qa.Report{TestResults: &qa.TestResults{Failed: 1, Flaky: 1}}}
+	broken := RepairAttempt{TestReport: qa.Report{TestResults: &qa.TestResults{Failed: 2, Flaky: 1}}}
+
+	tests := []struct {
+		name      string
+		attempt   RepairAttempt
+		configErr error
+		testErr   error
+		lintErr   error
+		targetErr error
+		want      string
+	}{
+		{name: "flaky only", attempt: flaky, testErr: failed, want: "flaky_only"},
+		{name: "flaky and real failures", attempt: broken, testErr: failed, want: "test_failure"},
+		{name: "flaky and target failure", attempt: flaky, testErr: failed, targetErr: errors.New("build failed"), want: "target_failure"},
+		{name: "flaky and lint failure", attempt: flaky, testErr: failed, lintErr: errors.New("lint failed"), want: "both_failure"},
+		{name: "invalid config", attempt: flaky, configErr: errors.New("bad .toolhub.yml"), testErr: failed, want: "qa_error"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := repairFailureCategory(&tc.attempt, tc.configErr, tc.testErr, tc.lintErr, tc.targetErr); got != tc.want {
+				t.Fatalf("category = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateTestReruns(t *testing.T) {
+	runner, err := qa.NewRunner(qa.Config{TestCmd: "go test ./...", LintCmd: "go vet ./...", TestMaxReruns: 2})
+	if err != nil {
+		t.Fatalf("new runner: %v", err)
+	}
+	ts := &toolset{Deps: Deps{QA: runner}}
+	for _, tc := range []struct {
+		reruns  int
+		wantErr bool
+	}{{0, false}, {2, false}, {3, true}, {-1, true}} {
+		if err := ts.validateTestReruns("rerun_failed", tc.reruns); (err != nil) != tc.wantErr {
+			t.Fatalf("reruns %d: err = %v", tc.reruns, err)
+		}
+	}
+	if err := (&toolset{}).validateTestReruns("rerun_failed", 1); err == nil {
+		t.Fatal("reruns allowed without a qa runner")
+	}
+}
@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/toolhub/toolhub/internal/core"
+	"github.com/toolhub/toolhub/internal/db"
+)
+
+type RunsCreateArgs struct {
+	Repo    string `json:"repo" jsonschema:"description=owner/repo"`
+	Purpose string `json:"purpose" jsonschema:"description=Why this run exists"`
+}
+
+func (t *toolset) registerRuns(reg *core.Registry) {
+	reg.Register(core.ToolSpec{
+		Name:        "runs.create",
+		Description: "Create a new ToolHub run for a repository",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs", OperationID: "createRun", Status: http.StatusCreated, Raw: true},
+		NewArgs:     func() any { return &RunsCreateArgs{} },
+		Result:      db.Run{},
+		Policy: core.ToolPolicy{
+			NoRun: true,
+			Validate: func(args any) error {
+				if err := t.Policy.CheckRepo(args.(*RunsCreateArgs).Repo); err != nil {
+					return &core.ToolRequestError{Status: http.StatusForbidden, Message: err.Error()}
+				}
+				return nil
+			},
+		},
+		Execute: t.runsCreate,
+	})
+}
+
+func (t *toolset) runsCreate(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+	args := call.Args.(*RunsCreateArgs)
+	run, err := t.Runs.CreateRun(ctx, core.CreateRunRequest{Repo: args.Repo, Purpose: args.Purpose})
+	if err != nil {
+		return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	return &core.ToolOutcome{Result: run, Raw: true, Status: http.StatusCreated}, nil
+}
@@ -0,0 +1,44 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/toolhub/toolhub/internal/core"
+)
+
+type ToolCallCancelArgs struct {
+	RunID      string `json:"run_id"`
+	ToolCallID string `json:"tool_call_id"`
+}
+
+func (t *toolset) registerToolCalls(reg *core.Registry) {
+	reg.Register(core.ToolSpec{
+		Name:        "tool_calls.cancel",
+		Description: "Cancel a running tool call by the tool_call_id of its started progress event; it is recorded as cancelled once it has stopped",
+		Route:       &core.ToolRoute{Method: http.MethodPost, Path: "/api/v1/runs/{runID}/tool-calls/{toolCallID}/cancel", OperationID: "cancelToolCall", Params: []core.RouteParam{runIDParam, toolCallIDParam}, Raw: true},
+		NewArgs:     func() any { return &ToolCallCancelArgs{} },
+		Result:      core.RunningToolCall{},
+		Policy:      core.ToolPolicy{SkipAllowlist: true},
+		Execute: func(ctx context.Context, call *core.ToolCall) (*core.ToolOutcome, error) {
+			args := call.Args.(*ToolCallCancelArgs)
+			running, ok := reg.CancelToolCall(call.Run.RunID, args.ToolCallID)
+			if ok {
+				call.Logger.Info("tool call cancel requested", "cancelled_tool_call_id", running.ToolCallID, "cancelled_tool_name", running.ToolName)
+				return &core.ToolOutcome{Result: running, Raw: true}, nil
+			}
+			if t.Audit == nil {
+				return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: "audit is not configured"}
+			}
+			tc, err := t.Audit.GetToolCall(ctx, call.Run.RunID, args.ToolCallID)
+			if err != nil {
+				return nil, &core.ToolRequestError{Status: http.StatusInternalServerError, Message: err.Error()}
+			}
+			if tc != nil {
+				return nil, &core.ToolCallError{Code: "tool_call_finished", Message: fmt.Sprintf("tool call %q already finished with status %s", tc.ToolCallID, tc.Status)}
+			}
+			return nil, &core.ToolCallError{Code: "tool_call_not_found", Message: fmt.Sprintf("tool call %q is not running in run", args.ToolCallID)}
+		},
+	})
+}
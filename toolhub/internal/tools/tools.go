@@ -0,0 +1,87 @@
+// Package tools defines every ToolHub tool once, on top of core.Registry.
+// The HTTP and MCP servers only translate their transport into
+// Registry.Invoke calls.
+package tools
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/toolhub/toolhub/internal/codeops"
+	"github.com/toolhub/toolhub/internal/core"
+	gh "github.com/toolhub/toolhub/internal/github"
+	"github.com/toolhub/toolhub/internal/qa"
+	"github.com/toolhub/toolhub/internal/workspace"
+)
+
+// Deps are the services tools execute against. Nil services are allowed
+// when only the definitions are needed (docs generation).
+type Deps struct {
+	Runs                *core.RunService
+	Audit               *core.AuditService
+	Policy              *core.Policy
+	GitHub              *gh.Client
+	QA                  *qa.Runner
+	Code                *codeops.Runner
+	Workspaces          *workspace.Manager
+	RepairSessions      *core.RepairSessionService
+	FlakyTests          *core.FlakyTestService
+	Jobs                *core.JobService
+	Logger              *slog.Logger
+	BatchMode           core.BatchMode
+	RepairMaxIterations int
+	// CoAuthorFromPrincipal credits the calling principal with a
+	// Co-authored-by trailer when it is an email identity.
+	CoAuthorFromPrincipal bool
+	// QARepoConfigDiscovery reads the QA configuration of repositories
+	// without one in the policy file from their .toolhub.yml on the base
+	// branch.
+	QARepoConfigDiscovery bool
+}
+
+type toolset struct {
+	Deps
+}
+
+// NewRegistry builds a registry with every ToolHub tool registered.
+func NewRegistry(deps Deps) *core.Registry {
+	if deps.RepairMaxIterations <= 0 {
+		deps.RepairMaxIterations = 3
+	}
+	reg := core.NewRegistry(deps.Runs, deps.Audit, deps.Policy, deps.Logger)
+	reg.SetJobs(deps.Jobs)
+	t := &toolset{Deps: deps}
+	t.registerRuns(reg)
+	t.registerIssues(reg)
+	t.registerPulls(reg)
+	t.registerQA(reg)
+	t.registerCode(reg)
+	t.registerRepair(reg)
+	t.registerJobs(reg)
+	t.registerToolCalls(reg)
+	return reg
+}
+
+// Definitions returns the registered tools without any backing services.
+func Definitions() []*core.ToolSpec {
+	return NewRegistry(Deps{}).Specs()
+}
+
+var (
+	runIDParam      = core.RouteParam{Name: "runID", Arg: "run_id"}
+	prNumberParam   = core.RouteParam{Name: "prNumber", Arg: "pr_number", Integer: true}
+	jobIDParam      = core.RouteParam{Name: "jobID", Arg: "job_id"}
+	toolCallIDParam = core.RouteParam{Name: "toolCallID", Arg: "tool_call_id"}
+	// qaTargetParam names a configured QA target of qa.run.
+	qaTargetParam = core.RouteParam{Name: "target", Arg: "target"}
+	// repairSessionParam names the open repair loop a follow-up call targets.
+	repairSessionParam = core.RouteParam{Name: "sessionID", Arg: "repair_session_id"}
+)
+
+func splitRepo(fullRepo string) (string, string) {
+	parts := strings.SplitN(fullRepo, "/", 2)
+	if len(parts) != 2 {
+		return fullRepo, ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,40 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefinitionsRouteParamsInSchema(t *testing.T) {
+	seen := make(map[string]bool)
+	operationIDs := make(map[string]bool)
+	for _, spec := range Definitions() {
+		if seen[spec.MCPName()] {
+			t.Fatalf("duplicate MCP name %q", spec.MCPName())
+		}
+		seen[spec.MCPName()] = true
+
+		if spec.NewArgs == nil || spec.Execute == nil {
+			t.Fatalf("%s: NewArgs and Execute are required", spec.Name)
+		}
+		if spec.Route == nil {
+			continue
+		}
+		if spec.Route.OperationID == "" || operationIDs[spec.Route.OperationID] {
+			t.Fatalf("%s: operation id %q must be set and unique", spec.Name, spec.Route.OperationID)
+		}
+		operationIDs[spec.Route.OperationID] = true
+		if spec.Result == nil {
+			t.Fatalf("%s: routed tools must declare their Result type", spec.Name)
+		}
+		props, _ := spec.InputSchema["properties"].(map[string]any)
+		for _, p := range spec.Route.Params {
+			if !strings.Contains(spec.Route.Path, "{"+p.Name+"}") {
+				t.Fatalf("%s: route %s has no {%s} wildcard", spec.Name, spec.Route.Path, p.Name)
+			}
+			if _, ok := props[p.Arg]; !ok {
+				t.Fatalf("%s: path param %s maps to unknown argument %s", spec.Name, p.Name, p.Arg)
+			}
+		}
+	}
+}
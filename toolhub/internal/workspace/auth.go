@@ -0,0 +1,45 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultBaseURL is the host clone, fetch and push URLs point at unless
+// configured otherwise.
+const DefaultBaseURL = "https://github.com"
+
+// tokenEnv carries the installation token to the credential helper. It only
+// ever exists in the environment of the git process.
+const tokenEnv = "TOOLHUB_GIT_TOKEN"
+
+// credentialHelper answers git's "get" requests from tokenEnv, so the token
+// never appears in argv, git config or on disk.
+const credentialHelper = `!f() { test "$1" = get && printf 'username=x-access-token\npassword=%s\n' "$` + tokenEnv + `"; }; f`
+
+// AuthEnv returns the environment that authenticates git over HTTPS to
+// baseURL with a fresh installation token. Credential helpers configured
+// elsewhere are reset for baseURL, so ambient credentials are never used.
+// Prompts are disabled either way; nil tokens means anonymous access.
+func AuthEnv(ctx context.Context, tokens TokenSource, baseURL string) ([]string, error) {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if tokens == nil {
+		return env, nil
+	}
+	token, err := tokens.InstallationToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("git auth: %w", err)
+	}
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	key := "credential." + baseURL + ".helper"
+	return append(env,
+		tokenEnv+"="+token,
+		"GIT_CONFIG_COUNT=2",
+		"GIT_CONFIG_KEY_0="+key,
+		"GIT_CONFIG_VALUE_0=",
+		"GIT_CONFIG_KEY_1="+key,
+		"GIT_CONFIG_VALUE_1="+credentialHelper,
+	), nil
+}
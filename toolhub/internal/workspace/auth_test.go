@@ -0,0 +1,50 @@
+package workspace
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthEnvCredentialHelper(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	// An ambient helper must be overridden for the base URL.
+	global := filepath.Join(t.TempDir(), "gitconfig")
+	if err := os.WriteFile(global, []byte("[credential]\n\thelper = \"!f() { echo username=ambient; echo password=ambient; }; f\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", global)
+
+	env, err := AuthEnv(context.Background(), staticToken("s3cr3t"), "https://github.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GIT_CONFIG_") && strings.Contains(kv, "s3cr3t") {
+			t.Fatalf("token leaked into git config: %q", kv)
+		}
+	}
+
+	fill := func(host string) string {
+		t.Helper()
+		cmd := exec.Command("git", "credential", "fill")
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdin = strings.NewReader("protocol=https\nhost=" + host + "\n\n")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git credential fill: %v\n%s", err, out)
+		}
+		return string(out)
+	}
+	if out := fill("github.com"); !strings.Contains(out, "username=x-access-token\n") || !strings.Contains(out, "password=s3cr3t\n") {
+		t.Fatalf("credential fill for github.com:\n%s", out)
+	}
+	if out := fill("example.com"); strings.Contains(out, "s3cr3t") {
+		t.Fatalf("token offered to another host:\n%s", out)
+	}
+}
@@ -0,0 +1,92 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// gitWaitDelay bounds how long a killed git waits for helpers (remote
+// transports, credential helpers) that inherited its output.
+const gitWaitDelay = 5 * time.Second
+
+// RunGit runs git in dir. env is appended to the process environment; it is
+// how authentication reaches git without touching argv or disk. When ctx is
+// cancelled git is killed and the error wraps the cause of the cancellation.
+func RunGit(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.WaitDelay = gitWaitDelay
+	out, err := cmd.CombinedOutput()
+	if err != nil && ctx.Err() != nil {
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), context.Cause(ctx))
+	}
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// RunGitOutput runs git in dir like RunGit but returns only its standard
+// output, for output that is data: file contents, object IDs and listings
+// must not pick up warnings git prints on stderr. Stderr still goes into
+// the error.
+func RunGitOutput(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.WaitDelay = gitWaitDelay
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && ctx.Err() != nil {
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), context.Cause(ctx))
+	}
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// ReadFileAt returns the content of path as committed at ref in the
+// repository of dir. found is false when ref has no such file.
+func ReadFileAt(ctx context.Context, dir, ref, path string) (content []byte, found bool, err error) {
+	if _, err := RunGitOutput(ctx, dir, nil, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		return nil, false, err
+	}
+	listed, err := RunGitOutput(ctx, dir, nil, "ls-tree", "--name-only", ref, "--", path)
+	if err != nil {
+		return nil, false, err
+	}
+	if strings.TrimSpace(listed) == "" {
+		return nil, false, nil
+	}
+	out, err := RunGitOutput(ctx, dir, nil, "cat-file", "blob", ref+":"+path)
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(out), true, nil
+}
+
+// ChangedFiles lists the paths head changed since it branched off base, in
+// the repository of dir. A rename lists both paths.
+func ChangedFiles(ctx context.Context, dir, base, head string) ([]string, error) {
+	out, err := RunGitOutput(ctx, dir, nil, "diff", "--name-only", "--no-renames", "-z", base+"..."+head)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, p := range strings.Split(out, "\x00") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,73 @@
+package workspace
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReadFileAt(t *testing.T) {
+	work := newRemote(t, t.TempDir(), "acme/app")
+	commitFile(t, work, ".toolhub.yml", "qa:\n  test:\n    command: go test ./...\n")
+	gitT(t, work, "checkout", "-q", "-b", "feature")
+	commitFile(t, work, ".toolhub.yml", "qa: {}\n")
+
+	ctx := context.Background()
+	content, found, err := ReadFileAt(ctx, work, "main", ".toolhub.yml")
+	if err != nil || !found {
+		t.Fatalf("read at main = %v, %v", found, err)
+	}
+	if string(content) != "qa:\n  test:\n    command: go test ./...\n" {
+		t.Fatalf("content = %q", content)
+	}
+	if _, found, err := ReadFileAt(ctx, work, "main", "missing.yml"); err != nil || found {
+		t.Fatalf("missing file = %v, %v", found, err)
+	}
+	if _, _, err := ReadFileAt(ctx, work, "no-such-branch", ".toolhub.yml"); err == nil {
+		t.Fatal("expected an error for an unknown ref")
+	}
+
+	// Whatever git prints on stderr stays out of the content.
+	t.Setenv("GIT_TRACE", "1")
+	if content, _, err := ReadFileAt(ctx, work, "feature", ".toolhub.yml"); err != nil || string(content) != "qa: {}\n" {
+		t.Fatalf("content with GIT_TRACE = %q, %v", content, err)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	work := newRemote(t, t.TempDir(), "acme/app")
+	commitFile(t, work, "old.go", "package app\n")
+	gitT(t, work, "checkout", "-q", "-b", "feature")
+	commitFile(t, work, "new file.go", "package app\n")
+	gitT(t, work, "mv", "old.go", "renamed.go")
+	gitT(t, work, "commit", "-q", "-m", "rename")
+	gitT(t, work, "checkout", "-q", "main")
+	commitFile(t, work, "main-only.go", "package app\n")
+
+	changed, err := ChangedFiles(context.Background(), work, "main", "feature")
+	if err != nil {
+		t.Fatalf("changed files: %v", err)
+	}
+	if got := strings.Join(changed, ","); got != "new file.go,old.go,renamed.go" {
+		t.Fatalf("changed = %s", got)
+	}
+}
+
+func TestRunGitOutputKeepsStderrOut(t *testing.T) {
+	work := newRemote(t, t.TempDir(), "acme/app")
+	// GIT_TRACE makes git write to stderr on every run.
+	env := []string{"GIT_TRACE=1"}
+	ctx := context.Background()
+
+	out, err := RunGitOutput(ctx, work, env, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatalf("rev-parse: %v", err)
+	}
+	if want := gitT(t, work, "rev-parse", "HEAD"); out != want+"\n" {
+		t.Fatalf("output = %q, want %q", out, want)
+	}
+	_, err = RunGitOutput(ctx, work, env, "rev-parse", "--verify", "no-such-ref")
+	if err == nil || !strings.Contains(err.Error(), "fatal:") {
+		t.Fatalf("err = %v, want git's stderr", err)
+	}
+}
@@ -0,0 +1,263 @@
+// Package workspace keeps a cached bare clone of every repository ToolHub
+// operates on. Code and QA operations lease a clone, which is fetched first,
+// and work in temporary worktrees of it.
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remote is the name of the GitHub remote in every cached clone.
+const Remote = "origin"
+
+// TokenSource issues GitHub App installation tokens.
+type TokenSource interface {
+	InstallationToken(ctx context.Context) (string, error)
+}
+
+type Config struct {
+	// Root holds the clones as <owner>/<repo>.git.
+	Root string
+	// BaseURL is prefixed to owner/repo to build clone URLs; defaults to
+	// https://github.com.
+	BaseURL string
+	// Tokens authenticates clone, fetch and push through AuthEnv. Nil means
+	// anonymous.
+	Tokens TokenSource
+	// AllowRepo rejects repositories outside the allowlist.
+	AllowRepo func(repo string) error
+	// MaxRepos caps the number of cached clones; the least recently used
+	// idle clone is evicted first. 0 means no cap.
+	MaxRepos int
+	// MaxIdle evicts clones unused for longer. 0 means never.
+	MaxIdle time.Duration
+}
+
+type Manager struct {
+	cfg   Config
+	now   func() time.Time
+	mu    sync.Mutex
+	repos map[string]*entry
+}
+
+type entry struct {
+	// mu serializes clone and fetch of one repository.
+	mu       sync.Mutex
+	gitDir   string
+	lastUsed time.Time
+	leases   int
+}
+
+var repoPartRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// NewManager creates the cache root and registers clones left by a previous
+// process, so the cache survives restarts.
+func NewManager(cfg Config) (*Manager, error) {
+	if strings.TrimSpace(cfg.Root) == "" {
+		return nil, fmt.Errorf("workspace root is required")
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return nil, fmt.Errorf("resolve workspace root: %w", err)
+	}
+	cfg.Root = root
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("create workspace root: %w", err)
+	}
+
+	m := &Manager{cfg: cfg, now: time.Now, repos: map[string]*entry{}}
+	existing, _ := filepath.Glob(filepath.Join(root, "*", "*.git"))
+	for _, dir := range existing {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		repo := filepath.Base(filepath.Dir(dir)) + "/" + strings.TrimSuffix(filepath.Base(dir), ".git")
+		m.repos[repo] = &entry{gitDir: dir, lastUsed: info.ModTime()}
+	}
+	return m, nil
+}
+
+// GitDir returns where the clone of repo lives, whether or not it exists.
+func (m *Manager) GitDir(repo string) (string, error) {
+	owner, name, err := splitRepo(repo)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(m.cfg.Root, owner, name+".git"), nil
+}
+
+// Repo is a leased clone, fetched when it was acquired. It is not evicted
+// until Release is called.
+type Repo struct {
+	Name   string
+	GitDir string
+	// Env authenticates git commands that talk to the remote.
+	Env     []string
+	release func()
+	once    sync.Once
+}
+
+// Release returns the lease. It is safe to call more than once.
+func (r *Repo) Release() {
+	if r == nil {
+		return
+	}
+	r.once.Do(r.release)
+}
+
+// DefaultRef is the remote default branch of the clone.
+func (r *Repo) DefaultRef() string { return Remote + "/HEAD" }
+
+// BranchRef is the fetched remote branch name.
+func (r *Repo) BranchRef(branch string) string { return Remote + "/" + branch }
+
+// Acquire leases the clone of repo, cloning it on first use and fetching
+// otherwise.
+func (m *Manager) Acquire(ctx context.Context, repo string) (*Repo, error) {
+	if m.cfg.AllowRepo != nil {
+		if err := m.cfg.AllowRepo(repo); err != nil {
+			return nil, err
+		}
+	}
+	gitDir, err := m.GitDir(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	e := m.repos[repo]
+	if e == nil {
+		e = &entry{gitDir: gitDir}
+		m.repos[repo] = e
+	}
+	e.leases++
+	e.lastUsed = m.now()
+	m.mu.Unlock()
+
+	lease := &Repo{Name: repo, GitDir: gitDir}
+	lease.release = func() {
+		m.mu.Lock()
+		e.leases--
+		e.lastUsed = m.now()
+		m.mu.Unlock()
+		m.Evict()
+	}
+
+	env, err := AuthEnv(ctx, m.cfg.Tokens, m.cfg.BaseURL)
+	if err == nil {
+		lease.Env = env
+		err = m.sync(ctx, e, repo, env)
+	}
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	return lease, nil
+}
+
+// sync clones the repository if needed and fetches every branch into
+// refs/remotes/origin. Branches are never fetched into refs/heads, where
+// they could clash with branches checked out in worktrees.
+func (m *Manager) sync(ctx context.Context, e *entry, repo string, env []string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(e.gitDir, "HEAD")); errors.Is(err, os.ErrNotExist) {
+		if err := m.initClone(ctx, e.gitDir, repo); err != nil {
+			return err
+		}
+	}
+	if err := Fetch(ctx, e.gitDir, env, "--prune", Remote); err != nil {
+		return err
+	}
+	if _, err := RunGit(ctx, e.gitDir, env, "remote", "set-head", Remote, "--auto"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Manager) initClone(ctx context.Context, gitDir, repo string) error {
+	if err := os.MkdirAll(filepath.Dir(gitDir), 0o755); err != nil {
+		return fmt.Errorf("create workspace dir: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(gitDir), ".clone-")
+	if err != nil {
+		return fmt.Errorf("create workspace dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	steps := [][]string{
+		{"init", "--quiet", "--bare"},
+		{"remote", "add", Remote, m.cfg.BaseURL + "/" + repo + ".git"},
+		{"config", "remote." + Remote + ".fetch", "+refs/heads/*:refs/remotes/" + Remote + "/*"},
+	}
+	for _, args := range steps {
+		if _, err := RunGit(ctx, tmp, nil, args...); err != nil {
+			return err
+		}
+	}
+	// Rename into place so a failed init never leaves a half-made clone.
+	if err := os.Rename(tmp, gitDir); err != nil {
+		return fmt.Errorf("install clone of %s: %w", repo, err)
+	}
+	return nil
+}
+
+// Evict removes clones idle for longer than MaxIdle, then the least
+// recently used ones above MaxRepos. Leased clones are kept. It returns the
+// evicted repositories.
+func (m *Manager) Evict() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type candidate struct {
+		repo string
+		e    *entry
+	}
+	var idle []candidate
+	for repo, e := range m.repos {
+		if e.leases == 0 {
+			idle = append(idle, candidate{repo, e})
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool { return idle[i].e.lastUsed.Before(idle[j].e.lastUsed) })
+
+	var evicted []string
+	now := m.now()
+	over := 0
+	if m.cfg.MaxRepos > 0 {
+		over = len(m.repos) - m.cfg.MaxRepos
+	}
+	for _, c := range idle {
+		expired := m.cfg.MaxIdle > 0 && now.Sub(c.e.lastUsed) > m.cfg.MaxIdle
+		if !expired && over <= 0 {
+			continue
+		}
+		os.RemoveAll(c.e.gitDir)
+		delete(m.repos, c.repo)
+		evicted = append(evicted, c.repo)
+		over--
+	}
+	return evicted
+}
+
+func splitRepo(repo string) (string, string, error) {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || !repoPartRe.MatchString(owner) || !repoPartRe.MatchString(name) || owner == "." || owner == ".." || name == "." || name == ".." {
+		return "", "", fmt.Errorf("invalid repository name %q", repo)
+	}
+	return owner, name, nil
+}
@@ -0,0 +1,168 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gitT(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newRemote creates <base>/<repo>.git with one commit on main and returns a
+// clone of it for pushing more commits.
+func newRemote(t *testing.T, base, repo string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	remote := filepath.Join(base, repo+".git")
+	work := filepath.Join(t.TempDir(), "work")
+	gitT(t, base, "init", "-q", "--bare", "-b", "main", remote)
+	gitT(t, base, "clone", "-q", remote, work)
+	gitT(t, work, "config", "user.email", "toolhub@example.com")
+	gitT(t, work, "config", "user.name", "ToolHub Test")
+	gitT(t, work, "checkout", "-q", "-b", "main")
+	commitFile(t, work, "README.md", "base\n")
+	return work
+}
+
+func commitFile(t *testing.T, work, name, content string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(work, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gitT(t, work, "add", name)
+	gitT(t, work, "commit", "-q", "-m", "update "+name)
+	gitT(t, work, "push", "-q", "origin", "main")
+	return gitT(t, work, "rev-parse", "HEAD")
+}
+
+type staticToken string
+
+func (s staticToken) InstallationToken(context.Context) (string, error) { return string(s), nil }
+
+func TestManagerAcquireClonesAndFetches(t *testing.T) {
+	base := t.TempDir()
+	work := newRemote(t, base, "acme/app")
+	m, err := NewManager(Config{Root: t.TempDir(), BaseURL: "file://" + base, Tokens: staticToken("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	lease, err := m.Acquire(ctx, "acme/app")
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if got, want := gitT(t, lease.GitDir, "rev-parse", lease.BranchRef("main")), gitT(t, work, "rev-parse", "HEAD"); got != want {
+		t.Fatalf("cloned main = %s, want %s", got, want)
+	}
+	if got := gitT(t, lease.GitDir, "rev-parse", "--abbrev-ref", lease.DefaultRef()); got != "origin/main" {
+		t.Fatalf("default ref = %q", got)
+	}
+	// The token is passed through the environment only.
+	if cfg := gitT(t, lease.GitDir, "config", "--list"); strings.Contains(cfg, "secret") || strings.Contains(cfg, "credential") {
+		t.Fatalf("token persisted in git config:\n%s", cfg)
+	}
+	lease.Release()
+
+	head := commitFile(t, work, "NEW.md", "new\n")
+	lease, err = m.Acquire(ctx, "acme/app")
+	if err != nil {
+		t.Fatalf("second Acquire: %v", err)
+	}
+	defer lease.Release()
+	if got := gitT(t, lease.GitDir, "rev-parse", lease.BranchRef("main")); got != head {
+		t.Fatalf("fetched main = %s, want %s", got, head)
+	}
+}
+
+func TestManagerAcquireRejectsRepos(t *testing.T) {
+	denied := errors.New("repo not allowed")
+	m, err := NewManager(Config{Root: t.TempDir(), AllowRepo: func(repo string) error {
+		if repo != "acme/app" {
+			return denied
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Acquire(context.Background(), "other/app"); !errors.Is(err, denied) {
+		t.Fatalf("err = %v, want allowlist rejection", err)
+	}
+	for _, repo := range []string{"acme", "acme/../etc", "../x/y", "acme/app/extra"} {
+		if _, err := m.GitDir(repo); err == nil {
+			t.Fatalf("GitDir(%q) accepted invalid name", repo)
+		}
+	}
+}
+
+func TestManagerEvict(t *testing.T) {
+	base := t.TempDir()
+	for _, repo := range []string{"acme/a", "acme/b", "acme/c"} {
+		newRemote(t, base, repo)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m, err := NewManager(Config{Root: t.TempDir(), BaseURL: "file://" + base, MaxRepos: 2, MaxIdle: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	acquire := func(repo string) *Repo {
+		t.Helper()
+		lease, err := m.Acquire(ctx, repo)
+		if err != nil {
+			t.Fatalf("Acquire %s: %v", repo, err)
+		}
+		return lease
+	}
+
+	acquire("acme/a").Release()
+	now = now.Add(time.Minute)
+	held := acquire("acme/b")
+	now = now.Add(time.Minute)
+	// Releasing c puts three clones in the cache; a is the least recently
+	// used idle one.
+	acquire("acme/c").Release()
+	if _, err := os.Stat(filepath.Join(m.cfg.Root, "acme", "a.git")); !os.IsNotExist(err) {
+		t.Fatalf("acme/a not evicted: %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if got := m.Evict(); len(got) != 1 || got[0] != "acme/c" {
+		t.Fatalf("Evict = %v, want only the idle acme/c", got)
+	}
+	held.Release()
+	if _, err := os.Stat(held.GitDir); err != nil {
+		t.Fatalf("acme/b evicted right after release: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if got := m.Evict(); len(got) != 1 || got[0] != "acme/b" {
+		t.Fatalf("Evict = %v, want acme/b", got)
+	}
+
+	// A restarted manager finds clones on disk.
+	acquire("acme/a").Release()
+	m2, err := NewManager(Config{Root: m.cfg.Root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m2.repos["acme/a"]; !ok {
+		t.Fatalf("existing clone not registered: %v", m2.repos)
+	}
+}
@@ -0,0 +1,84 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// adminLocks serializes worktree add and remove, and fetches, per
+// repository. Both read the metadata of every worktree and fail on an entry
+// that is still being written.
+var adminLocks sync.Map
+
+func lockAdmin(gitDir string) func() {
+	v, _ := adminLocks.LoadOrStore(filepath.Clean(gitDir), &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Worktree is a temporary git worktree owned by a single operation.
+type Worktree struct {
+	Dir      string
+	gitDir   string
+	onRemove func()
+}
+
+// AddWorktree checks out ref, detached, in a fresh directory under root
+// (the system temp directory when empty).
+func AddWorktree(ctx context.Context, gitDir, ref, root string) (*Worktree, error) {
+	dir, err := os.MkdirTemp(root, "toolhub-worktree-")
+	if err != nil {
+		return nil, fmt.Errorf("create worktree dir: %w", err)
+	}
+	unlock := lockAdmin(gitDir)
+	_, err = RunGit(ctx, gitDir, nil, "worktree", "add", "--detach", dir, ref)
+	unlock()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &Worktree{Dir: dir, gitDir: gitDir}, nil
+}
+
+// Fetch runs git fetch with args in gitDir while no worktree is added or
+// removed there: fetch reads the HEAD of every worktree as well.
+func Fetch(ctx context.Context, gitDir string, env []string, args ...string) error {
+	unlock := lockAdmin(gitDir)
+	defer unlock()
+	_, err := RunGit(ctx, gitDir, env, append([]string{"fetch"}, args...)...)
+	return err
+}
+
+// OnRemove registers f to run once the worktree has been removed.
+func (w *Worktree) OnRemove(f func()) {
+	w.onRemove = f
+}
+
+// Remove deletes the worktree and its git metadata. It runs even when ctx
+// is already cancelled and is safe to call more than once or on nil.
+func (w *Worktree) Remove(ctx context.Context) error {
+	if w == nil || w.Dir == "" {
+		return nil
+	}
+	ctx = context.WithoutCancel(ctx)
+	unlock := lockAdmin(w.gitDir)
+	defer unlock()
+	_, err := RunGit(ctx, w.gitDir, nil, "worktree", "remove", "--force", w.Dir)
+	if err != nil {
+		// Fall back to deleting the directory and pruning the stale entry.
+		os.RemoveAll(w.Dir)
+		if _, pruneErr := RunGit(ctx, w.gitDir, nil, "worktree", "prune"); pruneErr == nil {
+			err = nil
+		}
+	}
+	w.Dir = ""
+	if w.onRemove != nil {
+		w.onRemove()
+		w.onRemove = nil
+	}
+	return err
+}
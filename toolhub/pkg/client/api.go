@@ -0,0 +1,274 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func (c *Client) Health(ctx context.Context) (*HealthStatus, error) {
+	var out HealthStatus
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: "/healthz", replayable: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) Version(ctx context.Context) (*VersionInfo, error) {
+	var out VersionInfo
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: "/version", replayable: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Runs
+
+// CreateRun is not retried: a lost response could otherwise create two runs.
+func (c *Client) CreateRun(ctx context.Context, req CreateRunRequest) (*Run, error) {
+	var out Run
+	if err := c.getJSON(ctx, request{method: http.MethodPost, path: "/api/v1/runs", body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) GetRun(ctx context.Context, runID string) (*Run, error) {
+	var out Run
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: runPath(runID), replayable: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Approvals
+
+func (c *Client) CreateApproval(ctx context.Context, runID string, req CreateApprovalRequest) (*Approval, error) {
+	var out Approval
+	if err := c.getJSON(ctx, request{method: http.MethodPost, path: runPath(runID, "approvals"), body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) ListApprovals(ctx context.Context, runID string) ([]Approval, error) {
+	var out []Approval
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: runPath(runID, "approvals"), replayable: true}, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) GetApproval(ctx context.Context, runID, approvalID string) (*Approval, error) {
+	var out Approval
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: runPath(runID, "approvals", url.PathEscape(approvalID)), replayable: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) ApproveApproval(ctx context.Context, runID, approvalID, approver string) (*Approval, error) {
+	return c.resolveApproval(ctx, runID, approvalID, "approve", approver)
+}
+
+func (c *Client) RejectApproval(ctx context.Context, runID, approvalID, approver string) (*Approval, error) {
+	return c.resolveApproval(ctx, runID, approvalID, "reject", approver)
+}
+
+func (c *Client) resolveApproval(ctx context.Context, runID, approvalID, action, approver string) (*Approval, error) {
+	var out Approval
+	req := request{
+		method: http.MethodPost,
+		path:   runPath(runID, "approvals", url.PathEscape(approvalID), action),
+		body:   ResolveApprovalRequest{Approver: approver},
+	}
+	if err := c.getJSON(ctx, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Audit
+
+func (c *Client) ListToolCalls(ctx context.Context, runID string, filter ToolCallFilter) ([]ToolCall, error) {
+	q := url.Values{}
+	if filter.Status != "" {
+		q.Set("status", filter.Status)
+	}
+	if filter.ToolName != "" {
+		q.Set("tool_name", filter.ToolName)
+	}
+	if filter.CreatedAfter != nil {
+		q.Set("created_after", filter.CreatedAfter.UTC().Format(time.RFC3339))
+	}
+	if filter.CreatedBefore != nil {
+		q.Set("created_before", filter.CreatedBefore.UTC().Format(time.RFC3339))
+	}
+	var out []ToolCall
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: runPath(runID, "tool-calls"), query: q, replayable: true}, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) ListArtifacts(ctx context.Context, runID string) ([]Artifact, error) {
+	var out []Artifact
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: runPath(runID, "artifacts"), replayable: true}, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) GetArtifact(ctx context.Context, runID, artifactID string) (*Artifact, error) {
+	var out Artifact
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: runPath(runID, "artifacts", url.PathEscape(artifactID)), replayable: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ArtifactContent streams an artifact body. The caller must Close it.
+type ArtifactContent struct {
+	io.ReadCloser
+	ContentType string
+	// Size is the Content-Length, or -1 when unknown.
+	Size int64
+}
+
+// DownloadArtifact streams artifact content without buffering it in memory.
+func (c *Client) DownloadArtifact(ctx context.Context, runID, artifactID string) (*ArtifactContent, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: runPath(runID, "artifacts", url.PathEscape(artifactID), "content"), replayable: true})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+	return &ArtifactContent{ReadCloser: resp.Body, ContentType: resp.Header.Get("Content-Type"), Size: resp.ContentLength}, nil
+}
+
+// Issues and pull requests
+
+func (c *Client) CreateIssue(ctx context.Context, req IssueCreateRequest, opts ...CallOption) (*ToolResponse[Issue], error) {
+	return callTool[Issue](ctx, c, toolRequest(http.MethodPost, runPath(req.RunID, "issues"), req, true, opts))
+}
+
+func (c *Client) BatchCreateIssues(ctx context.Context, req IssueBatchCreateRequest, opts ...CallOption) (*ToolResponse[BatchResult], error) {
+	return callTool[BatchResult](ctx, c, toolRequest(http.MethodPost, runPath(req.RunID, "issues", "batch"), req, true, opts))
+}
+
+func (c *Client) CreatePRComment(ctx context.Context, req PRCommentRequest, opts ...CallOption) (*ToolResponse[Comment], error) {
+	path := runPath(req.RunID, "prs", strconv.Itoa(req.PRNumber), "comment")
+	return callTool[Comment](ctx, c, toolRequest(http.MethodPost, path, req, true, opts))
+}
+
+// CreatePRReview submits a review; lint findings in the request become
+// inline comments on the lines the pull request changes.
+func (c *Client) CreatePRReview(ctx context.Context, req PRReviewRequest, opts ...CallOption) (*ToolResponse[PRReviewResult], error) {
+	path := runPath(req.RunID, "prs", strconv.Itoa(req.PRNumber), "review")
+	return callTool[PRReviewResult](ctx, c, toolRequest(http.MethodPost, path, req, true, opts))
+}
+
+func (c *Client) GetPR(ctx context.Context, req PRRequest) (*ToolResponse[PullRequest], error) {
+	path := runPath(req.RunID, "prs", strconv.Itoa(req.PRNumber))
+	return callTool[PullRequest](ctx, c, toolRequest(http.MethodGet, path, nil, false, nil))
+}
+
+func (c *Client) ListPRFiles(ctx context.Context, req PRRequest) (*ToolResponse[PRFilesResult], error) {
+	path := runPath(req.RunID, "prs", strconv.Itoa(req.PRNumber), "files")
+	return callTool[PRFilesResult](ctx, c, toolRequest(http.MethodGet, path, nil, false, nil))
+}
+
+// QA. A failing command returns the envelope and an *Error with CodeQAFail,
+// CodeQATimeout or CodeQAError.
+
+func (c *Client) RunQATest(ctx context.Context, req QARequest) (*ToolResponse[QAResult], error) {
+	return callTool[QAResult](ctx, c, toolRequest(http.MethodPost, runPath(req.RunID, "qa", "test"), req, false, nil))
+}
+
+func (c *Client) RunQALint(ctx context.Context, req QARequest) (*ToolResponse[QAResult], error) {
+	return callTool[QAResult](ctx, c, toolRequest(http.MethodPost, runPath(req.RunID, "qa", "lint"), req, false, nil))
+}
+
+// RunQATarget runs a named QA target such as build or e2e.
+func (c *Client) RunQATarget(ctx context.Context, req QARunRequest) (*ToolResponse[QAResult], error) {
+	return callTool[QAResult](ctx, c, toolRequest(http.MethodPost, runPath(req.RunID, "qa", "targets", url.PathEscape(req.Target)), req, false, nil))
+}
+
+func (c *Client) ListQATargets(ctx context.Context, req QATargetsRequest) (*ToolResponse[QATargetsResult], error) {
+	return callTool[QATargetsResult](ctx, c, toolRequest(http.MethodGet, runPath(req.RunID, "qa", "targets"), nil, false, nil))
+}
+
+// ListQAFlakyTests returns the flakiness history of the run's repository.
+func (c *Client) ListQAFlakyTests(ctx context.Context, req QAFlakyTestsRequest) (*ToolResponse[QAFlakyTestsResult], error) {
+	return callTool[QAFlakyTestsResult](ctx, c, toolRequest(http.MethodGet, runPath(req.RunID, "qa", "flaky-tests"), nil, false, nil))
+}
+
+// Code operations are never retried: they push branches and open PRs.
+
+func (c *Client) GeneratePatch(ctx context.Context, req PatchRequest) (*ToolResponse[PatchResult], error) {
+	return callTool[PatchResult](ctx, c, toolRequest(http.MethodPost, runPath(req.RunID, "code", "patch"), req, false, nil))
+}
+
+func (c *Client) CreateBranchPR(ctx context.Context, req BranchPRRequest) (*ToolResponse[BranchPRResult], error) {
+	return callTool[BranchPRResult](ctx, c, toolRequest(http.MethodPost, runPath(req.RunID, "code", "branch-pr"), req, false, nil))
+}
+
+func (c *Client) UpdateBranch(ctx context.Context, req BranchUpdateRequest) (*ToolResponse[BranchUpdateResult], error) {
+	path := runPath(req.RunID, "code", "prs", strconv.Itoa(req.PRNumber), "branch")
+	return callTool[BranchUpdateResult](ctx, c, toolRequest(http.MethodPost, path, req, false, nil))
+}
+
+func (c *Client) RunRepairLoop(ctx context.Context, req RepairLoopRequest) (*ToolResponse[RepairLoopResult], error) {
+	return callTool[RepairLoopResult](ctx, c, toolRequest(http.MethodPost, runPath(req.RunID, "code", "repair-loop"), req, false, nil))
+}
+
+func (c *Client) SubmitRepairFix(ctx context.Context, req RepairSubmitRequest) (*ToolResponse[RepairLoopResult], error) {
+	path := runPath(req.RunID, "code", "repair-loop", url.PathEscape(req.RepairSessionID), "submit")
+	return callTool[RepairLoopResult](ctx, c, toolRequest(http.MethodPost, path, req, false, nil))
+}
+
+func (c *Client) AbandonRepairLoop(ctx context.Context, req RepairAbandonRequest) (*ToolResponse[RepairAbandonResult], error) {
+	path := runPath(req.RunID, "code", "repair-loop", url.PathEscape(req.RepairSessionID), "abandon")
+	return callTool[RepairAbandonResult](ctx, c, toolRequest(http.MethodPost, path, req, false, nil))
+}
+
+// Jobs. Tool calls started with async=true return ToolResponse.Job.
+
+func (c *Client) GetJob(ctx context.Context, runID, jobID string) (*Job, error) {
+	var out Job
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: runPath(runID, "jobs", url.PathEscape(jobID)), replayable: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) CancelJob(ctx context.Context, runID, jobID string) (*Job, error) {
+	var out Job
+	if err := c.getJSON(ctx, request{method: http.MethodPost, path: runPath(runID, "jobs", url.PathEscape(jobID), "cancel")}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CancelToolCall cancels a tool call of the run while it is in flight. The
+// call's own request then fails with tool_call_cancelled; its ID is known
+// from the started progress event.
+func (c *Client) CancelToolCall(ctx context.Context, runID, toolCallID string) (*RunningToolCall, error) {
+	var out RunningToolCall
+	if err := c.getJSON(ctx, request{method: http.MethodPost, path: runPath(runID, "tool-calls", url.PathEscape(toolCallID), "cancel")}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// JobResult returns the response of a finished job as the tool call would
+// have returned it; T is the result type of the job's tool. A job that is
+// still running fails with CodeJobNotFinished.
+func JobResult[T any](ctx context.Context, c *Client, runID, jobID string) (*ToolResponse[T], error) {
+	return callTool[T](ctx, c, toolRequest(http.MethodGet, runPath(runID, "jobs", url.PathEscape(jobID), "result"), nil, false, nil))
+}
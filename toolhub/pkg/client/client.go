@@ -0,0 +1,341 @@
+// Package client is a typed Go SDK for the ToolHub HTTP API.
+//
+// Request and result types are aliases of the server types, so the SDK and
+// openapi.yaml describe the same payloads.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/toolhub/toolhub/internal/core"
+)
+
+// Config configures a Client.
+type Config struct {
+	// BaseURL is the ToolHub HTTP address, e.g. http://localhost:8080.
+	BaseURL string
+	// HTTPClient defaults to a client with a 60s timeout.
+	HTTPClient *http.Client
+	// Principal is sent as X-ToolHub-Principal for per-principal rate limits.
+	Principal string
+	// MaxRetries bounds retries of replayable calls; 0 means 3, negative
+	// disables retries.
+	MaxRetries int
+	// RetryBackoff is the initial backoff, doubled on each retry; 0 means
+	// 200ms. A Retry-After header from the server takes precedence.
+	RetryBackoff time.Duration
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	principal  string
+	maxRetries int
+	backoff    time.Duration
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+func New(cfg Config) (*Client, error) {
+	base := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base url %q", cfg.BaseURL)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 200 * time.Millisecond
+	}
+	return &Client{
+		baseURL:    base,
+		httpClient: cfg.HTTPClient,
+		principal:  cfg.Principal,
+		maxRetries: cfg.MaxRetries,
+		backoff:    cfg.RetryBackoff,
+		sleep:      sleepContext,
+	}, nil
+}
+
+// CallOption adjusts a single tool call.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	idempotencyKey string
+}
+
+// WithIdempotencyKey sends an explicit Idempotency-Key. Replaying a key with
+// a different payload fails with CodeIdempotencyKeyConflict.
+func WithIdempotencyKey(key string) CallOption {
+	return func(o *callOptions) { o.idempotencyKey = key }
+}
+
+// ToolResponse is a decoded tool envelope.
+type ToolResponse[T any] struct {
+	OK   bool
+	Meta core.ToolMeta
+	// Result is decoded from the envelope result. Dry runs of create tools
+	// return a preview instead; RawResult keeps the original JSON.
+	Result    T
+	RawResult json.RawMessage
+	// Replayed is true when the server answered from an earlier call with
+	// the same idempotency key.
+	Replayed bool
+	// Job is set instead of Result when the call was started with
+	// async=true; fetch the response with JobResult once it has finished.
+	Job *Job
+}
+
+// request describes one HTTP call.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   any
+	// replayable calls are retried on transient failures: reads and tools
+	// that replay by idempotency key.
+	replayable     bool
+	idempotencyKey string
+}
+
+// toolRequest builds a tool call. Keyed tools get a generated
+// Idempotency-Key when the caller supplied none, so retries never create a
+// second issue or comment.
+func toolRequest(method, path string, body any, keyed bool, opts []CallOption) request {
+	var o callOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	req := request{method: method, path: path, body: body, idempotencyKey: o.idempotencyKey}
+	if keyed && req.idempotencyKey == "" {
+		req.idempotencyKey = uuid.NewString()
+	}
+	req.replayable = method == http.MethodGet || keyed
+	return req
+}
+
+// do sends req, retrying replayable calls on transport errors, 429 and
+// 502-504. The caller closes the returned body.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	var payload []byte
+	if req.body != nil {
+		var err error
+		if payload, err = json.Marshal(req.body); err != nil {
+			return nil, fmt.Errorf("marshal request: %w", err)
+		}
+	}
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if payload != nil {
+			httpReq.Header.Set("Content-Type", "application/json")
+		}
+		if req.idempotencyKey != "" {
+			httpReq.Header.Set("Idempotency-Key", req.idempotencyKey)
+		}
+		if c.principal != "" {
+			httpReq.Header.Set("X-ToolHub-Principal", c.principal)
+		}
+
+		resp, err := c.httpClient.Do(httpReq)
+		retry := req.replayable && attempt < c.maxRetries
+		if err != nil {
+			if !retry || ctx.Err() != nil {
+				return nil, err
+			}
+			if err := c.sleep(ctx, c.backoffFor(attempt, nil)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if retry && retryableStatus(resp.StatusCode) {
+			wait := c.backoffFor(attempt, resp)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if err := c.sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return resp, nil
+	}
+}
+
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) backoffFor(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	d := c.backoff << attempt
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// getJSON performs req and decodes a plain (non-envelope) JSON response.
+func (c *Client) getJSON(ctx context.Context, req request, out any) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return decodeError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// callTool performs a tool request and decodes its envelope. An OK=false
+// envelope is returned together with an *Error, so callers can still read
+// results such as failed QA reports.
+func callTool[T any](ctx context.Context, c *Client, req request) (*ToolResponse[T], error) {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	var raw json.RawMessage
+	env := core.ToolEnvelope{Result: &raw}
+	if err := json.Unmarshal(body, &env); err != nil || (resp.StatusCode >= 300 && env.Error == nil) {
+		// Transport errors use the plain error body.
+		return nil, decodeErrorBody(resp, body)
+	}
+
+	out := &ToolResponse[T]{
+		OK:        env.OK,
+		Meta:      env.Meta,
+		RawResult: raw,
+		Replayed:  env.Meta.Replayed || resp.Header.Get("Idempotency-Replayed") == "true",
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		out.Job = &Job{}
+		if err := json.Unmarshal(raw, out.Job); err != nil {
+			return nil, fmt.Errorf("decode job: %w", err)
+		}
+		return out, nil
+	}
+	if len(raw) > 0 && string(raw) != "null" {
+		// Best effort: dry-run previews do not match T.
+		_ = json.Unmarshal(raw, &out.Result)
+	}
+	if !env.OK {
+		e := &Error{StatusCode: resp.StatusCode, Message: "tool call failed"}
+		if env.Error != nil {
+			e.Code = ErrorCode(env.Error.Code)
+			e.Message = env.Error.Message
+		}
+		meta := env.Meta
+		e.Meta = &meta
+		return out, e
+	}
+	return out, nil
+}
+
+// Error is a failed ToolHub call: either a non-2xx error body or a tool
+// envelope with ok=false.
+type Error struct {
+	StatusCode int
+	Code       ErrorCode
+	Message    string
+	// Fields lists schema violations for CodeInvalidRequestSchema.
+	Fields []core.SchemaFieldError
+	// RetryAfter is set for CodeRateLimited.
+	RetryAfter time.Duration
+	// Meta is set when the error came inside a tool envelope.
+	Meta *core.ToolMeta
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("toolhub: %s (http %d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// IsCode reports whether err is a ToolHub *Error with the given code.
+func IsCode(err error, code ErrorCode) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return decodeErrorBody(resp, body)
+}
+
+func decodeErrorBody(resp *http.Response, body []byte) error {
+	var payload ErrorResponse
+	e := &Error{StatusCode: resp.StatusCode}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Code != "" {
+		e.Code = ErrorCode(payload.Code)
+		e.Message = payload.Message
+		e.Fields = payload.Errors
+		e.RetryAfter = time.Duration(payload.RetryAfterSeconds) * time.Second
+	} else {
+		e.Code = CodeInternalError
+		e.Message = strings.TrimSpace(string(body))
+		if e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && e.RetryAfter == 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+func runPath(runID string, parts ...string) string {
+	p := "/api/v1/runs/" + url.PathEscape(runID)
+	for _, part := range parts {
+		p += "/" + part
+	}
+	return p
+}
@@ -0,0 +1,325 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toolhub/toolhub/internal/core"
+	"github.com/toolhub/toolhub/internal/qa"
+)
+
+// fakeToolHub records requests and answers each path from a queue of
+// canned responses; the last response repeats.
+type fakeToolHub struct {
+	t         *testing.T
+	responses map[string][]fakeResponse
+	requests  []*http.Request
+	bodies    []string
+}
+
+type fakeResponse struct {
+	status  int
+	headers map[string]string
+	body    any
+}
+
+func (f *fakeToolHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r)
+	f.bodies = append(f.bodies, string(body))
+
+	key := r.Method + " " + r.URL.Path
+	queue := f.responses[key]
+	if len(queue) == 0 {
+		f.t.Errorf("unexpected request %s", key)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp := queue[0]
+	if len(queue) > 1 {
+		f.responses[key] = queue[1:]
+	}
+	for k, v := range resp.headers {
+		w.Header().Set(k, v)
+	}
+	if s, ok := resp.body.(string); ok {
+		w.WriteHeader(resp.status)
+		io.WriteString(w, s)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	json.NewEncoder(w).Encode(resp.body)
+}
+
+func newTestClient(t *testing.T, responses map[string][]fakeResponse) (*Client, *fakeToolHub, *[]time.Duration) {
+	t.Helper()
+	hub := &fakeToolHub{t: t, responses: responses}
+	srv := httptest.NewServer(hub)
+	t.Cleanup(srv.Close)
+
+	c, err := New(Config{BaseURL: srv.URL + "/", Principal: "orchestrator"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	var sleeps []time.Duration
+	c.sleep = func(_ context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	return c, hub, &sleeps
+}
+
+func envelope(ok bool, result any, toolErr *core.ToolError) core.ToolEnvelope {
+	return core.ToolEnvelope{OK: ok, Meta: core.ToolMeta{RunID: "run-1", ToolCallID: "tc-1"}, Result: result, Error: toolErr}
+}
+
+func TestNewRejectsInvalidBaseURL(t *testing.T) {
+	for _, base := range []string{"", "localhost:8080", "://x"} {
+		if _, err := New(Config{BaseURL: base}); err == nil {
+			t.Fatalf("expected error for base url %q", base)
+		}
+	}
+}
+
+func TestCreateIssueRetriesWithStableIdempotencyKey(t *testing.T) {
+	c, hub, sleeps := newTestClient(t, map[string][]fakeResponse{
+		"POST /api/v1/runs/run-1/issues": {
+			{status: http.StatusBadGateway, body: ErrorResponse{Code: "upstream_error", Message: "boom"}},
+			{status: http.StatusOK, headers: map[string]string{"Idempotency-Replayed": "true"}, body: envelope(true, Issue{Number: 7, Title: "t"}, nil)},
+		},
+	})
+
+	resp, err := c.CreateIssue(context.Background(), IssueCreateRequest{RunID: "run-1", Title: "t", Body: "b"})
+	if err != nil {
+		t.Fatalf("create issue: %v", err)
+	}
+	if resp.Result.Number != 7 || !resp.Replayed || resp.Meta.ToolCallID != "tc-1" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if len(hub.requests) != 2 || len(*sleeps) != 1 {
+		t.Fatalf("expected one retry, got %d requests and %d sleeps", len(hub.requests), len(*sleeps))
+	}
+	first, second := hub.requests[0].Header.Get("Idempotency-Key"), hub.requests[1].Header.Get("Idempotency-Key")
+	if first == "" || first != second {
+		t.Fatalf("retries must reuse a generated idempotency key, got %q and %q", first, second)
+	}
+	if got := hub.requests[0].Header.Get("X-ToolHub-Principal"); got != "orchestrator" {
+		t.Fatalf("expected principal header, got %q", got)
+	}
+	if !strings.Contains(hub.bodies[0], `"title":"t"`) {
+		t.Fatalf("unexpected request body %s", hub.bodies[0])
+	}
+}
+
+func TestToolErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		response   fakeResponse
+		call       func(c *Client) (bool, error)
+		wantCode   ErrorCode
+		wantResult bool
+		wantCalls  int
+	}{
+		{
+			name:     "idempotency conflict is not retried",
+			response: fakeResponse{status: http.StatusConflict, body: ErrorResponse{Code: "idempotency_key_conflict", Message: "payload differs"}},
+			call: func(c *Client) (bool, error) {
+				resp, err := c.CreatePRComment(context.Background(), PRCommentRequest{RunID: "run-1", PRNumber: 3, Body: "x"}, WithIdempotencyKey("k1"))
+				return resp != nil, err
+			},
+			wantCode:  CodeIdempotencyKeyConflict,
+			wantCalls: 1,
+		},
+		{
+			name: "qa failure keeps the report",
+			response: fakeResponse{status: http.StatusOK, body: envelope(false, QAResult{Status: qa.StatusFail, Report: qa.Report{ExitCode: 1}},
+				&core.ToolError{Code: "fail", Message: "exit status 1"})},
+			call: func(c *Client) (bool, error) {
+				resp, err := c.RunQATest(context.Background(), QARequest{RunID: "run-1"})
+				return resp != nil, err
+			},
+			wantCode:   CodeQAFail,
+			wantResult: true,
+			wantCalls:  1,
+		},
+		{
+			name:     "code ops are not retried",
+			response: fakeResponse{status: http.StatusBadGateway, body: ErrorResponse{Code: "upstream_error", Message: "push failed"}},
+			call: func(c *Client) (bool, error) {
+				resp, err := c.CreateBranchPR(context.Background(), BranchPRRequest{RunID: "run-1"})
+				return resp != nil, err
+			},
+			wantCode:  CodeUpstreamError,
+			wantCalls: 1,
+		},
+		{
+			name: "path policy envelope",
+			response: fakeResponse{status: http.StatusForbidden, body: core.ToolEnvelope{
+				Error: &core.ToolError{Code: "path_policy_forbidden", Message: "forbidden path"}}},
+			call: func(c *Client) (bool, error) {
+				resp, err := c.GeneratePatch(context.Background(), PatchRequest{RunID: "run-1", Path: ".git/config"})
+				return resp != nil, err
+			},
+			wantCode:   CodePathPolicyForbidden,
+			wantResult: true,
+			wantCalls:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, hub, _ := newTestClient(t, map[string][]fakeResponse{
+				"POST /api/v1/runs/run-1/prs/3/comment":  {tt.response},
+				"POST /api/v1/runs/run-1/qa/test":        {tt.response},
+				"POST /api/v1/runs/run-1/code/branch-pr": {tt.response},
+				"POST /api/v1/runs/run-1/code/patch":     {tt.response},
+			})
+			gotResult, err := tt.call(c)
+			if !IsCode(err, tt.wantCode) {
+				t.Fatalf("expected code %s, got %v", tt.wantCode, err)
+			}
+			if len(hub.requests) != tt.wantCalls {
+				t.Fatalf("expected %d requests, got %d", tt.wantCalls, len(hub.requests))
+			}
+			if gotResult != tt.wantResult {
+				t.Fatalf("expected response present=%v, got %v", tt.wantResult, gotResult)
+			}
+		})
+	}
+}
+
+func TestSchemaErrorFields(t *testing.T) {
+	c, _, _ := newTestClient(t, map[string][]fakeResponse{
+		"POST /api/v1/runs/run-1/issues/batch": {{status: http.StatusBadRequest, body: ErrorResponse{
+			Code:    "invalid_request_schema",
+			Message: "invalid request",
+			Errors:  []core.SchemaFieldError{{Path: "$.issues[0].title", Expected: "string", Got: "missing"}},
+		}}},
+	})
+	_, err := c.BatchCreateIssues(context.Background(), IssueBatchCreateRequest{RunID: "run-1"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || len(apiErr.Fields) != 1 || apiErr.Fields[0].Path != "$.issues[0].title" {
+		t.Fatalf("expected schema error with field details, got %#v", err)
+	}
+}
+
+func TestReadsRetryRateLimitsUsingRetryAfter(t *testing.T) {
+	limited := fakeResponse{
+		status:  http.StatusTooManyRequests,
+		headers: map[string]string{"Retry-After": "2"},
+		body:    ErrorResponse{Code: "rate_limited", Message: "slow down", RetryAfterSeconds: 2},
+	}
+	c, hub, sleeps := newTestClient(t, map[string][]fakeResponse{
+		"GET /api/v1/runs/run-1/prs/9/files": {limited},
+	})
+	c.maxRetries = 2
+
+	_, err := c.ListPRFiles(context.Background(), PRRequest{RunID: "run-1", PRNumber: 9})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != CodeRateLimited || apiErr.RetryAfter != 2*time.Second {
+		t.Fatalf("expected rate limited error with retry-after, got %v", err)
+	}
+	if len(hub.requests) != 3 {
+		t.Fatalf("expected 1 call + 2 retries, got %d", len(hub.requests))
+	}
+	for _, d := range *sleeps {
+		if d != 2*time.Second {
+			t.Fatalf("expected Retry-After to drive the backoff, slept %v", d)
+		}
+	}
+}
+
+func TestListToolCallsEncodesFilters(t *testing.T) {
+	c, hub, _ := newTestClient(t, map[string][]fakeResponse{
+		"GET /api/v1/runs/run-1/tool-calls": {{status: http.StatusOK, body: []ToolCall{{ToolCallID: "tc-1", Status: "ok"}}}},
+	})
+	after := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	calls, err := c.ListToolCalls(context.Background(), "run-1", ToolCallFilter{Status: "ok", ToolName: "qa.test", CreatedAfter: &after})
+	if err != nil {
+		t.Fatalf("list tool calls: %v", err)
+	}
+	if len(calls) != 1 || calls[0].ToolCallID != "tc-1" {
+		t.Fatalf("unexpected tool calls: %+v", calls)
+	}
+	q := hub.requests[0].URL.Query()
+	if q.Get("status") != "ok" || q.Get("tool_name") != "qa.test" || q.Get("created_after") != "2026-01-02T03:04:05Z" || q.Has("created_before") {
+		t.Fatalf("unexpected query %s", hub.requests[0].URL.RawQuery)
+	}
+}
+
+func TestDownloadArtifactStreamsContent(t *testing.T) {
+	c, _, _ := newTestClient(t, map[string][]fakeResponse{
+		"GET /api/v1/runs/run-1/artifacts/a-1/content":     {{status: http.StatusOK, headers: map[string]string{"Content-Type": "text/x-diff"}, body: "--- a/x\n+++ b/x\n"}},
+		"GET /api/v1/runs/run-1/artifacts/missing/content": {{status: http.StatusNotFound, body: ErrorResponse{Code: "not_found", Message: "artifact not found"}}},
+	})
+
+	content, err := c.DownloadArtifact(context.Background(), "run-1", "a-1")
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "--- a/x\n+++ b/x\n" || content.ContentType != "text/x-diff" {
+		t.Fatalf("unexpected content %q (%s)", data, content.ContentType)
+	}
+
+	if _, err := c.DownloadArtifact(context.Background(), "run-1", "missing"); !IsCode(err, CodeNotFound) {
+		t.Fatalf("expected not_found, got %v", err)
+	}
+}
+
+func TestCreateRunIsNotRetried(t *testing.T) {
+	c, hub, _ := newTestClient(t, map[string][]fakeResponse{
+		"POST /api/v1/runs": {{status: http.StatusServiceUnavailable, body: "unavailable"}},
+	})
+	_, err := c.CreateRun(context.Background(), CreateRunRequest{Repo: "o/r", Purpose: "p"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.Message != "unavailable" {
+		t.Fatalf("expected plain-text error to be surfaced, got %v", err)
+	}
+	if len(hub.requests) != 1 {
+		t.Fatalf("expected a single request, got %d", len(hub.requests))
+	}
+}
+
+func TestAsyncCallReturnsJob(t *testing.T) {
+	pass := QAResult{Status: qa.StatusPass, Summary: "ok"}
+	c, hub, _ := newTestClient(t, map[string][]fakeResponse{
+		"POST /api/v1/runs/run-1/qa/test": {{status: http.StatusAccepted, body: envelope(true, Job{JobID: "job-1", RunID: "run-1", ToolName: "qa.test", Status: "running"}, nil)}},
+		"GET /api/v1/runs/run-1/jobs/job-1/result": {
+			{status: http.StatusConflict, body: ErrorResponse{Code: "job_not_finished", Message: `job "job-1" is still running`}},
+			{status: http.StatusOK, body: envelope(true, pass, nil)},
+		},
+	})
+	ctx := context.Background()
+
+	resp, err := c.RunQATest(ctx, QARequest{RunID: "run-1", Async: true})
+	if err != nil {
+		t.Fatalf("start qa job: %v", err)
+	}
+	if resp.Job == nil || resp.Job.JobID != "job-1" || resp.Result.Status != "" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if !strings.Contains(hub.bodies[0], `"async":true`) {
+		t.Fatalf("unexpected request body %s", hub.bodies[0])
+	}
+
+	if _, err := JobResult[QAResult](ctx, c, "run-1", "job-1"); !IsCode(err, CodeJobNotFinished) {
+		t.Fatalf("expected job_not_finished, got %v", err)
+	}
+	result, err := JobResult[QAResult](ctx, c, "run-1", "job-1")
+	if err != nil || result.Result.Status != qa.StatusPass || result.Job != nil {
+		t.Fatalf("unexpected job result: %+v, %v", result, err)
+	}
+}
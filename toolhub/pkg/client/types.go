@@ -0,0 +1,143 @@
+package client
+
+import (
+	"github.com/toolhub/toolhub/internal/codeops"
+	"github.com/toolhub/toolhub/internal/core"
+	"github.com/toolhub/toolhub/internal/db"
+	gh "github.com/toolhub/toolhub/internal/github"
+	httpsvr "github.com/toolhub/toolhub/internal/http"
+	"github.com/toolhub/toolhub/internal/qa"
+	"github.com/toolhub/toolhub/internal/tools"
+)
+
+// Server types, re-exported so callers outside this module can name them.
+type (
+	Run              = db.Run
+	Approval         = db.Approval
+	Artifact         = db.Artifact
+	ToolCall         = db.ToolCall
+	ToolCallFilter   = db.ToolCallListFilter
+	ToolMeta         = core.ToolMeta
+	ToolError        = core.ToolError
+	SchemaFieldError = core.SchemaFieldError
+	ErrorResponse    = httpsvr.ErrorResponse
+	HealthStatus     = httpsvr.HealthStatus
+	VersionInfo      = httpsvr.VersionInfo
+
+	CreateRunRequest        = tools.RunsCreateArgs
+	CreateApprovalRequest   = httpsvr.CreateApprovalRequest
+	ResolveApprovalRequest  = httpsvr.ResolveApprovalRequest
+	IssueCreateRequest      = tools.IssueCreateArgs
+	IssueBatchCreateRequest = tools.IssueBatchCreateArgs
+	BatchIssue              = tools.BatchIssue
+	PRCommentRequest        = tools.PRCommentCreateArgs
+	PRReviewRequest         = tools.PRReviewCreateArgs
+	ReviewComment           = gh.ReviewComment
+	PRRequest               = tools.PRReadArgs
+	QARequest               = tools.QAArgs
+	QARunRequest            = tools.QARunArgs
+	QATargetsRequest        = tools.QATargetsListArgs
+	QAFlakyTestsRequest     = tools.QAFlakyTestsListArgs
+	PatchRequest            = tools.CodePatchArgs
+	BranchPRRequest         = tools.CodeBranchPRArgs
+	BranchUpdateRequest     = tools.CodeBranchUpdateArgs
+	RepairLoopRequest       = tools.CodeRepairLoopArgs
+	RepairSubmitRequest     = tools.CodeRepairSubmitArgs
+	RepairAbandonRequest    = tools.CodeRepairAbandonArgs
+	FileChange              = codeops.FileChange
+	EditBlock               = codeops.EditBlock
+	HunkConflict            = codeops.HunkConflict
+	StaleFile               = codeops.StaleFile
+	CommitVerification      = codeops.Verification
+
+	Issue               = gh.Issue
+	Comment             = gh.Comment
+	PullRequest         = gh.PullRequest
+	PullRequestFile     = gh.PullRequestFile
+	BatchResult         = tools.BatchResult
+	BatchItemResult     = tools.BatchItemResult
+	PRFilesResult       = tools.PRFilesResult
+	QAResult            = tools.QAResult
+	QAReport            = qa.Report
+	QATargetsResult     = tools.QATargetsResult
+	QATarget            = qa.TargetInfo
+	TestResults         = qa.TestResults
+	TestCase            = qa.TestCase
+	LintFindings        = qa.LintFindings
+	Finding             = qa.Finding
+	TestSelection       = qa.TestSelection
+	TestRerun           = qa.TestRerun
+	QAFlakyTestsResult  = tools.QAFlakyTestsResult
+	FlakyTest           = db.FlakyTest
+	PRReviewResult      = tools.PRReviewResult
+	Review              = gh.Review
+	PatchResult         = tools.PatchResult
+	BranchPRResult      = tools.BranchPRResult
+	BranchUpdateResult  = tools.BranchUpdateResult
+	RepairLoopResult    = tools.RepairLoopResult
+	RepairAttempt       = tools.RepairAttempt
+	TargetAttempt       = tools.TargetAttempt
+	RepairAbandonResult = tools.RepairAbandonResult
+	Job                 = db.Job
+	JobStep             = db.Step
+	RunningToolCall     = core.RunningToolCall
+	ProgressEvent       = core.ProgressEvent
+)
+
+// ErrorCode is the machine-readable code of a ToolHub error.
+type ErrorCode string
+
+const (
+	CodeInvalidRequestSchema   ErrorCode = "invalid_request_schema"
+	CodeBadRequest             ErrorCode = "bad_request"
+	CodeForbidden              ErrorCode = "forbidden"
+	CodeNotFound               ErrorCode = "not_found"
+	CodeRunNotFound            ErrorCode = "run_not_found"
+	CodeRepoNotAllowed         ErrorCode = "repo_not_allowed"
+	CodeToolNotAllowed         ErrorCode = "tool_not_allowed"
+	CodeIdempotencyKeyConflict ErrorCode = "idempotency_key_conflict"
+	CodeRateLimited            ErrorCode = "rate_limited"
+	CodeUpstreamError          ErrorCode = "upstream_error"
+	CodeInternalError          ErrorCode = "internal_error"
+
+	CodeAppNotInstalled        ErrorCode = "app_not_installed"
+	CodeGitHubPermissionDenied ErrorCode = "github_permission_denied"
+	CodeGitHubAuthFailed       ErrorCode = "github_auth_failed"
+	CodeGitHubNotFound         ErrorCode = "github_not_found"
+	CodeGitHubValidationFailed ErrorCode = "github_validation_failed"
+	CodeBatchPartialFailure    ErrorCode = "batch_partial_failure"
+	CodePatchConflict          ErrorCode = "patch_conflict"
+	CodeStaleOriginalContent   ErrorCode = "stale_original_content"
+	CodeHeadBranchMoved        ErrorCode = "head_branch_moved"
+	CodePRNotOwned             ErrorCode = "pr_not_owned"
+	CodePRNotOpen              ErrorCode = "pr_not_open"
+	CodeRepairSessionNotFound  ErrorCode = "repair_session_not_found"
+	CodeRepairSessionClosed    ErrorCode = "repair_session_closed"
+	CodeJobNotFound            ErrorCode = "job_not_found"
+	CodeJobNotFinished         ErrorCode = "job_not_finished"
+	CodeJobFinished            ErrorCode = "job_finished"
+	CodeJobCancelled           ErrorCode = "job_cancelled"
+	CodeJobInterrupted         ErrorCode = "job_interrupted"
+	CodeToolCallNotFound       ErrorCode = "tool_call_not_found"
+	CodeToolCallFinished       ErrorCode = "tool_call_finished"
+	CodeToolCallCancelled      ErrorCode = "tool_call_cancelled"
+
+	CodePathPolicyForbidden        ErrorCode = ErrorCode(core.ViolationPathForbidden)
+	CodePathPolicyApprovalRequired ErrorCode = ErrorCode(core.ViolationPathApprovalRequired)
+	CodePathPolicyTraversal        ErrorCode = ErrorCode(core.ViolationPathTraversal)
+	CodePathPolicyEmpty            ErrorCode = ErrorCode(core.ViolationPathEmpty)
+
+	// QA tools report these as OK=false envelopes.
+	CodeQAFail    ErrorCode = ErrorCode(qa.StatusFail)
+	CodeQATimeout ErrorCode = ErrorCode(qa.StatusTimeout)
+	CodeQAError   ErrorCode = ErrorCode(qa.StatusError)
+
+	CodeQACancelled ErrorCode = qa.ErrCodeCancelled
+
+	CodeQACommandEmpty      ErrorCode = qa.ErrCodeCommandEmpty
+	CodeQACommandNotAllowed ErrorCode = qa.ErrCodeCommandNotAllowed
+	CodeQACommandInvalid    ErrorCode = qa.ErrCodeCommandInvalid
+	CodeQAWorkdirInvalid    ErrorCode = qa.ErrCodeWorkdirInvalid
+	CodeQATargetNotFound    ErrorCode = qa.ErrCodeTargetNotFound
+	CodeQARepoConfigInvalid ErrorCode = qa.ErrCodeRepoConfigInvalid
+)